@@ -61,7 +61,24 @@ func InitTable() error {
         status TINYINT(1) DEFAULT 1
     ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
 
-	_, err := DB.Exec(createTableSQL)
+	if _, err := DB.Exec(createTableSQL); err != nil {
+		return err
+	}
+
+	// API 令牌，供 gRPC 等非浏览器客户端认证，只保存令牌的 SHA-256
+	createTokenTableSQL := `
+    CREATE TABLE IF NOT EXISTS api_token (
+        id INT AUTO_INCREMENT PRIMARY KEY,
+        user_name VARCHAR(100) NOT NULL,
+        name VARCHAR(100) NOT NULL,
+        token_hash CHAR(64) NOT NULL UNIQUE,
+        create_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        expire_time TIMESTAMP NULL,
+        last_used TIMESTAMP NULL,
+        INDEX idx_user_name (user_name)
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`
+
+	_, err := DB.Exec(createTokenTableSQL)
 	return err
 }
 
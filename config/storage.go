@@ -6,6 +6,7 @@ import (
 	"github.com/donnie4w/go-logger/logger"
 	"github.com/fatih/color"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -17,6 +18,12 @@ type StorageLocation struct {
 	NetFilePath    string `bson:"net_file_path"`    // 网络文件路径，当系统路径存在的时候，此字段可以为空
 }
 
+// Replica 集群中保存文件内容的一个节点
+type Replica struct {
+	NodeID string `bson:"node_id" json:"node_id"`
+	Path   string `bson:"path" json:"-"` // 该节点上的本地路径，只在该节点上有效
+}
+
 // FileNode 代表一个逻辑上的文件或文件夹节点
 type FileNode struct {
 	// --- 核心标识与层级 ---
@@ -26,13 +33,30 @@ type FileNode struct {
 	Name     string             `bson:"name" json:"name"` // 用户看到的、在当前层级下的名称，�� "report.pdf" 或 "documents"
 	Path     string             `bson:"path" json:"path"`
 	//AuthLevel          *int               `bson:"auth_level,omitempty"` // 权限级别，表示当前节点的权限要求，用指针表示父节点,nil表示继承父节点权限，0表示无权限
-	EffectiveAuthLevel int              `bson:"effective_auth_level" json:"auth_level"`     //查询时访问的值，前端显示为auth_level
-	Storage            *StorageLocation `bson:"storage,omitempty" json:"storage,omitempty"` // 存储位置，指向具体的存储节点'
+	EffectiveAuthLevel int              `bson:"effective_auth_level" json:"auth_level"`       //查询时访问的值，前端显示为auth_level
+	Storage            *StorageLocation `bson:"storage,omitempty" json:"storage,omitempty"`   // 存储位置，指向具体的存储节点'
+	Replicas           []Replica        `bson:"replicas,omitempty" json:"replicas,omitempty"` // 集群模式下保存文件内容的节点
+}
+
+// ReplicaOn 返回 nodeID 上的副本，不存在时返回 nil
+func (n *FileNode) ReplicaOn(nodeID string) *Replica {
+	for i := range n.Replicas {
+		if n.Replicas[i].NodeID == nodeID {
+			return &n.Replicas[i]
+		}
+	}
+	return nil
 }
 
 var FileClient *mongo.Client
 var FileCollection *mongo.Collection
-var RootPath = "." // 根目录路径
+var JobCollection *mongo.Collection         // 后台任务
+var ScheduleCollection *mongo.Collection    // 定时任务
+var ScheduleRunCollection *mongo.Collection // 定时任务执行历史
+var ClusterNodeCollection *mongo.Collection // 集群节点
+var P2PMessageCollection *mongo.Collection  // 通过P2P连接收到的消息
+var RootPath = "."                          // 根目录路径
+var NodeID = ""                             // 集群模式下本节点的ID，单机运行时为空
 
 func InitFileDB() error {
 	// 加载 .env 文件
@@ -70,12 +94,37 @@ func InitFileDB() error {
 		return err
 	}
 	FileCollection = FileClient.Database("GoFileShare").Collection("FileDir")
+	JobCollection = FileClient.Database("GoFileShare").Collection("Jobs")
+	ScheduleCollection = FileClient.Database("GoFileShare").Collection("Schedules")
+	ScheduleRunCollection = FileClient.Database("GoFileShare").Collection("ScheduleRuns")
+	ClusterNodeCollection = FileClient.Database("GoFileShare").Collection("ClusterNodes")
+	P2PMessageCollection = FileClient.Database("GoFileShare").Collection("P2PMessages")
+
+	if err := initP2PMessageIndexes(); err != nil {
+		logger.Errorf("创建P2P消息索引失败: %v", err)
+		color.Red("创建P2P消息索引失败: %v", err)
+		return err
+	}
 
 	color.Green("Connected to MongoDB successfully.")
 
 	return nil
 }
 
+// initP2PMessageIndexes 同一对方的同一消息ID只能保存一次，收件箱按用户和接收时间查询
+func initP2PMessageIndexes() error {
+	_, err := P2PMessageCollection.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "peer_key", Value: 1}, {Key: "message_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user", Value: 1}, {Key: "received_at", Value: -1}},
+		},
+	})
+	return err
+}
+
 func CloseFileDB() error {
 	if FileClient != nil {
 		err := FileClient.Disconnect(context.TODO())
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"GoFileShare/models"
+	"GoFileShare/services"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// GetBandwidth 获取当前带宽限制配置和生效速率
+func GetBandwidth(c *gin.Context) {
+	manager := services.GetBandwidthManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "带宽管理器未初始化"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"bandwidth": manager.Status(),
+	})
+}
+
+// UpdateBandwidth 运行时替换全局、每用户、每任务速率及时段规则
+func UpdateBandwidth(c *gin.Context) {
+	manager := services.GetBandwidthManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "带宽管理器未初始化"})
+		return
+	}
+
+	var config models.BandwidthConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := manager.SetConfig(config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"message":   "带宽配置已更新",
+		"bandwidth": manager.Status(),
+	})
+}
+
+// rateRequest 单个用户或任务的速率设置，rate 为负数时恢复默认
+type rateRequest struct {
+	Rate *int64 `json:"rate" binding:"required"`
+}
+
+// SetUserBandwidth 为指定用户设置速率
+func SetUserBandwidth(c *gin.Context) {
+	manager := services.GetBandwidthManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "带宽管理器未初始化"})
+		return
+	}
+
+	var req rateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	manager.SetUserRate(c.Param("name"), *req.Rate)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"bandwidth": manager.Status(),
+	})
+}
+
+// SetTaskBandwidth 为指定任务设置速率
+func SetTaskBandwidth(c *gin.Context) {
+	manager := services.GetBandwidthManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "带宽管理器未初始化"})
+		return
+	}
+
+	var req rateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	manager.SetTaskRate(c.Param("id"), *req.Rate)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"bandwidth": manager.Status(),
+	})
+}
+
+// GetTransferPool 查看传输协程池的队列深度、吞吐和延迟
+func GetTransferPool(c *gin.Context) {
+	service := services.GetTransferService()
+	if service == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "传输服务未初始化"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"pool":   service.PoolMetrics(),
+	})
+}
+
+// poolRequest 调整协程池大小的请求
+type poolRequest struct {
+	Workers int `json:"workers" binding:"required,min=1"`
+}
+
+// ResizeTransferPool 运行时调整同时执行的传输任务数
+func ResizeTransferPool(c *gin.Context) {
+	service := services.GetTransferService()
+	if service == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "传输服务未初始化"})
+		return
+	}
+
+	var req poolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	service.ResizeWorkers(req.Workers)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"pool":   service.PoolMetrics(),
+	})
+}
+
+// GetClusterView 查看本节点看到的集群成员及其状态
+func GetClusterView(c *gin.Context) {
+	cluster := services.GetCluster()
+	if cluster == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "未启用集群模式"})
+		return
+	}
+
+	members := cluster.Members()
+	counts := make(map[services.MemberState]int)
+	for _, member := range members {
+		counts[member.State]++
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"self":    cluster.NodeID(),
+		"members": members,
+		"counts":  counts,
+	})
+}
+
+// p2pTrustStore 获取P2P客户端的信任列表，未初始化时返回503
+func p2pTrustStore(c *gin.Context) *services.P2PTrustStore {
+	client := services.GetGlobalEnhancedP2PClient()
+	if client == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "P2P客户端未初始化"})
+		return nil
+	}
+	return client.TrustStore()
+}
+
+// ListP2PTrustedPeers 列出记录过身份公钥的P2P节点
+func ListP2PTrustedPeers(c *gin.Context) {
+	trust := p2pTrustStore(c)
+	if trust == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"strict": trust.Strict(),
+		"peers":  trust.List(),
+	})
+}
+
+// PinP2PPeer 记录或替换P2P节点的身份公钥
+func PinP2PPeer(c *gin.Context) {
+	trust := p2pTrustStore(c)
+	if trust == nil {
+		return
+	}
+
+	var req struct {
+		PublicKey string `json:"public_key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := trust.Pin(c.Param("key"), req.PublicKey); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "peers": trust.List()})
+}
+
+// UnpinP2PPeer 删除P2P节点的身份公钥记录，之后的握手按信任模式重新处理
+func UnpinP2PPeer(c *gin.Context) {
+	trust := p2pTrustStore(c)
+	if trust == nil {
+		return
+	}
+
+	removed, err := trust.Unpin(c.Param("key"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有该节点的记录"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "peers": trust.List()})
+}
@@ -2,10 +2,12 @@ package controllers
 
 import (
 	"GoFileShare/models"
+	"GoFileShare/services"
 	"fmt"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strings"
 )
 
 // ShowLoginPage 显示登录页面
@@ -35,6 +37,14 @@ func Register(c *gin.Context) {
 		})
 		return
 	}
+	// node: 前缀留给凭证书调用的集群节点
+	if strings.HasPrefix(username, services.NodeCallerPrefix) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": "用户名不能以 " + services.NodeCallerPrefix + " 开头",
+		})
+		return
+	}
 
 	// 检查用户是否存在
 	exists, err := models.UserExists(username)
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"GoFileShare/services"
+	"errors"
+	"fmt"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// importRequest 离线下载请求
+type importRequest struct {
+	URL      string            `json:"url" binding:"required"`
+	ParentID string            `json:"parent_id"`
+	Name     string            `json:"name"`    // 可选，默认取URL中的文件名
+	Headers  map[string]string `json:"headers"` // 可选，下载时附带的请求头，例如 Authorization
+}
+
+// CreateImport 让服务器从URL下载文件并导入到指定文件夹
+func CreateImport(c *gin.Context) {
+	session := sessions.Default(c)
+	username := session.Get("user")
+	authLevel := session.Get("authLevel")
+	if username == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	auth, ok := authLevel.(int)
+	if !ok {
+		auth = 0
+	}
+
+	var req importRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少下载地址"})
+		return
+	}
+
+	// 下载在后台任务中进行，任务ID可用于 /api/jobs 查询和取消
+	submitJob(c, services.JobKindImport, fmt.Sprint(username), "离线下载 "+req.URL,
+		services.ImportJobParams{
+			URL:       req.URL,
+			ParentID:  req.ParentID,
+			Name:      req.Name,
+			Headers:   req.Headers,
+			AuthLevel: auth,
+		},
+		gin.H{"message": "离线下载任务已提交"})
+}
+
+// ListImports 列出当前用户的导入任务
+func ListImports(c *gin.Context) {
+	session := sessions.Default(c)
+	username := session.Get("user")
+	if username == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"imports": services.GetImportManager().List(fmt.Sprint(username)),
+	})
+}
+
+// CancelImport 取消当前用户的导入任务
+func CancelImport(c *gin.Context) {
+	session := sessions.Default(c)
+	username := session.Get("user")
+	if username == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	err := services.GetImportManager().Cancel(fmt.Sprint(username), c.Param("id"))
+	if errors.Is(err, services.ErrImportNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "导入任务已取消",
+	})
+}
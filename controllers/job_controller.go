@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"GoFileShare/services"
+	"errors"
+	"fmt"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// ListJobs 列出当前用户最近的后台任务
+func ListJobs(c *gin.Context) {
+	session := sessions.Default(c)
+	username := session.Get("user")
+	if username == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	manager := services.GetJobManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "后台任务管理器未初始化"})
+		return
+	}
+
+	jobs, err := manager.List(fmt.Sprint(username))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"jobs":   jobs,
+	})
+}
+
+// GetJob 查看单个后台任务的状态、进度和结果
+func GetJob(c *gin.Context) {
+	session := sessions.Default(c)
+	username := session.Get("user")
+	if username == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	manager := services.GetJobManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "后台任务管理器未初始化"})
+		return
+	}
+
+	job, err := manager.Get(fmt.Sprint(username), c.Param("id"))
+	if errors.Is(err, services.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"job":    job,
+	})
+}
+
+// CancelJob 取消当前用户的后台任务
+func CancelJob(c *gin.Context) {
+	session := sessions.Default(c)
+	username := session.Get("user")
+	if username == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+	manager := services.GetJobManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "后台任务管理器未初始化"})
+		return
+	}
+
+	err := manager.Cancel(fmt.Sprint(username), c.Param("id"))
+	if errors.Is(err, services.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "任务已取消",
+	})
+}
+
+// submitJob 提交后台任务并返回 202 和任务ID
+func submitJob(c *gin.Context, kind, owner, title string, params interface{}, extra gin.H) {
+	manager := services.GetJobManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "后台任务管理器未初始化"})
+		return
+	}
+
+	job, err := manager.Submit(kind, owner, title, params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交任务失败: " + err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"status": "accepted",
+		"job_id": job.ID.Hex(),
+		"job":    job,
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	c.JSON(http.StatusAccepted, response)
+}
@@ -2,9 +2,15 @@ package controllers
 
 import (
 	"GoFileShare/services"
+	"errors"
 	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // GetP2PStatus 获取P2P连接状态
@@ -171,12 +177,10 @@ func ConnectP2PPeer(c *gin.Context) {
 	})
 }
 
-// SendP2PMessage 发送P2P消息
+// SendP2PMessage 通过聊天频道发送P2P消息，对方保存到 to_user 的收件箱后返回
 func SendP2PMessage(c *gin.Context) {
-	session := sessions.Default(c)
-	username := session.Get("user")
-	if username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+	caller, ok := sessionCaller(c)
+	if !ok {
 		return
 	}
 
@@ -188,14 +192,17 @@ func SendP2PMessage(c *gin.Context) {
 		return
 	}
 
-	client := services.GetGlobalEnhancedP2PClient()
-	if client == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "P2P客户端未初始化"})
+	inbox := p2pInbox(c)
+	if inbox == nil {
 		return
 	}
 
-	err := client.SendP2PMessage(targetKey, message)
+	env, err := inbox.Send(caller, targetKey, c.PostForm("to_user"), message)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidP2PMessage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "发送消息失败",
 			"message": err.Error(),
@@ -206,6 +213,7 @@ func SendP2PMessage(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":     "success",
 		"message":    "消息发送成功",
+		"message_id": env.ID,
 		"target_key": targetKey,
 		"content":    message,
 	})
@@ -235,6 +243,245 @@ func GetP2PConnections(c *gin.Context) {
 	})
 }
 
+// GetP2PLANPeers 获取局域网中发现的P2P节点
+func GetP2PLANPeers(c *gin.Context) {
+	session := sessions.Default(c)
+	username := session.Get("user")
+	if username == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	client := services.GetGlobalEnhancedP2PClient()
+	if client == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "P2P客户端未初始化"})
+		return
+	}
+
+	peers := client.LANPeers()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"peers":  peers,
+		"count":  len(peers),
+	})
+}
+
+// p2pTransferHTTPStatus 把P2P文件传输的错误转换为 HTTP 状态码
+func p2pTransferHTTPStatus(err error) int {
+	if errors.Is(err, services.ErrP2POfferNotFound) {
+		return http.StatusNotFound
+	}
+	if status := fileTreeHTTPStatus(err); status != http.StatusInternalServerError {
+		return status
+	}
+	return http.StatusBadRequest
+}
+
+// p2pTransferManager 获取P2P文件传输管理器，未启用时返回503
+func p2pTransferManager(c *gin.Context) *services.P2PTransferManager {
+	manager := services.GetP2PTransferManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "P2P客户端未初始化"})
+	}
+	return manager
+}
+
+// OfferP2PFile 把文件节点发送给已连接的P2P节点，等待对方接受
+func OfferP2PFile(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+	targetKey := c.PostForm("target_key")
+	nodeID := c.PostForm("node_id")
+	if targetKey == "" || nodeID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "目标密钥和文件不能为空"})
+		return
+	}
+	manager := p2pTransferManager(c)
+	if manager == nil {
+		return
+	}
+
+	offer, err := manager.OfferFile(caller, targetKey, nodeID, c.PostForm("to_user"))
+	if err != nil {
+		c.JSON(p2pTransferHTTPStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "offer": offer})
+}
+
+// ListP2POffers 列出当前用户发出和收到的文件提议
+func ListP2POffers(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+	manager := p2pTransferManager(c)
+	if manager == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "offers": manager.List(caller)})
+}
+
+// AcceptP2POffer 接受文件提议，文件保存到 parent_id 文件夹
+func AcceptP2POffer(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+	manager := p2pTransferManager(c)
+	if manager == nil {
+		return
+	}
+
+	offer, err := manager.Accept(caller, c.Param("id"), c.PostForm("parent_id"))
+	if err != nil {
+		c.JSON(p2pTransferHTTPStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "offer": offer, "task_id": offer.TaskID})
+}
+
+// RejectP2POffer 拒绝文件提议
+func RejectP2POffer(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+	manager := p2pTransferManager(c)
+	if manager == nil {
+		return
+	}
+
+	if err := manager.Reject(caller, c.Param("id")); err != nil {
+		c.JSON(p2pTransferHTTPStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "已拒绝"})
+}
+
+// CancelP2POffer 取消发出或收到的文件提议，进行中的传输随之停止
+func CancelP2POffer(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+	manager := p2pTransferManager(c)
+	if manager == nil {
+		return
+	}
+
+	if err := manager.Cancel(caller, c.Param("id")); err != nil {
+		c.JSON(p2pTransferHTTPStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "已取消"})
+}
+
+// p2pInbox 获取P2P收件箱，未启用时返回503
+func p2pInbox(c *gin.Context) *services.P2PInbox {
+	inbox := services.GetP2PInbox()
+	if inbox == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "P2P客户端未初始化"})
+	}
+	return inbox
+}
+
+// GetP2PInbox 列出当前用户收到的P2P消息，unread=true 时只列出未读消息
+func GetP2PInbox(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+	inbox := p2pInbox(c)
+	if inbox == nil {
+		return
+	}
+
+	limit, _ := strconv.ParseInt(c.Query("limit"), 10, 64)
+	messages, err := inbox.Inbox(caller, c.Query("unread") == "true", limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "messages": messages, "count": len(messages)})
+}
+
+// MarkP2PInboxRead 把逗号分隔的 ids 标记为已读，ids 为空时标记全部
+func MarkP2PInboxRead(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+	inbox := p2pInbox(c)
+	if inbox == nil {
+		return
+	}
+
+	var ids []string
+	for _, id := range strings.Split(c.PostForm("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	updated, err := inbox.MarkRead(caller, ids)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidP2PMessage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "updated": updated})
+}
+
+// StreamP2PInbox 以 Server-Sent Events 推送当前用户新收到的P2P消息，
+// 断线期间的消息通过 GetP2PInbox 补齐
+func StreamP2PInbox(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+	inbox := p2pInbox(c)
+	if inbox == nil {
+		return
+	}
+
+	messages, unsubscribe := inbox.Subscribe(caller)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 关闭反向代理缓冲
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return false
+			}
+			c.Render(-1, sse.Event{
+				Id:    msg.ID.Hex(),
+				Event: "message",
+				Data:  msg,
+			})
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
 // ShowP2PDebugPage 显示P2P调试页面
 func ShowP2PDebugPage(c *gin.Context) {
 	session := sessions.Default(c)
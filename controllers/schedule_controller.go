@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"GoFileShare/services"
+	"errors"
+	"fmt"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// scheduleUser 读取会话中的用户和权限等级，并确认调度器已初始化
+func scheduleUser(c *gin.Context) (string, int, *services.Scheduler, bool) {
+	session := sessions.Default(c)
+	username := session.Get("user")
+	if username == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return "", 0, nil, false
+	}
+	auth, ok := session.Get("authLevel").(int)
+	if !ok {
+		auth = 0
+	}
+	scheduler := services.GetScheduler()
+	if scheduler == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "定时任务调度器未初始化"})
+		return "", 0, nil, false
+	}
+	return fmt.Sprint(username), auth, scheduler, true
+}
+
+// scheduleError 将调度器错误转换为响应，不存在返回 404，其余视为请求参数错误
+func scheduleError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrScheduleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// CreateSchedule 创建定时下载或同步任务
+func CreateSchedule(c *gin.Context) {
+	username, auth, scheduler, ok := scheduleUser(c)
+	if !ok {
+		return
+	}
+	var spec services.ScheduleSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	schedule, err := scheduler.Create(username, auth, spec)
+	if err != nil {
+		scheduleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"schedule": schedule,
+	})
+}
+
+// ListSchedules 列出当前用户的定时任务
+func ListSchedules(c *gin.Context) {
+	username, _, scheduler, ok := scheduleUser(c)
+	if !ok {
+		return
+	}
+	schedules, err := scheduler.List(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"schedules": schedules,
+	})
+}
+
+// GetSchedule 查看单个定时任务
+func GetSchedule(c *gin.Context) {
+	username, _, scheduler, ok := scheduleUser(c)
+	if !ok {
+		return
+	}
+	schedule, err := scheduler.Get(username, c.Param("id"))
+	if err != nil {
+		scheduleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"schedule": schedule,
+	})
+}
+
+// UpdateSchedule 修改定时任务，可通过 enabled 暂停或恢复
+func UpdateSchedule(c *gin.Context) {
+	username, auth, scheduler, ok := scheduleUser(c)
+	if !ok {
+		return
+	}
+	var spec services.ScheduleSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	schedule, err := scheduler.Update(username, auth, c.Param("id"), spec)
+	if err != nil {
+		scheduleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"schedule": schedule,
+	})
+}
+
+// DeleteSchedule 删除定时任务
+func DeleteSchedule(c *gin.Context) {
+	username, _, scheduler, ok := scheduleUser(c)
+	if !ok {
+		return
+	}
+	if err := scheduler.Delete(username, c.Param("id")); err != nil {
+		scheduleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "定时任务已删除",
+	})
+}
+
+// RunSchedule 立即执行一次定时任务
+func RunSchedule(c *gin.Context) {
+	username, _, scheduler, ok := scheduleUser(c)
+	if !ok {
+		return
+	}
+	run, err := scheduler.RunNow(username, c.Param("id"))
+	if errors.Is(err, services.ErrScheduleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "accepted",
+		"job_id": run.JobID,
+		"run":    run,
+	})
+}
+
+// ListScheduleRuns 查看定时任务最近的执行历史
+func ListScheduleRuns(c *gin.Context) {
+	username, _, scheduler, ok := scheduleUser(c)
+	if !ok {
+		return
+	}
+	runs, err := scheduler.History(username, c.Param("id"))
+	if err != nil {
+		scheduleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"runs":   runs,
+	})
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"GoFileShare/models"
+	"GoFileShare/services"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CreateAPITokenRequest 创建 API 令牌的请求体
+type CreateAPITokenRequest struct {
+	Name     string `json:"name"`
+	TTLHours int    `json:"ttl_hours"` // 有效期（小时），0 表示不过期
+}
+
+// CreateAPIToken 为当前用户创建 API 令牌，令牌明文只在响应中出现一次
+func CreateAPIToken(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "令牌名称不能为空且不超过100个字符"})
+		return
+	}
+	if req.TTLHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "有效期不能为负数"})
+		return
+	}
+
+	plain, token, err := services.IssueAPIToken(caller.Name, req.Name, time.Duration(req.TTLHours)*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建令牌失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"token":   plain,
+		"info":    token,
+		"message": "请妥善保存令牌，之后无法再次查看",
+	})
+}
+
+// ListAPITokens 列出当前用户的 API 令牌，不包含令牌明文
+func ListAPITokens(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := models.ListAPITokens(caller.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"tokens": tokens,
+	})
+}
+
+// DeleteAPIToken 吊销当前用户的 API 令牌
+func DeleteAPIToken(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的令牌ID"})
+		return
+	}
+	deleted, err := models.DeleteAPIToken(caller.Name, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "令牌不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "令牌已吊销",
+	})
+}
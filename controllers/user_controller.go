@@ -3,13 +3,20 @@ package controllers
 import (
 	"GoFileShare/config"
 	"GoFileShare/models"
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"GoFileShare/services"
+	"errors"
 	"fmt"
+	"github.com/donnie4w/go-logger/logger"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
@@ -146,66 +153,14 @@ func ListFileDirByName(c *gin.Context) {
 
 // ListFileDirByID 根据文件节点ID列出文件目录
 func ListFileDirByID(c *gin.Context) {
-	session := sessions.Default(c)
-	username := session.Get("user")
-	authLevel := session.Get("authLevel") // 统一使用 authLevel
-	if username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未登录",
-		})
-		return
-	}
-
-	// 安全地获取权限等级
-	auth, ok := authLevel.(int)
+	caller, ok := sessionCaller(c)
 	if !ok {
-		// 如果获取失败，默认为0（普通用户）
-		auth = 0
-	}
-
-	nodeID := c.Param("id")
-	if nodeID == "" || nodeID == "root" {
-		// 如果是根目录，获取所有父节点为nil的文件
-		fileNodes, err := models.SearchFileNodeByParentID(primitive.NilObjectID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
-			return
-		}
-		checkedFileNodes, err := config.AuthCheck(auth, fileNodes)
-		if err != nil {
-			c.JSON(http.StatusOK, gin.H{
-				"error": err.Error(),
-			})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"files": checkedFileNodes,
-		})
 		return
 	}
 
-	objID, err := primitive.ObjectIDFromHex(nodeID)
+	checkedFileNodes, err := services.ListChildren(caller, c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的文件节点ID",
-		})
-		return
-	}
-
-	// 根据父节点ID获取子文件和文件夹
-	fileNodes, err := models.SearchFileNodeByParentID(objID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
-		return
-	}
-	checkedFileNodes, err := config.AuthCheck(auth, fileNodes)
-	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
+		c.JSON(fileTreeHTTPStatus(err), gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -302,13 +257,86 @@ func StartDownload(c *gin.Context) {
 	}
 
 	if len(downloadTask) > 0 {
-		// 只下载第一个文件
-		c.File(downloadTask[0].Storage.SystemFilePath)
+		// 只下载第一个文件，集群模式下本节点没有副本时从其他节点转发
+		if path := services.LocalFilePath(downloadTask[0]); path != "" {
+			serveFileThrottled(c, path, fmt.Sprint(username))
+		} else if cluster := services.GetCluster(); cluster != nil {
+			serveProxiedDownload(c, cluster, downloadTask[0], fmt.Sprint(username))
+		} else {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+		}
 	} else {
 		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
 	}
 }
 
+// readCloser 组合限速后的 Reader 和原始请求体的 Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// serveFileThrottled 按带宽限制发送文件，支持Range请求
+func serveFileThrottled(c *gin.Context, path, username string) {
+	file, err := os.Open(path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+		return
+	}
+
+	taskID := fmt.Sprintf("http_dl_%d", time.Now().UnixNano())
+	bandwidth := services.GetBandwidthManager()
+	defer bandwidth.ReleaseTask(taskID)
+
+	content := bandwidth.ReadSeeker(c.Request.Context(), file, username, taskID)
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), content)
+}
+
+// serveProxiedDownload 从保存副本的节点读取文件并按带宽限制转发，不支持Range请求
+func serveProxiedDownload(c *gin.Context, cluster *services.Cluster, node config.FileNode, username string) {
+	taskID := fmt.Sprintf("http_dl_%d", time.Now().UnixNano())
+	bandwidth := services.GetBandwidthManager()
+	defer bandwidth.ReleaseTask(taskID)
+
+	pr, pw := io.Pipe()
+	go func() {
+		// 响应头在第一次写入管道之前设置好，读到数据后才会写响应
+		err := cluster.ProxyDownload(c.Request.Context(), node, func(info *filesharev1.FileInfo) {
+			c.Header("Content-Type", "application/octet-stream")
+			c.Header("Content-Length", strconv.FormatInt(info.GetSize(), 10))
+			c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.GetName()}))
+			c.Header("Last-Modified", time.UnixMilli(info.GetModifiedAtUnixMs()).UTC().Format(http.TimeFormat))
+		}, pw)
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	content := bandwidth.Reader(c.Request.Context(), pr, username, taskID)
+	buf := make([]byte, 32*1024)
+	n, err := content.Read(buf)
+	if n == 0 && err != nil && !errors.Is(err, io.EOF) {
+		logger.Errorf("转发下载失败 %s: %v", node.ID.Hex(), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "无法从其他节点读取文件: " + err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+	if n > 0 {
+		if _, err := c.Writer.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+	if _, err := io.Copy(c.Writer, content); err != nil {
+		logger.Errorf("转发下载中断 %s: %v", node.ID.Hex(), err)
+	}
+}
+
 // StartUpload 提供上传接口
 func StartUpload(c *gin.Context) {
 	session := sessions.Default(c)
@@ -328,9 +356,25 @@ func StartUpload(c *gin.Context) {
 		auth = 0
 	}
 
+	// 客户端可以自带上传ID，以便把事件流中的进度对应到自己的界面上
+	uploadID := c.Query("upload_id")
+	if uploadID == "" {
+		uploadID = fmt.Sprintf("http_ul_%d", time.Now().UnixNano())
+	}
+	tracker := services.NewUploadTracker(uploadID, fmt.Sprint(username), "", c.Request.ContentLength)
+
+	// 按带宽限制读取请求体，并推送上传进度
+	bandwidth := services.GetBandwidthManager()
+	defer bandwidth.ReleaseTask(uploadID)
+	c.Request.Body = readCloser{
+		Reader: tracker.Reader(bandwidth.Reader(c.Request.Context(), c.Request.Body, fmt.Sprint(username), uploadID)),
+		Closer: c.Request.Body,
+	}
+
 	// 获取上传的文件
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
+		tracker.Fail(err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "获取文件失败"})
 		return
 	}
@@ -338,9 +382,11 @@ func StartUpload(c *gin.Context) {
 
 	fileName := header.Filename
 	filePath := filepath.Join(config.RootPath, "FileStore", fileName)
+	tracker.SetName(fileName)
 
 	// 确保目录存在
 	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		tracker.Fail(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建目录失败"})
 		return
 	}
@@ -353,6 +399,7 @@ func StartUpload(c *gin.Context) {
 
 	// 保存上传的文件
 	if err := c.SaveUploadedFile(header, filePath); err != nil {
+		tracker.Fail(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
 		return
 	}
@@ -360,9 +407,11 @@ func StartUpload(c *gin.Context) {
 	// 文件保存成功后添加节点记录
 	err = models.AddFileNode(filePath, fileName, false, parentID, auth)
 	if err != nil {
+		tracker.Fail(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "添加文件节点失败: " + err.Error()})
 		return
 	}
+	tracker.Complete()
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":   "success",
@@ -373,46 +422,115 @@ func StartUpload(c *gin.Context) {
 
 // UpdateDir创建文件夹
 func UpdateDir(c *gin.Context) {
-	session := sessions.Default(c)
-	username := session.Get("user")
-	authLevel := session.Get("authLevel")
-	if username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+	caller, ok := sessionCaller(c)
+	if !ok {
 		return
 	}
 
-	// 从URL参数获取父目录ID
-	parentID := c.Param("id")
-	if parentID == "" || parentID == "undefined" || parentID == "null" {
-		parentID = "root"
-	}
-
+	// 父目录ID来自URL参数，为空时创建在根目录
 	addDirName := c.PostForm("addDirName")
-	if addDirName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少文件夹名称"})
+	if _, err := services.CreateFolder(caller, c.Param("id"), addDirName); err != nil {
+		if errors.Is(err, services.ErrMissingFolderName) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建文件夹失败: " + err.Error()})
 		return
 	}
 
-	auth, ok := authLevel.(int)
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "文件夹创建成功",
+		"name":    addDirName,
+	})
+}
+
+// SearchFiles 搜索文件
+func SearchFiles(c *gin.Context) {
+	caller, ok := sessionCaller(c)
 	if !ok {
-		auth = 0
+		return
 	}
 
-	err := models.AddFileNode("", addDirName, true, parentID, auth)
+	// 获取搜索关键词，集群模式下同时搜索其他节点
+	searchTerm := c.Query("q")
+	result, err := services.FederatedSearch(c.Request.Context(), caller, searchTerm)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建文件夹失败: " + err.Error()})
+		if errors.Is(err, services.ErrMissingQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索失败: " + err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"status":  "success",
-		"message": "文件夹创建成功",
-		"name":    addDirName,
+		"files":   result.Hits,
+		"count":   len(result.Hits),
+		"query":   searchTerm,
+		"nodes":   result.Nodes,
+		"partial": result.Partial,
 	})
 }
 
-// SearchFiles 搜索文件
-func SearchFiles(c *gin.Context) {
+// DeleteFile 删除文件或文件夹
+func DeleteFile(c *gin.Context) {
+	caller, ok := sessionCaller(c)
+	if !ok {
+		return
+	}
+
+	// 删除文件节点和所有子节点（如果是文件夹）可能很慢，交给后台任务执行
+	job, fileNode, err := services.DeleteNode(caller, c.Param("id"))
+	if errors.Is(err, services.ErrPermissionDenied) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足，无法删除此文件"})
+		return
+	}
+	if err != nil {
+		c.JSON(fileTreeHTTPStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  "accepted",
+		"job_id":  job.ID.Hex(),
+		"job":     job,
+		"message": "删除任务已提交",
+		"name":    fileNode.Name,
+	})
+}
+
+// sessionCaller 从会话中读取当前用户和权限等级，未登录时返回 401
+func sessionCaller(c *gin.Context) (services.Caller, bool) {
+	session := sessions.Default(c)
+	username := session.Get("user")
+	if username == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return services.Caller{}, false
+	}
+
+	// 安全地获取权限等级，获取失败时默认为0（普通用户）
+	auth, _ := session.Get("authLevel").(int)
+	return services.Caller{Name: fmt.Sprint(username), AuthLevel: auth}, true
+}
+
+// fileTreeHTTPStatus 把文件树服务层的错误转换为 HTTP 状态码
+func fileTreeHTTPStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidNodeID), errors.Is(err, services.ErrMissingFolderName), errors.Is(err, services.ErrMissingQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrNodeNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrJobsUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// ZipFolder 将文件夹打包为zip，完成后压缩包出现在文件夹的同级目录
+func ZipFolder(c *gin.Context) {
 	session := sessions.Default(c)
 	username := session.Get("user")
 	authLevel := session.Get("authLevel")
@@ -421,42 +539,39 @@ func SearchFiles(c *gin.Context) {
 		return
 	}
 
-	// 安全地获取权限等级
 	auth, ok := authLevel.(int)
 	if !ok {
 		auth = 0
 	}
 
-	// 获取搜索关键词
-	searchTerm := c.Query("q")
-	if searchTerm == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少搜索关键词"})
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件节点ID"})
 		return
 	}
 
-	// 调用模型层的搜索函数
-	fileNodes, err := models.SearchFileNodeByNamePattern(searchTerm)
+	fileNodes, err := models.SearchFileNodeByID(objID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "搜索失败: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查找文件失败: " + err.Error()})
 		return
 	}
-
-	// 权限检查
 	checkedFileNodes, err := config.AuthCheck(auth, fileNodes)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "权限检查失败: " + err.Error()})
+	if err != nil || len(checkedFileNodes) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文件夹不存在或权限不足"})
+		return
+	}
+	if !checkedFileNodes[0].Type {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "只能打包文件夹"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"files": checkedFileNodes,
-		"count": len(checkedFileNodes),
-		"query": searchTerm,
-	})
+	submitJob(c, services.JobKindZip, fmt.Sprint(username), "打包 "+checkedFileNodes[0].Name,
+		services.ZipJobParams{NodeID: c.Param("id"), AuthLevel: auth},
+		gin.H{"message": "打包任务已提交", "name": checkedFileNodes[0].Name})
 }
 
-// DeleteFile 删除文件或文件夹
-func DeleteFile(c *gin.Context) {
+// GetFileManifest 返回文件的分块校验清单，供下载端逐块校验
+func GetFileManifest(c *gin.Context) {
 	session := sessions.Default(c)
 	username := session.Get("user")
 	authLevel := session.Get("authLevel")
@@ -465,55 +580,50 @@ func DeleteFile(c *gin.Context) {
 		return
 	}
 
-	// 安全地获取权限等级
 	auth, ok := authLevel.(int)
 	if !ok {
 		auth = 0
 	}
 
-	nodeID := c.Param("id")
-	if nodeID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少文件节点ID"})
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件节点ID"})
 		return
 	}
 
-	objID, err := primitive.ObjectIDFromHex(nodeID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的文件节点ID"})
+	chunkSize, err := strconv.ParseInt(c.DefaultQuery("chunkSize", "1048576"), 10, 64)
+	if err != nil || chunkSize <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的分块大小"})
 		return
 	}
 
-	// 首先查找文件节点
 	fileNodes, err := models.SearchFileNodeByID(objID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查找文件失败: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-
-	if len(fileNodes) == 0 {
+	checkedFileNodes, err := config.AuthCheck(auth, fileNodes)
+	if err != nil || len(checkedFileNodes) == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
 		return
 	}
 
-	fileNode := fileNodes[0]
-
-	// 权限检查
-	checkedFileNodes, err := config.AuthCheck(auth, []config.FileNode{fileNode})
-	if err != nil || len(checkedFileNodes) == 0 {
-		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足，无法删除此文件"})
+	node := checkedFileNodes[0]
+	if node.Type || node.Storage == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "只能为文件生成校验清单"})
 		return
 	}
 
-	// 删除文件节点和所有子节点（如果是文件夹）
-	err = models.DeleteFileNodeWithChildren(nodeID)
+	path := services.LocalFilePath(node)
+	if path == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "文件内容不在本节点"})
+		return
+	}
+	manifest, err := services.BuildFileManifest(path, chunkSize)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除文件节点失败: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成校验清单失败: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "success",
-		"message": "删除成功",
-		"name":    fileNode.Name,
-	})
+	c.JSON(http.StatusOK, manifest)
 }
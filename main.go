@@ -5,6 +5,7 @@ import (
 	"GoFileShare/models"
 	"GoFileShare/routes"
 	"GoFileShare/services"
+	"GoFileShare/utils"
 	"fmt"
 	"github.com/donnie4w/go-logger/logger"
 	"github.com/joho/godotenv"
@@ -15,6 +16,15 @@ import (
 )
 
 func main() {
+	// p2p-server 模式只运行P2P信令服务器，配置文件可选
+	if len(os.Args) > 1 && os.Args[1] == "p2p-server" {
+		_ = godotenv.Load(".env")
+		if err := services.RunP2PServer(); err != nil {
+			log.Fatalf("P2P信令服务器运行失败: %v", err)
+		}
+		return
+	}
+
 	err := godotenv.Load(".env")
 	if err != nil {
 		log.Fatalf("加载配置文件失败: %v", err)
@@ -49,6 +59,41 @@ func main() {
 		}
 	}
 
+	// 初始化带宽限制
+	if err := services.InitBandwidthManager(); err != nil {
+		log.Fatalf("初始化带宽限制失败: %v", err)
+	}
+
+	// 初始化传输服务
+	if err := services.InitTransferService(); err != nil {
+		log.Fatalf("初始化传输服务失败: %v", err)
+	}
+	defer services.GetTransferService().Stop()
+
+	// 初始化后台任务，恢复上次未完成的任务
+	if err := services.InitJobManager(); err != nil {
+		log.Fatalf("初始化后台任务失败: %v", err)
+	}
+	defer services.GetJobManager().Stop()
+
+	// 初始化定时任务，启动时补跑停机期间错过的计划
+	if err := services.InitScheduler(); err != nil {
+		log.Fatalf("初始化定时任务失败: %v", err)
+	}
+	defer services.GetScheduler().Stop()
+
+	// 启动 gRPC 服务，与 Gin 同时运行
+	if err := services.InitGRPCServer(); err != nil {
+		log.Fatalf("启动gRPC服务失败: %v", err)
+	}
+	defer services.StopGRPCServer()
+
+	// 设置了 CLUSTER_NODE_ID 时加入集群，复制文件需要 gRPC 服务
+	if err := services.InitCluster(); err != nil {
+		log.Fatalf("加入集群失败: %v", err)
+	}
+	defer services.StopCluster()
+
 	// 初始化P2P客户端
 	serverAddr := os.Getenv("P2P_SERVER_IP") + ":" + os.Getenv("P2P_SERVER_PORT")
 	err = services.InitP2PClient(serverAddr)
@@ -65,6 +110,10 @@ func main() {
 			} else {
 				log.Println("P2P客户端注册成功")
 			}
+			// 通过P2P连接收发文件节点
+			services.InitP2PTransfer(p2pClient)
+			// 聊天消息的收件箱
+			services.InitP2PInbox(p2pClient)
 		}
 	}
 
@@ -74,8 +123,10 @@ func main() {
 	// 加载HTML模板
 	r.LoadHTMLGlob("views/*.html")
 
-	fmt.Println("服务器启动在 http://0.0.0.0:8080")
-	if err := r.Run("0.0.0.0:8080"); err != nil {
+	// 在同一台机器上运行多个节点时通过 HTTP_ADDR 区分端口
+	httpAddr := utils.GetEnv("HTTP_ADDR", "0.0.0.0:8080")
+	fmt.Printf("服务器启动在 http://%s\n", httpAddr)
+	if err := r.Run(httpAddr); err != nil {
 		log.Fatalf("服务器启动失败: %v", err)
 	}
 }
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"GoFileShare/utils"
 	"fmt"
 	"net/http"
 
@@ -28,3 +29,19 @@ func AuthRequired() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AdminRequired 管理员权限验证，需在 AuthRequired 之后使用
+// 权限等级不低于 ADMIN_AUTH_LEVEL（默认100）的用户视为管理员
+func AdminRequired() gin.HandlerFunc {
+	adminLevel := utils.AdminAuthLevel()
+	return func(c *gin.Context) {
+		session := sessions.Default(c)
+		auth, ok := session.Get("authLevel").(int)
+		if !ok || auth < adminLevel {
+			c.JSON(http.StatusForbidden, gin.H{"error": "需要管理员权限"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
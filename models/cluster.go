@@ -0,0 +1,129 @@
+package models
+
+import (
+	"GoFileShare/config"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// ClusterNode 集群中的一个 GoFileShare 实例，各节点共享文件树，文件内容保存在各自的存储目录
+type ClusterNode struct {
+	ID        string    `bson:"_id" json:"id"`
+	GRPCAddr  string    `bson:"grpc_addr" json:"grpc_addr"` // 其他节点连接本节点 gRPC 服务的地址
+	HTTPAddr  string    `bson:"http_addr" json:"http_addr"` // 本节点 HTTP 服务的地址
+	StartedAt time.Time `bson:"started_at" json:"started_at"`
+	LastSeen  time.Time `bson:"last_seen" json:"last_seen"`
+}
+
+// UpsertClusterNode 注册节点或刷新心跳时间
+func UpsertClusterNode(node *ClusterNode) error {
+	_, err := config.ClusterNodeCollection.UpdateOne(context.TODO(),
+		map[string]interface{}{"_id": node.ID},
+		map[string]interface{}{"$set": map[string]interface{}{
+			"grpc_addr":  node.GRPCAddr,
+			"http_addr":  node.HTTPAddr,
+			"started_at": node.StartedAt,
+			"last_seen":  node.LastSeen,
+		}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// ListClusterNodes 列出 since 之后有心跳的节点
+func ListClusterNodes(since time.Time) ([]ClusterNode, error) {
+	opts := options.Find().SetSort(map[string]interface{}{"_id": 1})
+	cursor, err := config.ClusterNodeCollection.Find(context.TODO(),
+		map[string]interface{}{"last_seen": map[string]interface{}{"$gte": since}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ClusterNode, 0)
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// AddFileReplica 记录文件内容保存在 replica.NodeID 上，已有记录时更新路径。文件节点不存在时返回 false
+func AddFileReplica(id primitive.ObjectID, replica config.Replica) (bool, error) {
+	result, err := config.FileCollection.UpdateOne(context.TODO(),
+		map[string]interface{}{"_id": id, "replicas.node_id": replica.NodeID},
+		map[string]interface{}{"$set": map[string]interface{}{"replicas.$.path": replica.Path}})
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount > 0 {
+		return true, nil
+	}
+	result, err = config.FileCollection.UpdateOne(context.TODO(),
+		map[string]interface{}{"_id": id},
+		map[string]interface{}{"$push": map[string]interface{}{"replicas": replica}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// RemoveFileReplica 删除节点上的副本记录
+func RemoveFileReplica(id primitive.ObjectID, nodeID string) error {
+	_, err := config.FileCollection.UpdateOne(context.TODO(),
+		map[string]interface{}{"_id": id},
+		map[string]interface{}{"$pull": map[string]interface{}{"replicas": map[string]interface{}{"node_id": nodeID}}})
+	return err
+}
+
+// ResetFileReplicas 文件内容在某个节点上被替换后，其他节点的副本已经过期，只保留这一个
+func ResetFileReplicas(id primitive.ObjectID, replica config.Replica) error {
+	_, err := config.FileCollection.UpdateOne(context.TODO(),
+		map[string]interface{}{"_id": id},
+		map[string]interface{}{"$set": map[string]interface{}{"replicas": []config.Replica{replica}}})
+	return err
+}
+
+// ListFilesOnNode 列出在节点上有副本的文件
+func ListFilesOnNode(nodeID string) ([]config.FileNode, error) {
+	return findFileNodes(map[string]interface{}{"type": false, "replicas.node_id": nodeID}, 0)
+}
+
+// ListFilesWithoutReplicas 列出还没有副本记录的文件，例如启用集群模式之前上传的文件
+func ListFilesWithoutReplicas() ([]config.FileNode, error) {
+	return findFileNodes(map[string]interface{}{
+		"type":     false,
+		"replicas": map[string]interface{}{"$in": []interface{}{nil, []interface{}{}}},
+	}, 0)
+}
+
+// ListUnderReplicatedFiles 列出在 liveNodes 上的副本少于 replicas 个的文件
+func ListUnderReplicatedFiles(liveNodes []string, replicas int, limit int64) ([]config.FileNode, error) {
+	liveReplicas := map[string]interface{}{
+		"$filter": map[string]interface{}{
+			"input": map[string]interface{}{"$ifNull": []interface{}{"$replicas", []interface{}{}}},
+			"as":    "r",
+			"cond":  map[string]interface{}{"$in": []interface{}{"$$r.node_id", liveNodes}},
+		},
+	}
+	return findFileNodes(map[string]interface{}{
+		"type": false,
+		"$expr": map[string]interface{}{
+			"$lt": []interface{}{map[string]interface{}{"$size": liveReplicas}, replicas},
+		},
+	}, limit)
+}
+
+func findFileNodes(filter map[string]interface{}, limit int64) ([]config.FileNode, error) {
+	opts := options.Find()
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := config.FileCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var results []config.FileNode
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
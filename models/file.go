@@ -8,12 +8,22 @@ import (
 	"github.com/fatih/color"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
 	"os"
 )
 
+// FileNodeCreatedHook 文件节点创建后调用，集群模式下用于把新文件复制到其他节点
+var FileNodeCreatedHook func(node *config.FileNode)
+
 // AddFileNode 添加文件节点到数据库
 func AddFileNode(path string, name string, nodeType bool, parentID string, authLevel int) error {
+	_, err := CreateFileNode(path, name, nodeType, parentID, authLevel)
+	return err
+}
+
+// CreateFileNode 添加文件节点到数据库，并返回创建的节点
+func CreateFileNode(path string, name string, nodeType bool, parentID string, authLevel int) (*config.FileNode, error) {
 	var parentObjID primitive.ObjectID
 	if parentID == "" || parentID == "root" || parentID == "undefined" || parentID == "null" {
 		// 根目录，使用零值 ObjectID
@@ -23,11 +33,11 @@ func AddFileNode(path string, name string, nodeType bool, parentID string, authL
 		var err error
 		parentObjID, err = primitive.ObjectIDFromHex(parentID)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		// 非法 ID，返回错误
-		return fmt.Errorf("无效的父节点ID: %s", parentID)
+		return nil, fmt.Errorf("无效的父节点ID: %s", parentID)
 	}
 
 	fileNode := &config.FileNode{
@@ -41,9 +51,18 @@ func AddFileNode(path string, name string, nodeType bool, parentID string, authL
 			SystemFilePath: config.GetSystemFilePath(path, config.RootPath),
 		},
 	}
+	if config.NodeID != "" && !nodeType && path != "" {
+		// 集群模式下记录文件内容保存在本节点
+		fileNode.Replicas = []config.Replica{{NodeID: config.NodeID, Path: path}}
+	}
 
-	_, err := config.FileCollection.InsertOne(context.TODO(), fileNode)
-	return err
+	if _, err := config.FileCollection.InsertOne(context.TODO(), fileNode); err != nil {
+		return nil, err
+	}
+	if hook := FileNodeCreatedHook; hook != nil && !nodeType {
+		hook(fileNode)
+	}
+	return fileNode, nil
 }
 
 // DeleteFileNode 删除文件节点
@@ -54,19 +73,25 @@ func DeleteFileNode(nodeID primitive.ObjectID) error {
 
 // DeleteFileNodeWithChildren 文件节点清除时，删除所有子节点和物理文件
 func DeleteFileNodeWithChildren(nodeID string) error {
+	_, err := DeleteFileNodeTree(nodeID)
+	return err
+}
+
+// DeleteFileNodeTree 删除节点及其所有子节点，返回被删除的节点
+func DeleteFileNodeTree(nodeID string) ([]config.FileNode, error) {
 	nodeObjID, err := config.ParseObjectID(nodeID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	deque := utils.NewDeque()
 	tempNodes, err := SearchFileNodeByID(nodeObjID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if len(tempNodes) == 0 {
-		return fmt.Errorf("文件节点不存在")
+		return nil, fmt.Errorf("文件节点不存在")
 	}
 
 	// ��节点加入队列
@@ -83,22 +108,22 @@ func DeleteFileNodeWithChildren(nodeID string) error {
 		// 查找当前节点的所有子节点
 		cursor, err := config.FileCollection.Find(context.TODO(), map[string]interface{}{"parent_id": currentNode.ID})
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		for cursor.Next(context.TODO()) {
 			childNode := &config.FileNode{}
 			if err := cursor.Decode(childNode); err != nil {
 				cursor.Close(context.TODO())
-				return err
+				return nil, err
 			}
 			deque.EnterQueue(*childNode)
 		}
 		cursor.Close(context.TODO())
 	}
 
-	// 删除所有物理文件（从叶子节点开始删除）
-	for i := len(allNodesToDelete) - 1; i >= 0; i-- {
+	// 删除所有物理文件（从叶子节点开始删除），集群模式下由调用方按副本记录删除各节点上的文件
+	for i := len(allNodesToDelete) - 1; i >= 0 && config.NodeID == ""; i-- {
 		node := allNodesToDelete[i]
 
 		// 如果是文件（不是文件夹），删除物理文件
@@ -121,12 +146,12 @@ func DeleteFileNodeWithChildren(nodeID string) error {
 	if len(deleteIDs) > 0 {
 		_, err = config.FileCollection.DeleteMany(context.TODO(), map[string]interface{}{"_id": map[string]interface{}{"$in": deleteIDs}})
 		if err != nil {
-			return err
+			return nil, err
 		}
 		color.Green("成功删除 %d 个文件节点记录", len(deleteIDs))
 	}
 
-	return nil
+	return allNodesToDelete, nil
 }
 
 // SearchFileNodeByID 在数据库中根据ID搜索文件节点
@@ -250,3 +275,21 @@ func InsertFileNode(fileNode *config.FileNode) error {
 	_, err := config.FileCollection.InsertOne(context.TODO(), fileNode)
 	return err
 }
+
+// FileNodeUsesPath 是否有文件节点以 filePath 作为主路径，或登记为 nodeID 上的副本路径
+func FileNodeUsesPath(filePath, nodeID string) (bool, error) {
+	filter := map[string]interface{}{
+		"$or": []interface{}{
+			map[string]interface{}{"path": filePath},
+			map[string]interface{}{"storage.system_file_path": filePath},
+			map[string]interface{}{"replicas": map[string]interface{}{
+				"$elemMatch": map[string]interface{}{"node_id": nodeID, "path": filePath},
+			}},
+		},
+	}
+	count, err := config.FileCollection.CountDocuments(context.TODO(), filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
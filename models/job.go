@@ -0,0 +1,110 @@
+package models
+
+import (
+	"GoFileShare/config"
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// 后台任务状态
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job 持久化的后台任务，例如递归删除、打包和离线下载
+type Job struct {
+	ID          primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Kind        string                 `bson:"kind" json:"kind"`
+	Owner       string                 `bson:"owner" json:"owner"`
+	Title       string                 `bson:"title" json:"title"` // 展示给用户的描述，例如 "删除 report.pdf"
+	State       string                 `bson:"state" json:"state"`
+	Progress    float64                `bson:"progress" json:"progress"`
+	Message     string                 `bson:"message,omitempty" json:"message,omitempty"`
+	Params      map[string]interface{} `bson:"params,omitempty" json:"-"` // 可能包含认证信息，不返回给前端
+	Result      map[string]interface{} `bson:"result,omitempty" json:"result,omitempty"`
+	Error       string                 `bson:"error,omitempty" json:"error,omitempty"`
+	Attempts    int                    `bson:"attempts" json:"attempts"`
+	MaxAttempts int                    `bson:"max_attempts" json:"max_attempts"`
+	CreatedAt   time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time              `bson:"updated_at" json:"updated_at"`
+	StartedAt   time.Time              `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt  time.Time              `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// Finished 判断任务是否已结束
+func (j *Job) Finished() bool {
+	return j.State == JobCompleted || j.State == JobFailed || j.State == JobCancelled
+}
+
+// InsertJob 保存新任务，ID 为空时自动生成
+func InsertJob(job *Job) error {
+	if job.ID.IsZero() {
+		job.ID = primitive.NewObjectID()
+	}
+	now := time.Now()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	_, err := config.JobCollection.InsertOne(context.TODO(), job)
+	return err
+}
+
+// UpdateJob 更新任务的部分字段
+func UpdateJob(id primitive.ObjectID, fields map[string]interface{}) error {
+	fields["updated_at"] = time.Now()
+	_, err := config.JobCollection.UpdateOne(context.TODO(),
+		map[string]interface{}{"_id": id},
+		map[string]interface{}{"$set": fields})
+	return err
+}
+
+// GetJob 根据ID获取任务，不存在时返回 nil
+func GetJob(id primitive.ObjectID) (*Job, error) {
+	var job Job
+	err := config.JobCollection.FindOne(context.TODO(), map[string]interface{}{"_id": id}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListJobsByOwner 列出用户最近的任务，新任务在前
+func ListJobsByOwner(owner string, limit int64) ([]Job, error) {
+	opts := options.Find().SetSort(map[string]interface{}{"created_at": -1}).SetLimit(limit)
+	cursor, err := config.JobCollection.Find(context.TODO(), map[string]interface{}{"owner": owner}, opts)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Job, 0)
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ListUnfinishedJobs 列出排队中和执行中的任务，用于服务重启后恢复
+func ListUnfinishedJobs() ([]Job, error) {
+	filter := map[string]interface{}{
+		"state": map[string]interface{}{"$in": []string{JobQueued, JobRunning}},
+	}
+	opts := options.Find().SetSort(map[string]interface{}{"created_at": 1})
+	cursor, err := config.JobCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var results []Job
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
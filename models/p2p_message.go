@@ -0,0 +1,78 @@
+package models
+
+import (
+	"GoFileShare/config"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// P2PMessage 通过P2P连接收到的消息，按接收用户保存；User 为空的消息由管理员查看
+type P2PMessage struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	MessageID  string             `bson:"message_id" json:"message_id"` // 发送方生成的ID，对方重发时据此去重
+	User       string             `bson:"user" json:"user"`
+	PeerKey    string             `bson:"peer_key" json:"peer_key"`
+	FromUser   string             `bson:"from_user,omitempty" json:"from_user,omitempty"`
+	Channel    string             `bson:"channel" json:"channel"`
+	Type       string             `bson:"type" json:"type"`
+	Payload    string             `bson:"payload" json:"payload"`
+	Read       bool               `bson:"read" json:"read"`
+	ReceivedAt time.Time          `bson:"received_at" json:"received_at"`
+}
+
+// InsertP2PMessage 保存收到的消息，同一对方的同一消息ID只保存一次，返回是否为新消息
+func InsertP2PMessage(msg *P2PMessage) (bool, error) {
+	if msg.ID.IsZero() {
+		msg.ID = primitive.NewObjectID()
+	}
+	filter := map[string]interface{}{"peer_key": msg.PeerKey, "message_id": msg.MessageID}
+	result, err := config.P2PMessageCollection.UpdateOne(context.TODO(), filter,
+		map[string]interface{}{"$setOnInsert": msg},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// 同一消息同时到达时，唯一索引让后插入的一方失败，此时消息已经保存
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+// ListP2PMessages 列出 users 收到的消息，新消息在前
+func ListP2PMessages(users []string, unreadOnly bool, limit int64) ([]P2PMessage, error) {
+	filter := map[string]interface{}{"user": map[string]interface{}{"$in": users}}
+	if unreadOnly {
+		filter["read"] = false
+	}
+	opts := options.Find().SetSort(map[string]interface{}{"received_at": -1}).SetLimit(limit)
+	cursor, err := config.P2PMessageCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]P2PMessage, 0)
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// MarkP2PMessagesRead 把 users 的消息标记为已读，ids 为空时标记全部，返回更新的条数
+func MarkP2PMessagesRead(users []string, ids []primitive.ObjectID) (int64, error) {
+	filter := map[string]interface{}{
+		"user": map[string]interface{}{"$in": users},
+		"read": false,
+	}
+	if len(ids) > 0 {
+		filter["_id"] = map[string]interface{}{"$in": ids}
+	}
+	result, err := config.P2PMessageCollection.UpdateMany(context.TODO(), filter,
+		map[string]interface{}{"$set": map[string]interface{}{"read": true}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
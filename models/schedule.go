@@ -0,0 +1,174 @@
+package models
+
+import (
+	"GoFileShare/config"
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// 错过执行时间（例如服务停机）后的补跑策略
+const (
+	CatchUpSkip = "skip" // 丢弃错过的执行，等待下一次
+	CatchUpOnce = "once" // 合并为一次立即执行
+	CatchUpAll  = "all"  // 每个错过的时间点都补跑一次
+)
+
+// 执行记录的结果
+const (
+	ScheduleRunSubmitted = "submitted" // 已提交后台任务
+	ScheduleRunSkipped   = "skipped"   // 按策略跳过或上一次仍在执行
+	ScheduleRunError     = "error"     // 提交任务失败
+)
+
+// Schedule 按 cron 表达式定期提交后台任务，例如每晚同步供应商文件
+type Schedule struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Owner     string                 `bson:"owner" json:"owner"`
+	Name      string                 `bson:"name" json:"name"`
+	Cron      string                 `bson:"cron" json:"cron"`
+	Timezone  string                 `bson:"timezone,omitempty" json:"timezone,omitempty"` // 为空时使用服务器时区
+	Kind      string                 `bson:"kind" json:"kind"`                             // 提交的后台任务类型
+	Params    map[string]interface{} `bson:"params" json:"params"`
+	AuthLevel int                    `bson:"auth_level" json:"-"` // 创建者的权限等级，执行时以此校验目标文件夹
+	CatchUp   string                 `bson:"catch_up" json:"catch_up"`
+	Enabled   bool                   `bson:"enabled" json:"enabled"`
+	NextRun   time.Time              `bson:"next_run" json:"next_run"`
+	LastRun   time.Time              `bson:"last_run,omitempty" json:"last_run,omitempty"`
+	LastJobID string                 `bson:"last_job_id,omitempty" json:"last_job_id,omitempty"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time              `bson:"updated_at" json:"updated_at"`
+}
+
+// ScheduleRun 定时任务的一次触发记录
+type ScheduleRun struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ScheduleID  primitive.ObjectID `bson:"schedule_id" json:"schedule_id"`
+	ScheduledAt time.Time          `bson:"scheduled_at" json:"scheduled_at"` // 按 cron 计算的计划时间
+	TriggeredAt time.Time          `bson:"triggered_at" json:"triggered_at"` // 实际触发时间
+	Manual      bool               `bson:"manual,omitempty" json:"manual,omitempty"`
+	CatchUp     bool               `bson:"catch_up,omitempty" json:"catch_up,omitempty"` // 服务停机期间错过、启动后补跑
+	Result      string             `bson:"result" json:"result"`
+	Missed      int                `bson:"missed,omitempty" json:"missed,omitempty"` // 被合并或跳过的错过次数
+	JobID       string             `bson:"job_id,omitempty" json:"job_id,omitempty"`
+	Message     string             `bson:"message,omitempty" json:"message,omitempty"`
+	JobState    string             `bson:"-" json:"job_state,omitempty"` // 查询时从任务记录填充
+}
+
+// InsertSchedule 保存新的定时任务
+func InsertSchedule(schedule *Schedule) error {
+	if schedule.ID.IsZero() {
+		schedule.ID = primitive.NewObjectID()
+	}
+	now := time.Now()
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+	_, err := config.ScheduleCollection.InsertOne(context.TODO(), schedule)
+	return err
+}
+
+// UpdateSchedule 更新定时任务的部分字段
+func UpdateSchedule(id primitive.ObjectID, fields map[string]interface{}) error {
+	fields["updated_at"] = time.Now()
+	_, err := config.ScheduleCollection.UpdateOne(context.TODO(),
+		map[string]interface{}{"_id": id},
+		map[string]interface{}{"$set": fields})
+	return err
+}
+
+// GetSchedule 根据ID获取定时任务，不存在时返回 nil
+func GetSchedule(id primitive.ObjectID) (*Schedule, error) {
+	var schedule Schedule
+	err := config.ScheduleCollection.FindOne(context.TODO(), map[string]interface{}{"_id": id}).Decode(&schedule)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// DeleteSchedule 删除定时任务及其执行历史
+func DeleteSchedule(id primitive.ObjectID) error {
+	if _, err := config.ScheduleCollection.DeleteOne(context.TODO(), map[string]interface{}{"_id": id}); err != nil {
+		return err
+	}
+	_, err := config.ScheduleRunCollection.DeleteMany(context.TODO(), map[string]interface{}{"schedule_id": id})
+	return err
+}
+
+// ListSchedulesByOwner 列出用户的定时任务
+func ListSchedulesByOwner(owner string) ([]Schedule, error) {
+	opts := options.Find().SetSort(map[string]interface{}{"created_at": 1})
+	cursor, err := config.ScheduleCollection.Find(context.TODO(), map[string]interface{}{"owner": owner}, opts)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Schedule, 0)
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ListDueSchedules 列出已启用且到达执行时间的定时任务
+func ListDueSchedules(now time.Time) ([]Schedule, error) {
+	filter := map[string]interface{}{
+		"enabled":  true,
+		"next_run": map[string]interface{}{"$lte": now},
+	}
+	cursor, err := config.ScheduleCollection.Find(context.TODO(), filter)
+	if err != nil {
+		return nil, err
+	}
+	var results []Schedule
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// InsertScheduleRun 保存一次触发记录，并只保留最近 keep 条
+func InsertScheduleRun(run *ScheduleRun, keep int64) error {
+	if run.ID.IsZero() {
+		run.ID = primitive.NewObjectID()
+	}
+	if _, err := config.ScheduleRunCollection.InsertOne(context.TODO(), run); err != nil {
+		return err
+	}
+
+	// 找到第 keep+1 新的记录，删除它以及更早的记录
+	opts := options.FindOne().SetSort(map[string]interface{}{"_id": -1}).SetSkip(keep)
+	var oldest ScheduleRun
+	err := config.ScheduleRunCollection.FindOne(context.TODO(),
+		map[string]interface{}{"schedule_id": run.ScheduleID}, opts).Decode(&oldest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = config.ScheduleRunCollection.DeleteMany(context.TODO(), map[string]interface{}{
+		"schedule_id": run.ScheduleID,
+		"_id":         map[string]interface{}{"$lte": oldest.ID},
+	})
+	return err
+}
+
+// ListScheduleRuns 列出定时任务最近的触发记录，新记录在前
+func ListScheduleRuns(scheduleID primitive.ObjectID, limit int64) ([]ScheduleRun, error) {
+	opts := options.Find().SetSort(map[string]interface{}{"_id": -1}).SetLimit(limit)
+	cursor, err := config.ScheduleRunCollection.Find(context.TODO(), map[string]interface{}{"schedule_id": scheduleID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ScheduleRun, 0)
+	if err = cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
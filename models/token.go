@@ -0,0 +1,78 @@
+package models
+
+import (
+	"GoFileShare/config"
+	"database/sql"
+	"time"
+)
+
+// APIToken 用户创建的 API 令牌，令牌本身只在创建时返回一次
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserName   string     `json:"user_name"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	CreateTime time.Time  `json:"create_time"`
+	ExpireTime *time.Time `json:"expire_time"`
+	LastUsed   *time.Time `json:"last_used"`
+}
+
+// CreateAPIToken 保存新令牌的哈希，返回令牌ID
+func CreateAPIToken(userName, name, tokenHash string, expireTime *time.Time) (int64, error) {
+	result, err := config.DB.Exec("INSERT INTO api_token(user_name, name, token_hash, expire_time) VALUES(?, ?, ?, ?)",
+		userName, name, tokenHash, expireTime)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// ListAPITokens 列出用户的全部令牌
+func ListAPITokens(userName string) ([]APIToken, error) {
+	rows, err := config.DB.Query("SELECT id, user_name, name, token_hash, create_time, expire_time, last_used FROM api_token WHERE user_name = ? ORDER BY id", userName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		var token APIToken
+		if err := rows.Scan(&token.ID, &token.UserName, &token.Name, &token.TokenHash, &token.CreateTime, &token.ExpireTime, &token.LastUsed); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// GetAPITokenByHash 根据令牌哈希查找令牌，不存在时返回 nil
+func GetAPITokenByHash(tokenHash string) (*APIToken, error) {
+	token := &APIToken{}
+	err := config.DB.QueryRow("SELECT id, user_name, name, token_hash, create_time, expire_time, last_used FROM api_token WHERE token_hash = ?", tokenHash).Scan(
+		&token.ID, &token.UserName, &token.Name, &token.TokenHash, &token.CreateTime, &token.ExpireTime, &token.LastUsed,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// DeleteAPIToken 删除用户的令牌，令牌不存在或不属于该用户时返回 false
+func DeleteAPIToken(userName string, id int) (bool, error) {
+	result, err := config.DB.Exec("DELETE FROM api_token WHERE id = ? AND user_name = ?", id, userName)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// UpdateAPITokenLastUsed 更新令牌最后使用时间
+func UpdateAPITokenLastUsed(id int) error {
+	_, err := config.DB.Exec("UPDATE api_token SET last_used = NOW() WHERE id = ?", id)
+	return err
+}
@@ -5,25 +5,42 @@ import "time"
 
 // TransferConfig 传输配置
 type TransferConfig struct {
-	WorkerCount int    // 工作协程数量
-	MetaDir     string // 元数据保存目录
-	ChunkSize   int64  // 分块大小
+	WorkerCount  int    // 工作协程数量
+	MetaDir      string // 元数据保存目录
+	ChunkSize    int64  // 分块大小
+	ChunkRetries int    // 单个分块下载或校验失败时的最大重试次数
 }
 
 // TaskMetadata 任务元数据
 type TaskMetadata struct {
-	ID             string        // 任务ID
-	CreatedTime    time.Time     // 创建时间
-	LastModified   time.Time     // 最后修改时间
-	FilePath       string        // 文件路径
-	FileName       string        // 文件名
-	TotalSize      int64         // 总文件大小
-	ChunkSize      int64         // 分块大小
-	WorkerProgress map[int]int64 // 每个线程的进度(线程ID -> 最后处理的块索引)
-	Progress       float64       // 进度百分比
-	Completed      bool          // 是否完成
-	TaskType       string        // 任务类型："download"或"upload"
-	URL            string        // 下载URL或上传目标
+	ID              string        // 任务ID
+	CreatedTime     time.Time     // 创建时间
+	LastModified    time.Time     // 最后修改时间
+	FilePath        string        // 文件路径
+	FileName        string        // 文件名
+	TotalSize       int64         // 总文件大小
+	ChunkSize       int64         // 分块大小
+	WorkerProgress  map[int]int64 // 旧版本每个线程的进度，仅用于兼容旧的元数据文件
+	CompletedChunks []int         // 已完成的块索引
+	Progress        float64       // 进度百分比
+	Completed       bool          // 是否完成
+	TaskType        string        // 任务类型："download"或"upload"
+	URL             string        // 下载URL或上传目标
+	Sources         []string      // 全部数据源
+	ManifestURL     string        // 分块校验清单地址
+	ExpectedHash    string        // 整个文件的期望SHA-256
+	Owner           string        // 发起任务的用户
+	ServerETag      string        // 下载时服务器返回的ETag
+	ServerModified  string        // 下载时服务器返回的Last-Modified
+}
+
+// FileManifest 文件校验清单，记录整体和每个分块的哈希
+type FileManifest struct {
+	FileSize    int64    `json:"file_size"`
+	ChunkSize   int64    `json:"chunk_size"`
+	Algorithm   string   `json:"algorithm"` // 目前仅支持 "sha256"
+	FileHash    string   `json:"file_hash"`
+	ChunkHashes []string `json:"chunk_hashes"`
 }
 
 // TransferTask 文件传输任务接口
@@ -35,3 +52,20 @@ type TransferTask interface {
 	SaveState() error     // 保存状态
 	Resume() error        // 恢复任务
 }
+
+// BandwidthSchedule 按时间段生效的限速规则，时段内覆盖默认速率
+type BandwidthSchedule struct {
+	Start       string `json:"start"`         // 开始时间 "HH:MM"
+	End         string `json:"end"`           // 结束时间 "HH:MM"，早于开始时间表示跨天
+	GlobalRate  int64  `json:"global_rate"`   // 时段内全局速率
+	PerUserRate int64  `json:"per_user_rate"` // 时段内每个用户的速率
+	PerTaskRate int64  `json:"per_task_rate"` // 时段内每个任务的速率
+}
+
+// BandwidthConfig 带宽限制配置，速率单位均为字节/秒，0 表示不限速
+type BandwidthConfig struct {
+	GlobalRate  int64               `json:"global_rate"`
+	PerUserRate int64               `json:"per_user_rate"`
+	PerTaskRate int64               `json:"per_task_rate"`
+	Schedules   []BandwidthSchedule `json:"schedules"`
+}
@@ -60,20 +60,74 @@ func SetupRouter() *gin.Engine {
 		private.POST("/api/InitDownloadTask/:id", controllers.InitDownloadTask)
 		private.GET("/api/listFileDirByName/:name", controllers.ListFileDirByName)
 		private.GET("/api/downloadFile/:id", controllers.StartDownload) // 改为GET方法
+		private.GET("/api/fileManifest/:id", controllers.GetFileManifest)
 		private.POST("/api/updateFile/:id", controllers.StartUpload)
 		private.GET("/api/listFileDirByID/:id", controllers.ListFileDirByID)
 		private.POST("/api/updateDir/:id", controllers.UpdateDir)
+		// 离线下载
+		private.POST("/api/imports", controllers.CreateImport)
+		private.GET("/api/imports", controllers.ListImports)
+		private.DELETE("/api/imports/:id", controllers.CancelImport)
+		// 传输事件流
+		private.GET("/api/events", controllers.StreamEvents)
 		// 搜索功能
 		private.GET("/api/searchFiles", controllers.SearchFiles)
 		// 删除功能
 		private.DELETE("/api/deleteFile/:id", controllers.DeleteFile)
+		// 打包文件夹
+		private.POST("/api/zipFolder/:id", controllers.ZipFolder)
+		// 后台任务
+		private.GET("/api/jobs", controllers.ListJobs)
+		private.GET("/api/jobs/:id", controllers.GetJob)
+		private.DELETE("/api/jobs/:id", controllers.CancelJob)
+		// 定时任务
+		private.POST("/api/schedules", controllers.CreateSchedule)
+		private.GET("/api/schedules", controllers.ListSchedules)
+		private.GET("/api/schedules/:id", controllers.GetSchedule)
+		private.PUT("/api/schedules/:id", controllers.UpdateSchedule)
+		private.DELETE("/api/schedules/:id", controllers.DeleteSchedule)
+		private.POST("/api/schedules/:id/run", controllers.RunSchedule)
+		private.GET("/api/schedules/:id/runs", controllers.ListScheduleRuns)
+		// API 令牌（gRPC 认证）
+		private.POST("/api/tokens", controllers.CreateAPIToken)
+		private.GET("/api/tokens", controllers.ListAPITokens)
+		private.DELETE("/api/tokens/:id", controllers.DeleteAPIToken)
 		// P2P功能
 		private.GET("/api/p2p/status", controllers.GetP2PStatus)
 		private.POST("/api/p2p/register", controllers.RegisterP2PKey)
 		private.GET("/api/p2p/query", controllers.QueryP2PIP)
 		private.POST("/api/p2p/connect", controllers.ConnectP2PPeer)
 		private.POST("/api/p2p/send", controllers.SendP2PMessage)
+		private.GET("/api/p2p/inbox", controllers.GetP2PInbox)
+		private.POST("/api/p2p/inbox/read", controllers.MarkP2PInboxRead)
+		private.GET("/api/p2p/inbox/stream", controllers.StreamP2PInbox)
 		private.GET("/api/p2p/connections", controllers.GetP2PConnections)
+		private.GET("/api/p2p/lan-peers", controllers.GetP2PLANPeers)
+		private.POST("/api/p2p/offers", controllers.OfferP2PFile)
+		private.GET("/api/p2p/offers", controllers.ListP2POffers)
+		private.POST("/api/p2p/offers/:id/accept", controllers.AcceptP2POffer)
+		private.POST("/api/p2p/offers/:id/reject", controllers.RejectP2POffer)
+		private.POST("/api/p2p/offers/:id/cancel", controllers.CancelP2POffer)
+	}
+
+	// 需要管理员权限的路由
+	admin := r.Group("/api/admin")
+	admin.Use(middleware.AuthRequired(), middleware.AdminRequired())
+	{
+		// 带宽限制
+		admin.GET("/bandwidth", controllers.GetBandwidth)
+		admin.PUT("/bandwidth", controllers.UpdateBandwidth)
+		admin.PUT("/bandwidth/users/:name", controllers.SetUserBandwidth)
+		admin.PUT("/bandwidth/tasks/:id", controllers.SetTaskBandwidth)
+		// 传输协程池
+		admin.GET("/transfer/pool", controllers.GetTransferPool)
+		admin.PUT("/transfer/pool", controllers.ResizeTransferPool)
+		// 集群成员
+		admin.GET("/cluster", controllers.GetClusterView)
+		// P2P节点的身份公钥
+		admin.GET("/p2p/peers", controllers.ListP2PTrustedPeers)
+		admin.PUT("/p2p/peers/:key", controllers.PinP2PPeer)
+		admin.DELETE("/p2p/peers/:key", controllers.UnpinP2PPeer)
 	}
 
 	return r
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken 令牌不存在、已过期或所属用户已被删除
+var ErrInvalidToken = errors.New("无效的令牌")
+
+// ErrUnknownUser 用户在本节点不存在
+var ErrUnknownUser = errors.New("用户不存在")
+
+const (
+	apiTokenPrefix    = "gfs_"
+	apiTokenTouchStep = time.Minute // 最后使用时间的更新间隔，避免每次调用都写数据库
+)
+
+// Caller 调用方身份，HTTP 请求来自会话，gRPC 调用来自令牌
+type Caller struct {
+	Name      string
+	AuthLevel int
+	Via       string // 其他节点代表用户调用时为该节点的身份
+	Node      string // 按访问控制规则、凭客户端证书调用的集群节点身份，只由证书认证设置
+}
+
+// IsAdmin 权限等级不低于 ADMIN_AUTH_LEVEL（默认100）的用户视为管理员
+func (c Caller) IsAdmin() bool {
+	return c.AuthLevel >= utils.AdminAuthLevel()
+}
+
+// IssueAPIToken 为用户创建令牌，ttl 为 0 时不过期；明文令牌只在这里返回一次，数据库只保存哈希
+func IssueAPIToken(userName, name string, ttl time.Duration) (string, *models.APIToken, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	plain := apiTokenPrefix + hex.EncodeToString(raw)
+
+	token := &models.APIToken{
+		UserName:   userName,
+		Name:       name,
+		TokenHash:  hashAPIToken(plain),
+		CreateTime: time.Now(),
+	}
+	if ttl > 0 {
+		expireTime := token.CreateTime.Add(ttl)
+		token.ExpireTime = &expireTime
+	}
+	id, err := models.CreateAPIToken(token.UserName, token.Name, token.TokenHash, token.ExpireTime)
+	if err != nil {
+		return "", nil, err
+	}
+	token.ID = int(id)
+	return plain, token, nil
+}
+
+// AuthenticateAPIToken 校验令牌，返回所属用户当前的权限等级，用户降权后立即生效
+func AuthenticateAPIToken(plain string) (Caller, error) {
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return Caller{}, ErrInvalidToken
+	}
+	token, err := models.GetAPITokenByHash(hashAPIToken(plain))
+	if err != nil {
+		return Caller{}, err
+	}
+	if token == nil || (token.ExpireTime != nil && time.Now().After(*token.ExpireTime)) {
+		return Caller{}, ErrInvalidToken
+	}
+	user, err := models.GetUserByName(token.UserName)
+	if err != nil {
+		return Caller{}, err
+	}
+	if user == nil {
+		return Caller{}, ErrInvalidToken
+	}
+
+	if token.LastUsed == nil || time.Since(*token.LastUsed) > apiTokenTouchStep {
+		_ = models.UpdateAPITokenLastUsed(token.ID)
+	}
+	return Caller{Name: user.Name, AuthLevel: user.Status}, nil
+}
+
+// LookupUserCaller 按用户名查询本节点的用户和当前权限等级，用于其他节点代表用户调用
+func LookupUserCaller(name string) (Caller, error) {
+	user, err := models.GetUserByName(name)
+	if err != nil {
+		return Caller{}, err
+	}
+	if user == nil {
+		return Caller{}, ErrUnknownUser
+	}
+	return Caller{Name: user.Name, AuthLevel: user.Status}, nil
+}
+
+func hashAPIToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bandwidthIdleTimeout 用户和任务的令牌桶超过该时间没有读取时被清理，管理员设置的速率保留
+const bandwidthIdleTimeout = 10 * time.Minute
+
+// BandwidthManager 管理全局、用户和任务三级令牌桶
+// 数据依次经过任务、用户、全局三个桶，任意一级不足都会阻塞
+type BandwidthManager struct {
+	mu        sync.RWMutex
+	config    models.BandwidthConfig
+	global    *utils.TokenBucket
+	users     map[string]*utils.TokenBucket
+	tasks     map[string]*utils.TokenBucket
+	userRates map[string]int64 // 管理员为单个用户设置的速率，优先于默认值和时段规则
+	taskRates map[string]int64 // 管理员为单个任务设置的速率
+	stopCh    chan struct{}
+}
+
+// BandwidthStatus 当前生效的限速状态
+type BandwidthStatus struct {
+	Config        models.BandwidthConfig `json:"config"`
+	ActiveGlobal  int64                  `json:"active_global_rate"`
+	ActiveUser    int64                  `json:"active_per_user_rate"`
+	ActiveTask    int64                  `json:"active_per_task_rate"`
+	UserOverrides map[string]int64       `json:"user_overrides"`
+	TaskOverrides map[string]int64       `json:"task_overrides"`
+}
+
+// NewBandwidthManager 创建带宽管理器
+func NewBandwidthManager(config models.BandwidthConfig) (*BandwidthManager, error) {
+	if err := validateBandwidthConfig(config); err != nil {
+		return nil, err
+	}
+	m := &BandwidthManager{
+		config:    config,
+		global:    utils.NewTokenBucket(0),
+		users:     make(map[string]*utils.TokenBucket),
+		tasks:     make(map[string]*utils.TokenBucket),
+		userRates: make(map[string]int64),
+		taskRates: make(map[string]int64),
+		stopCh:    make(chan struct{}),
+	}
+	m.applyRates(time.Now())
+	return m, nil
+}
+
+// Start 启动时段规则检查协程
+func (m *BandwidthManager) Start() {
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				m.applyRates(now)
+				m.evictIdle(now)
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止时段规则检查
+func (m *BandwidthManager) Stop() {
+	close(m.stopCh)
+}
+
+// SetConfig 运行时替换限速配置
+func (m *BandwidthManager) SetConfig(config models.BandwidthConfig) error {
+	if err := validateBandwidthConfig(config); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.config = config
+	m.mu.Unlock()
+	m.applyRates(time.Now())
+	return nil
+}
+
+// SetUserRate 为单个用户设置速率，rate < 0 表示恢复默认
+func (m *BandwidthManager) SetUserRate(user string, rate int64) {
+	m.mu.Lock()
+	if rate < 0 {
+		delete(m.userRates, user)
+	} else {
+		m.userRates[user] = rate
+	}
+	m.mu.Unlock()
+	m.applyRates(time.Now())
+}
+
+// SetTaskRate 为单个任务设置速率，rate < 0 表示恢复默认
+func (m *BandwidthManager) SetTaskRate(taskID string, rate int64) {
+	m.mu.Lock()
+	if rate < 0 {
+		delete(m.taskRates, taskID)
+	} else {
+		m.taskRates[taskID] = rate
+	}
+	m.mu.Unlock()
+	m.applyRates(time.Now())
+}
+
+// ReleaseTask 任务结束后释放其令牌桶
+func (m *BandwidthManager) ReleaseTask(taskID string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tasks, taskID)
+	delete(m.taskRates, taskID)
+}
+
+// Status 返回当前配置和生效速率
+func (m *BandwidthManager) Status() BandwidthStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	global, user, task := m.activeDefaults(time.Now())
+	status := BandwidthStatus{
+		Config:        m.config,
+		ActiveGlobal:  global,
+		ActiveUser:    user,
+		ActiveTask:    task,
+		UserOverrides: make(map[string]int64, len(m.userRates)),
+		TaskOverrides: make(map[string]int64, len(m.taskRates)),
+	}
+	for k, v := range m.userRates {
+		status.UserOverrides[k] = v
+	}
+	for k, v := range m.taskRates {
+		status.TaskOverrides[k] = v
+	}
+	return status
+}
+
+// Reader 返回受限速的读取器，m 为 nil 时原样返回
+func (m *BandwidthManager) Reader(ctx context.Context, r io.Reader, user, taskID string) io.Reader {
+	if m == nil {
+		return r
+	}
+	return utils.NewRateLimitedReader(ctx, r, m.buckets(user, taskID)...)
+}
+
+// ReadSeeker 返回受限速的 ReadSeeker，m 为 nil 时原样返回
+func (m *BandwidthManager) ReadSeeker(ctx context.Context, rs io.ReadSeeker, user, taskID string) io.ReadSeeker {
+	if m == nil {
+		return rs
+	}
+	return utils.NewRateLimitedReadSeeker(ctx, rs, m.buckets(user, taskID)...)
+}
+
+// buckets 按任务、用户、全局顺序返回令牌桶，不存在时按当前规则创建
+func (m *BandwidthManager) buckets(user, taskID string) []*utils.TokenBucket {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, userDefault, taskDefault := m.activeDefaults(time.Now())
+	var result []*utils.TokenBucket
+	if taskID != "" {
+		b, ok := m.tasks[taskID]
+		if !ok {
+			b = utils.NewTokenBucket(m.rateFor(m.taskRates, taskID, taskDefault))
+			m.tasks[taskID] = b
+		}
+		b.Touch()
+		result = append(result, b)
+	}
+	if user != "" {
+		b, ok := m.users[user]
+		if !ok {
+			b = utils.NewTokenBucket(m.rateFor(m.userRates, user, userDefault))
+			m.users[user] = b
+		}
+		b.Touch()
+		result = append(result, b)
+	}
+	return append(result, m.global)
+}
+
+// evictIdle 清理长时间没有读取的用户和任务令牌桶，之后的读取按当前规则重新创建
+func (m *BandwidthManager) evictIdle(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, b := range m.users {
+		if now.Sub(b.LastUsed()) > bandwidthIdleTimeout {
+			delete(m.users, name)
+		}
+	}
+	for id, b := range m.tasks {
+		if now.Sub(b.LastUsed()) > bandwidthIdleTimeout {
+			delete(m.tasks, id)
+		}
+	}
+}
+
+func (m *BandwidthManager) rateFor(overrides map[string]int64, key string, fallback int64) int64 {
+	if rate, ok := overrides[key]; ok {
+		return rate
+	}
+	return fallback
+}
+
+// applyRates 按当前时间重新计算所有令牌桶的速率
+func (m *BandwidthManager) applyRates(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	global, user, task := m.activeDefaults(now)
+	m.global.SetRate(global)
+	for name, b := range m.users {
+		b.SetRate(m.rateFor(m.userRates, name, user))
+	}
+	for id, b := range m.tasks {
+		b.SetRate(m.rateFor(m.taskRates, id, task))
+	}
+}
+
+// activeDefaults 返回当前时刻生效的默认速率，命中时段规则时使用规则中的值
+func (m *BandwidthManager) activeDefaults(now time.Time) (int64, int64, int64) {
+	minute := now.Hour()*60 + now.Minute()
+	for _, schedule := range m.config.Schedules {
+		start, _ := parseClock(schedule.Start)
+		end, _ := parseClock(schedule.End)
+		if inWindow(minute, start, end) {
+			return schedule.GlobalRate, schedule.PerUserRate, schedule.PerTaskRate
+		}
+	}
+	return m.config.GlobalRate, m.config.PerUserRate, m.config.PerTaskRate
+}
+
+func inWindow(minute, start, end int) bool {
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	// 跨天时段，例如 22:00-06:00
+	return minute >= start || minute < end
+}
+
+// parseClock 将 "HH:MM" 解析为当天的分钟数
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("无效的时间 %q，应为 HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateBandwidthConfig(config models.BandwidthConfig) error {
+	if config.GlobalRate < 0 || config.PerUserRate < 0 || config.PerTaskRate < 0 {
+		return fmt.Errorf("速率不能为负数")
+	}
+	for _, schedule := range config.Schedules {
+		if _, err := parseClock(schedule.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(schedule.End); err != nil {
+			return err
+		}
+		if schedule.GlobalRate < 0 || schedule.PerUserRate < 0 || schedule.PerTaskRate < 0 {
+			return fmt.Errorf("时段 %s-%s 的速率不能为负数", schedule.Start, schedule.End)
+		}
+	}
+	return nil
+}
+
+// GlobalBandwidthManager 全局带宽管理器
+var GlobalBandwidthManager *BandwidthManager
+
+// InitBandwidthManager 从环境变量初始化全局带宽管理器
+// BANDWIDTH_SCHEDULES 为 JSON 数组，例如 [{"start":"22:00","end":"06:00"}] 表示夜间不限速
+func InitBandwidthManager() error {
+	var config models.BandwidthConfig
+	var err error
+	if config.GlobalRate, err = strconv.ParseInt(utils.GetEnv("BANDWIDTH_GLOBAL_RATE", "0"), 10, 64); err != nil {
+		return fmt.Errorf("BANDWIDTH_GLOBAL_RATE 无效: %w", err)
+	}
+	if config.PerUserRate, err = strconv.ParseInt(utils.GetEnv("BANDWIDTH_PER_USER_RATE", "0"), 10, 64); err != nil {
+		return fmt.Errorf("BANDWIDTH_PER_USER_RATE 无效: %w", err)
+	}
+	if config.PerTaskRate, err = strconv.ParseInt(utils.GetEnv("BANDWIDTH_PER_TASK_RATE", "0"), 10, 64); err != nil {
+		return fmt.Errorf("BANDWIDTH_PER_TASK_RATE 无效: %w", err)
+	}
+	if schedules := utils.GetEnv("BANDWIDTH_SCHEDULES", ""); schedules != "" {
+		if err := json.Unmarshal([]byte(schedules), &config.Schedules); err != nil {
+			return fmt.Errorf("BANDWIDTH_SCHEDULES 无效: %w", err)
+		}
+	}
+
+	manager, err := NewBandwidthManager(config)
+	if err != nil {
+		return err
+	}
+	manager.Start()
+	GlobalBandwidthManager = manager
+	color.Green("带宽限制已初始化: 全局 %d B/s, 每用户 %d B/s, 每任务 %d B/s, 时段规则 %d 条",
+		config.GlobalRate, config.PerUserRate, config.PerTaskRate, len(config.Schedules))
+	return nil
+}
+
+// GetBandwidthManager 获取全局带宽管理器，未初始化时返回 nil（不限速）
+func GetBandwidthManager() *BandwidthManager {
+	return GlobalBandwidthManager
+}
@@ -0,0 +1,693 @@
+package services
+
+import (
+	"GoFileShare/config"
+	"GoFileShare/models"
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"GoFileShare/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoReplica 文件没有位于在线节点上的副本
+var ErrNoReplica = errors.New("没有可用的副本")
+
+const (
+	clusterRepairBatch = 50  // 每轮检查的副本不足文件数
+	clusterQueueSize   = 256 // 等待拉取的文件数
+)
+
+// Cluster 集群模式：各节点共享同一个 MongoDB 中的文件树，文件内容保存在各自的存储目录。
+// 每个文件记录保存内容的节点（FileNode.Replicas），后台把副本补足到 replicas 个；
+// 下载时本节点没有副本则从其他节点代理。节点是否在线由 Membership 的故障检测决定
+type Cluster struct {
+	self     models.ClusterNode
+	replicas int
+	storeDir string
+	token    string // 调用其他节点时使用的 API 令牌，为空时依靠 mTLS 节点身份
+	interval time.Duration
+	seeds    []string
+	members  *Membership
+
+	queue  chan primitive.ObjectID
+	kick   chan struct{} // 有节点下线时立即开始修复
+	mu     sync.Mutex
+	queued map[primitive.ObjectID]bool
+	conns  map[string]*grpc.ClientConn // 按地址缓存的连接
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewCluster 创建集群成员，调用 Start 后开始心跳、故障检测和副本修复。seeds 是启动时联系的其他节点 gRPC 地址
+func NewCluster(self models.ClusterNode, replicas int, storeDir, token string, interval time.Duration, seeds []string, detector MembershipConfig) *Cluster {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Cluster{
+		self:     self,
+		replicas: replicas,
+		storeDir: storeDir,
+		token:    token,
+		interval: interval,
+		seeds:    seeds,
+		queue:    make(chan primitive.ObjectID, clusterQueueSize),
+		kick:     make(chan struct{}, 1),
+		queued:   make(map[primitive.ObjectID]bool),
+		conns:    make(map[string]*grpc.ClientConn),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	// 启动时间作为 incarnation，重启后的节点总能覆盖之前的下线状态
+	c.members = NewMembership(Member{
+		ID:          self.ID,
+		GRPCAddr:    self.GRPCAddr,
+		HTTPAddr:    self.HTTPAddr,
+		Incarnation: uint64(self.StartedAt.UnixMilli()),
+	}, detector, c.dial)
+	c.members.OnChange = c.memberChanged
+	return c
+}
+
+// NodeID 本节点ID
+func (c *Cluster) NodeID() string {
+	return c.self.ID
+}
+
+// Members 本节点看到的成员列表
+func (c *Cluster) Members() []Member {
+	return c.members.Members()
+}
+
+// Start 注册本节点，联系种子节点和注册表中的节点，登记已有的本地文件，然后启动故障检测和后台修复
+func (c *Cluster) Start() error {
+	if err := c.heartbeat(); err != nil {
+		return fmt.Errorf("注册集群节点失败: %w", err)
+	}
+	c.discover()
+	if err := c.members.Join(c.ctx, c.seeds); err != nil {
+		color.Yellow("集群: 无法联系种子节点，等待其他节点加入: %v", err)
+	}
+	c.adoptLocalFiles()
+
+	c.wg.Add(3)
+	go c.loop()
+	go c.worker()
+	go func() {
+		defer c.wg.Done()
+		c.members.Run(c.ctx)
+	}()
+	return nil
+}
+
+// Stop 通知其他节点本节点退出，停止后台任务并关闭到其他节点的连接
+func (c *Cluster) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	c.members.Leave(ctx)
+	cancel()
+	c.cancel()
+	c.wg.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conn := range c.conns {
+		_ = conn.Close()
+		delete(c.conns, addr)
+	}
+}
+
+func (c *Cluster) loop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.heartbeat(); err != nil {
+				logger.Errorf("集群心跳失败: %v", err)
+				color.Red("集群心跳失败: %v", err)
+				continue
+			}
+			c.discover()
+			c.verifyLocal()
+		case <-c.kick:
+		}
+		c.repair()
+	}
+}
+
+func (c *Cluster) heartbeat() error {
+	c.self.LastSeen = time.Now()
+	return models.UpsertClusterNode(&c.self)
+}
+
+// discover 把注册表中最近三个修复周期内有心跳的节点加入成员表，之后由故障检测跟踪它们的状态
+func (c *Cluster) discover() {
+	nodes, err := models.ListClusterNodes(time.Now().Add(-3 * c.interval))
+	if err != nil {
+		logger.Errorf("查询集群节点失败: %v", err)
+		return
+	}
+	for _, node := range nodes {
+		if node.ID != c.self.ID {
+			c.members.Discover(node.ID, node.GRPCAddr)
+		}
+	}
+}
+
+// memberChanged 节点下线时立即检查副本，不必等待下一个修复周期
+func (c *Cluster) memberChanged(member Member, previous MemberState) {
+	if member.State != MemberDead && member.State != MemberLeft {
+		return
+	}
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+}
+
+// LiveNodes 没有被判定为下线的成员，包括本节点。可疑的节点仍然计入副本数
+func (c *Cluster) LiveNodes() []Member {
+	var live []Member
+	for _, member := range c.members.Members() {
+		if member.Reachable() {
+			live = append(live, member)
+		}
+	}
+	return live
+}
+
+// adoptLocalFiles 启用集群模式之前的文件没有副本记录，本地存在的文件登记为本节点的副本
+func (c *Cluster) adoptLocalFiles() {
+	files, err := models.ListFilesWithoutReplicas()
+	if err != nil {
+		logger.Errorf("查询未登记副本的文件失败: %v", err)
+		return
+	}
+	adopted := 0
+	for _, file := range files {
+		filePath := legacyFilePath(file)
+		if filePath == "" {
+			continue
+		}
+		if _, err := models.AddFileReplica(file.ID, config.Replica{NodeID: c.self.ID, Path: filePath}); err != nil {
+			logger.Errorf("登记副本失败 %s: %v", file.ID.Hex(), err)
+			continue
+		}
+		adopted++
+	}
+	if adopted > 0 {
+		color.Green("集群: 登记了 %d 个本地文件的副本", adopted)
+	}
+}
+
+// verifyLocal 本地文件被删除或磁盘损坏时移除副本记录，由修复流程在其他节点上补足
+func (c *Cluster) verifyLocal() {
+	files, err := models.ListFilesOnNode(c.self.ID)
+	if err != nil {
+		logger.Errorf("查询本地副本失败: %v", err)
+		return
+	}
+	for _, file := range files {
+		replica := file.ReplicaOn(c.self.ID)
+		if info, err := os.Stat(replica.Path); err == nil && info.Mode().IsRegular() {
+			continue
+		}
+		if err := models.RemoveFileReplica(file.ID, c.self.ID); err != nil {
+			logger.Errorf("移除丢失的副本失败 %s: %v", file.ID.Hex(), err)
+			continue
+		}
+		color.Yellow("集群: 副本丢失，等待修复: %s (%s)", file.Name, replica.Path)
+	}
+}
+
+// repair 查找在线副本不足的文件，按排名由本节点负责的文件加入拉取队列
+func (c *Cluster) repair() {
+	live := c.LiveNodes()
+	liveIDs := make([]string, 0, len(live))
+	for _, node := range live {
+		liveIDs = append(liveIDs, node.ID)
+	}
+	target := min(c.replicas, len(live))
+	files, err := models.ListUnderReplicatedFiles(liveIDs, target, clusterRepairBatch)
+	if err != nil {
+		logger.Errorf("查询副本不足的文件失败: %v", err)
+		return
+	}
+	for _, file := range files {
+		if c.responsibleFor(file, live) {
+			c.Enqueue(file.ID)
+		}
+	}
+}
+
+// responsibleFor 没有副本的在线节点按文件ID排名，排在前面、数量等于缺少副本数的节点负责拉取。
+// 每个节点独立计算出相同的结果，不需要协调
+func (c *Cluster) responsibleFor(file config.FileNode, live []Member) bool {
+	for _, node := range c.replicaTargets(file, live) {
+		if node.ID == c.self.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// replicaTargets 返回应当补充副本的节点，只选择状态正常的节点
+func (c *Cluster) replicaTargets(file config.FileNode, live []Member) []Member {
+	holders := 0
+	var candidates []Member
+	for _, node := range live {
+		if file.ReplicaOn(node.ID) != nil {
+			holders++
+		} else if node.Healthy() {
+			candidates = append(candidates, node)
+		}
+	}
+	need := min(c.replicas, len(live)) - holders
+	if holders == 0 || need <= 0 {
+		// 没有在线副本时无法拉取，等待保存副本的节点恢复
+		return nil
+	}
+	key := file.ID.Hex()
+	sort.Slice(candidates, func(i, j int) bool {
+		return rendezvousScore(key, candidates[i].ID) > rendezvousScore(key, candidates[j].ID)
+	})
+	return candidates[:min(need, len(candidates))]
+}
+
+func rendezvousScore(key, nodeID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(nodeID))
+	return h.Sum64()
+}
+
+// Enqueue 把文件加入拉取队列，队列已满时返回 false，由下一轮修复重新发现
+func (c *Cluster) Enqueue(id primitive.ObjectID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.queued[id] {
+		return true
+	}
+	select {
+	case c.queue <- id:
+		c.queued[id] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// worker 逐个拉取文件，避免同名文件同时选中同一个本地路径
+func (c *Cluster) worker() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case id := <-c.queue:
+			if err := c.pull(id); err != nil && c.ctx.Err() == nil {
+				logger.Errorf("拉取副本失败 %s: %v", id.Hex(), err)
+				color.Red("拉取副本失败 %s: %v", id.Hex(), err)
+			}
+			c.mu.Lock()
+			delete(c.queued, id)
+			c.mu.Unlock()
+		}
+	}
+}
+
+// pull 从一个在线副本下载文件到本地并登记副本
+func (c *Cluster) pull(id primitive.ObjectID) error {
+	nodes, err := models.SearchFileNodeByID(id)
+	if err != nil || len(nodes) == 0 || nodes[0].Type {
+		return err
+	}
+	file := nodes[0]
+	if replica := file.ReplicaOn(c.self.ID); replica != nil {
+		if info, err := os.Stat(replica.Path); err == nil && info.Mode().IsRegular() {
+			return nil
+		}
+	}
+
+	holders, err := c.holders(file)
+	if err != nil {
+		return err
+	}
+	_, localPath := utils.GlobalPathReserver.Reserve(c.storeDir, file.Name)
+	defer utils.GlobalPathReserver.Release(localPath)
+	var lastErr error = ErrNoReplica
+	for _, holder := range holders {
+		conn, err := c.dial(holder.GRPCAddr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if _, err := DownloadFile(c.ctx, filesharev1.NewFileServiceClient(conn), id.Hex(), localPath); err != nil {
+			lastErr = fmt.Errorf("从节点 %s 下载失败: %w", holder.ID, err)
+			continue
+		}
+		exists, err := models.AddFileReplica(id, config.Replica{NodeID: c.self.ID, Path: localPath})
+		if err != nil || !exists {
+			// 下载期间文件节点被删除
+			_ = os.Remove(localPath)
+			return err
+		}
+		color.Green("集群: 已从节点 %s 复制 %s", holder.ID, file.Name)
+		return nil
+	}
+	return lastErr
+}
+
+// holders 返回保存了文件副本的其他节点：状态正常的节点按探测延迟排在前面，可疑的节点只作为最后的选择
+func (c *Cluster) holders(file config.FileNode) ([]Member, error) {
+	var holders []Member
+	for _, node := range c.LiveNodes() {
+		if node.ID != c.self.ID && file.ReplicaOn(node.ID) != nil {
+			holders = append(holders, node)
+		}
+	}
+	if len(holders) == 0 {
+		return nil, ErrNoReplica
+	}
+	sort.SliceStable(holders, func(i, j int) bool {
+		if holders[i].Healthy() != holders[j].Healthy() {
+			return holders[i].Healthy()
+		}
+		return holders[i].RTTMillis < holders[j].RTTMillis
+	})
+	return holders, nil
+}
+
+// Announce 本节点保存了新文件后，通知排名靠前的节点立即拉取，不必等待下一轮修复
+func (c *Cluster) Announce(file config.FileNode) {
+	go func() {
+		for _, node := range c.replicaTargets(file, c.LiveNodes()) {
+			conn, err := c.dial(node.GRPCAddr)
+			if err != nil {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+			_, err = filesharev1.NewClusterServiceClient(conn).Replicate(ctx, &filesharev1.ReplicateRequest{NodeId: file.ID.Hex()})
+			cancel()
+			if err != nil {
+				logger.Errorf("通知节点 %s 复制失败: %v", node.ID, err)
+			}
+		}
+	}()
+}
+
+// DropReplicas 文件节点删除后删除各节点上的副本，离线节点上的文件会残留在存储目录中
+func (c *Cluster) DropReplicas(deleted []config.FileNode) {
+	addrs := make(map[string]string)
+	if all, err := models.ListClusterNodes(time.Time{}); err == nil {
+		for _, node := range all {
+			addrs[node.ID] = node.GRPCAddr
+		}
+	} else {
+		logger.Errorf("查询集群节点失败: %v", err)
+	}
+	for _, member := range c.members.Members() {
+		addrs[member.ID] = member.GRPCAddr
+	}
+	for _, file := range deleted {
+		for _, replica := range file.Replicas {
+			if replica.NodeID == c.self.ID {
+				c.removeLocal(replica.Path)
+				continue
+			}
+			conn, err := c.dial(addrs[replica.NodeID])
+			if err == nil {
+				ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+				_, err = filesharev1.NewClusterServiceClient(conn).DropReplica(ctx, &filesharev1.DropReplicaRequest{
+					NodeId: file.ID.Hex(),
+					Path:   replica.Path,
+				})
+				cancel()
+			}
+			if err != nil {
+				logger.Errorf("通知节点 %s 删除副本失败: %v", replica.NodeID, err)
+			}
+		}
+	}
+}
+
+// removeLocal 删除存储目录中的副本文件，拒绝目录之外的路径
+func (c *Cluster) removeLocal(filePath string) bool {
+	rel, err := filepath.Rel(c.storeDir, filePath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		logger.Errorf("删除副本文件失败 %s: %v", filePath, err)
+		return false
+	}
+	return true
+}
+
+// ProxyDownload 从保存副本的其他节点读取文件写入 w，开始写入前用 onInfo 设置响应头。
+// 传输中途节点失败时从下一个副本的相同偏移继续，内容不同（SHA-256 不一致）的副本会被跳过
+func (c *Cluster) ProxyDownload(ctx context.Context, file config.FileNode, onInfo func(*filesharev1.FileInfo), w io.Writer) error {
+	holders, err := c.holders(file)
+	if err != nil {
+		return err
+	}
+	var info *filesharev1.FileInfo
+	var written int64
+	var lastErr error = ErrNoReplica
+	for _, holder := range holders {
+		conn, err := c.dial(holder.GRPCAddr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		stream, err := filesharev1.NewFileServiceClient(conn).Download(ctx, &filesharev1.DownloadRequest{
+			NodeId: file.ID.Hex(),
+			Offset: written,
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		first, err := stream.Recv()
+		if err != nil || first.GetInfo() == nil {
+			lastErr = fmt.Errorf("节点 %s 无法提供文件: %v", holder.ID, err)
+			continue
+		}
+		if info == nil {
+			info = first.GetInfo()
+			onInfo(info)
+		} else if first.GetInfo().GetSha256() != info.GetSha256() || first.GetInfo().GetSize() != info.GetSize() {
+			lastErr = fmt.Errorf("节点 %s 上的副本内容不同", holder.ID)
+			continue
+		}
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				lastErr = fmt.Errorf("从节点 %s 读取中断: %w", holder.ID, err)
+				break
+			}
+			chunk := resp.GetChunk()
+			if chunk == nil || chunk.GetOffset() != written || crc32.Checksum(chunk.GetData(), castagnoli) != chunk.GetCrc32C() {
+				lastErr = fmt.Errorf("节点 %s 返回的分块校验失败", holder.ID)
+				break
+			}
+			if _, err := w.Write(chunk.GetData()); err != nil {
+				// 客户端断开，不再尝试其他节点
+				return err
+			}
+			written += int64(len(chunk.GetData()))
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		color.Yellow("集群: %v，切换到下一个副本", lastErr)
+	}
+	return lastErr
+}
+
+// HealthySources 调整传输任务的数据源顺序：指向已下线节点的 HTTP 地址被移除，指向可疑节点的排到最后。
+// 没有可用的数据源时保持原样，由下载过程报告错误
+func (c *Cluster) HealthySources(sources []string) []string {
+	states := make(map[string]MemberState)
+	for _, member := range c.members.Members() {
+		if member.HTTPAddr != "" && member.ID != c.self.ID {
+			states[strings.ToLower(member.HTTPAddr)] = member.State
+		}
+	}
+	var healthy, suspect []string
+	for _, source := range sources {
+		switch states[sourceHostPort(source)] {
+		case MemberDead, MemberLeft:
+			continue
+		case MemberSuspect:
+			suspect = append(suspect, source)
+		default:
+			healthy = append(healthy, source)
+		}
+	}
+	result := append(healthy, suspect...)
+	if len(result) == 0 {
+		return sources
+	}
+	return result
+}
+
+// sourceHostPort 返回数据源地址的 host:port，省略端口时使用协议的默认端口
+func sourceHostPort(source string) string {
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	return strings.ToLower(net.JoinHostPort(u.Hostname(), port))
+}
+
+// dial 返回到节点的缓存连接
+func (c *Cluster) dial(addr string) (*grpc.ClientConn, error) {
+	if addr == "" {
+		return nil, errors.New("节点地址未知")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := DialFileShare(addr, c.token)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// LocalFilePath 返回文件在本节点上的路径，本节点没有副本时返回空
+func LocalFilePath(node config.FileNode) string {
+	return nodeFilePath(node)
+}
+
+// GlobalCluster 全局集群成员，未启用集群模式时为 nil
+var GlobalCluster *Cluster
+
+// InitCluster 设置了 CLUSTER_NODE_ID 时加入集群，需要在 gRPC 服务之后调用
+func InitCluster() error {
+	nodeID := utils.GetEnv("CLUSTER_NODE_ID", "")
+	if nodeID == "" {
+		return nil
+	}
+	advertise, err := advertiseAddr("CLUSTER_ADVERTISE_ADDR", "GRPC_ADDR", ":18521")
+	if err != nil {
+		return err
+	}
+	httpAdvertise, err := advertiseAddr("CLUSTER_HTTP_ADDR", "HTTP_ADDR", "0.0.0.0:8080")
+	if err != nil {
+		return err
+	}
+	replicas, err := strconv.Atoi(utils.GetEnv("CLUSTER_REPLICAS", "2"))
+	if err != nil || replicas < 1 {
+		return fmt.Errorf("无效的 CLUSTER_REPLICAS: %s", utils.GetEnv("CLUSTER_REPLICAS", "2"))
+	}
+	interval, err := clusterDuration("CLUSTER_REPAIR_INTERVAL", "1m")
+	if err != nil {
+		return err
+	}
+	detector := MembershipConfig{IndirectProbes: 3}
+	if detector.ProbeInterval, err = clusterDuration("CLUSTER_GOSSIP_INTERVAL", "1s"); err != nil {
+		return err
+	}
+	if detector.ProbeTimeout, err = clusterDuration("CLUSTER_PROBE_TIMEOUT", "500ms"); err != nil {
+		return err
+	}
+	if detector.SuspectTimeout, err = clusterDuration("CLUSTER_SUSPECT_TIMEOUT", "5s"); err != nil {
+		return err
+	}
+	var seeds []string
+	for _, seed := range strings.Split(utils.GetEnv("CLUSTER_SEEDS", ""), ",") {
+		if seed = strings.TrimSpace(seed); seed != "" && seed != advertise {
+			seeds = append(seeds, seed)
+		}
+	}
+
+	now := time.Now()
+	self := models.ClusterNode{ID: nodeID, GRPCAddr: advertise, HTTPAddr: httpAdvertise, StartedAt: now, LastSeen: now}
+	storeDir := filepath.Join(config.RootPath, "FileStore")
+	cluster := NewCluster(self, replicas, storeDir, utils.GetEnv("CLUSTER_TOKEN", ""), interval, seeds, detector)
+	config.NodeID = nodeID
+	// 启动过程中其他节点可能已经开始探测本节点
+	GlobalCluster = cluster
+	if err := cluster.Start(); err != nil {
+		GlobalCluster = nil
+		config.NodeID = ""
+		return err
+	}
+	models.FileNodeCreatedHook = func(node *config.FileNode) {
+		cluster.Announce(*node)
+	}
+	color.Green("已加入集群: 节点 %s (%s)，副本数 %d", nodeID, advertise, replicas)
+	return nil
+}
+
+// advertiseAddr 读取其他节点访问本节点使用的地址，未设置时使用主机名和监听地址的端口
+func advertiseAddr(key, listenKey, listenDefault string) (string, error) {
+	if addr := utils.GetEnv(key, ""); addr != "" {
+		return addr, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("获取主机名失败: %w", err)
+	}
+	_, port, err := net.SplitHostPort(utils.GetEnv(listenKey, listenDefault))
+	if err != nil {
+		return "", fmt.Errorf("无效的 %s: %w", listenKey, err)
+	}
+	return net.JoinHostPort(hostname, port), nil
+}
+
+func clusterDuration(key, fallback string) (time.Duration, error) {
+	value, err := time.ParseDuration(utils.GetEnv(key, fallback))
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("无效的 %s: %s", key, utils.GetEnv(key, fallback))
+	}
+	return value, nil
+}
+
+// GetCluster 获取集群成员，未启用集群模式时返回 nil
+func GetCluster() *Cluster {
+	return GlobalCluster
+}
+
+// StopCluster 停止集群后台任务
+func StopCluster() {
+	if GlobalCluster != nil {
+		GlobalCluster.Stop()
+	}
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// 事件类型
+const (
+	EventProgress = "progress" // 进度更新
+	EventState    = "state"    // 状态变化
+	EventComplete = "complete" // 完成
+	EventError    = "error"    // 出错
+)
+
+// 任务状态
+const (
+	TaskStateQueued    = "queued"
+	TaskStateRunning   = "running"
+	TaskStateCompleted = "completed"
+	TaskStateFailed    = "failed"
+	TaskStateCancelled = "cancelled"
+)
+
+// TransferEvent 推送给用户的传输事件
+type TransferEvent struct {
+	ID       uint64    `json:"id"`
+	Type     string    `json:"type"`
+	Kind     string    `json:"kind"` // "download"、"upload" 等
+	TaskID   string    `json:"task_id"`
+	User     string    `json:"-"`
+	Name     string    `json:"name,omitempty"`
+	Progress float64   `json:"progress"`
+	State    string    `json:"state,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// eventHistorySize 每个用户保留的最近事件数，用于断线重连后补发
+const eventHistorySize = 200
+
+// eventSubscriber 单个事件流连接
+type eventSubscriber struct {
+	ch     chan TransferEvent
+	closed bool
+}
+
+// EventHub 按用户分发传输事件
+// 所有发布都在同一把锁内完成并分配递增ID，因此每个订阅者收到的事件严格有序
+type EventHub struct {
+	mu          sync.Mutex
+	nextID      uint64
+	subscribers map[string]map[*eventSubscriber]struct{}
+	history     map[string][]TransferEvent
+}
+
+// NewEventHub 创建事件中心
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: make(map[string]map[*eventSubscriber]struct{}),
+		history:     make(map[string][]TransferEvent),
+	}
+}
+
+// Publish 发布事件，hub 为 nil 或事件没有所属用户时忽略
+func (h *EventHub) Publish(event TransferEvent) {
+	if h == nil || event.User == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	event.ID = h.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	history := append(h.history[event.User], event)
+	if len(history) > eventHistorySize {
+		history = history[len(history)-eventHistorySize:]
+	}
+	h.history[event.User] = history
+
+	for sub := range h.subscribers[event.User] {
+		select {
+		case sub.ch <- event:
+		default:
+			// 消费过慢，断开连接让客户端带 Last-Event-ID 重连补发，而不是丢弃中间事件
+			h.removeLocked(event.User, sub)
+		}
+	}
+}
+
+// Subscribe 订阅用户的事件，lastID 之后的历史事件会先被补发
+func (h *EventHub) Subscribe(user string, lastID uint64) (<-chan TransferEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &eventSubscriber{ch: make(chan TransferEvent, eventHistorySize+64)}
+	if lastID > 0 {
+		for _, event := range h.history[user] {
+			if event.ID > lastID {
+				sub.ch <- event
+			}
+		}
+	}
+	if h.subscribers[user] == nil {
+		h.subscribers[user] = make(map[*eventSubscriber]struct{})
+	}
+	h.subscribers[user][sub] = struct{}{}
+
+	return sub.ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.removeLocked(user, sub)
+	}
+}
+
+func (h *EventHub) removeLocked(user string, sub *eventSubscriber) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	delete(h.subscribers[user], sub)
+	if len(h.subscribers[user]) == 0 {
+		delete(h.subscribers, user)
+	}
+}
+
+// GlobalEventHub 全局事件中心
+var GlobalEventHub = NewEventHub()
+
+// GetEventHub 获取全局事件中心
+func GetEventHub() *EventHub {
+	return GlobalEventHub
+}
+
+// publishTaskEvent 发布传输任务相关的事件
+func publishTaskEvent(task *FileTask, eventType, message string) {
+	task.mu.Lock()
+	event := TransferEvent{
+		Type:     eventType,
+		Kind:     task.TaskType,
+		TaskID:   task.ID,
+		User:     task.Owner,
+		Name:     task.FileName,
+		Progress: task.Progress,
+		State:    task.State,
+		Message:  message,
+	}
+	task.mu.Unlock()
+	GetEventHub().Publish(event)
+}
+
+// progressDispatcher 按顺序调用单个任务的进度回调并推送事件
+// 调用方在持有任务锁时投递，进度因此按计算顺序到达
+type progressDispatcher struct {
+	ch   chan float64
+	done chan struct{}
+}
+
+func newProgressDispatcher(task *FileTask) *progressDispatcher {
+	d := &progressDispatcher{ch: make(chan float64, 64), done: make(chan struct{})}
+	go func() {
+		defer close(d.done)
+		last := -1.0
+		for progress := range d.ch {
+			if progress < last {
+				continue
+			}
+			last = progress
+			if task.OnProgress != nil {
+				task.OnProgress(progress)
+			}
+			GetEventHub().Publish(TransferEvent{
+				Type:     EventProgress,
+				Kind:     task.TaskType,
+				TaskID:   task.ID,
+				User:     task.Owner,
+				Name:     task.FileName,
+				Progress: progress,
+				State:    TaskStateRunning,
+			})
+		}
+	}()
+	return d
+}
+
+// post 投递进度，队列满时丢弃，后续更高的进度会覆盖
+func (d *progressDispatcher) post(progress float64) {
+	select {
+	case d.ch <- progress:
+	default:
+	}
+}
+
+// close 等待已投递的进度全部处理完
+func (d *progressDispatcher) close() {
+	close(d.ch)
+	<-d.done
+}
+
+// UploadTracker 跟踪一次HTTP上传的进度并推送事件
+type UploadTracker struct {
+	mu       sync.Mutex
+	id       string
+	user     string
+	name     string
+	total    int64
+	read     int64
+	lastSent time.Time
+}
+
+// NewUploadTracker 创建上传跟踪器，total 未知时传 -1
+func NewUploadTracker(id, user, name string, total int64) *UploadTracker {
+	t := &UploadTracker{id: id, user: user, name: name, total: total}
+	t.publish(EventState, TaskStateRunning, 0, "")
+	return t
+}
+
+// Reader 包装请求体，读取时推送进度
+func (t *UploadTracker) Reader(r io.Reader) io.Reader {
+	return &trackingReader{r: r, tracker: t}
+}
+
+// SetName 文件名在解析表单后才知道
+func (t *UploadTracker) SetName(name string) {
+	t.mu.Lock()
+	t.name = name
+	t.mu.Unlock()
+}
+
+// Complete 上传完成
+func (t *UploadTracker) Complete() {
+	t.publish(EventComplete, TaskStateCompleted, 100, "")
+}
+
+// Fail 上传失败
+func (t *UploadTracker) Fail(err error) {
+	t.publish(EventError, TaskStateFailed, t.progress(), err.Error())
+}
+
+func (t *UploadTracker) add(n int) {
+	t.mu.Lock()
+	t.read += int64(n)
+	// 限制推送频率，避免大文件上传产生过多事件
+	due := time.Since(t.lastSent) >= 250*time.Millisecond
+	if due {
+		t.lastSent = time.Now()
+	}
+	t.mu.Unlock()
+	if due {
+		t.publish(EventProgress, TaskStateRunning, t.progress(), "")
+	}
+}
+
+func (t *UploadTracker) progress() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.total <= 0 {
+		return 0
+	}
+	progress := float64(t.read) / float64(t.total) * 100
+	if progress > 99 {
+		// 请求体读完后还要保存文件和写数据库，完成事件再报 100
+		progress = 99
+	}
+	return progress
+}
+
+func (t *UploadTracker) publish(eventType, state string, progress float64, message string) {
+	t.mu.Lock()
+	event := TransferEvent{
+		Type:     eventType,
+		Kind:     "upload",
+		TaskID:   t.id,
+		User:     t.user,
+		Name:     t.name,
+		Progress: progress,
+		State:    state,
+		Message:  message,
+	}
+	t.mu.Unlock()
+	GetEventHub().Publish(event)
+}
+
+type trackingReader struct {
+	r       io.Reader
+	tracker *UploadTracker
+}
+
+func (r *trackingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.tracker.add(n)
+	}
+	return n, err
+}
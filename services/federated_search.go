@@ -0,0 +1,207 @@
+package services
+
+import (
+	"GoFileShare/config"
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"GoFileShare/utils"
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SearchHit 联邦搜索的一条结果，字段与文件列表一致，另外标明来源节点
+type SearchHit struct {
+	ID         string   `json:"_id"`
+	ParentID   string   `json:"parent_id,omitempty"`
+	Name       string   `json:"name"`
+	Type       bool     `json:"type"`
+	Path       string   `json:"path"` // 只有本节点的结果有路径
+	AuthLevel  int      `json:"auth_level"`
+	Size       int64    `json:"size"`
+	ModifiedAt int64    `json:"modified_at_unix_ms,omitempty"`
+	Origin     string   `json:"origin"`            // 返回该结果的节点，单机运行时为空
+	Origins    []string `json:"origins,omitempty"` // 共享文件树时多个节点会返回同一个结果
+	Score      float64  `json:"score"`
+}
+
+// NodeSearchStatus 一个节点的搜索情况
+type NodeSearchStatus struct {
+	Node       string `json:"node"`
+	Count      int    `json:"count"`
+	TookMillis int64  `json:"took_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// FederatedSearchResult 合并后的搜索结果
+type FederatedSearchResult struct {
+	Hits    []SearchHit        `json:"files"`
+	Nodes   []NodeSearchStatus `json:"nodes"`
+	Partial bool               `json:"partial"` // 有节点超时或失败，结果可能不完整
+}
+
+// FederatedSearch 搜索本节点，集群模式下同时向状态正常的其他节点并行搜索，在 SEARCH_FANOUT_TIMEOUT（默认2s）内合并、去重并排序。
+// 其他节点按调用方在该节点的权限等级过滤结果，超时或失败的节点记录在 Nodes 中，不影响其他结果
+func FederatedSearch(ctx context.Context, caller Caller, query string) (*FederatedSearchResult, error) {
+	start := time.Now()
+	local, err := SearchNodes(caller, query)
+	if err != nil {
+		return nil, err
+	}
+	localHits := make([]SearchHit, 0, len(local))
+	for _, fileNode := range local {
+		hit := searchHitFromProto(fileNodeToProto(fileNode))
+		hit.Path = fileNode.Path
+		localHits = append(localHits, hit)
+	}
+	result := &FederatedSearchResult{
+		Nodes: []NodeSearchStatus{{Node: config.NodeID, Count: len(localHits), TookMillis: time.Since(start).Milliseconds()}},
+	}
+	hits := [][]SearchHit{localHits}
+
+	cluster := GetCluster()
+	if cluster != nil {
+		remote, statuses := cluster.searchPeers(ctx, caller, query)
+		hits = append(hits, remote...)
+		result.Nodes = append(result.Nodes, statuses...)
+	}
+	for _, status := range result.Nodes {
+		if status.Error != "" {
+			result.Partial = true
+		}
+	}
+	result.Hits = mergeSearchHits(query, hits)
+	return result, nil
+}
+
+// searchPeers 代表调用方向其他在线节点搜索，每个节点的结果单独返回
+func (c *Cluster) searchPeers(ctx context.Context, caller Caller, query string) ([][]SearchHit, []NodeSearchStatus) {
+	timeout, err := time.ParseDuration(utils.GetEnv("SEARCH_FANOUT_TIMEOUT", "2s"))
+	if err != nil || timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var peers []Member
+	for _, member := range c.members.Members() {
+		if member.ID != c.self.ID && member.Healthy() {
+			peers = append(peers, member)
+		}
+	}
+	hits := make([][]SearchHit, len(peers))
+	statuses := make([]NodeSearchStatus, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer Member) {
+			defer wg.Done()
+			start := time.Now()
+			statuses[i].Node = peer.ID
+			nodes, err := c.searchPeer(onBehalfOf(ctx, caller.Name), peer, query)
+			statuses[i].TookMillis = time.Since(start).Milliseconds()
+			if err != nil {
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					statuses[i].Error = "超时"
+				} else {
+					statuses[i].Error = err.Error()
+				}
+				return
+			}
+			for _, node := range nodes {
+				hit := searchHitFromProto(node)
+				if hit.Origin == "" {
+					hit.Origin = peer.ID
+				}
+				hits[i] = append(hits[i], hit)
+			}
+			statuses[i].Count = len(hits[i])
+		}(i, peer)
+	}
+	wg.Wait()
+	return hits, statuses
+}
+
+func (c *Cluster) searchPeer(ctx context.Context, peer Member, query string) ([]*filesharev1.FileNode, error) {
+	conn, err := c.dial(peer.GRPCAddr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := filesharev1.NewFileTreeServiceClient(conn).SearchFiles(ctx, &filesharev1.SearchFilesRequest{Query: query})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetNodes(), nil
+}
+
+func searchHitFromProto(node *filesharev1.FileNode) SearchHit {
+	return SearchHit{
+		ID:         node.GetId(),
+		ParentID:   node.GetParentId(),
+		Name:       node.GetName(),
+		Type:       node.GetIsFolder(),
+		AuthLevel:  int(node.GetAuthLevel()),
+		Size:       node.GetSize(),
+		ModifiedAt: node.GetModifiedAtUnixMs(),
+		Origin:     node.GetOriginNode(),
+	}
+}
+
+// mergeSearchHits 按节点ID去重，保留最先出现的结果（本节点的排在最前），再按匹配程度排序：
+// 名称完全相同 > 前缀匹配 > 包含，同分时文件夹在前，然后按修改时间从新到旧
+func mergeSearchHits(query string, groups [][]SearchHit) []SearchHit {
+	merged := make([]SearchHit, 0)
+	index := make(map[string]int)
+	for _, group := range groups {
+		for _, hit := range group {
+			if i, ok := index[hit.ID]; ok {
+				if hit.Origin != "" && !containsString(merged[i].Origins, hit.Origin) {
+					merged[i].Origins = append(merged[i].Origins, hit.Origin)
+				}
+				continue
+			}
+			if hit.Origin != "" {
+				hit.Origins = []string{hit.Origin}
+			}
+			hit.Score = searchScore(hit.Name, query)
+			index[hit.ID] = len(merged)
+			merged = append(merged, hit)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		a, b := merged[i], merged[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Type != b.Type {
+			return a.Type
+		}
+		if a.ModifiedAt != b.ModifiedAt {
+			return a.ModifiedAt > b.ModifiedAt
+		}
+		return a.Name < b.Name
+	})
+	return merged
+}
+
+// searchScore 名称与关键词的匹配程度，范围 (0, 1]
+func searchScore(name, query string) float64 {
+	n, q := strings.ToLower(name), strings.ToLower(query)
+	base := strings.TrimSuffix(n, path.Ext(n))
+	switch {
+	case q == "":
+		return 0
+	case n == q || base == q:
+		return 1
+	case strings.HasPrefix(n, q):
+		return 0.8
+	case strings.Contains(n, q):
+		// 关键词占名称的比例越大越相关
+		return 0.5 + 0.2*float64(len(q))/float64(len(n))
+	}
+	// 正则匹配但不是字面包含
+	return 0.3
+}
@@ -2,7 +2,6 @@ package services
 
 import (
 	"GoFileShare/models"
-	"GoFileShare/proto"
 	"GoFileShare/utils"
 	"context"
 	"encoding/json"
@@ -10,15 +9,11 @@ import (
 	"fmt"
 	"github.com/donnie4w/go-logger/logger"
 	"github.com/fatih/color"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/reflection"
-	"io"
 	"math"
-	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -35,26 +30,123 @@ type TransferService struct {
 // FileTask 文件传输任务
 type FileTask struct {
 	ID             string
-	URL            string        // 下载URL或上传目标
-	FilePath       string        // 本地文件路径
-	FileName       string        // 文件名
-	FileSize       int64         // 文件大小
-	ChunkSize      int64         // 分块大小
-	WorkerProgress map[int]int64 // 核心状态：workerID -> 已完成的块数
+	URL            string   // 下载URL或上传目标
+	Sources        []string // 全部数据源，第一个为 URL，其余为镜像、其他节点或P2P对端
+	FilePath       string   // 本地文件路径
+	FileName       string   // 文件名
+	FileSize       int64    // 文件大小，-1 表示服务器没有给出长度
+	ChunkSize      int64    // 分块大小
 	Progress       float64
 	Completed      bool
-	TaskType       string // "download" 或 "upload"
+	State          string      // 任务状态：queued、running、completed、failed、cancelled
+	TaskType       string      // "download" 或 "upload"
+	Owner          string      // 发起任务的用户，用于按用户限速
+	ManifestURL    string      // 分块校验清单地址，为空时不做分块哈希校验
+	ExpectedHash   string      // 整个文件的期望SHA-256，为空时不做整体校验
+	Headers        http.Header // 每个请求附带的请求头，例如认证信息
+	ETag           string      // 探测时服务器返回的ETag，续传时用于 If-Range
+	LastModified   string      // 探测时服务器返回的Last-Modified，没有强ETag时用于 If-Range
+	RangeSupported bool        // 服务器是否支持Range请求，不支持或大小未知时改为单流下载
 	OnProgress     func(float64)
 	OnComplete     func(*FileTask)
 	OnError        func(*FileTask, error)
 	cancel         chan struct{}
+	client         *http.Client // 任务独立的客户端，保存Cookie并应用重定向策略
+	streamURL      string       // 单流下载使用的数据源
+	manifest       *models.FileManifest
+	mu             sync.Mutex // 保护 chunkDone、Progress、State、sources 和 dispatcher
+	chunkDone      []bool     // 核心状态：每个块是否已完成
+	sources        *sourceSet
+	dispatcher     *progressDispatcher
 }
 
-type UploadTask struct {
-	filePath string
-	fileName string
-	url      string
-	fileSize int64
+// errTaskCancelled 任务被取消
+var errTaskCancelled = errors.New("任务已取消")
+
+// withCancel 返回在任务取消或 parent 结束时结束的 ctx，用于中止进行中的请求和限速等待
+func (t *FileTask) withCancel(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-t.cancel:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// DownloadOptions 下载任务的可选参数
+type DownloadOptions struct {
+	ManifestURL  string         // 分块校验清单地址
+	ExpectedHash string         // 整个文件的期望SHA-256，为空时使用清单中的值
+	Owner        string         // 发起任务的用户
+	Sources      []string       // 额外的数据源：HTTP镜像、其他GoFileShare节点或已注册协议的P2P对端
+	Headers      http.Header    // 附加请求头，例如 Authorization
+	Cookies      []*http.Cookie // 初始Cookie，服务器在重定向和后续请求中设置的Cookie会自动保存
+	MaxRedirects int            // 最大重定向次数，0 使用默认值，负数表示不跟随重定向
+	Priority     utils.Priority // 调度优先级，零值为低优先级
+	TaskID       string         // 固定的任务ID，重新提交同一ID时从保存的进度继续；为空时自动生成
+	PublicOnly   bool           // 只连接公网地址，用于用户提供的下载地址；每次重定向和建立连接时都会检查
+}
+
+// chunkCount 返回任务的总块数
+func (t *FileTask) chunkCount() int {
+	if t.ChunkSize <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(t.FileSize) / float64(t.ChunkSize)))
+}
+
+// completedChunks 返回已完成块的索引
+func (t *FileTask) completedChunks() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var result []int
+	for index, done := range t.chunkDone {
+		if done {
+			result = append(result, index)
+		}
+	}
+	return result
+}
+
+// markChunkDone 标记块完成并返回最新进度，进度回调由任务的分发协程按序执行
+func (t *FileTask) markChunkDone(index int) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.chunkDone[index] = true
+	completed := 0
+	for _, done := range t.chunkDone {
+		if done {
+			completed++
+		}
+	}
+	t.Progress = float64(completed) / float64(len(t.chunkDone)) * 100
+	// 在锁内投递，保证进度按计算顺序送达
+	if t.dispatcher != nil {
+		t.dispatcher.post(t.Progress)
+	}
+	return t.Progress
+}
+
+// setState 更新任务状态并推送状态事件
+func (t *FileTask) setState(state string) {
+	t.mu.Lock()
+	t.State = state
+	t.mu.Unlock()
+	publishTaskEvent(t, EventState, "")
+}
+
+// SourceStatus 返回各数据源的实测速度和状态
+func (t *FileTask) SourceStatus() []SourceStatus {
+	t.mu.Lock()
+	sources := t.sources
+	t.mu.Unlock()
+	if sources == nil {
+		return nil
+	}
+	return sources.snapshot()
 }
 
 // NewTransferService 创建传输服务
@@ -119,20 +211,35 @@ func (s *TransferService) saveTaskStatus(task *FileTask) error {
 		return nil
 	}
 
+	if task.TaskType == "upload" {
+		// 对端拉取的上传任务没有需要续传的本地状态
+		return nil
+	}
+
 	metaFile := filepath.Join(s.config.MetaDir, task.ID+".json")
+	completed := task.completedChunks()
+	task.mu.Lock()
+	progress := task.Progress
+	task.mu.Unlock()
 	metaData := models.TaskMetadata{
-		ID:             task.ID,
-		CreatedTime:    time.Now(), // Can be optimized to store initial time
-		LastModified:   time.Now(),
-		FilePath:       task.FilePath,
-		FileName:       task.FileName,
-		TotalSize:      task.FileSize,
-		ChunkSize:      task.ChunkSize,
-		WorkerProgress: task.WorkerProgress, // 保存新的状态
-		Progress:       task.Progress,
-		Completed:      task.Completed,
-		TaskType:       task.TaskType,
-		URL:            task.URL,
+		ID:              task.ID,
+		CreatedTime:     time.Now(), // Can be optimized to store initial time
+		LastModified:    time.Now(),
+		FilePath:        task.FilePath,
+		FileName:        task.FileName,
+		TotalSize:       task.FileSize,
+		ChunkSize:       task.ChunkSize,
+		CompletedChunks: completed, // 保存新的状态
+		Progress:        progress,
+		Completed:       task.Completed,
+		TaskType:        task.TaskType,
+		URL:             task.URL,
+		Sources:         task.Sources,
+		ManifestURL:     task.ManifestURL,
+		ExpectedHash:    task.ExpectedHash,
+		Owner:           task.Owner,
+		ServerETag:      task.ETag,
+		ServerModified:  task.LastModified,
 	}
 
 	jsonData, err := json.MarshalIndent(metaData, "", "  ")
@@ -150,7 +257,7 @@ func (s *TransferService) loadTaskState(task *FileTask) error {
 	if err != nil {
 		if os.IsNotExist(err) {
 			// 文件不存在，初始化一个新的任务状态
-			task.WorkerProgress = make(map[int]int64)
+			task.chunkDone = make([]bool, task.chunkCount())
 			return nil
 		}
 		return err
@@ -161,16 +268,50 @@ func (s *TransferService) loadTaskState(task *FileTask) error {
 		return err
 	}
 
+	// 服务器上的文件已变化，之前下载的内容作废
+	if (meta.ServerETag != "" && meta.ServerETag != task.ETag) || (meta.ServerModified != "" && meta.ServerModified != task.LastModified) {
+		color.Yellow("Task %s: 服务器上的文件已变化，重新下载", task.ID)
+		if err := os.Remove(task.FilePath + ".download"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		task.chunkDone = make([]bool, task.chunkCount())
+		return nil
+	}
+
 	// 恢复状态
-	task.WorkerProgress = meta.WorkerProgress
-	if task.WorkerProgress == nil { // 兼容旧的元数据文件
-		task.WorkerProgress = make(map[int]int64)
+	total := task.chunkCount()
+	task.chunkDone = make([]bool, total)
+	for _, index := range meta.CompletedChunks {
+		if index >= 0 && index < total {
+			task.chunkDone[index] = true
+		}
+	}
+	if meta.CompletedChunks == nil && len(meta.WorkerProgress) > 0 { // 兼容旧的元数据文件
+		restoreWorkerProgress(task.chunkDone, meta.WorkerProgress, s.config.WorkerCount)
+	}
+	if len(meta.Sources) > 0 {
+		task.Sources = meta.Sources
 	}
 	task.Progress = meta.Progress
 	task.Completed = meta.Completed
 	return nil
 }
 
+// restoreWorkerProgress 将旧版本按 worker 连续分段记录的进度转换为逐块状态
+func restoreWorkerProgress(chunkDone []bool, workerProgress map[int]int64, workerCount int) {
+	if workerCount <= 0 {
+		return
+	}
+	total := len(chunkDone)
+	chunksPerWorker := total / workerCount
+	for workerID, count := range workerProgress {
+		start := workerID * chunksPerWorker
+		for index := start; index < start+int(count) && index < total; index++ {
+			chunkDone[index] = true
+		}
+	}
+}
+
 // periodicStatusSave 定期保存状态
 func (s *TransferService) periodicStatusSave() {
 	ticker := time.NewTicker(5 * time.Second)
@@ -180,25 +321,43 @@ func (s *TransferService) periodicStatusSave() {
 		select {
 		case <-ticker.C:
 			s.jobsMutex.RLock()
+			tasks := make([]*FileTask, 0, len(s.activeJobs))
 			for _, task := range s.activeJobs {
-				// 复制一份以在锁外保存
-				taskCopy := *task
-				err := s.saveTaskStatus(&taskCopy)
+				tasks = append(tasks, task)
+			}
+			s.jobsMutex.RUnlock()
+
+			// 在锁外保存，saveTaskStatus 内部会再次获取读锁
+			for _, task := range tasks {
+				err := s.saveTaskStatus(task)
 				if err != nil {
 					logger.Error("Error saving task status for %s: %v", task.ID, err)
 					color.Red("Error saving task status for %s: %v", task.ID, err)
-					return
 				}
 			}
-			s.jobsMutex.RUnlock()
 		case <-s.stopCh:
 			return
 		}
 	}
 }
 
+// containsString 判断切片中是否包含指定字符串
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
 // AddDownloadTask 添加下载任务
 func (s *TransferService) AddDownloadTask(url, filePath string, onProgress func(float64), onComplete func(*FileTask), onError func(*FileTask, error)) string {
+	return s.AddDownloadTaskWithOptions(url, filePath, DownloadOptions{Priority: utils.PriorityNormal}, onProgress, onComplete, onError)
+}
+
+// AddDownloadTaskWithOptions 添加带校验等可选参数的下载任务
+func (s *TransferService) AddDownloadTaskWithOptions(url, filePath string, opts DownloadOptions, onProgress func(float64), onComplete func(*FileTask), onError func(*FileTask, error)) string {
 	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		logger.Error("Error creating directory for %s: %v", filePath, err)
 		color.Red("Error creating directory for %s: %s", filePath, err)
@@ -208,37 +367,96 @@ func (s *TransferService) AddDownloadTask(url, filePath string, onProgress func(
 		return ""
 	}
 
-	resp, err := http.Head(url)
+	sources := []string{url}
+	for _, source := range opts.Sources {
+		if source != "" && !containsString(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+	if cluster := GetCluster(); cluster != nil {
+		// 跳过指向已下线集群节点的数据源
+		sources = cluster.HealthySources(sources)
+	}
+
+	client, err := newTaskHTTPClient(url, opts)
 	if err != nil {
-		logger.Error("Error HEADing %s: %v", url, err)
-		color.Red("Error HEADing %s: %s", url, err)
 		if onError != nil {
 			onError(nil, err)
 		}
 		return ""
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			logger.Error("Error closing response body for %s: %v", url, err)
-			color.Red("Error closing response body for %s: %v", url, err)
-		}
-	}(resp.Body)
 
-	fileSize := resp.ContentLength
+	taskID := opts.TaskID
+	if taskID == "" {
+		taskID = fmt.Sprintf("dl_%d", time.Now().UnixNano())
+	} else if _, running := s.GetTaskStatus(taskID); running {
+		if onError != nil {
+			onError(nil, fmt.Errorf("任务 %s 正在进行", taskID))
+		}
+		return ""
+	}
 
 	task := &FileTask{
-		ID:         fmt.Sprintf("dl_%d", time.Now().UnixNano()),
-		URL:        url,
-		FilePath:   filePath,
-		FileName:   filepath.Base(filePath),
-		FileSize:   fileSize,
-		ChunkSize:  s.config.ChunkSize,
-		TaskType:   "download",
-		OnProgress: onProgress,
-		OnComplete: onComplete,
-		OnError:    onError,
-		cancel:     make(chan struct{}),
+		ID:           taskID,
+		URL:          url,
+		Sources:      sources,
+		FilePath:     filePath,
+		FileName:     filepath.Base(filePath),
+		ChunkSize:    s.config.ChunkSize,
+		TaskType:     "download",
+		State:        TaskStateQueued,
+		ManifestURL:  opts.ManifestURL,
+		ExpectedHash: opts.ExpectedHash,
+		Owner:        opts.Owner,
+		Headers:      opts.Headers.Clone(),
+		OnProgress:   onProgress,
+		OnComplete:   onComplete,
+		OnError:      onError,
+		cancel:       make(chan struct{}),
+		client:       client,
+	}
+
+	// 探测文件大小和Range支持，决定分块下载还是单流下载
+	probe, err := probeSources(task, sources)
+	if err != nil {
+		logger.Errorf("Error probing %s: %v", url, err)
+		color.Red("Error probing %s: %s", url, err)
+		if onError != nil {
+			onError(nil, err)
+		}
+		return ""
+	}
+	task.FileSize = probe.size
+	task.RangeSupported = probe.rangeSupported
+	task.ETag = probe.etag
+	task.LastModified = probe.lastModified
+	task.streamURL = probe.url
+	fileSize := task.FileSize
+
+	if task.ManifestURL != "" {
+		manifest, err := fetchManifest(task, task.ManifestURL)
+		if err != nil {
+			logger.Errorf("Error fetching manifest %s: %v", task.ManifestURL, err)
+			color.Red("Error fetching manifest %s: %v", task.ManifestURL, err)
+			if onError != nil {
+				onError(task, err)
+			}
+			return ""
+		}
+		if fileSize >= 0 && manifest.FileSize != fileSize {
+			err := fmt.Errorf("校验清单文件大小 %d 与服务器返回的 %d 不一致", manifest.FileSize, fileSize)
+			if onError != nil {
+				onError(task, err)
+			}
+			return ""
+		}
+		// 分块必须与清单对齐，才能逐块校验
+		task.manifest = manifest
+		task.FileSize = manifest.FileSize
+		task.ChunkSize = manifest.ChunkSize
+		if task.ExpectedHash == "" {
+			task.ExpectedHash = manifest.FileHash
+		}
 	}
 
 	if err := s.loadTaskState(task); err != nil {
@@ -253,26 +471,129 @@ func (s *TransferService) AddDownloadTask(url, filePath string, onProgress func(
 	s.jobsMutex.Lock()
 	s.activeJobs[task.ID] = task
 	s.jobsMutex.Unlock()
+	publishTaskEvent(task, EventState, "")
 
-	s.workerPool.Submit(func() {
-		if err := s.processDownload(task); err != nil {
+	// 同一优先级内按用户轮转，单个用户提交大量任务不会挤占其他用户
+	s.workerPool.Enqueue(utils.TaskOptions{Priority: opts.Priority, Owner: task.Owner}, func(ctx context.Context) (interface{}, error) {
+		task.setState(TaskStateRunning)
+		err := errTaskCancelled
+		select {
+		case <-task.cancel:
+			// 排队期间已被取消
+		default:
+			err = s.processDownload(ctx, task)
+		}
+		if err != nil {
+			if errors.Is(err, errTaskCancelled) {
+				task.setState(TaskStateCancelled)
+			} else {
+				task.setState(TaskStateFailed)
+			}
+			publishTaskEvent(task, EventError, err.Error())
 			if task.OnError != nil {
 				task.OnError(task, err)
 			}
-		} else if task.OnComplete != nil {
-			task.OnComplete(task)
+		} else {
+			task.setState(TaskStateCompleted)
+			publishTaskEvent(task, EventComplete, "")
+			if task.OnComplete != nil {
+				task.OnComplete(task)
+			}
 		}
 
 		s.jobsMutex.Lock()
 		delete(s.activeJobs, task.ID)
 		s.jobsMutex.Unlock()
+		GetBandwidthManager().ReleaseTask(task.ID)
+		return nil, err
 	})
 
 	return task.ID
 }
 
-// processDownload 处理下载任务
-func (s *TransferService) processDownload(task *FileTask) error {
+// AddUploadTask 登记一个由对端按块拉取的上传任务，进度按已发送的块计算。
+// 任务不占用工作协程，由调用方在每块发送后调用 MarkUploadChunk，结束时调用 FinishUploadTask
+func (s *TransferService) AddUploadTask(taskID, filePath, fileName, owner string, fileSize, chunkSize int64) (*FileTask, error) {
+	if chunkSize <= 0 {
+		chunkSize = s.config.ChunkSize
+	}
+	task := &FileTask{
+		ID:        taskID,
+		URL:       filePath,
+		FilePath:  filePath,
+		FileName:  fileName,
+		FileSize:  fileSize,
+		ChunkSize: chunkSize,
+		TaskType:  "upload",
+		State:     TaskStateRunning,
+		Owner:     owner,
+		cancel:    make(chan struct{}),
+	}
+	task.chunkDone = make([]bool, task.chunkCount())
+
+	s.jobsMutex.Lock()
+	if _, exists := s.activeJobs[taskID]; exists {
+		s.jobsMutex.Unlock()
+		return nil, fmt.Errorf("任务 %s 正在进行", taskID)
+	}
+	s.activeJobs[taskID] = task
+	s.jobsMutex.Unlock()
+	publishTaskEvent(task, EventState, "")
+	return task, nil
+}
+
+// MarkUploadChunk 记录上传任务已发送的块，任务已取消时返回 errTaskCancelled
+func (s *TransferService) MarkUploadChunk(task *FileTask, index int) error {
+	select {
+	case <-task.cancel:
+		return errTaskCancelled
+	default:
+	}
+	if index < 0 || index >= len(task.chunkDone) {
+		return nil
+	}
+	progress := task.markChunkDone(index)
+	publishTaskEvent(task, EventProgress, "")
+	if task.OnProgress != nil {
+		task.OnProgress(progress)
+	}
+	return nil
+}
+
+// FinishUploadTask 结束上传任务，err 为 nil 表示对端已确认收到完整文件
+func (s *TransferService) FinishUploadTask(task *FileTask, err error) {
+	s.jobsMutex.Lock()
+	_, active := s.activeJobs[task.ID]
+	delete(s.activeJobs, task.ID)
+	s.jobsMutex.Unlock()
+	if !active {
+		return
+	}
+	GetBandwidthManager().ReleaseTask(task.ID)
+	switch {
+	case err == nil:
+		task.mu.Lock()
+		task.Progress = 100
+		task.Completed = true
+		task.mu.Unlock()
+		task.setState(TaskStateCompleted)
+		publishTaskEvent(task, EventComplete, "")
+	case errors.Is(err, errTaskCancelled):
+		task.setState(TaskStateCancelled)
+		publishTaskEvent(task, EventError, err.Error())
+	default:
+		task.setState(TaskStateFailed)
+		publishTaskEvent(task, EventError, err.Error())
+	}
+}
+
+// processDownload 处理下载任务，ctx 结束或任务取消时中止进行中的请求
+func (s *TransferService) processDownload(ctx context.Context, task *FileTask) error {
+	if task.streamMode() {
+		color.Yellow("Task %s: 服务器不支持Range或未返回文件大小，使用单流下载", task.ID)
+		return s.processStreamDownload(ctx, task)
+	}
+
 	// 1. 准备文件
 	tempFile := task.FilePath + ".download"
 	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_RDWR, 0644)
@@ -290,140 +611,119 @@ func (s *TransferService) processDownload(task *FileTask) error {
 		return err
 	}
 
-	// 2. 计算任务分配
-	totalChunkCount := int(math.Ceil(float64(task.FileSize) / float64(task.ChunkSize)))
-	workerCount := s.config.WorkerCount
-	chunksPerWorker := totalChunkCount / workerCount
-
-	// 3. 初始化并发控制
-	var wg sync.WaitGroup
-	var progressMutex sync.Mutex
-	errorCh := make(chan error, workerCount) // 缓冲通道，防止worker阻塞
-
-	// 4. 启动所有 workers
-	for workerID := 0; workerID < workerCount; workerID++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-
-			// 计算此 worker 的块范围
-			startChunk := workerID * chunksPerWorker
-			endChunk := (workerID + 1) * chunksPerWorker
-			if workerID == workerCount-1 {
-				endChunk = totalChunkCount // 最后一个 worker 处理所有剩余的
-			}
-
-			// 恢复逻辑：计算此 worker 真正的起始点
-			progressMutex.Lock()
-			completedByThisWorker := task.WorkerProgress[workerID]
-			progressMutex.Unlock()
-
-			// 从上次完成的地方继续
-			resumeStartChunk := startChunk + int(completedByThisWorker)
-
-			if resumeStartChunk < endChunk {
-				color.Green("Worker %d: Resuming from chunk %d (Total for this worker: %d to %d)",
-					workerID, resumeStartChunk, startChunk, endChunk-1)
-			}
-
-			for chunkIndex := resumeStartChunk; chunkIndex < endChunk; chunkIndex++ {
-				select {
-				case <-task.cancel:
-					return // 任务被取消
-				default:
-				}
-
-				// 下载单个块
-				startByte := int64(chunkIndex) * task.ChunkSize
-				endByte := startByte + task.ChunkSize - 1
-				if endByte >= task.FileSize {
-					endByte = task.FileSize - 1
-				}
-
-				err := downloadChunk(task.URL, file, startByte, endByte)
-				if err != nil {
-					select {
-					case errorCh <- fmt.Errorf("worker %d failed on chunk %d: %w", workerID, chunkIndex, err):
-					default:
-					}
-					return
-				}
-
-				// 更新进度 (在锁内)
-				progressMutex.Lock()
-				task.WorkerProgress[workerID]++
-				var totalCompleted int64
-				for _, count := range task.WorkerProgress {
-					totalCompleted += count
-				}
-				task.Progress = float64(totalCompleted) / float64(totalChunkCount) * 100
-				currentProgress := task.Progress
-				progressMutex.Unlock()
+	// 2. 初始化数据源
+	var sources []ChunkSource
+	for _, raw := range task.Sources {
+		source, err := newChunkSource(raw)
+		if err != nil {
+			color.Yellow("Task %s: skipping source %s: %v", task.ID, raw, err)
+			continue
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return errors.New("没有可用的数据源")
+	}
+	task.mu.Lock()
+	task.sources = newSourceSet(sources)
+	task.mu.Unlock()
 
-				// 在锁外调用回调
-				if task.OnProgress != nil {
-					go task.OnProgress(currentProgress) // 异步调用，防止阻塞
-				}
-			}
-		}(workerID)
+	// 3. 收集未完成的块，已完成的块用于断点续传
+	totalChunkCount := task.chunkCount()
+	task.mu.Lock()
+	if len(task.chunkDone) != totalChunkCount {
+		task.chunkDone = make([]bool, totalChunkCount)
+	}
+	var pending []int
+	for index, done := range task.chunkDone {
+		if !done {
+			pending = append(pending, index)
+		}
+	}
+	task.mu.Unlock()
+	if len(pending) < totalChunkCount {
+		color.Green("Task %s: resuming, %d of %d chunks already done", task.ID, totalChunkCount-len(pending), totalChunkCount)
 	}
 
-	// 5. 等待所有 workers 完成
-	doneCh := make(chan struct{})
+	ctx, cancel := task.withCancel(ctx)
+	defer cancel()
+	sched := newChunkScheduler(pending)
+	stopWatch := make(chan struct{})
 	go func() {
-		wg.Wait()
-		close(doneCh)
+		select {
+		case <-task.cancel:
+			sched.fail(errTaskCancelled)
+		case <-stopWatch:
+		}
 	}()
 
-	// 6. 等待完成或错误
-	select {
-	case err := <-errorCh:
-		err = s.saveTaskStatus(task)
-		if err != nil {
-			logger.Error("Error saving task status after error: %v", err)
-			color.Red("Error saving task status after error: %v", err)
-			return err
-		} // 发生错误，保存当前进度
-		return err
-	case <-task.cancel:
-		err = s.saveTaskStatus(task)
-		if err != nil {
-			logger.Error("Error saving task status on cancel: %v", err)
-			color.Red("Error saving task status on cancel: %v", err)
-			return err
+	// 4. 每个数据源启动若干 worker，共享同一个分块队列
+	workersPerSource := s.config.WorkerCount / len(sources)
+	if workersPerSource < 1 {
+		workersPerSource = 1
+	}
+	dispatcher := newProgressDispatcher(task)
+	task.mu.Lock()
+	task.dispatcher = dispatcher
+	task.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, state := range task.sources.sources {
+		for i := 0; i < workersPerSource; i++ {
+			wg.Add(1)
+			go func(state *sourceState) {
+				defer wg.Done()
+				s.sourceWorker(ctx, task, file, state, sched, len(sources))
+			}(state)
 		}
-		return errors.New("任务已取消")
-	case <-doneCh:
-		// 全部完成，继续执行
 	}
+	wg.Wait()
+	close(stopWatch)
+
+	// 等待已投递的进度处理完，后续的回调才不会与之交错
+	task.mu.Lock()
+	task.dispatcher = nil
+	task.mu.Unlock()
+	dispatcher.close()
 
-	// 7. 最终验证和完成
-	var finalCompletedCount int64
-	for _, count := range task.WorkerProgress {
-		finalCompletedCount += count
+	// 5. 出错或取消时保存当前进度
+	if err := sched.result(); err != nil {
+		if saveErr := s.saveTaskStatus(task); saveErr != nil {
+			logger.Errorf("Error saving task status after error: %v", saveErr)
+			color.Red("Error saving task status after error: %v", saveErr)
+		}
+		return err
 	}
-	if finalCompletedCount != int64(totalChunkCount) {
+
+	// 6. 最终验证和完成
+	if completed := len(task.completedChunks()); completed != totalChunkCount {
 		err := s.saveTaskStatus(task)
 		if err != nil {
 			logger.Error("Error saving task status after final check: %v", err)
 			color.Red("Error saving task status after final check: %v", err)
 			return err
 		}
-		return fmt.Errorf("下载未完全完成，预期 %d 块，实际完成 %d 块", totalChunkCount, finalCompletedCount)
+		return fmt.Errorf("下载未完全完成，预期 %d 块，实际完成 %d 块", totalChunkCount, completed)
+	}
+
+	if err := s.verifyDownloadedFile(ctx, task, file, totalChunkCount); err != nil {
+		return err
 	}
 
 	if err := os.Rename(tempFile, task.FilePath); err != nil {
 		return err
 	}
+	task.mu.Lock()
 	task.Completed = true
 	task.Progress = 100
+	task.mu.Unlock()
 	if task.OnProgress != nil {
 		task.OnProgress(100)
 	}
 	// 删除元数据文件
 	metaFile := filepath.Join(s.config.MetaDir, task.ID+".json")
 	err = os.Remove(metaFile)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		logger.Error("Error removing metadata file %s: %v", metaFile, err)
 		color.Red("Error removing metadata file %s: %v", metaFile, err)
 		return err
@@ -432,46 +732,138 @@ func (s *TransferService) processDownload(task *FileTask) error {
 	return nil
 }
 
-// downloadChunk 下载单个块的辅助函数
-func downloadChunk(url string, file *os.File, start, end int64) error {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
-	}
+// sourceWorker 从共享队列取块并从指定数据源下载，数据源被剔除后退出
+func (s *TransferService) sourceWorker(ctx context.Context, task *FileTask, file *os.File, state *sourceState, sched *chunkScheduler, sourceCount int) {
+	retryLimit := s.chunkRetries() + sourceCount
+	for {
+		if task.sources.dropped(state) {
+			return
+		}
+		index, ok := sched.next()
+		if !ok {
+			return
+		}
 
-	// 添加Range头以请求特定的字节范围
-	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		// 任务尾部让明显较慢的数据源把分块留给快的来源，避免拖慢完成时间
+		if sched.queued() < s.config.WorkerCount && task.sources.alive() > 1 && task.sources.isSlow(state) {
+			sched.giveBack(index)
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
 
-	// 发送请求
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
+		start, size := chunkBounds(index, task.ChunkSize, task.FileSize)
+		began := time.Now()
+		err := state.source.FetchChunk(ctx, task, file, start, start+size-1)
+		if err == nil {
+			err = verifyChunk(task, file, index)
+		}
 		if err != nil {
-			logger.Error("Error closing response body: %v", err)
-			color.Red("Error closing response body: %v", err)
+			color.Yellow("Task %s: chunk %d from %s failed: %v", task.ID, index, state.source.Name(), err)
+			dropped := task.sources.recordFailure(state, err)
+			if !sched.retry(index, retryLimit) {
+				sched.fail(fmt.Errorf("第 %d 块多次下载失败: %w", index, err))
+				return
+			}
+			if dropped {
+				if task.sources.alive() == 0 {
+					sched.fail(fmt.Errorf("所有数据源均不可用: %w", err))
+				}
+				return
+			}
+			continue
 		}
-	}(resp.Body)
 
-	// 验证响应状态
-	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("意外的状态码: %d", resp.StatusCode)
+		task.sources.recordSuccess(state, size, time.Since(began))
+		task.markChunkDone(index)
+		sched.done()
 	}
+}
 
-	// 使用新添加的函数从reader直接写入到文件
-	_, err = file.Seek(start, 0)
-	if err != nil {
-		return err
+// chunkRetries 返回单个分块的最大重试次数
+func (s *TransferService) chunkRetries() int {
+	if s.config.ChunkRetries > 0 {
+		return s.config.ChunkRetries
 	}
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		logger.Error("Error writing chunk to file: %v", err)
-		color.Red("Error writing chunk to file: %v", err)
+	return 3
+}
+
+// fetchChunk 按速度从快到慢尝试各数据源下载第 index 块并按清单校验
+func (s *TransferService) fetchChunk(ctx context.Context, task *FileTask, file *os.File, index int) error {
+	start, size := chunkBounds(index, task.ChunkSize, task.FileSize)
+	end := start + size - 1
+
+	var err error = errors.New("没有可用的数据源")
+	for attempt := 0; attempt <= s.chunkRetries(); attempt++ {
+		for _, state := range task.sources.bySpeed() {
+			select {
+			case <-task.cancel:
+				return errTaskCancelled
+			default:
+			}
+
+			err = state.source.FetchChunk(ctx, task, file, start, end)
+			if err == nil {
+				err = verifyChunk(task, file, index)
+			}
+			if err == nil {
+				return nil
+			}
+			task.sources.recordFailure(state, err)
+			color.Yellow("Task %s chunk %d attempt %d from %s failed: %v", task.ID, index, attempt+1, state.source.Name(), err)
+		}
+	}
+	return err
+}
+
+// verifyDownloadedFile 完成后校验整个文件，有清单时只重新下载损坏的分块
+func (s *TransferService) verifyDownloadedFile(ctx context.Context, task *FileTask, file *os.File, totalChunkCount int) error {
+	err := verifyFile(task, file)
+	if err == nil {
+		return nil
+	}
+	logger.Errorf("Task %s failed whole-file verification: %v", task.ID, err)
+	color.Red("Task %s failed whole-file verification: %v", task.ID, err)
+
+	if task.manifest == nil || len(task.manifest.ChunkHashes) == 0 {
+		// 无法定位损坏的分块，清空进度让下次恢复时整体重新下载
+		task.mu.Lock()
+		task.chunkDone = make([]bool, totalChunkCount)
+		task.Progress = 0
+		task.mu.Unlock()
+		if saveErr := s.saveTaskStatus(task); saveErr != nil {
+			logger.Errorf("Error saving task status after verification failure: %v", saveErr)
+			color.Red("Error saving task status after verification failure: %v", saveErr)
+		}
 		return err
 	}
-	return nil
+
+	repaired := 0
+	for index := 0; index < totalChunkCount; index++ {
+		verifyErr := verifyChunk(task, file, index)
+		if verifyErr == nil {
+			continue
+		}
+		if !errors.Is(verifyErr, ErrChunkCorrupted) {
+			return verifyErr
+		}
+		if err := s.fetchChunk(ctx, task, file, index); err != nil {
+			return fmt.Errorf("重新下载损坏的第 %d 块失败: %w", index, err)
+		}
+		repaired++
+	}
+	color.Yellow("Task %s re-downloaded %d corrupted chunks", task.ID, repaired)
+
+	return verifyFile(task, file)
+}
+
+// PoolMetrics 返回传输协程池的队列深度和延迟统计
+func (s *TransferService) PoolMetrics() utils.PoolMetrics {
+	return s.workerPool.Metrics()
+}
+
+// ResizeWorkers 运行时调整同时执行的传输任务数
+func (s *TransferService) ResizeWorkers(workerCount int) {
+	s.workerPool.Resize(workerCount)
 }
 
 // CancelTask 取消任务
@@ -484,117 +876,63 @@ func (s *TransferService) CancelTask(taskID string) bool {
 		return false
 	}
 
-	close(task.cancel)
+	select {
+	case <-task.cancel:
+		// 已经取消过，避免重复关闭通道
+	default:
+		close(task.cancel)
+	}
 	// 不要立即删除，让任务自然退出
 	return true
 }
 
-type server struct {
-	proto.CallUploadServer
-}
-
-type client struct {
-	proto.CallUploadClient
-}
-
-func listenUploadS() {
-	// 启动 gRPC 服务器，监听上传任务
-	listen, err := net.Listen("tcp", ":18521")
-	if err != nil {
-		fmt.Printf("failed to listen: %v", err)
-		return
-	}
-	s := grpc.NewServer()
-	proto.RegisterCallUploadServer(s, &server{})
-	reflection.Register(s)
-	defer func() {
-		s.Stop()
-		err := listen.Close()
-		if err != nil {
-			logger.Error("Error closing grpc listener: %v", err)
-			color.Red("Error closing upload server: %v", err)
-			return
+// DiscardTaskFiles 删除任务的临时文件和元数据，用于取消后不再续传的任务
+func (s *TransferService) DiscardTaskFiles(task *FileTask) {
+	for _, path := range []string{
+		task.FilePath + ".download",
+		filepath.Join(s.config.MetaDir, task.ID+".json"),
+	} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("Error removing %s: %v", path, err)
+			color.Red("Error removing %s: %v", path, err)
 		}
-	}()
-	fmt.Println("Serving 8001...Listen to the file upload task")
-	err = s.Serve(listen)
-	if err != nil {
-		fmt.Printf("failed to serve: %v", err)
-		return
 	}
 }
 
-// callUploads 调用上传函数(Server端)
-func (s *server) callUploadS(fileInfo *proto.FileInfo) (*proto.ErrInfo, error) {
-	// 实现上传逻辑 x
-	var uploadTask UploadTask
-	err := json.Unmarshal([]byte(fileInfo.FileDataJson), &uploadTask)
-	if err != nil {
-		logger.Error("Error unmarshalling upload task: %v", err)
-		color.Red("Error unmarshalling upload task: %v", err)
-		return nil, err
-	}
-	transferService := NewTransferService(models.TransferConfig{
-		MetaDir:     "meta",      // 元数据目录
-		WorkerCount: 4,           // 并发 worker 数量
-		ChunkSize:   1024 * 1024, // 每块大小，单位：字节
-	})
-	if transferService == nil {
-		return &proto.ErrInfo{ErrStr: "Failed to initialize TransferService"}, errors.New("TransferService initialization failed")
-	}
-	taskID := transferService.AddDownloadTask(uploadTask.url, uploadTask.filePath, onProgress, onComplete, onError)
-	color.Green("Upload task %v successfully", taskID)
-	return &proto.ErrInfo{ErrStr: ""}, nil
-}
+// GlobalTransferService 全局传输服务
+var GlobalTransferService *TransferService
 
-// callUploadC 调用上传函数(Client端)
-func callUploadC(task UploadTask, serviceHost string) {
-	conn, err := grpc.NewClient(serviceHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		fmt.Println(err)
+// InitTransferService 从环境变量初始化并启动全局传输服务
+func InitTransferService() error {
+	workers, err := strconv.Atoi(utils.GetEnv("TRANSFER_WORKERS", "4"))
+	if err != nil || workers <= 0 {
+		return fmt.Errorf("TRANSFER_WORKERS 无效: %s", utils.GetEnv("TRANSFER_WORKERS", "4"))
 	}
-	defer func(conn *grpc.ClientConn) {
-		err := conn.Close()
-		if err != nil {
-			logger.Error("Error closing connection: %v", err)
-			color.Red("Error closing connection: %v", err)
-		}
-	}(conn)
-	taskJson := MakeTaskShareJSON(task.filePath, task.url, task.fileName, task.fileSize)
-	client := proto.NewCallUploadClient(conn)
-	_, err = client.CallUpload(context.Background(), &proto.FileInfo{FileDataJson: taskJson})
-	if err != nil {
-		logger.Error("Error calling upload: %v", err)
-		color.Red("Error saving task status after error: %v", err)
-		return
+	chunkSize, err := strconv.ParseInt(utils.GetEnv("TRANSFER_CHUNK_SIZE", "1048576"), 10, 64)
+	if err != nil || chunkSize <= 0 {
+		return fmt.Errorf("TRANSFER_CHUNK_SIZE 无效: %s", utils.GetEnv("TRANSFER_CHUNK_SIZE", "1048576"))
 	}
-	color.Green("Upload task %v successfully", task.fileName)
-}
-
-// MakeTaskShareJSON 创建用来发送上传任务的JSON
-func MakeTaskShareJSON(filePath, url, fileName string, fileSize int64) []byte {
-	task := UploadTask{fileName: fileName, filePath: filePath, url: url, fileSize: fileSize}
-	outJson, err := json.Marshal(task)
+	retries, err := strconv.Atoi(utils.GetEnv("TRANSFER_CHUNK_RETRIES", "3"))
 	if err != nil {
-		logger.Error("Error marshalling task json: %v", err)
-		color.Red("Error marshalling task json: %v", err)
+		return fmt.Errorf("TRANSFER_CHUNK_RETRIES 无效: %w", err)
 	}
-	return outJson
-}
 
-func onProgress(Progress float64) {
-
-	color.Green("Task progress: %.2f%%", Progress)
-
-}
-
-func onComplete(task *FileTask) {
-	color.Green("Task %s completed successfully! File saved to %s", task.ID, task.FilePath)
+	service := NewTransferService(models.TransferConfig{
+		WorkerCount:  workers,
+		MetaDir:      utils.GetEnv("TRANSFER_META_DIR", "meta"),
+		ChunkSize:    chunkSize,
+		ChunkRetries: retries,
+	})
+	if service == nil {
+		return errors.New("创建传输服务失败")
+	}
+	service.Start()
+	GlobalTransferService = service
+	color.Green("传输服务已启动: %d 个工作协程, 分块大小 %d 字节", workers, chunkSize)
+	return nil
 }
 
-func onError(task *FileTask, err error) {
-	logger.Error("Task %s encountered an error: %v", task.ID, err)
-	logger.Error("Error saving task status to file %s: %v", task.FileName, err)
-	color.Red("Task %s encountered an error: %v", task.ID, err)
-	color.Red("Error saving task status to file %s: %v", task.FileName, err)
+// GetTransferService 获取全局传输服务，未初始化时返回 nil
+func GetTransferService() *TransferService {
+	return GlobalTransferService
 }
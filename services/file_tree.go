@@ -0,0 +1,100 @@
+package services
+
+import (
+	"GoFileShare/config"
+	"GoFileShare/models"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+)
+
+// 文件树操作的错误，HTTP 控制器和 gRPC 服务分别映射为各自的状态码
+var (
+	ErrInvalidNodeID     = errors.New("无效的文件节点ID")
+	ErrNodeNotFound      = errors.New("文件不存在")
+	ErrPermissionDenied  = errors.New("权限不足")
+	ErrMissingFolderName = errors.New("缺少文件夹名称")
+	ErrMissingQuery      = errors.New("缺少搜索关键词")
+	ErrJobsUnavailable   = errors.New("后台任务管理器未初始化")
+)
+
+// ListChildren 列出文件夹下调用方有权限查看的节点，parentID 为空或 "root" 时列出根目录
+func ListChildren(caller Caller, parentID string) ([]config.FileNode, error) {
+	parentObjID := primitive.NilObjectID
+	if parentID != "" && parentID != "root" {
+		var err error
+		if parentObjID, err = primitive.ObjectIDFromHex(parentID); err != nil {
+			return nil, ErrInvalidNodeID
+		}
+	}
+
+	fileNodes, err := models.SearchFileNodeByParentID(parentObjID)
+	if err != nil {
+		return nil, err
+	}
+	return config.AuthCheck(caller.AuthLevel, fileNodes)
+}
+
+// SearchNodes 按名称模糊搜索调用方有权限查看的节点
+func SearchNodes(caller Caller, query string) ([]config.FileNode, error) {
+	if query == "" {
+		return nil, ErrMissingQuery
+	}
+	fileNodes, err := models.SearchFileNodeByNamePattern(query)
+	if err != nil {
+		return nil, err
+	}
+	return config.AuthCheck(caller.AuthLevel, fileNodes)
+}
+
+// GetNode 查询单个节点，不存在时返回 ErrNodeNotFound，权限不足时返回 ErrPermissionDenied
+func GetNode(caller Caller, nodeID string) (*config.FileNode, error) {
+	objID, err := primitive.ObjectIDFromHex(nodeID)
+	if err != nil {
+		return nil, ErrInvalidNodeID
+	}
+	fileNodes, err := models.SearchFileNodeByID(objID)
+	if err != nil {
+		return nil, err
+	}
+	if len(fileNodes) == 0 {
+		return nil, ErrNodeNotFound
+	}
+	checkedFileNodes, err := config.AuthCheck(caller.AuthLevel, fileNodes)
+	if err != nil {
+		return nil, err
+	}
+	if len(checkedFileNodes) == 0 {
+		return nil, ErrPermissionDenied
+	}
+	return &checkedFileNodes[0], nil
+}
+
+// CreateFolder 在 parentID 下创建文件夹，新文件夹的权限等级与创建者相同
+func CreateFolder(caller Caller, parentID, name string) (*config.FileNode, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrMissingFolderName
+	}
+	if parentID == "" || parentID == "undefined" || parentID == "null" {
+		parentID = "root"
+	}
+	return models.CreateFileNode("", name, true, parentID, caller.AuthLevel)
+}
+
+// DeleteNode 检查权限后提交后台删除任务，文件夹会递归删除
+func DeleteNode(caller Caller, nodeID string) (*models.Job, *config.FileNode, error) {
+	fileNode, err := GetNode(caller, nodeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	manager := GetJobManager()
+	if manager == nil {
+		return nil, nil, ErrJobsUnavailable
+	}
+	job, err := manager.Submit(JobKindDelete, caller.Name, "删除 "+fileNode.Name, DeleteJobParams{NodeID: nodeID})
+	if err != nil {
+		return nil, nil, err
+	}
+	return job, fileNode, nil
+}
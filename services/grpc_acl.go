@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PeerRule 一个对端身份允许调用的方法。
+// Methods 使用完整方法名，例如 "/fileshare.v1.FileService/Download"，也可以用 "/fileshare.v1.FileService/*" 或 "*"；
+// AuthLevel 不为空时该节点可以不带令牌调用，以 "node:<身份>" 和该权限等级执行
+type PeerRule struct {
+	Identity  string   `json:"identity"` // 证书 CN 或 SAN 中的 DNS/URI，"*" 匹配任意已通过 CA 校验的证书
+	Methods   []string `json:"methods"`
+	AuthLevel *int     `json:"auth_level,omitempty"`
+}
+
+// allows 判断规则是否允许调用 fullMethod
+func (r *PeerRule) allows(fullMethod string) bool {
+	for _, pattern := range r.Methods {
+		if pattern == "*" || pattern == fullMethod {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(fullMethod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerACL 从 JSON 文件加载的对端访问控制列表，文件修改后自动重新加载
+type PeerACL struct {
+	file string
+
+	mu      sync.RWMutex
+	rules   []PeerRule
+	version time.Time
+}
+
+// NewPeerACL 加载访问控制文件，格式为 {"peers": [{"identity": "...", "methods": ["..."]}]}
+func NewPeerACL(file string) (*PeerACL, error) {
+	acl := &PeerACL{file: file}
+	if err := acl.Reload(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// Reload 重新读取访问控制文件，失败时保留之前的规则
+func (a *PeerACL) Reload() error {
+	var version time.Time
+	if info, err := os.Stat(a.file); err == nil {
+		version = info.ModTime()
+	}
+	data, err := os.ReadFile(a.file)
+	if err != nil {
+		return fmt.Errorf("读取gRPC访问控制文件失败: %w", err)
+	}
+	var parsed struct {
+		Peers []PeerRule `json:"peers"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return fmt.Errorf("解析gRPC访问控制文件失败: %w", err)
+	}
+	for i, rule := range parsed.Peers {
+		if rule.Identity == "" || len(rule.Methods) == 0 {
+			return fmt.Errorf("gRPC访问控制第 %d 条规则缺少 identity 或 methods", i+1)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = parsed.Peers
+	a.version = version
+	return nil
+}
+
+func (a *PeerACL) reloadIfChanged() {
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return
+	}
+	a.mu.RLock()
+	unchanged := info.ModTime().Equal(a.version)
+	a.mu.RUnlock()
+	if unchanged {
+		return
+	}
+	if err := a.Reload(); err != nil {
+		logger.Errorf("重新加载gRPC访问控制失败: %v", err)
+		color.Red("重新加载gRPC访问控制失败: %v", err)
+		// 记录新的修改时间，避免每次调用都重试同一个错误的文件
+		a.mu.Lock()
+		a.version = info.ModTime()
+		a.mu.Unlock()
+		return
+	}
+	color.Green("gRPC 访问控制已重新加载")
+}
+
+// Match 返回允许这些身份调用 fullMethod 的第一条规则，精确匹配身份的规则优先于 "*"
+func (a *PeerACL) Match(identities []string, fullMethod string) *PeerRule {
+	a.reloadIfChanged()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var wildcard *PeerRule
+	for i := range a.rules {
+		rule := &a.rules[i]
+		if !rule.allows(fullMethod) {
+			continue
+		}
+		if rule.Identity == "*" {
+			if wildcard == nil {
+				wildcard = rule
+			}
+			continue
+		}
+		for _, identity := range identities {
+			if identity == rule.Identity {
+				matched := *rule
+				return &matched
+			}
+		}
+	}
+	if wildcard != nil {
+		matched := *wildcard
+		return &matched
+	}
+	return nil
+}
+
+// peerIdentities 返回已通过 CA 校验的客户端证书中的身份：CN、DNS 和 URI SAN，没有证书时返回 nil
+func peerIdentities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := info.State.VerifiedChains[0][0]
+	var identities []string
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
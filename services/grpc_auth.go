@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// TokenAuthenticator 根据 API 令牌返回调用方身份，令牌无效时返回 ErrInvalidToken
+type TokenAuthenticator func(token string) (Caller, error)
+
+// UserLookup 按用户名返回本节点的用户身份，用户不存在时返回 ErrUnknownUser
+type UserLookup func(name string) (Caller, error)
+
+// GRPCAuth gRPC 调用的认证配置
+type GRPCAuth struct {
+	Tokens TokenAuthenticator // 校验 API 令牌
+	ACL    *PeerACL           // 不为空时要求客户端证书，并按对端身份限制可调用的方法
+	Users  UserLookup         // 集群节点代表用户调用时查询用户，为空时不允许代表调用
+}
+
+// onBehalfOfKey 集群节点代表用户调用时，在元数据中携带的用户名
+const onBehalfOfKey = "x-gfs-on-behalf-of"
+
+// NodeCallerPrefix 凭客户端证书调用的节点以 node:<身份> 为调用方名称，用户名不能使用这个前缀
+const NodeCallerPrefix = "node:"
+
+type callerKey struct{}
+
+// rpcCallerFromContext 返回认证拦截器放入的调用方身份，没有身份时按最低权限处理
+func rpcCallerFromContext(ctx context.Context) Caller {
+	caller, _ := ctx.Value(callerKey{}).(Caller)
+	return caller
+}
+
+// authUnaryInterceptor 校验对端证书和 authorization 元数据中的令牌
+func authUnaryInterceptor(auth GRPCAuth) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticateRPC(ctx, info.FullMethod, auth)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authStreamInterceptor 流式调用的认证
+func authStreamInterceptor(auth GRPCAuth) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticateRPC(stream.Context(), info.FullMethod, auth)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticateRPC 先按对端证书检查访问控制，再从 "authorization: Bearer <token>" 中取出令牌，
+// 把调用方身份放入 ctx。访问控制规则指定了 auth_level 的节点可以不带令牌调用
+func authenticateRPC(ctx context.Context, fullMethod string, auth GRPCAuth) (context.Context, error) {
+	var rule *PeerRule
+	if auth.ACL != nil {
+		identities := peerIdentities(ctx)
+		if len(identities) == 0 {
+			return nil, status.Error(codes.Unauthenticated, "需要客户端证书")
+		}
+		if rule = auth.ACL.Match(identities, fullMethod); rule == nil {
+			return nil, status.Errorf(codes.PermissionDenied, "节点 %s 无权调用 %s", identities[0], fullMethod)
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 && rule != nil && rule.AuthLevel != nil {
+		identity := peerIdentities(ctx)[0]
+		caller := Caller{Name: NodeCallerPrefix + identity, AuthLevel: *rule.AuthLevel, Node: identity}
+		return withCaller(ctx, md, caller, auth)
+	}
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "缺少令牌")
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok || token == "" {
+		return nil, status.Error(codes.Unauthenticated, "authorization 格式应为 Bearer <token>")
+	}
+	if auth.Tokens == nil {
+		return nil, status.Error(codes.Unavailable, "令牌认证未配置")
+	}
+	caller, err := auth.Tokens(token)
+	if errors.Is(err, ErrInvalidToken) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "校验令牌失败: %v", err)
+	}
+	return withCaller(ctx, md, caller, auth)
+}
+
+// withCaller 把调用方身份放入 ctx。元数据带有 x-gfs-on-behalf-of 时改为代表该用户调用：
+// 只允许集群节点（管理员令牌或凭客户端证书认证的节点身份）代表用户，权限等级取该用户在本节点的等级，且不超过节点自己的等级。
+// 节点身份以 Caller.Node 为准，不看名称，令牌用户即使名称以 node: 开头也不能代表其他用户
+func withCaller(ctx context.Context, md metadata.MD, caller Caller, auth GRPCAuth) (context.Context, error) {
+	values := md.Get(onBehalfOfKey)
+	if len(values) == 0 || values[0] == "" {
+		return context.WithValue(ctx, callerKey{}, caller), nil
+	}
+	if !caller.IsAdmin() && caller.Node == "" {
+		return nil, status.Error(codes.PermissionDenied, "只有集群节点可以代表其他用户调用")
+	}
+	if auth.Users == nil {
+		return nil, status.Error(codes.PermissionDenied, "本节点不接受代表用户的调用")
+	}
+	user, err := auth.Users(values[0])
+	if errors.Is(err, ErrUnknownUser) {
+		return nil, status.Errorf(codes.PermissionDenied, "用户 %s 在本节点不存在", values[0])
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "查询用户失败: %v", err)
+	}
+	delegated := Caller{Name: user.Name, AuthLevel: min(user.AuthLevel, caller.AuthLevel), Via: caller.Name}
+	return context.WithValue(ctx, callerKey{}, delegated), nil
+}
+
+// onBehalfOf 返回代表 user 调用其他节点的 ctx
+func onBehalfOf(ctx context.Context, user string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, onBehalfOfKey, user)
+}
+
+// tokenCredentials 客户端每次调用附带的令牌
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// WithToken 客户端连接选项，每次调用通过 authorization 元数据发送 API 令牌
+func WithToken(token string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(tokenCredentials(token))
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"path/filepath"
+	"time"
+)
+
+// ClusterServer 实现 fileshare.v1.ClusterService，供其他节点协调副本
+type ClusterServer struct {
+	filesharev1.UnimplementedClusterServiceServer
+
+	nodes FileNodeStore
+}
+
+// NewClusterServer 创建集群协调服务，未启用集群模式时调用返回 Unavailable
+func NewClusterServer(nodes FileNodeStore) *ClusterServer {
+	return &ClusterServer{nodes: nodes}
+}
+
+// Replicate 把文件加入本节点的拉取队列
+func (s *ClusterServer) Replicate(ctx context.Context, req *filesharev1.ReplicateRequest) (*filesharev1.ReplicateResponse, error) {
+	cluster, err := clusterForRPC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := primitive.ObjectIDFromHex(req.GetNodeId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "无效的节点ID: %s", req.GetNodeId())
+	}
+	node, err := s.nodes.Get(id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "查询文件节点失败: %v", err)
+	}
+	if node == nil || node.Type {
+		return nil, status.Errorf(codes.NotFound, "文件不存在: %s", req.GetNodeId())
+	}
+	if nodeFilePath(*node) != "" {
+		return &filesharev1.ReplicateResponse{Accepted: false}, nil
+	}
+	if !cluster.Enqueue(id) {
+		return nil, status.Error(codes.ResourceExhausted, "复制队列已满")
+	}
+	return &filesharev1.ReplicateResponse{Accepted: true}, nil
+}
+
+// DropReplica 删除已经不再登记在文件节点上的本地副本
+func (s *ClusterServer) DropReplica(ctx context.Context, req *filesharev1.DropReplicaRequest) (*filesharev1.DropReplicaResponse, error) {
+	cluster, err := clusterForRPC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := primitive.ObjectIDFromHex(req.GetNodeId()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "无效的节点ID: %s", req.GetNodeId())
+	}
+	// 文件节点中登记的路径都是规范化的，同一文件的其他写法无法与之比较
+	if filepath.Clean(req.GetPath()) != req.GetPath() {
+		return nil, status.Errorf(codes.InvalidArgument, "无效的副本路径: %s", req.GetPath())
+	}
+	// 请求中的节点ID由调用方提供，不能据此判断；任何文件节点仍以该路径为主路径或本节点副本时都拒绝删除
+	inUse, err := s.nodes.UsesPath(req.GetPath(), cluster.NodeID())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "查询文件节点失败: %v", err)
+	}
+	if inUse {
+		return nil, status.Error(codes.FailedPrecondition, "副本仍在使用")
+	}
+	if !cluster.removeLocal(req.GetPath()) {
+		return nil, status.Errorf(codes.InvalidArgument, "无法删除副本: %s", req.GetPath())
+	}
+	return &filesharev1.DropReplicaResponse{}, nil
+}
+
+// Gossip 合并调用方的成员列表，返回本节点看到的成员列表
+func (s *ClusterServer) Gossip(ctx context.Context, req *filesharev1.GossipRequest) (*filesharev1.GossipResponse, error) {
+	cluster, err := clusterForRPC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetFrom().GetNodeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "缺少调用方节点信息")
+	}
+	incoming := make([]Member, 0, len(req.GetMembers()))
+	for _, member := range req.GetMembers() {
+		incoming = append(incoming, memberFromProto(member))
+	}
+	resp := &filesharev1.GossipResponse{}
+	for _, member := range cluster.members.HandleGossip(memberFromProto(req.GetFrom()), incoming) {
+		resp.Members = append(resp.Members, memberToProto(member))
+	}
+	return resp, nil
+}
+
+// ProbeNode 代替调用方探测目标节点
+func (s *ClusterServer) ProbeNode(ctx context.Context, req *filesharev1.ProbeNodeRequest) (*filesharev1.ProbeNodeResponse, error) {
+	cluster, err := clusterForRPC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addr := req.GetGrpcAddr()
+	if member, ok := cluster.members.Get(req.GetNodeId()); ok && member.GRPCAddr != "" {
+		// 只探测成员表中登记的地址，不替调用方连接任意地址
+		addr = member.GRPCAddr
+	} else if !ok {
+		return nil, status.Errorf(codes.NotFound, "未知的节点: %s", req.GetNodeId())
+	}
+	timeout := time.Duration(req.GetTimeoutMs()) * time.Millisecond
+	return &filesharev1.ProbeNodeResponse{Reachable: cluster.members.Probe(ctx, addr, timeout)}, nil
+}
+
+// clusterForRPC 集群接口只允许管理员权限的调用方（其他节点）使用
+func clusterForRPC(ctx context.Context) (*Cluster, error) {
+	if !rpcCallerFromContext(ctx).IsAdmin() {
+		return nil, status.Error(codes.PermissionDenied, "需要管理员权限")
+	}
+	cluster := GetCluster()
+	if cluster == nil {
+		return nil, status.Error(codes.Unavailable, "未启用集群模式")
+	}
+	return cluster, nil
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"GoFileShare/config"
+	"GoFileShare/models"
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDropReplicaRefusesReferencedFiles(t *testing.T) {
+	storeDir := t.TempDir()
+	GlobalCluster = NewCluster(models.ClusterNode{ID: "node-a"}, 2, storeDir, "", time.Minute, nil, MembershipConfig{})
+	t.Cleanup(func() { GlobalCluster = nil })
+
+	write := func(name string) string {
+		filePath := filepath.Join(storeDir, name)
+		if err := os.WriteFile(filePath, []byte(name), 0644); err != nil {
+			t.Fatalf("写入文件失败: %v", err)
+		}
+		return filePath
+	}
+	primary := write("primary.bin")
+	replica := write("replica.bin")
+	otherNode := write("other-node.bin")
+	orphan := write("orphan.bin")
+	nodes := &memoryNodeStore{nodes: make(map[primitive.ObjectID]*config.FileNode)}
+	for _, node := range []*config.FileNode{
+		{ID: primitive.NewObjectID(), Name: "primary.bin", Path: primary},
+		{ID: primitive.NewObjectID(), Name: "replica.bin", Replicas: []config.Replica{{NodeID: "node-a", Path: replica}}},
+		{ID: primitive.NewObjectID(), Name: "other-node.bin", Replicas: []config.Replica{{NodeID: "node-b", Path: otherNode}}},
+	} {
+		nodes.nodes[node.ID] = node
+	}
+	server := NewClusterServer(nodes)
+	admin := context.WithValue(context.Background(), callerKey{}, Caller{Name: "node:node-b", AuthLevel: 1000})
+	drop := func(ctx context.Context, filePath string) error {
+		// 节点ID由调用方随意填写，不对应任何文件节点
+		_, err := server.DropReplica(ctx, &filesharev1.DropReplicaRequest{NodeId: primitive.NewObjectID().Hex(), Path: filePath})
+		return err
+	}
+
+	if err := drop(context.Background(), orphan); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("非管理员调用应返回 PermissionDenied，实际为 %v", err)
+	}
+	for _, filePath := range []string{primary, replica} {
+		if err := drop(admin, filePath); status.Code(err) != codes.FailedPrecondition {
+			t.Fatalf("删除仍在使用的 %s 应返回 FailedPrecondition，实际为 %v", filePath, err)
+		}
+		if _, err := os.Stat(filePath); err != nil {
+			t.Fatalf("仍在使用的文件被删除: %v", err)
+		}
+	}
+	// 同一文件的其他写法不能绕过检查
+	if err := drop(admin, storeDir+"/./primary.bin"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("未规范化的路径应返回 InvalidArgument，实际为 %v", err)
+	}
+	if err := drop(admin, filepath.Join(storeDir, "..", "outside.bin")); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("存储目录之外的路径应返回 InvalidArgument，实际为 %v", err)
+	}
+
+	// 只登记在其他节点上的路径和没有被引用的文件可以删除
+	for _, filePath := range []string{otherNode, orphan} {
+		if err := drop(admin, filePath); err != nil {
+			t.Fatalf("删除 %s 失败: %v", filePath, err)
+		}
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Fatalf("%s 应已被删除: %v", filePath, err)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"GoFileShare/config"
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"GoFileShare/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rpcTaskHistory  = 100 // 保留的已结束任务数
+	rpcDefaultLimit = 100
+)
+
+// FileShareServer 实现 fileshare.v1.TransferService，任务交给传输服务执行
+type FileShareServer struct {
+	filesharev1.UnimplementedTransferServiceServer
+
+	transfer *TransferService
+	storeDir string // 下载文件的保存目录
+
+	mu    sync.Mutex
+	tasks map[string]*filesharev1.Task
+}
+
+// NewFileShareServer 创建 gRPC 传输服务，文件保存到 storeDir
+func NewFileShareServer(transfer *TransferService, storeDir string) *FileShareServer {
+	return &FileShareServer{
+		transfer: transfer,
+		storeDir: storeDir,
+		tasks:    make(map[string]*filesharev1.Task),
+	}
+}
+
+// SubmitTask 校验请求并提交下载任务
+func (s *FileShareServer) SubmitTask(ctx context.Context, req *filesharev1.SubmitTaskRequest) (*filesharev1.SubmitTaskResponse, error) {
+	if s.transfer == nil {
+		return nil, status.Error(codes.Unavailable, "传输服务未初始化")
+	}
+	caller := rpcCallerFromContext(ctx)
+	u, err := url.Parse(req.GetUrl())
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "无效的下载地址: %s", req.GetUrl())
+	}
+	name := req.GetFileName()
+	if name == "" {
+		name = path.Base(u.Path)
+	}
+	name = filepath.Base(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." || name == "/" {
+		name = "download"
+	}
+
+	headers := make(http.Header)
+	for _, header := range req.GetHeaders() {
+		if header.GetName() == "" {
+			return nil, status.Error(codes.InvalidArgument, "请求头名称不能为空")
+		}
+		headers.Add(header.GetName(), header.GetValue())
+	}
+	opts := DownloadOptions{
+		ManifestURL:  req.GetManifestUrl(),
+		ExpectedHash: req.GetExpectedSha256(),
+		Owner:        caller.Name,
+		Sources:      req.GetSources(),
+		Headers:      headers,
+		Priority:     priorityFromProto(req.GetPriority()),
+		// 地址由调用方提供，只有管理员可以让服务器访问内网
+		PublicOnly: !caller.IsAdmin(),
+	}
+	if opts.PublicOnly {
+		if err := checkPublicURLs(ctx, append([]string{req.GetUrl(), req.GetManifestUrl()}, req.GetSources()...)); err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	record := &filesharev1.Task{
+		Url:             req.GetUrl(),
+		State:           filesharev1.TaskState_TASK_STATE_QUEUED,
+		FileSize:        -1,
+		Owner:           caller.Name,
+		CreatedAtUnixMs: now,
+		UpdatedAtUnixMs: now,
+	}
+	var filePath string
+	record.FileName, filePath = utils.GlobalPathReserver.Reserve(s.storeDir, name)
+
+	// 提交失败时 AddDownloadTaskWithOptions 会同步调用 onError 且任务为 nil
+	var startErr error
+	taskID := s.transfer.AddDownloadTaskWithOptions(req.GetUrl(), filePath, opts,
+		func(progress float64) {
+			s.update(record, func() {
+				record.State = filesharev1.TaskState_TASK_STATE_RUNNING
+				record.Progress = progress
+			})
+		},
+		func(task *FileTask) {
+			utils.GlobalPathReserver.Release(filePath)
+			s.update(record, func() {
+				record.State = filesharev1.TaskState_TASK_STATE_COMPLETED
+				record.Progress = 100
+			})
+			color.Green("gRPC 下载任务 %s 完成: %s", task.ID, task.FilePath)
+		},
+		func(task *FileTask, err error) {
+			if task == nil {
+				startErr = err
+				return
+			}
+			utils.GlobalPathReserver.Release(filePath)
+			s.update(record, func() {
+				if errors.Is(err, errTaskCancelled) {
+					record.State = filesharev1.TaskState_TASK_STATE_CANCELLED
+					return
+				}
+				record.State = filesharev1.TaskState_TASK_STATE_FAILED
+				record.Error = err.Error()
+			})
+		})
+	if taskID == "" {
+		utils.GlobalPathReserver.Release(filePath)
+		if startErr == nil {
+			startErr = errors.New("创建下载任务失败")
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "创建下载任务失败: %v", startErr)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record.Id = taskID
+	if task, ok := s.transfer.GetTaskStatus(taskID); ok {
+		record.FileSize = task.FileSize
+		record.RangeSupported = task.RangeSupported
+	}
+	s.tasks[taskID] = record
+	s.pruneLocked()
+	return &filesharev1.SubmitTaskResponse{Task: proto.Clone(record).(*filesharev1.Task)}, nil
+}
+
+// GetTask 返回任务的当前状态
+func (s *FileShareServer) GetTask(ctx context.Context, req *filesharev1.GetTaskRequest) (*filesharev1.GetTaskResponse, error) {
+	task, err := s.snapshot(rpcCallerFromContext(ctx), req.GetTaskId())
+	if err != nil {
+		return nil, err
+	}
+	return &filesharev1.GetTaskResponse{Task: task}, nil
+}
+
+// CancelTask 取消任务，已结束的任务返回 FailedPrecondition
+func (s *FileShareServer) CancelTask(ctx context.Context, req *filesharev1.CancelTaskRequest) (*filesharev1.CancelTaskResponse, error) {
+	task, err := s.snapshot(rpcCallerFromContext(ctx), req.GetTaskId())
+	if err != nil {
+		return nil, err
+	}
+	if rpcTaskFinished(task) || !s.transfer.CancelTask(task.GetId()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "任务已结束: %s", task.GetState())
+	}
+	return &filesharev1.CancelTaskResponse{Task: task}, nil
+}
+
+// ListTasks 按创建时间倒序列出调用方的任务，管理员可以看到所有任务
+func (s *FileShareServer) ListTasks(ctx context.Context, req *filesharev1.ListTasksRequest) (*filesharev1.ListTasksResponse, error) {
+	caller := rpcCallerFromContext(ctx)
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = rpcDefaultLimit
+	}
+
+	s.mu.Lock()
+	tasks := make([]*filesharev1.Task, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		if !caller.IsAdmin() && task.GetOwner() != caller.Name {
+			continue
+		}
+		if req.GetState() != filesharev1.TaskState_TASK_STATE_UNSPECIFIED && task.GetState() != req.GetState() {
+			continue
+		}
+		tasks = append(tasks, proto.Clone(task).(*filesharev1.Task))
+	}
+	s.mu.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].GetCreatedAtUnixMs() != tasks[j].GetCreatedAtUnixMs() {
+			return tasks[i].GetCreatedAtUnixMs() > tasks[j].GetCreatedAtUnixMs()
+		}
+		return tasks[i].GetId() > tasks[j].GetId()
+	})
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return &filesharev1.ListTasksResponse{Tasks: tasks}, nil
+}
+
+// snapshot 返回任务记录的副本，其他用户的任务只有管理员可见
+func (s *FileShareServer) snapshot(caller Caller, taskID string) (*filesharev1.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[taskID]
+	if !ok || (!caller.IsAdmin() && task.GetOwner() != caller.Name) {
+		return nil, status.Errorf(codes.NotFound, "任务不存在: %s", taskID)
+	}
+	return proto.Clone(task).(*filesharev1.Task), nil
+}
+
+// update 在锁内修改任务记录，已结束的任务不再变化
+func (s *FileShareServer) update(record *filesharev1.Task, change func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rpcTaskFinished(record) {
+		return
+	}
+	change()
+	record.UpdatedAtUnixMs = time.Now().UnixMilli()
+}
+
+// pruneLocked 只保留最近的已结束任务
+func (s *FileShareServer) pruneLocked() {
+	var finished []*filesharev1.Task
+	for _, task := range s.tasks {
+		if rpcTaskFinished(task) {
+			finished = append(finished, task)
+		}
+	}
+	if len(finished) <= rpcTaskHistory {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].GetUpdatedAtUnixMs() < finished[j].GetUpdatedAtUnixMs()
+	})
+	for _, task := range finished[:len(finished)-rpcTaskHistory] {
+		delete(s.tasks, task.GetId())
+	}
+}
+
+func rpcTaskFinished(task *filesharev1.Task) bool {
+	switch task.GetState() {
+	case filesharev1.TaskState_TASK_STATE_COMPLETED, filesharev1.TaskState_TASK_STATE_FAILED, filesharev1.TaskState_TASK_STATE_CANCELLED:
+		return true
+	}
+	return false
+}
+
+func priorityFromProto(priority filesharev1.Priority) utils.Priority {
+	switch priority {
+	case filesharev1.Priority_PRIORITY_LOW:
+		return utils.PriorityLow
+	case filesharev1.Priority_PRIORITY_HIGH:
+		return utils.PriorityHigh
+	}
+	return utils.PriorityNormal
+}
+
+// NewGRPCServer 创建注册了所有服务的 gRPC 服务器，不监听端口，便于在进程内通过 bufconn 调用。
+// 所有调用都经过 auth 认证，TLS 凭据通过 opts 传入
+func NewGRPCServer(transfer *TransferService, nodes FileNodeStore, auth GRPCAuth, storeDir string, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(authUnaryInterceptor(auth)),
+		grpc.ChainStreamInterceptor(authStreamInterceptor(auth)),
+	}, opts...)
+	server := grpc.NewServer(opts...)
+	filesharev1.RegisterTransferServiceServer(server, NewFileShareServer(transfer, storeDir))
+	filesharev1.RegisterFileServiceServer(server, NewFileStreamServer(nodes, storeDir))
+	filesharev1.RegisterFileTreeServiceServer(server, NewFileTreeServer())
+	filesharev1.RegisterClusterServiceServer(server, NewClusterServer(nodes))
+	return server
+}
+
+// DialFileShare 连接其他节点的 gRPC 服务，再通过 filesharev1.NewXxxClient(conn) 创建客户端。
+// 配置了节点证书时使用 mTLS，token 为空时只以节点身份调用
+func DialFileShare(addr, token string) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if reloader := GlobalGRPCTLS; reloader != nil {
+		serverName := tlsServerName(addr, utils.GetEnv("GRPC_TLS_SERVER_NAME", ""))
+		opts[0] = grpc.WithTransportCredentials(credentials.NewTLS(reloader.ClientConfig(serverName)))
+	}
+	if token != "" {
+		opts = append(opts, WithToken(token))
+	}
+	return grpc.NewClient(addr, opts...)
+}
+
+// GlobalGRPCServer 全局 gRPC 服务器
+var GlobalGRPCServer *grpc.Server
+
+// GlobalGRPCTLS 节点证书，未配置 GRPC_TLS_CERT 时为 nil
+var GlobalGRPCTLS *TLSReloader
+
+// loadGRPCSecurity 根据 GRPC_TLS_CERT、GRPC_TLS_KEY、GRPC_TLS_CA 和 GRPC_ACL_FILE 加载证书和访问控制
+func loadGRPCSecurity() (*TLSReloader, *PeerACL, error) {
+	certFile := utils.GetEnv("GRPC_TLS_CERT", "")
+	keyFile := utils.GetEnv("GRPC_TLS_KEY", "")
+	caFile := utils.GetEnv("GRPC_TLS_CA", "")
+	aclFile := utils.GetEnv("GRPC_ACL_FILE", "")
+	if certFile == "" {
+		if aclFile != "" {
+			return nil, nil, errors.New("GRPC_ACL_FILE 需要同时配置 GRPC_TLS_CERT")
+		}
+		allowInsecure, err := envBool("GRPC_INSECURE")
+		if err != nil {
+			return nil, nil, err
+		}
+		if !allowInsecure {
+			return nil, nil, errors.New("未配置 GRPC_TLS_CERT；只在开发环境使用明文连接时设置 GRPC_INSECURE=true")
+		}
+		return nil, nil, nil
+	}
+	if keyFile == "" || caFile == "" {
+		return nil, nil, errors.New("启用 mTLS 需要同时配置 GRPC_TLS_CERT、GRPC_TLS_KEY 和 GRPC_TLS_CA")
+	}
+	reloader, err := NewTLSReloader(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if aclFile == "" {
+		return reloader, nil, nil
+	}
+	acl, err := NewPeerACL(aclFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reloader, acl, nil
+}
+
+// envBool 读取布尔环境变量，未设置时为 false
+func envBool(key string) (bool, error) {
+	value := utils.GetEnv(key, "")
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s 应为 true 或 false: %q", key, value)
+	}
+	return enabled, nil
+}
+
+// InitGRPCServer 在 GRPC_ADDR（默认 :18521）上启动 gRPC 服务，需要在传输服务之后调用
+func InitGRPCServer() error {
+	transfer := GetTransferService()
+	if transfer == nil {
+		return errors.New("传输服务未初始化")
+	}
+	reloader, acl, err := loadGRPCSecurity()
+	if err != nil {
+		return err
+	}
+	withReflection, err := envBool("GRPC_REFLECTION")
+	if err != nil {
+		return err
+	}
+	var opts []grpc.ServerOption
+	if reloader != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(reloader.ServerConfig())))
+	} else {
+		color.Yellow("gRPC 设置了 GRPC_INSECURE，使用明文连接，仅适合开发环境")
+	}
+	addr := utils.GetEnv("GRPC_ADDR", ":18521")
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("gRPC 监听 %s 失败: %w", addr, err)
+	}
+
+	auth := GRPCAuth{Tokens: AuthenticateAPIToken, ACL: acl, Users: LookupUserCaller}
+	server := NewGRPCServer(transfer, MongoNodeStore, auth, filepath.Join(config.RootPath, "FileStore"), opts...)
+	if withReflection {
+		// 反射服务同样经过认证拦截器，只有持有令牌或被访问控制规则允许的调用方可以列出服务
+		reflection.Register(server)
+	}
+	GlobalGRPCServer = server
+	GlobalGRPCTLS = reloader
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logger.Errorf("gRPC server stopped: %v", err)
+			color.Red("gRPC server stopped: %v", err)
+		}
+	}()
+	color.Green("gRPC 服务已启动: %s", addr)
+	return nil
+}
+
+// StopGRPCServer 等待进行中的调用结束后停止 gRPC 服务，超时后强制关闭
+func StopGRPCServer() {
+	server := GlobalGRPCServer
+	if server == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		server.Stop()
+	}
+}
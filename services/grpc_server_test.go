@@ -0,0 +1,365 @@
+package services
+
+import (
+	"GoFileShare/config"
+	"GoFileShare/models"
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryNodeStore 测试用的文件节点存储
+type memoryNodeStore struct {
+	mu    sync.Mutex
+	nodes map[primitive.ObjectID]*config.FileNode
+}
+
+func (s *memoryNodeStore) Get(id primitive.ObjectID) (*config.FileNode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, ok := s.nodes[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *node
+	return &copied, nil
+}
+
+func (s *memoryNodeStore) Create(path, name, parentID string, authLevel int) (*config.FileNode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node := &config.FileNode{ID: primitive.NewObjectID(), Name: name, Path: path, EffectiveAuthLevel: authLevel}
+	s.nodes[node.ID] = node
+	return node, nil
+}
+
+func (s *memoryNodeStore) UsesPath(path, nodeID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, node := range s.nodes {
+		if node.Path == path || (node.Storage != nil && node.Storage.SystemFilePath == path) {
+			return true, nil
+		}
+		if replica := node.ReplicaOn(nodeID); replica != nil && replica.Path == path {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// testTokens 令牌即用户名，"admin" 为管理员
+func testTokens(token string) (Caller, error) {
+	switch token {
+	case "alice", "bob":
+		return Caller{Name: token, AuthLevel: 1}, nil
+	case "admin":
+		return Caller{Name: token, AuthLevel: 1000}, nil
+	}
+	return Caller{}, ErrInvalidToken
+}
+
+// grpcTestEnv 与 main.go 一样在同一进程中运行 Gin 和 gRPC 服务，gRPC 通过 bufconn 连接
+type grpcTestEnv struct {
+	web      *httptest.Server
+	storeDir string
+	nodes    *memoryNodeStore
+	listener *bufconn.Listener
+}
+
+func newGRPCTestEnv(t *testing.T, content []byte) *grpcTestEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/files/data.bin", func(c *gin.Context) {
+		http.ServeContent(c.Writer, c.Request, "data.bin", time.Unix(0, 0), bytes.NewReader(content))
+	})
+	web := httptest.NewServer(router)
+	t.Cleanup(web.Close)
+
+	transfer := NewTransferService(models.TransferConfig{
+		WorkerCount:  2,
+		MetaDir:      t.TempDir(),
+		ChunkSize:    64 << 10,
+		ChunkRetries: 1,
+	})
+	if transfer == nil {
+		t.Fatal("创建传输服务失败")
+	}
+	transfer.Start()
+	t.Cleanup(transfer.Stop)
+
+	env := &grpcTestEnv{
+		web:      web,
+		storeDir: t.TempDir(),
+		nodes:    &memoryNodeStore{nodes: make(map[primitive.ObjectID]*config.FileNode)},
+		listener: bufconn.Listen(1 << 20),
+	}
+	server := NewGRPCServer(transfer, env.nodes, GRPCAuth{Tokens: testTokens}, env.storeDir)
+	go server.Serve(env.listener)
+	t.Cleanup(server.Stop)
+	return env
+}
+
+// dial 以 token 连接 bufconn 上的服务，token 为空时不携带令牌
+func (e *grpcTestEnv) dial(t *testing.T, token string) *grpc.ClientConn {
+	t.Helper()
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return e.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+	if token != "" {
+		opts = append(opts, WithToken(token))
+	}
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatalf("连接 gRPC 服务失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i*7 + i/251)
+	}
+	return content
+}
+
+func TestGRPCRequiresToken(t *testing.T) {
+	env := newGRPCTestEnv(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := filesharev1.NewTransferServiceClient(env.dial(t, "")).ListTasks(ctx, &filesharev1.ListTasksRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("没有令牌时应返回 Unauthenticated，实际为 %v", err)
+	}
+	_, err = filesharev1.NewTransferServiceClient(env.dial(t, "mallory")).ListTasks(ctx, &filesharev1.ListTasksRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("无效令牌应返回 Unauthenticated，实际为 %v", err)
+	}
+}
+
+func TestGRPCSubmitTaskDownloadsFromGin(t *testing.T) {
+	content := testContent(300 << 10)
+	env := newGRPCTestEnv(t, content)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// 普通用户不能让服务器下载本机或内网地址，包括数据源和清单地址
+	alice := filesharev1.NewTransferServiceClient(env.dial(t, "alice"))
+	for _, req := range []*filesharev1.SubmitTaskRequest{
+		{Url: env.web.URL + "/files/data.bin"},
+		{Url: "https://example.com/data.bin", Sources: []string{env.web.URL + "/files/data.bin"}},
+		{Url: "https://example.com/data.bin", ManifestUrl: env.web.URL + "/manifest"},
+	} {
+		if _, err := alice.SubmitTask(ctx, req); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("普通用户提交内网地址应返回 PermissionDenied，实际为 %v", err)
+		}
+	}
+
+	admin := filesharev1.NewTransferServiceClient(env.dial(t, "admin"))
+	submitted, err := admin.SubmitTask(ctx, &filesharev1.SubmitTaskRequest{
+		Url:      env.web.URL + "/files/data.bin",
+		Priority: filesharev1.Priority_PRIORITY_HIGH,
+	})
+	if err != nil {
+		t.Fatalf("提交任务失败: %v", err)
+	}
+	taskID := submitted.GetTask().GetId()
+
+	var task *filesharev1.Task
+	for {
+		resp, err := admin.GetTask(ctx, &filesharev1.GetTaskRequest{TaskId: taskID})
+		if err != nil {
+			t.Fatalf("查询任务失败: %v", err)
+		}
+		task = resp.GetTask()
+		if rpcTaskFinished(task) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("任务没有完成，当前状态 %s", task.GetState())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if task.GetState() != filesharev1.TaskState_TASK_STATE_COMPLETED {
+		t.Fatalf("任务状态为 %s: %s", task.GetState(), task.GetError())
+	}
+	saved, err := os.ReadFile(filepath.Join(env.storeDir, task.GetFileName()))
+	if err != nil {
+		t.Fatalf("读取下载的文件失败: %v", err)
+	}
+	if !bytes.Equal(saved, content) {
+		t.Fatalf("下载的内容不一致: %d/%d 字节", len(saved), len(content))
+	}
+
+	// 其他用户看不到管理员的任务，管理员的列表中只有这一个任务
+	if _, err := alice.GetTask(ctx, &filesharev1.GetTaskRequest{TaskId: taskID}); status.Code(err) != codes.NotFound {
+		t.Fatalf("alice 查询管理员的任务应返回 NotFound，实际为 %v", err)
+	}
+	listed, err := admin.ListTasks(ctx, &filesharev1.ListTasksRequest{})
+	if err != nil || len(listed.GetTasks()) != 1 {
+		t.Fatalf("管理员应看到 1 个任务: %v %v", listed.GetTasks(), err)
+	}
+}
+
+func TestGRPCUploadResumeAndDownload(t *testing.T) {
+	content := testContent(200 << 10)
+	sum := sha256.Sum256(content)
+	env := newGRPCTestEnv(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	files := filesharev1.NewFileServiceClient(env.dial(t, "alice"))
+
+	header := &filesharev1.UploadHeader{
+		UploadId: "test-upload-1",
+		ParentId: "root",
+		FileName: "upload.bin",
+		FileSize: int64(len(content)),
+		Sha256:   hex.EncodeToString(sum[:]),
+	}
+	send := func(files filesharev1.FileServiceClient, from, to int64) (*filesharev1.UploadResponse, error) {
+		stream, err := files.Upload(ctx)
+		if err != nil {
+			return nil, err
+		}
+		first := proto.Clone(header).(*filesharev1.UploadHeader)
+		first.Offset = from
+		if err := stream.Send(&filesharev1.UploadRequest{Payload: &filesharev1.UploadRequest_Header{Header: first}}); err != nil {
+			return nil, err
+		}
+		for offset := from; offset < to; offset += 32 << 10 {
+			data := content[offset:min(offset+32<<10, to)]
+			err := stream.Send(&filesharev1.UploadRequest{Payload: &filesharev1.UploadRequest_Chunk{Chunk: &filesharev1.FileChunk{
+				Offset: offset,
+				Data:   data,
+				Crc32C: crc32.Checksum(data, castagnoli),
+			}}})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return stream.CloseAndRecv()
+	}
+
+	// 只发送前一半，服务端记录已接收的偏移量
+	half := int64(len(content) / 2)
+	if _, err := send(files, 0, half); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("上传一半时应返回 FailedPrecondition，实际为 %v", err)
+	}
+	progress, err := files.UploadStatus(ctx, &filesharev1.UploadStatusRequest{UploadId: header.UploadId})
+	if err != nil || progress.GetOffset() != half {
+		t.Fatalf("续传偏移量应为 %d: %v %v", half, progress, err)
+	}
+
+	// 其他用户使用相同的 upload_id 看不到也不能续传 alice 的上传
+	bob := filesharev1.NewFileServiceClient(env.dial(t, "bob"))
+	if _, err := bob.UploadStatus(ctx, &filesharev1.UploadStatusRequest{UploadId: header.UploadId}); status.Code(err) != codes.NotFound {
+		t.Fatalf("bob 查询 alice 的上传应返回 NotFound，实际为 %v", err)
+	}
+	if _, err := send(bob, half, int64(len(content))); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("bob 从 alice 的偏移量续传应返回 OutOfRange，实际为 %v", err)
+	}
+
+	uploaded, err := send(files, progress.GetOffset(), int64(len(content)))
+	if err != nil {
+		t.Fatalf("续传失败: %v", err)
+	}
+
+	download, err := files.Download(ctx, &filesharev1.DownloadRequest{NodeId: uploaded.GetNodeId()})
+	if err != nil {
+		t.Fatalf("下载失败: %v", err)
+	}
+	var received bytes.Buffer
+	var info *filesharev1.FileInfo
+	for {
+		resp, err := download.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("接收下载数据失败: %v", err)
+		}
+		if resp.GetInfo() != nil {
+			info = resp.GetInfo()
+			continue
+		}
+		received.Write(resp.GetChunk().GetData())
+	}
+	if info == nil || info.GetSha256() != header.Sha256 {
+		t.Fatalf("文件信息中的哈希不一致: %v", info)
+	}
+	if !bytes.Equal(received.Bytes(), content) {
+		t.Fatalf("下载的内容不一致: %d/%d 字节", received.Len(), len(content))
+	}
+}
+
+func TestFileHashCache(t *testing.T) {
+	cache := newFileHashCache(1)
+	dir := t.TempDir()
+	sum := func(path string) string {
+		t.Helper()
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("打开文件失败: %v", err)
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			t.Fatalf("读取文件信息失败: %v", err)
+		}
+		hash, err := cache.sum(path, file, info)
+		if err != nil {
+			t.Fatalf("计算哈希失败: %v", err)
+		}
+		return hash
+	}
+	expect := func(content []byte) string {
+		hash := sha256.Sum256(content)
+		return hex.EncodeToString(hash[:])
+	}
+
+	first := filepath.Join(dir, "first.bin")
+	os.WriteFile(first, []byte("first"), 0644)
+	if got := sum(first); got != expect([]byte("first")) {
+		t.Fatalf("哈希错误: %s", got)
+	}
+	// 文件内容变化后重新计算
+	os.WriteFile(first, []byte("changed content"), 0644)
+	if got := sum(first); got != expect([]byte("changed content")) {
+		t.Fatalf("文件变化后应重新计算哈希: %s", got)
+	}
+	// 超过容量时淘汰旧的条目
+	second := filepath.Join(dir, "second.bin")
+	os.WriteFile(second, []byte("second"), 0644)
+	sum(second)
+	if len(cache.entries) != 1 || cache.entries[second] == nil {
+		t.Fatalf("缓存应只保留最近使用的文件: %v", cache.entries)
+	}
+}
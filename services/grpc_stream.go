@@ -0,0 +1,738 @@
+package services
+
+import (
+	"GoFileShare/config"
+	"GoFileShare/models"
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"GoFileShare/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	streamChunkSize    = 256 * 1024  // 默认分块大小
+	streamMaxChunkSize = 1024 * 1024 // 单个分块的上限
+	fileHashCacheSize  = 1024        // 缓存哈希的文件数
+)
+
+// castagnoli 分块校验使用的 CRC-32C 表
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// FileNodeStore 流式传输读写文件节点的接口，默认实现访问 MongoDB
+type FileNodeStore interface {
+	Get(id primitive.ObjectID) (*config.FileNode, error) // 节点不存在时返回 nil
+	Create(path, name, parentID string, authLevel int) (*config.FileNode, error)
+	UsesPath(path, nodeID string) (bool, error) // 是否有节点以 path 为主路径或 nodeID 上的副本路径
+}
+
+// MongoNodeStore 基于 models 的文件节点存储
+var MongoNodeStore FileNodeStore = mongoNodeStore{}
+
+type mongoNodeStore struct{}
+
+func (mongoNodeStore) Get(id primitive.ObjectID) (*config.FileNode, error) {
+	nodes, err := models.SearchFileNodeByID(id)
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	return &nodes[0], nil
+}
+
+func (mongoNodeStore) Create(path, name, parentID string, authLevel int) (*config.FileNode, error) {
+	return models.CreateFileNode(path, name, false, parentID, authLevel)
+}
+
+func (mongoNodeStore) UsesPath(path, nodeID string) (bool, error) {
+	return models.FileNodeUsesPath(path, nodeID)
+}
+
+// uploadState 上传进度，保存在 .uploads/<uploadKey>.json，数据写入同名的 .part 文件
+type uploadState struct {
+	UploadID  string    `json:"upload_id"`
+	ParentID  string    `json:"parent_id"`
+	FileName  string    `json:"file_name"`
+	FileSize  int64     `json:"file_size"`
+	SHA256    string    `json:"sha256"`
+	Owner     string    `json:"owner"`
+	AuthLevel int       `json:"auth_level"` // 新节点的权限等级，继承父文件夹
+	Completed bool      `json:"completed"`
+	NodeID    string    `json:"node_id,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// matches 断点续传时请求头必须与第一次上传一致
+func (u *uploadState) matches(header *filesharev1.UploadHeader, owner string) bool {
+	return u.Owner == owner &&
+		u.ParentID == normalizeParentID(header.GetParentId()) &&
+		u.FileName == header.GetFileName() &&
+		u.FileSize == header.GetFileSize() &&
+		strings.EqualFold(u.SHA256, header.GetSha256())
+}
+
+// key 上传在服务器上的存储键
+func (u *uploadState) key() string {
+	return uploadKey(u.Owner, u.UploadID)
+}
+
+// uploadKey upload_id 由客户端选择，按调用方区分，其他用户使用相同的ID不会访问到同一个上传
+func uploadKey(owner, uploadID string) string {
+	sum := sha256.Sum256([]byte(owner))
+	return hex.EncodeToString(sum[:16]) + "-" + uploadID
+}
+
+func (u *uploadState) response() *filesharev1.UploadResponse {
+	return &filesharev1.UploadResponse{
+		UploadId: u.UploadID,
+		NodeId:   u.NodeID,
+		Size:     u.FileSize,
+		Sha256:   u.SHA256,
+	}
+}
+
+// FileStreamServer 实现 fileshare.v1.FileService，在服务器之间分块传输文件内容
+type FileStreamServer struct {
+	filesharev1.UnimplementedFileServiceServer
+
+	nodes    FileNodeStore
+	storeDir string // 完成的文件保存目录
+	partDir  string // 未完成的上传
+
+	mu      sync.Mutex
+	uploads map[string]bool // 正在接收数据的上传，键为 uploadKey
+	hashes  *fileHashCache
+}
+
+// NewFileStreamServer 创建流式传输服务，文件保存到 storeDir
+func NewFileStreamServer(nodes FileNodeStore, storeDir string) *FileStreamServer {
+	return &FileStreamServer{
+		nodes:    nodes,
+		storeDir: storeDir,
+		partDir:  filepath.Join(storeDir, ".uploads"),
+		uploads:  make(map[string]bool),
+		hashes:   newFileHashCache(fileHashCacheSize),
+	}
+}
+
+// fileHashCache 按路径缓存文件的 SHA-256，文件大小或修改时间变化后重新计算，
+// 超过容量时淘汰最久没有使用的条目
+type fileHashCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*fileHashEntry
+}
+
+type fileHashEntry struct {
+	size    int64
+	modTime time.Time
+	hash    string
+	used    time.Time
+}
+
+func newFileHashCache(capacity int) *fileHashCache {
+	return &fileHashCache{capacity: capacity, entries: make(map[string]*fileHashEntry)}
+}
+
+// sum 返回已打开文件的哈希，info 为该文件的 Stat 结果
+func (c *fileHashCache) sum(path string, file *os.File, info os.FileInfo) (string, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[path]; ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		entry.used = time.Now()
+		c.mu.Unlock()
+		return entry.hash, nil
+	}
+	c.mu.Unlock()
+
+	hash, err := utils.SHA256Range(file, 0, info.Size())
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[path]; !ok && len(c.entries) >= c.capacity {
+		var oldest string
+		for key, entry := range c.entries {
+			if oldest == "" || entry.used.Before(c.entries[oldest].used) {
+				oldest = key
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[path] = &fileHashEntry{size: info.Size(), modTime: info.ModTime(), hash: hash, used: time.Now()}
+	return hash, nil
+}
+
+// Upload 接收文件内容：第一条消息是 header，之后按顺序发送分块。
+// 连接中断后用同一个 upload_id 和 UploadStatus 返回的偏移量继续上传；
+// 全部接收后校验 SHA-256，再挂到父文件夹下
+func (s *FileStreamServer) Upload(stream filesharev1.FileService_UploadServer) error {
+	caller := rpcCallerFromContext(stream.Context())
+	first, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return status.Error(codes.InvalidArgument, "缺少上传请求头")
+	}
+	if err != nil {
+		return err
+	}
+	header := first.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "第一条消息必须是上传请求头")
+	}
+	if err := validateUploadHeader(header); err != nil {
+		return err
+	}
+	uploadID := header.GetUploadId()
+	key := uploadKey(caller.Name, uploadID)
+	if !s.acquire(key) {
+		return status.Errorf(codes.Aborted, "上传 %s 正在进行", uploadID)
+	}
+	defer s.release(key)
+
+	state, err := s.loadState(key)
+	if err != nil {
+		return status.Errorf(codes.Internal, "读取上传状态失败: %v", err)
+	}
+	if state != nil {
+		if state.Owner != caller.Name {
+			return status.Errorf(codes.PermissionDenied, "上传 %s 属于其他用户", uploadID)
+		}
+		if !state.matches(header, caller.Name) {
+			return status.Errorf(codes.FailedPrecondition, "上传 %s 的参数与之前不一致", uploadID)
+		}
+		if state.Completed {
+			return stream.SendAndClose(state.response())
+		}
+	} else {
+		parentID := normalizeParentID(header.GetParentId())
+		authLevel, err := s.checkParent(parentID, caller.AuthLevel)
+		if err != nil {
+			return err
+		}
+		state = &uploadState{
+			UploadID:  uploadID,
+			ParentID:  parentID,
+			FileName:  header.GetFileName(),
+			FileSize:  header.GetFileSize(),
+			SHA256:    strings.ToLower(header.GetSha256()),
+			Owner:     caller.Name,
+			AuthLevel: authLevel,
+		}
+		if err := s.saveState(state); err != nil {
+			return status.Errorf(codes.Internal, "保存上传状态失败: %v", err)
+		}
+	}
+
+	partPath := s.partPath(key)
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return status.Errorf(codes.Internal, "打开临时文件失败: %v", err)
+	}
+	written, err := s.resumeOffset(file, header.GetOffset(), state.FileSize)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	written, err = receiveChunks(stream, file, written, state.FileSize)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = status.Errorf(codes.Internal, "写入临时文件失败: %v", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	if written < state.FileSize {
+		return status.Errorf(codes.FailedPrecondition, "上传未完成，已接收 %d/%d 字节", written, state.FileSize)
+	}
+
+	if err := s.finishUpload(state); err != nil {
+		return err
+	}
+	return stream.SendAndClose(state.response())
+}
+
+// UploadStatus 返回已接收的字节数，客户端从该偏移量继续上传
+func (s *FileStreamServer) UploadStatus(ctx context.Context, req *filesharev1.UploadStatusRequest) (*filesharev1.UploadStatusResponse, error) {
+	caller := rpcCallerFromContext(ctx)
+	if !uploadIDPattern.MatchString(req.GetUploadId()) {
+		return nil, status.Errorf(codes.InvalidArgument, "无效的上传ID: %s", req.GetUploadId())
+	}
+	state, err := s.loadState(uploadKey(caller.Name, req.GetUploadId()))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "读取上传状态失败: %v", err)
+	}
+	if state == nil || state.Owner != caller.Name {
+		return nil, status.Errorf(codes.NotFound, "上传不存在: %s", req.GetUploadId())
+	}
+	resp := &filesharev1.UploadStatusResponse{
+		UploadId:  state.UploadID,
+		Completed: state.Completed,
+		NodeId:    state.NodeID,
+	}
+	if state.Completed {
+		resp.Offset = state.FileSize
+	} else if info, err := os.Stat(s.partPath(state.key())); err == nil {
+		resp.Offset = min(info.Size(), state.FileSize)
+	}
+	return resp, nil
+}
+
+// Download 先发送文件信息，再从 offset 开始按顺序发送分块，客户端中断后可从已收到的位置继续
+func (s *FileStreamServer) Download(req *filesharev1.DownloadRequest, stream filesharev1.FileService_DownloadServer) error {
+	caller := rpcCallerFromContext(stream.Context())
+	nodeID, err := primitive.ObjectIDFromHex(req.GetNodeId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "无效的节点ID: %s", req.GetNodeId())
+	}
+	node, err := s.nodes.Get(nodeID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "查询文件节点失败: %v", err)
+	}
+	if node == nil || node.Type {
+		return status.Errorf(codes.NotFound, "文件不存在: %s", req.GetNodeId())
+	}
+	if caller.AuthLevel < node.EffectiveAuthLevel {
+		return status.Error(codes.PermissionDenied, "权限不足")
+	}
+	chunkSize := int64(req.GetChunkSize())
+	if chunkSize <= 0 {
+		chunkSize = streamChunkSize
+	}
+	chunkSize = min(chunkSize, streamMaxChunkSize)
+
+	filePath := nodeFilePath(*node)
+	if filePath == "" {
+		return status.Errorf(codes.NotFound, "文件内容不存在: %s", node.Name)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "打开文件失败: %v", err)
+	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			logger.Errorf("Error closing file %s: %v", filePath, err)
+			color.Red("Error closing file %s: %v", filePath, err)
+		}
+	}(file)
+	info, err := file.Stat()
+	if err != nil {
+		return status.Errorf(codes.Internal, "读取文件信息失败: %v", err)
+	}
+	offset := req.GetOffset()
+	if offset < 0 || offset > info.Size() {
+		return status.Errorf(codes.OutOfRange, "偏移量 %d 超出文件大小 %d", offset, info.Size())
+	}
+	// 文件没有变化时使用缓存的哈希，续传不必每次重新读取整个文件
+	fileHash, err := s.hashes.sum(filePath, file, info)
+	if err != nil {
+		return status.Errorf(codes.Internal, "计算文件哈希失败: %v", err)
+	}
+	err = stream.Send(&filesharev1.DownloadResponse{Payload: &filesharev1.DownloadResponse_Info{Info: &filesharev1.FileInfo{
+		NodeId:           node.ID.Hex(),
+		Name:             node.Name,
+		Size:             info.Size(),
+		Sha256:           fileHash,
+		ModifiedAtUnixMs: info.ModTime().UnixMilli(),
+	}}})
+	if err != nil {
+		return err
+	}
+
+	taskID := fmt.Sprintf("grpc-download-%s-%d", node.ID.Hex(), time.Now().UnixNano())
+	bandwidth := GetBandwidthManager()
+	defer bandwidth.ReleaseTask(taskID)
+	reader := bandwidth.Reader(stream.Context(), io.NewSectionReader(file, offset, info.Size()-offset), caller.Name, taskID)
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			data := buf[:n]
+			err := stream.Send(&filesharev1.DownloadResponse{Payload: &filesharev1.DownloadResponse_Chunk{Chunk: &filesharev1.FileChunk{
+				Offset: offset,
+				Data:   data,
+				Crc32C: crc32.Checksum(data, castagnoli),
+			}}})
+			if err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			if ctxErr := stream.Context().Err(); ctxErr != nil {
+				return status.FromContextError(ctxErr).Err()
+			}
+			return status.Errorf(codes.Internal, "读取文件失败: %v", err)
+		}
+	}
+}
+
+// resumeOffset 按请求头的偏移量定位临时文件：超过已接收的字节数时拒绝，小于时丢弃之后的数据
+func (s *FileStreamServer) resumeOffset(file *os.File, offset, fileSize int64) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "读取临时文件失败: %v", err)
+	}
+	received := min(info.Size(), fileSize)
+	if offset < 0 || offset > received {
+		return 0, status.Errorf(codes.OutOfRange, "偏移量 %d 超出已接收的 %d 字节", offset, received)
+	}
+	if offset < info.Size() {
+		if err := file.Truncate(offset); err != nil {
+			return 0, status.Errorf(codes.Internal, "截断临时文件失败: %v", err)
+		}
+	}
+	return offset, nil
+}
+
+// receiveChunks 按顺序写入分块直到客户端结束发送，返回已写入的字节数
+func receiveChunks(stream filesharev1.FileService_UploadServer, file *os.File, written, fileSize int64) (int64, error) {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+		chunk := req.GetChunk()
+		if chunk == nil {
+			return written, status.Error(codes.InvalidArgument, "请求头之后只能发送分块")
+		}
+		data := chunk.GetData()
+		if chunk.GetOffset() != written {
+			return written, status.Errorf(codes.InvalidArgument, "分块偏移量 %d 与已接收的 %d 字节不连续", chunk.GetOffset(), written)
+		}
+		if len(data) > streamMaxChunkSize || written+int64(len(data)) > fileSize {
+			return written, status.Errorf(codes.OutOfRange, "分块超出文件大小 %d", fileSize)
+		}
+		if crc32.Checksum(data, castagnoli) != chunk.GetCrc32C() {
+			return written, status.Errorf(codes.DataLoss, "偏移量 %d 的分块 CRC32C 校验失败", written)
+		}
+		if _, err := file.WriteAt(data, written); err != nil {
+			return written, status.Errorf(codes.Internal, "写入临时文件失败: %v", err)
+		}
+		written += int64(len(data))
+	}
+}
+
+// finishUpload 校验 SHA-256，把临时文件移到存储目录并创建文件节点
+func (s *FileStreamServer) finishUpload(state *uploadState) error {
+	partPath := s.partPath(state.key())
+	file, err := os.Open(partPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "打开临时文件失败: %v", err)
+	}
+	fileHash, err := utils.SHA256Range(file, 0, state.FileSize)
+	_ = file.Close()
+	if err != nil {
+		return status.Errorf(codes.Internal, "计算文件哈希失败: %v", err)
+	}
+	if !strings.EqualFold(fileHash, state.SHA256) {
+		_ = os.Remove(partPath)
+		_ = os.Remove(s.statePath(state.key()))
+		return status.Errorf(codes.DataLoss, "文件哈希不匹配，预期 %s，实际 %s", state.SHA256, fileHash)
+	}
+
+	// 文件名在重命名完成前一直被保留，其他上传或下载不会选中同一路径
+	name, storePath := utils.GlobalPathReserver.Reserve(s.storeDir, state.FileName)
+	err = os.Rename(partPath, storePath)
+	utils.GlobalPathReserver.Release(storePath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "保存文件失败: %v", err)
+	}
+	node, err := s.nodes.Create(storePath, name, state.ParentID, state.AuthLevel)
+	if err != nil {
+		// 放回临时文件，客户端重试时只需重新完成这一步
+		_ = os.Rename(storePath, partPath)
+		return status.Errorf(codes.Internal, "添加文件节点失败: %v", err)
+	}
+	state.Completed = true
+	state.NodeID = node.ID.Hex()
+	if err := s.saveState(state); err != nil {
+		logger.Errorf("保存上传状态失败 %s: %v", state.UploadID, err)
+	}
+	color.Green("gRPC 上传完成: %s -> %s", state.UploadID, storePath)
+	return nil
+}
+
+// checkParent 检查父文件夹存在且调用方有权限，返回新文件继承的权限等级
+func (s *FileStreamServer) checkParent(parentID string, authLevel int) (int, error) {
+	if parentID == "root" {
+		return 0, nil
+	}
+	objID, err := primitive.ObjectIDFromHex(parentID)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "无效的父节点ID: %s", parentID)
+	}
+	parent, err := s.nodes.Get(objID)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "查询父文件夹失败: %v", err)
+	}
+	if parent == nil || !parent.Type {
+		return 0, status.Errorf(codes.NotFound, "父文件夹不存在: %s", parentID)
+	}
+	if authLevel < parent.EffectiveAuthLevel {
+		return 0, status.Error(codes.PermissionDenied, "权限不足")
+	}
+	return parent.EffectiveAuthLevel, nil
+}
+
+func (s *FileStreamServer) acquire(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.uploads[key] {
+		return false
+	}
+	s.uploads[key] = true
+	return true
+}
+
+func (s *FileStreamServer) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploads, key)
+}
+
+func (s *FileStreamServer) statePath(key string) string {
+	return filepath.Join(s.partDir, key+".json")
+}
+
+func (s *FileStreamServer) partPath(key string) string {
+	return filepath.Join(s.partDir, key+".part")
+}
+
+// loadState 读取上传状态，不存在时返回 nil
+func (s *FileStreamServer) loadState(key string) (*uploadState, error) {
+	data, err := os.ReadFile(s.statePath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// saveState 先写临时文件再重命名，避免中途崩溃留下损坏的状态
+func (s *FileStreamServer) saveState(state *uploadState) error {
+	if err := os.MkdirAll(s.partDir, 0755); err != nil {
+		return err
+	}
+	state.UpdatedAt = time.Now()
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.statePath(state.key()) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.statePath(state.key()))
+}
+
+// validateUploadHeader 校验上传ID、文件名、大小和哈希格式
+func validateUploadHeader(header *filesharev1.UploadHeader) error {
+	if !uploadIDPattern.MatchString(header.GetUploadId()) {
+		return status.Errorf(codes.InvalidArgument, "无效的上传ID: %s", header.GetUploadId())
+	}
+	name := header.GetFileName()
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || strings.TrimSpace(name) != name {
+		return status.Errorf(codes.InvalidArgument, "无效的文件名: %q", name)
+	}
+	if header.GetFileSize() < 0 {
+		return status.Errorf(codes.InvalidArgument, "无效的文件大小: %d", header.GetFileSize())
+	}
+	if hash, err := hex.DecodeString(header.GetSha256()); err != nil || len(hash) != sha256.Size {
+		return status.Errorf(codes.InvalidArgument, "无效的 SHA-256: %s", header.GetSha256())
+	}
+	return nil
+}
+
+func normalizeParentID(parentID string) string {
+	if parentID == "" || parentID == "undefined" || parentID == "null" {
+		return "root"
+	}
+	return parentID
+}
+
+// UploadFile 把本地文件上传到其他节点的 parentID 文件夹，先查询 UploadStatus 从断点继续，
+// 同一个 uploadID 重复调用是幂等的
+func UploadFile(ctx context.Context, client filesharev1.FileServiceClient, localPath, parentID, uploadID string) (*filesharev1.UploadResponse, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			logger.Errorf("Error closing file %s: %v", localPath, err)
+			color.Red("Error closing file %s: %v", localPath, err)
+		}
+	}(file)
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fileHash, err := utils.SHA256Range(file, 0, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	var offset int64
+	progress, err := client.UploadStatus(ctx, &filesharev1.UploadStatusRequest{UploadId: uploadID})
+	switch {
+	case status.Code(err) == codes.NotFound:
+	case err != nil:
+		return nil, err
+	case progress.GetCompleted():
+		return &filesharev1.UploadResponse{UploadId: uploadID, NodeId: progress.GetNodeId(), Size: info.Size(), Sha256: fileHash}, nil
+	default:
+		offset = progress.GetOffset()
+	}
+
+	stream, err := client.Upload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = stream.Send(&filesharev1.UploadRequest{Payload: &filesharev1.UploadRequest_Header{Header: &filesharev1.UploadHeader{
+		UploadId: uploadID,
+		ParentId: parentID,
+		FileName: filepath.Base(localPath),
+		FileSize: info.Size(),
+		Sha256:   fileHash,
+		Offset:   offset,
+	}}})
+	buf := make([]byte, streamChunkSize)
+	for err == nil && offset < info.Size() {
+		var n int
+		n, err = file.ReadAt(buf, offset)
+		if n == 0 {
+			break
+		}
+		data := buf[:n]
+		err = stream.Send(&filesharev1.UploadRequest{Payload: &filesharev1.UploadRequest_Chunk{Chunk: &filesharev1.FileChunk{
+			Offset: offset,
+			Data:   data,
+			Crc32C: crc32.Checksum(data, castagnoli),
+		}}})
+		offset += int64(n)
+	}
+	// 服务器提前结束流时 Send 返回 io.EOF，真正的错误由 CloseAndRecv 返回
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return stream.CloseAndRecv()
+}
+
+// DownloadFile 从其他节点下载文件到 localPath，未完成的数据保存在 localPath.part，
+// 再次调用时从已下载的位置继续，全部接收后校验 SHA-256
+func DownloadFile(ctx context.Context, client filesharev1.FileServiceClient, nodeID, localPath string) (*filesharev1.FileInfo, error) {
+	partPath := localPath + ".part"
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := downloadToPart(ctx, client, nodeID, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if info, statErr := os.Stat(partPath); statErr == nil && info.Size() == 0 {
+			_ = os.Remove(partPath)
+		}
+		return nil, err
+	}
+
+	file, err = os.Open(partPath)
+	if err != nil {
+		return nil, err
+	}
+	fileHash, err := utils.SHA256Range(file, 0, fileInfo.GetSize())
+	_ = file.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(fileHash, fileInfo.GetSha256()) {
+		// 远程文件可能在两次下载之间被替换，丢弃旧数据
+		_ = os.Remove(partPath)
+		return nil, fmt.Errorf("文件哈希不匹配，预期 %s，实际 %s", fileInfo.GetSha256(), fileHash)
+	}
+	if err := os.Rename(partPath, localPath); err != nil {
+		return nil, err
+	}
+	return fileInfo, nil
+}
+
+// downloadToPart 从临时文件的末尾继续接收分块，临时文件比远程文件大时从头下载
+func downloadToPart(ctx context.Context, client filesharev1.FileServiceClient, nodeID string, file *os.File) (*filesharev1.FileInfo, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size()
+	stream, err := client.Download(ctx, &filesharev1.DownloadRequest{NodeId: nodeID, Offset: offset})
+	if err != nil {
+		return nil, err
+	}
+	first, err := stream.Recv()
+	if status.Code(err) == codes.OutOfRange && offset > 0 {
+		if err := file.Truncate(0); err != nil {
+			return nil, err
+		}
+		return downloadToPart(ctx, client, nodeID, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fileInfo := first.GetInfo()
+	if fileInfo == nil {
+		return nil, errors.New("第一条消息必须是文件信息")
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunk := resp.GetChunk()
+		if chunk == nil || chunk.GetOffset() != offset {
+			return nil, fmt.Errorf("分块不连续，预期偏移量 %d", offset)
+		}
+		if crc32.Checksum(chunk.GetData(), castagnoli) != chunk.GetCrc32C() {
+			return nil, fmt.Errorf("偏移量 %d 的分块 CRC32C 校验失败", offset)
+		}
+		if _, err := file.WriteAt(chunk.GetData(), offset); err != nil {
+			return nil, err
+		}
+		offset += int64(len(chunk.GetData()))
+	}
+	if offset != fileInfo.GetSize() {
+		return nil, fmt.Errorf("下载未完成，已接收 %d/%d 字节", offset, fileInfo.GetSize())
+	}
+	return fileInfo, nil
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSReloader 持有节点证书和 CA，文件修改后在下一次握手时自动重新加载，不需要重启服务。
+// 同一张证书既用作服务端证书，也用作连接其他节点时的客户端证书
+type TLSReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	versions [3]time.Time // 三个文件上次加载时的修改时间
+}
+
+// NewTLSReloader 加载节点证书、私钥和用于校验对端的 CA 证书
+func NewTLSReloader(certFile, keyFile, caFile string) (*TLSReloader, error) {
+	r := &TLSReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 重新读取证书文件，失败时保留之前的证书
+func (r *TLSReloader) Reload() error {
+	versions := r.fileVersions()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载节点证书失败: %w", err)
+	}
+	caPEM, err := os.ReadFile(r.caFile)
+	if err != nil {
+		return fmt.Errorf("读取CA证书失败: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("CA证书中没有有效的证书: %s", r.caFile)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.pool = pool
+	r.versions = versions
+	return nil
+}
+
+// reloadIfChanged 任一文件的修改时间变化时重新加载，证书和私钥分开替换时可能短暂不匹配，失败后下次握手重试
+func (r *TLSReloader) reloadIfChanged() {
+	r.mu.RLock()
+	current := r.versions
+	r.mu.RUnlock()
+	if r.fileVersions() == current {
+		return
+	}
+	if err := r.Reload(); err != nil {
+		logger.Errorf("重新加载gRPC证书失败: %v", err)
+		color.Red("重新加载gRPC证书失败: %v", err)
+		return
+	}
+	color.Green("gRPC 证书已重新加载")
+}
+
+func (r *TLSReloader) fileVersions() [3]time.Time {
+	var versions [3]time.Time
+	for i, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if info, err := os.Stat(file); err == nil {
+			versions[i] = info.ModTime()
+		}
+	}
+	return versions
+}
+
+func (r *TLSReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.reloadIfChanged()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// ServerConfig 要求并校验客户端证书的服务端配置，每次握手使用最新的证书和 CA
+func (r *TLSReloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig 连接 serverName 时使用的客户端配置，出示节点证书并用当前 CA 校验服务端。
+// 标准校验在握手时只能使用固定的 RootCAs，这里改为在 VerifyConnection 中用最新的 CA 校验
+func (r *TLSReloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("服务端没有提供证书")
+			}
+			_, pool := r.current()
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       serverName,
+				Roots:         pool,
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	}
+}
+
+// tlsServerName 连接地址中的主机名，GRPC_TLS_SERVER_NAME 可以覆盖（例如通过IP连接时）
+func tlsServerName(addr, override string) string {
+	if override != "" {
+		return override
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"GoFileShare/config"
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+)
+
+// FileTreeServer 实现 fileshare.v1.FileTreeService，与 HTTP 控制器共用 file_tree.go 中的服务层
+type FileTreeServer struct {
+	filesharev1.UnimplementedFileTreeServiceServer
+}
+
+// NewFileTreeServer 创建文件树管理服务
+func NewFileTreeServer() *FileTreeServer {
+	return &FileTreeServer{}
+}
+
+// ListChildren 列出文件夹下的节点
+func (s *FileTreeServer) ListChildren(ctx context.Context, req *filesharev1.ListChildrenRequest) (*filesharev1.ListChildrenResponse, error) {
+	fileNodes, err := ListChildren(rpcCallerFromContext(ctx), req.GetParentId())
+	if err != nil {
+		return nil, fileTreeStatus(err)
+	}
+	return &filesharev1.ListChildrenResponse{Nodes: fileNodesToProto(fileNodes)}, nil
+}
+
+// SearchFiles 按名称搜索节点
+func (s *FileTreeServer) SearchFiles(ctx context.Context, req *filesharev1.SearchFilesRequest) (*filesharev1.SearchFilesResponse, error) {
+	fileNodes, err := SearchNodes(rpcCallerFromContext(ctx), req.GetQuery())
+	if err != nil {
+		return nil, fileTreeStatus(err)
+	}
+	return &filesharev1.SearchFilesResponse{Nodes: fileNodesToProto(fileNodes)}, nil
+}
+
+// GetNode 查询节点元数据
+func (s *FileTreeServer) GetNode(ctx context.Context, req *filesharev1.GetNodeRequest) (*filesharev1.GetNodeResponse, error) {
+	fileNode, err := GetNode(rpcCallerFromContext(ctx), req.GetNodeId())
+	if err != nil {
+		return nil, fileTreeStatus(err)
+	}
+	return &filesharev1.GetNodeResponse{Node: fileNodeToProto(*fileNode)}, nil
+}
+
+// CreateFolder 创建文件夹
+func (s *FileTreeServer) CreateFolder(ctx context.Context, req *filesharev1.CreateFolderRequest) (*filesharev1.CreateFolderResponse, error) {
+	fileNode, err := CreateFolder(rpcCallerFromContext(ctx), req.GetParentId(), req.GetName())
+	if err != nil {
+		return nil, fileTreeStatus(err)
+	}
+	return &filesharev1.CreateFolderResponse{Node: fileNodeToProto(*fileNode)}, nil
+}
+
+// DeleteNode 提交后台删除任务
+func (s *FileTreeServer) DeleteNode(ctx context.Context, req *filesharev1.DeleteNodeRequest) (*filesharev1.DeleteNodeResponse, error) {
+	job, _, err := DeleteNode(rpcCallerFromContext(ctx), req.GetNodeId())
+	if err != nil {
+		return nil, fileTreeStatus(err)
+	}
+	return &filesharev1.DeleteNodeResponse{JobId: job.ID.Hex()}, nil
+}
+
+// fileTreeStatus 把服务层错误转换为 gRPC 状态码
+func fileTreeStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidNodeID), errors.Is(err, ErrMissingFolderName), errors.Is(err, ErrMissingQuery):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrNodeNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrJobsUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func fileNodesToProto(fileNodes []config.FileNode) []*filesharev1.FileNode {
+	nodes := make([]*filesharev1.FileNode, 0, len(fileNodes))
+	for _, fileNode := range fileNodes {
+		nodes = append(nodes, fileNodeToProto(fileNode))
+	}
+	return nodes
+}
+
+// fileNodeToProto 转换节点，不返回服务器上的存储路径；文件的大小和修改时间从磁盘读取
+func fileNodeToProto(fileNode config.FileNode) *filesharev1.FileNode {
+	node := &filesharev1.FileNode{
+		Id:         fileNode.ID.Hex(),
+		Name:       fileNode.Name,
+		IsFolder:   fileNode.Type,
+		AuthLevel:  int32(fileNode.EffectiveAuthLevel),
+		OriginNode: config.NodeID,
+	}
+	if !fileNode.ParentID.IsZero() {
+		node.ParentId = fileNode.ParentID.Hex()
+	}
+	if !fileNode.Type {
+		if filePath := nodeFilePath(fileNode); filePath != "" {
+			if info, err := os.Stat(filePath); err == nil {
+				node.Size = info.Size()
+				node.ModifiedAtUnixMs = info.ModTime().UnixMilli()
+			}
+		}
+	}
+	return node
+}
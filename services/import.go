@@ -0,0 +1,308 @@
+package services
+
+import (
+	"GoFileShare/config"
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrImportNotFound 导入任务不存在或不属于当前用户
+var ErrImportNotFound = errors.New("导入任务不存在")
+
+// importHistorySize 每个用户保留的已结束导入任务数
+const importHistorySize = 100
+
+// ImportJob 离线下载任务：服务器代替用户从URL下载文件并加入文件树
+type ImportJob struct {
+	ID         string    `json:"id"`
+	TaskID     string    `json:"task_id"` // 对应的下载任务ID，与事件流中的 task_id 一致
+	Owner      string    `json:"owner"`
+	URL        string    `json:"url"`
+	ParentID   string    `json:"parent_id"`
+	FileName   string    `json:"file_name"`
+	AuthLevel  int       `json:"auth_level"`
+	State      string    `json:"state"`
+	Progress   float64   `json:"progress"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+
+	filePath        string
+	cancelRequested bool
+}
+
+// finished 判断任务是否已结束
+func (j *ImportJob) finished() bool {
+	return j.State == TaskStateCompleted || j.State == TaskStateFailed || j.State == TaskStateCancelled
+}
+
+// ImportManager 管理所有用户的离线下载任务
+type ImportManager struct {
+	mu     sync.Mutex
+	nextID uint64
+	jobs   map[string]*ImportJob
+}
+
+// NewImportManager 创建导入任务管理器
+func NewImportManager() *ImportManager {
+	return &ImportManager{
+		jobs: make(map[string]*ImportJob),
+	}
+}
+
+// Start 校验目标文件夹并提交下载，name 为空时使用URL中的文件名，headers 用于需要认证的下载地址
+func (m *ImportManager) Start(owner string, authLevel int, rawURL, parentID, name string, headers http.Header) (*ImportJob, error) {
+	service := GetTransferService()
+	if service == nil {
+		return nil, errors.New("传输服务未初始化")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("无效的下载地址: %s", rawURL)
+	}
+	if err := checkPublicURL(context.Background(), u); err != nil {
+		return nil, err
+	}
+	if parentID == "" || parentID == "undefined" || parentID == "null" {
+		parentID = "root"
+	}
+	if err := checkImportParent(parentID, authLevel); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = path.Base(u.Path)
+	}
+	name = filepath.Base(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." || name == "/" {
+		name = "download"
+	}
+
+	m.mu.Lock()
+	m.nextID++
+	job := &ImportJob{
+		ID:        fmt.Sprintf("imp_%d_%d", time.Now().UnixNano(), m.nextID),
+		Owner:     owner,
+		URL:       rawURL,
+		ParentID:  parentID,
+		AuthLevel: authLevel,
+		State:     TaskStateQueued,
+		CreatedAt: time.Now(),
+	}
+	job.FileName, job.filePath = utils.GlobalPathReserver.Reserve(filepath.Join(config.RootPath, "FileStore"), name)
+	m.jobs[job.ID] = job
+	m.pruneLocked(owner)
+	m.mu.Unlock()
+
+	// 提交失败时 AddDownloadTask 会同步调用 onError 且任务为 nil
+	var startErr error
+	taskID := service.AddDownloadTaskWithOptions(rawURL, job.filePath, DownloadOptions{
+		Owner:    owner,
+		Headers:  headers,
+		Priority: utils.PriorityLow, // 离线下载是后台任务，让位于交互式传输
+		// 下载地址由用户提供，不允许借服务器访问内网
+		PublicOnly: true,
+	},
+		func(progress float64) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if !job.finished() {
+				job.State = TaskStateRunning
+				job.Progress = progress
+			}
+		},
+		func(task *FileTask) {
+			m.complete(job, task)
+		},
+		func(task *FileTask, err error) {
+			if task == nil {
+				startErr = err
+				return
+			}
+			m.fail(job, task, err)
+		})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if taskID == "" {
+		delete(m.jobs, job.ID)
+		utils.GlobalPathReserver.Release(job.filePath)
+		if startErr == nil {
+			startErr = errors.New("创建下载任务失败")
+		}
+		return nil, startErr
+	}
+	job.TaskID = taskID
+	if job.cancelRequested && !job.finished() {
+		service.CancelTask(taskID)
+	}
+	copied := *job
+	return &copied, nil
+}
+
+// List 返回用户的导入任务，新任务在前
+func (m *ImportManager) List(owner string) []ImportJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]ImportJob, 0)
+	for _, job := range m.jobs {
+		if job.Owner == owner {
+			result = append(result, *job)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// Get 返回用户的单个导入任务
+func (m *ImportManager) Get(owner, id string) (*ImportJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok || job.Owner != owner {
+		return nil, ErrImportNotFound
+	}
+	copied := *job
+	return &copied, nil
+}
+
+// Cancel 取消用户的导入任务
+func (m *ImportManager) Cancel(owner, id string) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok || job.Owner != owner {
+		m.mu.Unlock()
+		return ErrImportNotFound
+	}
+	if job.finished() {
+		m.mu.Unlock()
+		return fmt.Errorf("导入任务已结束: %s", job.State)
+	}
+	taskID := job.TaskID
+	if taskID == "" {
+		// 下载任务还在提交中，提交完成后再取消
+		job.cancelRequested = true
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
+
+	if service := GetTransferService(); service == nil || !service.CancelTask(taskID) {
+		return fmt.Errorf("下载任务 %s 已结束", taskID)
+	}
+	return nil
+}
+
+// complete 下载完成后创建文件节点
+func (m *ImportManager) complete(job *ImportJob, task *FileTask) {
+	m.mu.Lock()
+	parentID, fileName, authLevel := job.ParentID, job.FileName, job.AuthLevel
+	m.mu.Unlock()
+
+	err := models.AddFileNode(task.FilePath, fileName, false, parentID, authLevel)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	utils.GlobalPathReserver.Release(job.filePath)
+	job.FinishedAt = time.Now()
+	if err != nil {
+		logger.Errorf("Error adding file node for import %s: %v", job.ID, err)
+		color.Red("Error adding file node for import %s: %v", job.ID, err)
+		if removeErr := os.Remove(task.FilePath); removeErr != nil {
+			color.Red("Error removing imported file %s: %v", task.FilePath, removeErr)
+		}
+		job.State = TaskStateFailed
+		job.Error = "添加文件节点失败: " + err.Error()
+		return
+	}
+	job.State = TaskStateCompleted
+	job.Progress = 100
+	color.Green("导入完成: %s -> %s", job.URL, task.FilePath)
+}
+
+// fail 下载失败或被取消，导入任务不支持续传，直接清理临时文件
+func (m *ImportManager) fail(job *ImportJob, task *FileTask, err error) {
+	if service := GetTransferService(); service != nil {
+		service.DiscardTaskFiles(task)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	utils.GlobalPathReserver.Release(job.filePath)
+	job.FinishedAt = time.Now()
+	if errors.Is(err, errTaskCancelled) {
+		job.State = TaskStateCancelled
+		return
+	}
+	job.State = TaskStateFailed
+	job.Error = err.Error()
+}
+
+// pruneLocked 只保留用户最近的已结束任务
+func (m *ImportManager) pruneLocked(owner string) {
+	var finished []*ImportJob
+	for _, job := range m.jobs {
+		if job.Owner == owner && job.finished() {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= importHistorySize {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].FinishedAt.Before(finished[j].FinishedAt)
+	})
+	for _, job := range finished[:len(finished)-importHistorySize] {
+		delete(m.jobs, job.ID)
+	}
+}
+
+// checkImportParent 校验目标节点是用户有权限访问的文件夹
+func checkImportParent(parentID string, authLevel int) error {
+	if parentID == "root" {
+		return nil
+	}
+	objID, err := primitive.ObjectIDFromHex(parentID)
+	if err != nil {
+		return fmt.Errorf("无效的父节点ID: %s", parentID)
+	}
+	nodes, err := models.SearchFileNodeByID(objID)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("目标文件夹不存在")
+	}
+	if !nodes[0].Type {
+		return fmt.Errorf("目标节点不是文件夹")
+	}
+	if nodes[0].EffectiveAuthLevel > authLevel {
+		return fmt.Errorf("没有目标文件夹的权限")
+	}
+	return nil
+}
+
+// GlobalImportManager 全局导入任务管理器
+var GlobalImportManager = NewImportManager()
+
+// GetImportManager 获取全局导入任务管理器
+func GetImportManager() *ImportManager {
+	return GlobalImportManager
+}
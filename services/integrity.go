@@ -0,0 +1,183 @@
+package services
+
+import (
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ManifestAlgorithm 校验清单使用的哈希算法
+const ManifestAlgorithm = "sha256"
+
+// ErrChunkCorrupted 分块内容与校验清单不一致
+var ErrChunkCorrupted = errors.New("分块校验失败")
+
+// BuildFileManifest 为本地文件生成校验清单
+func BuildFileManifest(filePath string, chunkSize int64) (*models.FileManifest, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("无效的分块大小: %d", chunkSize)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			logger.Errorf("Error closing file %s: %v", filePath, err)
+			color.Red("Error closing file %s: %v", filePath, err)
+		}
+	}(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s 是目录，无法生成校验清单", filePath)
+	}
+
+	fileSize := info.Size()
+	chunkCount := int(math.Ceil(float64(fileSize) / float64(chunkSize)))
+	manifest := &models.FileManifest{
+		FileSize:    fileSize,
+		ChunkSize:   chunkSize,
+		Algorithm:   ManifestAlgorithm,
+		ChunkHashes: make([]string, 0, chunkCount),
+	}
+
+	for i := 0; i < chunkCount; i++ {
+		offset, size := chunkBounds(i, chunkSize, fileSize)
+		hash, err := utils.SHA256Range(file, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		manifest.ChunkHashes = append(manifest.ChunkHashes, hash)
+	}
+
+	manifest.FileHash, err = utils.SHA256Range(file, 0, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// fetchManifest 从清单地址获取并校验清单格式。HTTP 清单通过任务的客户端请求，
+// 与下载使用相同的请求头、Cookie、重定向策略和地址限制
+func fetchManifest(task *FileTask, manifestURL string) (*models.FileManifest, error) {
+	if !strings.HasPrefix(manifestURL, "http://") && !strings.HasPrefix(manifestURL, "https://") {
+		source, err := newChunkSource(manifestURL)
+		if err != nil {
+			return nil, err
+		}
+		provider, ok := source.(manifestSource)
+		if !ok {
+			return nil, fmt.Errorf("数据源 %s 不提供校验清单", source.Name())
+		}
+		manifest, err := provider.Manifest()
+		if err != nil {
+			return nil, fmt.Errorf("获取校验清单失败: %w", err)
+		}
+		return checkManifest(manifest)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	req, err := task.newRequest(ctx, http.MethodGet, manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := task.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			logger.Errorf("Error closing manifest body for %s: %v", manifestURL, err)
+			color.Red("Error closing manifest body for %s: %v", manifestURL, err)
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取校验清单失败，状态码: %d", resp.StatusCode)
+	}
+
+	var manifest models.FileManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("解析校验清单失败: %w", err)
+	}
+	return checkManifest(&manifest)
+}
+
+// manifestSource 能够直接提供校验清单的非 HTTP 数据源
+type manifestSource interface {
+	Manifest() (*models.FileManifest, error)
+}
+
+// checkManifest 校验清单格式
+func checkManifest(manifest *models.FileManifest) (*models.FileManifest, error) {
+	if manifest.Algorithm != "" && !strings.EqualFold(manifest.Algorithm, ManifestAlgorithm) {
+		return nil, fmt.Errorf("不支持的校验算法: %s", manifest.Algorithm)
+	}
+	if manifest.ChunkSize <= 0 {
+		return nil, fmt.Errorf("校验清单中的分块大小无效: %d", manifest.ChunkSize)
+	}
+	expected := int(math.Ceil(float64(manifest.FileSize) / float64(manifest.ChunkSize)))
+	if len(manifest.ChunkHashes) != 0 && len(manifest.ChunkHashes) != expected {
+		return nil, fmt.Errorf("校验清单分块数量不匹配，预期 %d，实际 %d", expected, len(manifest.ChunkHashes))
+	}
+	return manifest, nil
+}
+
+// chunkBounds 计算第 index 个分块的起始偏移和长度
+func chunkBounds(index int, chunkSize, fileSize int64) (int64, int64) {
+	offset := int64(index) * chunkSize
+	size := chunkSize
+	if offset+size > fileSize {
+		size = fileSize - offset
+	}
+	return offset, size
+}
+
+// verifyChunk 使用清单中的哈希校验已写入的分块，没有清单时直接通过
+func verifyChunk(task *FileTask, file io.ReaderAt, index int) error {
+	if task.manifest == nil || len(task.manifest.ChunkHashes) == 0 {
+		return nil
+	}
+	offset, size := chunkBounds(index, task.ChunkSize, task.FileSize)
+	hash, err := utils.SHA256Range(file, offset, size)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(hash, task.manifest.ChunkHashes[index]) {
+		return fmt.Errorf("%w: 第 %d 块", ErrChunkCorrupted, index)
+	}
+	return nil
+}
+
+// verifyFile 校验整个文件的哈希，未设置期望值时直接通过
+func verifyFile(task *FileTask, file io.ReaderAt) error {
+	if task.ExpectedHash == "" {
+		return nil
+	}
+	hash, err := utils.SHA256Range(file, 0, task.FileSize)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(hash, task.ExpectedHash) {
+		return fmt.Errorf("文件校验失败，预期 %s，实际 %s", task.ExpectedHash, hash)
+	}
+	return nil
+}
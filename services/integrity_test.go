@@ -0,0 +1,46 @@
+package services
+
+import (
+	"GoFileShare/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchManifestUsesTaskClient(t *testing.T) {
+	manifest := models.FileManifest{FileSize: 10, ChunkSize: 4, Algorithm: ManifestAlgorithm, ChunkHashes: []string{"a", "b", "c"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 与 /api/fileManifest/:id 一样需要登录
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(manifest)
+	}))
+	defer server.Close()
+
+	headers := make(http.Header)
+	headers.Set("Authorization", "Bearer secret")
+	client, err := newTaskHTTPClient(server.URL, DownloadOptions{})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	got, err := fetchManifest(&FileTask{Headers: headers, client: client}, server.URL)
+	if err != nil {
+		t.Fatalf("带任务请求头获取清单失败: %v", err)
+	}
+	if got.FileSize != manifest.FileSize || len(got.ChunkHashes) != len(manifest.ChunkHashes) {
+		t.Fatalf("清单内容不一致: %+v", got)
+	}
+
+	// 只允许公网地址的任务不能通过清单地址访问本机
+	client, err = newTaskHTTPClient(server.URL, DownloadOptions{PublicOnly: true})
+	if err != nil {
+		t.Fatalf("创建客户端失败: %v", err)
+	}
+	if _, err := fetchManifest(&FileTask{Headers: headers, client: client}, server.URL); !errors.Is(err, ErrNonPublicAddress) {
+		t.Fatalf("本机清单地址应被拒绝，实际为 %v", err)
+	}
+}
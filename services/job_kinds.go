@@ -0,0 +1,265 @@
+package services
+
+import (
+	"GoFileShare/config"
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// 内置的后台任务类型
+const (
+	JobKindDelete = "delete"
+	JobKindZip    = "zip"
+	JobKindImport = "import"
+)
+
+func init() {
+	RegisterJobKind(JobKindDelete, 3, utils.PriorityNormal, runDeleteJob)
+	RegisterJobKind(JobKindZip, 2, utils.PriorityNormal, runZipJob)
+	RegisterJobKind(JobKindImport, 2, utils.PriorityLow, runImportJob)
+}
+
+// DeleteJobParams 递归删除文件节点
+type DeleteJobParams struct {
+	NodeID string `json:"node_id"`
+}
+
+// runDeleteJob 删除节点及其所有子节点和物理文件
+func runDeleteJob(jc *JobContext) (map[string]interface{}, error) {
+	var params DeleteJobParams
+	if err := jc.Bind(&params); err != nil {
+		return nil, err
+	}
+	objID, err := primitive.ObjectIDFromHex(params.NodeID)
+	if err != nil {
+		return nil, fmt.Errorf("无效的文件节点ID: %s", params.NodeID)
+	}
+	nodes, err := models.SearchFileNodeByID(objID)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		if jc.Attempt() > 1 {
+			// 上一次尝试已经删除了节点
+			return map[string]interface{}{"node_id": params.NodeID}, nil
+		}
+		return nil, fmt.Errorf("文件节点不存在")
+	}
+	deleted, err := models.DeleteFileNodeTree(params.NodeID)
+	if err != nil {
+		return nil, err
+	}
+	if cluster := GetCluster(); cluster != nil {
+		cluster.DropReplicas(deleted)
+	}
+	return map[string]interface{}{"node_id": params.NodeID, "name": nodes[0].Name}, nil
+}
+
+// ZipJobParams 将文件夹打包为zip并放在同一父目录下
+type ZipJobParams struct {
+	NodeID    string `json:"node_id"`
+	AuthLevel int    `json:"auth_level"` // 只打包提交者有权限访问的文件
+}
+
+// runZipJob 遍历文件夹收集文件，写入zip后创建文件节点
+func runZipJob(jc *JobContext) (map[string]interface{}, error) {
+	var params ZipJobParams
+	if err := jc.Bind(&params); err != nil {
+		return nil, err
+	}
+	objID, err := primitive.ObjectIDFromHex(params.NodeID)
+	if err != nil {
+		return nil, fmt.Errorf("无效的文件节点ID: %s", params.NodeID)
+	}
+	nodes, err := models.SearchFileNodeByID(objID)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 || !nodes[0].Type {
+		return nil, fmt.Errorf("文件夹不存在")
+	}
+	folder := nodes[0]
+
+	jc.Progress(0, "正在收集文件")
+	entries, authLevel, err := collectZipEntries(jc.Context(), models.SearchFileNodeByParentID, folder, params.AuthLevel)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("文件夹中没有可打包的文件")
+	}
+
+	name, zipPath := reserveStorePath(folder.Name + ".zip")
+	defer utils.GlobalPathReserver.Release(zipPath)
+	err = utils.ZipFiles(jc.Context(), zipPath, entries, func(done, total int) {
+		jc.Progress(float64(done)/float64(total)*95, fmt.Sprintf("已打包 %d/%d 个文件", done, total))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	parentID := "root"
+	if !folder.ParentID.IsZero() {
+		parentID = folder.ParentID.Hex()
+	}
+	// 压缩包包含的文件可能比文件夹要求更高的权限，按其中最高的权限登记
+	if err := models.AddFileNode(zipPath, name, false, parentID, authLevel); err != nil {
+		if removeErr := os.Remove(zipPath); removeErr != nil {
+			return nil, fmt.Errorf("添加文件节点失败: %v，且删除压缩包失败: %v", err, removeErr)
+		}
+		return nil, fmt.Errorf("添加文件节点失败: %w", err)
+	}
+	return map[string]interface{}{"file_name": name, "files": len(entries), "parent_id": parentID}, nil
+}
+
+// collectZipEntries 广度优先遍历文件夹，返回文件及其在压缩包中的相对路径，
+// 以及文件夹和被打包的节点中最高的 EffectiveAuthLevel
+func collectZipEntries(ctx context.Context, children func(primitive.ObjectID) ([]config.FileNode, error), folder config.FileNode, authLevel int) ([]utils.ZipEntry, int, error) {
+	type pending struct {
+		node   config.FileNode
+		prefix string
+	}
+	var entries []utils.ZipEntry
+	maxLevel := folder.EffectiveAuthLevel
+	queue := []pending{{node: folder, prefix: folder.Name}}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		current := queue[0]
+		queue = queue[1:]
+		nodes, err := children(current.node.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, child := range nodes {
+			if child.EffectiveAuthLevel > authLevel {
+				continue
+			}
+			name := path.Join(current.prefix, child.Name)
+			if child.Type {
+				queue = append(queue, pending{node: child, prefix: name})
+				continue
+			}
+			filePath := nodeFilePath(child)
+			if filePath == "" {
+				continue
+			}
+			entries = append(entries, utils.ZipEntry{Name: name, Path: filePath})
+			// 文件夹的名称出现在文件的路径中，经过的文件夹也计入
+			for _, level := range []int{child.EffectiveAuthLevel, current.node.EffectiveAuthLevel} {
+				if level > maxLevel {
+					maxLevel = level
+				}
+			}
+		}
+	}
+	return entries, maxLevel, nil
+}
+
+// nodeFilePath 返回文件节点对应的本地文件，找不到时返回空。
+// 集群模式下只使用本节点的副本，其他节点记录的路径在本机上无效
+func nodeFilePath(node config.FileNode) string {
+	if config.NodeID != "" && len(node.Replicas) > 0 {
+		replica := node.ReplicaOn(config.NodeID)
+		if replica == nil {
+			return ""
+		}
+		if info, err := os.Stat(replica.Path); err == nil && info.Mode().IsRegular() {
+			return replica.Path
+		}
+		return ""
+	}
+	return legacyFilePath(node)
+}
+
+// legacyFilePath 按节点记录的路径查找本地文件
+func legacyFilePath(node config.FileNode) string {
+	for _, candidate := range []string{node.Path, storagePath(node)} {
+		if candidate == "" {
+			continue
+		}
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func storagePath(node config.FileNode) string {
+	if node.Storage == nil {
+		return ""
+	}
+	return node.Storage.SystemFilePath
+}
+
+// reserveStorePath 在存储目录中保留一个未被占用的文件名，重名时追加序号；
+// 文件写入后或放弃写入时通过 utils.GlobalPathReserver.Release 释放
+func reserveStorePath(name string) (string, string) {
+	return utils.GlobalPathReserver.Reserve(filepath.Join(config.RootPath, "FileStore"), name)
+}
+
+// ImportJobParams 离线下载
+type ImportJobParams struct {
+	URL       string            `json:"url"`
+	ParentID  string            `json:"parent_id"`
+	Name      string            `json:"name"`
+	Headers   map[string]string `json:"headers"`
+	AuthLevel int               `json:"auth_level"`
+}
+
+// runImportJob 通过导入管理器下载文件，转发进度，任务取消时取消下载
+func runImportJob(jc *JobContext) (map[string]interface{}, error) {
+	var params ImportJobParams
+	if err := jc.Bind(&params); err != nil {
+		return nil, err
+	}
+	headers := make(http.Header)
+	for key, value := range params.Headers {
+		headers.Set(key, value)
+	}
+
+	manager := GetImportManager()
+	imported, err := manager.Start(jc.Owner(), params.AuthLevel, params.URL, params.ParentID, params.Name, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	done := jc.Context().Done()
+	for {
+		select {
+		case <-done:
+			// 只取消一次，之后等待下载任务退出
+			done = nil
+			if err := manager.Cancel(jc.Owner(), imported.ID); err != nil && !errors.Is(err, ErrImportNotFound) {
+				jc.Progress(imported.Progress, err.Error())
+			}
+		case <-ticker.C:
+		}
+
+		current, err := manager.Get(jc.Owner(), imported.ID)
+		if err != nil {
+			return nil, err
+		}
+		switch current.State {
+		case TaskStateCompleted:
+			return map[string]interface{}{"import_id": current.ID, "file_name": current.FileName}, nil
+		case TaskStateFailed:
+			return nil, errors.New(current.Error)
+		case TaskStateCancelled:
+			return nil, errTaskCancelled
+		}
+		jc.Progress(current.Progress, current.FileName)
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"GoFileShare/config"
+	"GoFileShare/utils"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectZipEntriesAuthLevel(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string, parent primitive.ObjectID, level int) config.FileNode {
+		filePath := filepath.Join(dir, name)
+		if err := os.WriteFile(filePath, []byte(name), 0644); err != nil {
+			t.Fatalf("写入文件失败: %v", err)
+		}
+		return config.FileNode{ID: primitive.NewObjectID(), Name: name, Path: filePath, ParentID: parent, EffectiveAuthLevel: level}
+	}
+	// 权限为 1 的文件夹中有一个权限为 5 的文件和一个权限为 3 的子文件夹
+	folder := config.FileNode{ID: primitive.NewObjectID(), Name: "docs", Type: true, EffectiveAuthLevel: 1}
+	sub := config.FileNode{ID: primitive.NewObjectID(), Name: "sub", Type: true, ParentID: folder.ID, EffectiveAuthLevel: 3}
+	tree := map[primitive.ObjectID][]config.FileNode{
+		folder.ID: {file("public.txt", folder.ID, 1), file("secret.txt", folder.ID, 5), sub},
+		sub.ID:    {file("inner.txt", sub.ID, 0)},
+	}
+	children := func(id primitive.ObjectID) ([]config.FileNode, error) {
+		return tree[id], nil
+	}
+
+	cases := []struct {
+		submitter int
+		files     int
+		level     int
+	}{
+		{submitter: 10, files: 3, level: 5},
+		{submitter: 4, files: 2, level: 3},
+		{submitter: 1, files: 1, level: 1},
+	}
+	for _, tc := range cases {
+		entries, level, err := collectZipEntries(context.Background(), children, folder, tc.submitter)
+		if err != nil {
+			t.Fatalf("权限 %d: 收集文件失败: %v", tc.submitter, err)
+		}
+		if len(entries) != tc.files {
+			t.Fatalf("权限 %d: 应打包 %d 个文件，实际为 %v", tc.submitter, tc.files, entries)
+		}
+		if level != tc.level {
+			t.Fatalf("权限 %d: 压缩包应按权限 %d 登记，实际为 %d", tc.submitter, tc.level, level)
+		}
+	}
+}
+
+func TestReserveStorePath(t *testing.T) {
+	root := config.RootPath
+	config.RootPath = t.TempDir()
+	t.Cleanup(func() { config.RootPath = root })
+	storeDir := filepath.Join(config.RootPath, "FileStore")
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		t.Fatalf("创建存储目录失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(storeDir, "report.pdf"), nil, 0644); err != nil {
+		t.Fatalf("写入文件失败: %v", err)
+	}
+
+	// 已存在的文件和其他组件保留的文件名都会跳过，包括同一目录的绝对路径写法
+	first, firstPath := reserveStorePath("report.pdf")
+	absDir, _ := filepath.Abs(storeDir)
+	second, secondPath := utils.GlobalPathReserver.Reserve(absDir, "report.pdf")
+	if first != "report (1).pdf" || second != "report (2).pdf" {
+		t.Fatalf("保留的文件名为 %q 和 %q", first, second)
+	}
+	utils.GlobalPathReserver.Release(firstPath)
+	utils.GlobalPathReserver.Release(secondPath)
+	name, filePath := reserveStorePath("report.pdf")
+	utils.GlobalPathReserver.Release(filePath)
+	if name != first {
+		t.Fatalf("释放后应重新使用 %q，实际为 %q", first, name)
+	}
+}
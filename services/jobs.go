@@ -0,0 +1,427 @@
+package services
+
+import (
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound 任务不存在或不属于当前用户
+var ErrJobNotFound = errors.New("任务不存在")
+
+// jobListLimit 列表接口返回的最大任务数
+const jobListLimit = 100
+
+// jobRetryDelay 第一次重试前的等待时间，之后按尝试次数的平方增长
+const jobRetryDelay = 5 * time.Second
+
+// JobStore 后台任务记录的存储
+type JobStore interface {
+	Insert(job *models.Job) error // ID 为空时自动生成
+	Update(id primitive.ObjectID, fields map[string]interface{}) error
+	Get(id primitive.ObjectID) (*models.Job, error) // 不存在时返回 nil
+	ListByOwner(owner string, limit int64) ([]models.Job, error)
+	ListUnfinished() ([]models.Job, error)
+}
+
+// MongoJobStore 基于 models 的任务存储
+var MongoJobStore JobStore = mongoJobStore{}
+
+type mongoJobStore struct{}
+
+func (mongoJobStore) Insert(job *models.Job) error {
+	return models.InsertJob(job)
+}
+
+func (mongoJobStore) Update(id primitive.ObjectID, fields map[string]interface{}) error {
+	return models.UpdateJob(id, fields)
+}
+
+func (mongoJobStore) Get(id primitive.ObjectID) (*models.Job, error) {
+	return models.GetJob(id)
+}
+
+func (mongoJobStore) ListByOwner(owner string, limit int64) ([]models.Job, error) {
+	return models.ListJobsByOwner(owner, limit)
+}
+
+func (mongoJobStore) ListUnfinished() ([]models.Job, error) {
+	return models.ListUnfinishedJobs()
+}
+
+// JobHandler 执行一种后台任务，返回的结果保存在任务记录中
+// 处理函数应当检查 jc.Context()，任务被取消时尽快返回
+type JobHandler func(jc *JobContext) (map[string]interface{}, error)
+
+// jobKind 已注册的任务类型
+type jobKind struct {
+	handler     JobHandler
+	maxAttempts int
+	priority    utils.Priority
+}
+
+var (
+	jobKindsMu sync.RWMutex
+	jobKinds   = make(map[string]jobKind)
+)
+
+// RegisterJobKind 注册任务类型，maxAttempts 为包括首次执行在内的最大尝试次数
+func RegisterJobKind(kind string, maxAttempts int, priority utils.Priority, handler JobHandler) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	jobKindsMu.Lock()
+	defer jobKindsMu.Unlock()
+	jobKinds[kind] = jobKind{handler: handler, maxAttempts: maxAttempts, priority: priority}
+}
+
+func lookupJobKind(kind string) (jobKind, bool) {
+	jobKindsMu.RLock()
+	defer jobKindsMu.RUnlock()
+	k, ok := jobKinds[kind]
+	return k, ok
+}
+
+// JobContext 传给任务处理函数的上下文，用于读取参数和报告进度
+type JobContext struct {
+	ctx      context.Context
+	store    JobStore
+	job      *models.Job
+	mu       sync.Mutex
+	lastSave time.Time
+}
+
+// Context 任务被取消或服务停止时结束
+func (jc *JobContext) Context() context.Context {
+	return jc.ctx
+}
+
+// ID 返回任务ID
+func (jc *JobContext) ID() string {
+	return jc.job.ID.Hex()
+}
+
+// Owner 返回提交任务的用户
+func (jc *JobContext) Owner() string {
+	return jc.job.Owner
+}
+
+// Attempt 返回当前是第几次尝试，从 1 开始
+func (jc *JobContext) Attempt() int {
+	return jc.job.Attempts
+}
+
+// Bind 将任务参数解码到类型化的结构体
+func (jc *JobContext) Bind(v interface{}) error {
+	data, err := json.Marshal(jc.job.Params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Progress 报告进度，数据库写入和事件推送限制为每 500ms 一次
+func (jc *JobContext) Progress(progress float64, message string) {
+	jc.mu.Lock()
+	if time.Since(jc.lastSave) < 500*time.Millisecond && progress < 100 {
+		jc.mu.Unlock()
+		return
+	}
+	jc.lastSave = time.Now()
+	jc.job.Progress = progress
+	jc.job.Message = message
+	jc.mu.Unlock()
+
+	if err := jc.store.Update(jc.job.ID, map[string]interface{}{"progress": progress, "message": message}); err != nil {
+		logger.Errorf("Error saving progress for job %s: %v", jc.ID(), err)
+	}
+	publishJobEvent(jc.job, EventProgress, message)
+}
+
+// JobManager 调度和持久化后台任务
+type JobManager struct {
+	pool     *utils.WorkerPool
+	store    JobStore
+	retry    time.Duration // 第一次重试前的等待时间
+	mu       sync.Mutex
+	handles  map[string]*utils.TaskHandle // 排队中和执行中的任务
+	canceled map[string]bool              // 用户主动取消的任务，用于和服务停止区分
+	stopping bool
+}
+
+// NewJobManager 创建任务管理器，任务记录保存在 store 中
+func NewJobManager(workerCount int, store JobStore) *JobManager {
+	return &JobManager{
+		pool:     utils.NewWorkerPool(workerCount),
+		store:    store,
+		retry:    jobRetryDelay,
+		handles:  make(map[string]*utils.TaskHandle),
+		canceled: make(map[string]bool),
+	}
+}
+
+// Start 启动 worker，并恢复上次停止时未完成的任务
+func (m *JobManager) Start() error {
+	m.pool.Start()
+
+	jobs, err := m.store.ListUnfinished()
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		job := jobs[i]
+		if _, ok := lookupJobKind(job.Kind); !ok {
+			m.finish(&job, models.JobFailed, nil, fmt.Errorf("未知的任务类型: %s", job.Kind))
+			continue
+		}
+		color.Yellow("恢复未完成的任务 %s (%s)", job.ID.Hex(), job.Kind)
+		m.enqueue(&job)
+	}
+	return nil
+}
+
+// Stop 停止执行，执行中的任务保持 running 状态，下次启动时重新执行
+func (m *JobManager) Stop() {
+	m.mu.Lock()
+	m.stopping = true
+	m.mu.Unlock()
+	m.pool.Stop()
+}
+
+// Submit 创建并排队一个任务，params 会被编码保存，处理函数通过 JobContext.Bind 读取
+func (m *JobManager) Submit(kind, owner, title string, params interface{}) (*models.Job, error) {
+	k, ok := lookupJobKind(kind)
+	if !ok {
+		return nil, fmt.Errorf("未知的任务类型: %s", kind)
+	}
+
+	var encoded map[string]interface{}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, fmt.Errorf("任务参数必须是对象: %w", err)
+	}
+
+	job := &models.Job{
+		Kind:        kind,
+		Owner:       owner,
+		Title:       title,
+		State:       models.JobQueued,
+		Params:      encoded,
+		MaxAttempts: k.maxAttempts,
+	}
+	if err := m.store.Insert(job); err != nil {
+		return nil, err
+	}
+	publishJobEvent(job, EventState, "")
+	m.enqueue(job)
+	return job, nil
+}
+
+// Get 获取用户的任务
+func (m *JobManager) Get(owner, id string) (*models.Job, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrJobNotFound
+	}
+	job, err := m.store.Get(objID)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil || job.Owner != owner {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// List 列出用户最近的任务
+func (m *JobManager) List(owner string) ([]models.Job, error) {
+	return m.store.ListByOwner(owner, jobListLimit)
+}
+
+// Cancel 取消用户的任务，排队中的任务直接结束，执行中的任务由处理函数响应取消
+func (m *JobManager) Cancel(owner, id string) error {
+	job, err := m.Get(owner, id)
+	if err != nil {
+		return err
+	}
+	if job.Finished() {
+		return fmt.Errorf("任务已结束: %s", job.State)
+	}
+
+	m.mu.Lock()
+	handle := m.handles[id]
+	m.canceled[id] = true
+	m.mu.Unlock()
+
+	if handle != nil && !handle.Cancel() {
+		// 正在执行，处理函数响应 ctx 取消后由 run 记录状态
+		return nil
+	}
+	// 还没开始执行或正在等待重试，直接标记为已取消
+	m.mu.Lock()
+	delete(m.canceled, id)
+	delete(m.handles, id)
+	m.mu.Unlock()
+	m.finish(job, models.JobCancelled, nil, nil)
+	return nil
+}
+
+// enqueue 将任务交给协程池
+func (m *JobManager) enqueue(job *models.Job) {
+	k, _ := lookupJobKind(job.Kind)
+	id := job.ID.Hex()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handles[id] = m.pool.Enqueue(utils.TaskOptions{Priority: k.priority, Owner: job.Owner}, func(ctx context.Context) (interface{}, error) {
+		return nil, m.run(ctx, job, k)
+	})
+}
+
+// run 执行一次任务，失败时按剩余次数安排重试
+func (m *JobManager) run(ctx context.Context, job *models.Job, k jobKind) error {
+	id := job.ID.Hex()
+	job.Attempts++
+	job.State = models.JobRunning
+	job.StartedAt = time.Now()
+	job.Error = ""
+	if err := m.store.Update(job.ID, map[string]interface{}{
+		"state": job.State, "attempts": job.Attempts, "started_at": job.StartedAt, "error": "",
+	}); err != nil {
+		logger.Errorf("Error updating job %s: %v", id, err)
+	}
+	publishJobEvent(job, EventState, "")
+
+	jc := &JobContext{ctx: ctx, store: m.store, job: job}
+	result, err := func() (result map[string]interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("任务 panic: %v", r)
+			}
+		}()
+		return k.handler(jc)
+	}()
+
+	m.mu.Lock()
+	canceled := m.canceled[id]
+	stopping := m.stopping
+	delete(m.canceled, id)
+	delete(m.handles, id)
+	m.mu.Unlock()
+
+	switch {
+	case canceled:
+		m.finish(job, models.JobCancelled, nil, nil)
+	case stopping && ctx.Err() != nil:
+		// 服务停止导致中断，保留 running 状态，下次启动时重新执行
+		job.Attempts--
+		if updateErr := m.store.Update(job.ID, map[string]interface{}{"attempts": job.Attempts}); updateErr != nil {
+			logger.Errorf("Error updating job %s: %v", id, updateErr)
+		}
+	case errors.Is(err, errTaskCancelled):
+		// 任务内部的操作被用户直接取消（例如通过 /api/imports/:id），不再重试
+		m.finish(job, models.JobCancelled, nil, nil)
+	case err == nil:
+		m.finish(job, models.JobCompleted, result, nil)
+	case job.Attempts < job.MaxAttempts:
+		delay := time.Duration(job.Attempts*job.Attempts) * m.retry
+		message := fmt.Sprintf("第 %d 次执行失败，%s 后重试: %v", job.Attempts, delay, err)
+		color.Yellow("Job %s: %s", id, message)
+		job.State = models.JobQueued
+		if updateErr := m.store.Update(job.ID, map[string]interface{}{"state": job.State, "error": err.Error(), "message": message}); updateErr != nil {
+			logger.Errorf("Error updating job %s: %v", id, updateErr)
+		}
+		publishJobEvent(job, EventState, message)
+		time.AfterFunc(delay, func() {
+			m.mu.Lock()
+			stopped := m.stopping
+			m.mu.Unlock()
+			// 等待期间可能已被取消
+			if latest, err := m.store.Get(job.ID); stopped || err != nil || latest == nil || latest.State != models.JobQueued {
+				return
+			}
+			m.enqueue(job)
+		})
+	default:
+		m.finish(job, models.JobFailed, nil, err)
+	}
+	return err
+}
+
+// finish 记录任务的最终状态并推送事件
+func (m *JobManager) finish(job *models.Job, state string, result map[string]interface{}, err error) {
+	job.State = state
+	job.FinishedAt = time.Now()
+	fields := map[string]interface{}{"state": state, "finished_at": job.FinishedAt}
+	eventType := EventState
+	message := ""
+	switch state {
+	case models.JobCompleted:
+		job.Progress = 100
+		job.Result = result
+		fields["progress"] = job.Progress
+		fields["result"] = result
+		eventType = EventComplete
+	case models.JobFailed:
+		job.Error = err.Error()
+		fields["error"] = job.Error
+		eventType = EventError
+		message = job.Error
+		logger.Errorf("Job %s (%s) failed: %v", job.ID.Hex(), job.Kind, err)
+		color.Red("Job %s (%s) failed: %v", job.ID.Hex(), job.Kind, err)
+	}
+	if updateErr := m.store.Update(job.ID, fields); updateErr != nil {
+		logger.Errorf("Error updating job %s: %v", job.ID.Hex(), updateErr)
+		color.Red("Error updating job %s: %v", job.ID.Hex(), updateErr)
+	}
+	publishJobEvent(job, eventType, message)
+}
+
+// publishJobEvent 通过传输事件流推送任务状态，前端与传输任务一起展示
+func publishJobEvent(job *models.Job, eventType, message string) {
+	GetEventHub().Publish(TransferEvent{
+		Type:     eventType,
+		Kind:     "job",
+		TaskID:   job.ID.Hex(),
+		User:     job.Owner,
+		Name:     job.Title,
+		Progress: job.Progress,
+		State:    job.State,
+		Message:  message,
+	})
+}
+
+// GlobalJobManager 全局后台任务管理器
+var GlobalJobManager *JobManager
+
+// InitJobManager 初始化并启动全局后台任务管理器，需要在文件数据库连接之后调用
+func InitJobManager() error {
+	workers, err := strconv.Atoi(utils.GetEnv("JOB_WORKERS", "2"))
+	if err != nil || workers <= 0 {
+		return fmt.Errorf("JOB_WORKERS 无效: %s", utils.GetEnv("JOB_WORKERS", "2"))
+	}
+	manager := NewJobManager(workers, MongoJobStore)
+	if err := manager.Start(); err != nil {
+		return err
+	}
+	GlobalJobManager = manager
+	color.Green("后台任务管理器已启动: %d 个工作协程", workers)
+	return nil
+}
+
+// GetJobManager 获取全局后台任务管理器，未初始化时返回 nil
+func GetJobManager() *JobManager {
+	return GlobalJobManager
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryJobStore 测试用的内存任务存储，只记录调度用到的字段
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[primitive.ObjectID]models.Job
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{jobs: make(map[primitive.ObjectID]models.Job)}
+}
+
+func (s *memoryJobStore) Insert(job *models.Job) error {
+	if job.ID.IsZero() {
+		job.ID = primitive.NewObjectID()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = *job
+	return nil
+}
+
+func (s *memoryJobStore) Update(id primitive.ObjectID, fields map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return errors.New("任务不存在")
+	}
+	if v, ok := fields["state"].(string); ok {
+		job.State = v
+	}
+	if v, ok := fields["attempts"].(int); ok {
+		job.Attempts = v
+	}
+	if v, ok := fields["error"].(string); ok {
+		job.Error = v
+	}
+	s.jobs[id] = job
+	return nil
+}
+
+func (s *memoryJobStore) Get(id primitive.ObjectID) (*models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+func (s *memoryJobStore) ListByOwner(owner string, limit int64) ([]models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []models.Job
+	for _, job := range s.jobs {
+		if job.Owner == owner && int64(len(jobs)) < limit {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (s *memoryJobStore) ListUnfinished() ([]models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []models.Job
+	for _, job := range s.jobs {
+		if !job.Finished() {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// newTestJobManager 创建使用内存存储、缩短重试间隔的任务管理器
+func newTestJobManager(t *testing.T, retry time.Duration) (*JobManager, *memoryJobStore) {
+	store := newMemoryJobStore()
+	manager := NewJobManager(2, store)
+	manager.retry = retry
+	if err := manager.Start(); err != nil {
+		t.Fatalf("启动任务管理器失败: %v", err)
+	}
+	t.Cleanup(manager.Stop)
+	return manager, store
+}
+
+// waitJobState 等待任务进入指定状态
+func waitJobState(t *testing.T, store *memoryJobStore, id primitive.ObjectID, state string) models.Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ := store.Get(id); job != nil && job.State == state {
+			return *job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	job, _ := store.Get(id)
+	t.Fatalf("任务没有进入 %s 状态，当前为 %+v", state, job)
+	return models.Job{}
+}
+
+func TestJobManagerRetriesWithBackoff(t *testing.T) {
+	const retry = 40 * time.Millisecond
+	var mu sync.Mutex
+	var starts []time.Time
+	RegisterJobKind("test-retry-backoff", 3, utils.PriorityNormal, func(jc *JobContext) (map[string]interface{}, error) {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+		if jc.Attempt() < 3 {
+			return nil, errors.New("暂时失败")
+		}
+		return map[string]interface{}{"ok": true}, nil
+	})
+	manager, store := newTestJobManager(t, retry)
+
+	job, err := manager.Submit("test-retry-backoff", "alice", "重试", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("提交任务失败: %v", err)
+	}
+	done := waitJobState(t, store, job.ID, models.JobCompleted)
+	if done.Attempts != 3 {
+		t.Fatalf("应在第 3 次成功，实际尝试 %d 次", done.Attempts)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(starts) != 3 {
+		t.Fatalf("应执行 3 次，实际为 %d 次", len(starts))
+	}
+	// 第 n 次失败后等待 n² 倍的重试间隔
+	for i, want := range []time.Duration{retry, 4 * retry} {
+		if gap := starts[i+1].Sub(starts[i]); gap < want {
+			t.Fatalf("第 %d 次重试间隔为 %s，应不少于 %s", i+1, gap, want)
+		}
+	}
+}
+
+func TestJobManagerFailsAfterMaxAttempts(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	RegisterJobKind("test-retry-exhausted", 2, utils.PriorityNormal, func(jc *JobContext) (map[string]interface{}, error) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		return nil, errors.New("一直失败")
+	})
+	manager, store := newTestJobManager(t, 10*time.Millisecond)
+
+	job, err := manager.Submit("test-retry-exhausted", "alice", "失败", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("提交任务失败: %v", err)
+	}
+	failed := waitJobState(t, store, job.ID, models.JobFailed)
+	if failed.Attempts != 2 || failed.Error != "一直失败" {
+		t.Fatalf("用完次数后应记录失败，实际为 %+v", failed)
+	}
+	// 失败后不再安排重试
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Fatalf("应只执行 2 次，实际为 %d 次", attempts)
+	}
+}
+
+func TestJobManagerCancelDuringRetryWait(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	RegisterJobKind("test-retry-cancel", 3, utils.PriorityNormal, func(jc *JobContext) (map[string]interface{}, error) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		return nil, errors.New("暂时失败")
+	})
+	manager, store := newTestJobManager(t, 200*time.Millisecond)
+
+	job, err := manager.Submit("test-retry-cancel", "alice", "取消", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("提交任务失败: %v", err)
+	}
+	// 第一次失败后任务回到排队状态，等待重试
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		latest, _ := store.Get(job.ID)
+		if latest.Attempts == 1 && latest.State == models.JobQueued {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("任务没有进入重试等待，当前为 %+v", latest)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := manager.Cancel("bob", job.ID.Hex()); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("其他用户不能取消任务，实际为 %v", err)
+	}
+	if err := manager.Cancel("alice", job.ID.Hex()); err != nil {
+		t.Fatalf("取消任务失败: %v", err)
+	}
+	waitJobState(t, store, job.ID, models.JobCancelled)
+
+	// 等待时间过后也不应再执行
+	time.Sleep(400 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 1 {
+		t.Fatalf("取消后不应再重试，实际执行 %d 次", attempts)
+	}
+	if latest, _ := store.Get(job.ID); latest.State != models.JobCancelled {
+		t.Fatalf("取消后的状态为 %s", latest.State)
+	}
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"context"
+	"errors"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memberRetention 下线的成员在成员表中保留的时间，期间仍会被探测，以便分区恢复后重新发现
+const memberRetention = time.Hour
+
+// MemberState 集群成员状态
+type MemberState string
+
+const (
+	MemberAlive   MemberState = "alive"   // 最近一次探测成功或收到了更新的存活信息
+	MemberSuspect MemberState = "suspect" // 直接和间接探测都失败，等待节点反驳
+	MemberDead    MemberState = "dead"    // 怀疑超时，不再参与下载和复制
+	MemberLeft    MemberState = "left"    // 节点正常退出
+)
+
+// stateRank 相同 incarnation 下状态的优先级，较高的覆盖较低的
+func (s MemberState) stateRank() int {
+	switch s {
+	case MemberSuspect:
+		return 1
+	case MemberDead:
+		return 2
+	case MemberLeft:
+		return 3
+	}
+	return 0
+}
+
+// Member 成员表中的一个节点
+type Member struct {
+	ID          string      `json:"id"`
+	GRPCAddr    string      `json:"grpc_addr"`
+	HTTPAddr    string      `json:"http_addr,omitempty"`
+	State       MemberState `json:"state"`
+	Incarnation uint64      `json:"incarnation"`
+	StateSince  time.Time   `json:"state_since"`
+	LastAck     time.Time   `json:"last_ack,omitempty"` // 本节点最近一次直接探测成功的时间
+	RTTMillis   int64       `json:"rtt_ms,omitempty"`   // 最近一次直接探测的往返时间
+}
+
+// Healthy 节点可以正常提供文件
+func (m Member) Healthy() bool {
+	return m.State == MemberAlive
+}
+
+// Reachable 节点没有被判定为下线，怀疑状态的节点仍计入副本数，避免短暂抖动触发复制
+func (m Member) Reachable() bool {
+	return m.State == MemberAlive || m.State == MemberSuspect
+}
+
+// MembershipConfig 故障检测参数
+type MembershipConfig struct {
+	ProbeInterval  time.Duration // 每轮探测一个成员的间隔
+	ProbeTimeout   time.Duration // 单次探测的超时
+	SuspectTimeout time.Duration // 怀疑多久没有反驳后判定为下线
+	IndirectProbes int           // 直接探测失败后请几个其他成员代为探测
+}
+
+// Membership 基于 SWIM 的成员管理：每轮轮流探测一个成员，失败后通过其他成员间接探测，
+// 仍然失败则标记为怀疑，超时后判定为下线。每次探测都交换完整的成员列表，
+// 被怀疑的节点看到自己的状态后增大 incarnation 反驳
+type Membership struct {
+	cfg  MembershipConfig
+	dial func(addr string) (*grpc.ClientConn, error)
+
+	mu         sync.Mutex
+	self       Member
+	members    map[string]*Member
+	probeOrder []string
+	probeIndex int
+
+	// OnChange 成员状态变化时调用，在锁外执行
+	OnChange func(member Member, previous MemberState)
+}
+
+// NewMembership 创建成员表，self.Incarnation 应当在每次启动时增大，例如使用启动时间
+func NewMembership(self Member, cfg MembershipConfig, dial func(addr string) (*grpc.ClientConn, error)) *Membership {
+	self.State = MemberAlive
+	self.StateSince = time.Now()
+	return &Membership{
+		cfg:     cfg,
+		dial:    dial,
+		self:    self,
+		members: make(map[string]*Member),
+	}
+}
+
+// Self 本节点
+func (m *Membership) Self() Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.self
+}
+
+// Members 按ID排序的成员列表，包括本节点
+func (m *Membership) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snapshotLocked()
+}
+
+func (m *Membership) snapshotLocked() []Member {
+	members := make([]Member, 0, len(m.members)+1)
+	members = append(members, m.self)
+	for _, member := range m.members {
+		members = append(members, *member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// Get 查询成员
+func (m *Membership) Get(id string) (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id == m.self.ID {
+		return m.self, true
+	}
+	member, ok := m.members[id]
+	if !ok {
+		return Member{}, false
+	}
+	return *member, true
+}
+
+// Discover 加入从注册表或配置中发现的节点，已知的节点不受影响
+func (m *Membership) Discover(id, grpcAddr string) {
+	if id == "" || grpcAddr == "" {
+		return
+	}
+	m.Merge([]Member{{ID: id, GRPCAddr: grpcAddr, State: MemberAlive}})
+}
+
+// Merge 合并其他节点发来的成员信息：incarnation 较大的覆盖较小的，相同时按 alive < suspect < dead < left 覆盖。
+// 关于本节点的怀疑或下线信息会被反驳
+func (m *Membership) Merge(incoming []Member) {
+	type change struct {
+		member   Member
+		previous MemberState
+	}
+	var changes []change
+	now := time.Now()
+
+	m.mu.Lock()
+	for _, in := range incoming {
+		if in.ID == "" {
+			continue
+		}
+		if in.ID == m.self.ID {
+			if in.State != MemberAlive && m.self.State == MemberAlive && in.Incarnation >= m.self.Incarnation {
+				m.self.Incarnation = in.Incarnation + 1
+				color.Yellow("集群: 其他节点认为本节点 %s，incarnation 增加到 %d", in.State, m.self.Incarnation)
+			}
+			continue
+		}
+		known, ok := m.members[in.ID]
+		if !ok {
+			member := in
+			member.StateSince = now
+			member.LastAck = time.Time{}
+			member.RTTMillis = 0
+			m.members[in.ID] = &member
+			changes = append(changes, change{member: member})
+			continue
+		}
+		newer := in.Incarnation > known.Incarnation ||
+			(in.Incarnation == known.Incarnation && in.State.stateRank() > known.State.stateRank())
+		if !newer {
+			continue
+		}
+		previous := known.State
+		known.Incarnation = in.Incarnation
+		if in.GRPCAddr != "" {
+			known.GRPCAddr = in.GRPCAddr
+		}
+		if in.HTTPAddr != "" {
+			known.HTTPAddr = in.HTTPAddr
+		}
+		if in.State != previous {
+			known.State = in.State
+			known.StateSince = now
+			changes = append(changes, change{member: *known, previous: previous})
+		}
+	}
+	m.mu.Unlock()
+
+	for _, ch := range changes {
+		m.notify(ch.member, ch.previous)
+	}
+}
+
+// setState 本节点的探测结果改变成员状态，incarnation 不变
+func (m *Membership) setState(id string, incarnation uint64, state MemberState) {
+	m.mu.Lock()
+	member, ok := m.members[id]
+	if !ok || member.Incarnation != incarnation || member.State == state {
+		m.mu.Unlock()
+		return
+	}
+	previous := member.State
+	member.State = state
+	member.StateSince = time.Now()
+	changed := *member
+	m.mu.Unlock()
+	m.notify(changed, previous)
+}
+
+func (m *Membership) notify(member Member, previous MemberState) {
+	switch member.State {
+	case MemberAlive:
+		if previous != "" {
+			color.Green("集群: 节点 %s 恢复在线 (%s)", member.ID, member.GRPCAddr)
+		}
+	case MemberSuspect:
+		color.Yellow("集群: 节点 %s 探测失败，标记为可疑", member.ID)
+	case MemberDead:
+		logger.Errorf("集群节点 %s 已下线", member.ID)
+		color.Red("集群: 节点 %s 已下线", member.ID)
+	case MemberLeft:
+		color.Yellow("集群: 节点 %s 已退出", member.ID)
+	}
+	if m.OnChange != nil {
+		m.OnChange(member, previous)
+	}
+}
+
+// Join 与种子节点交换成员列表，至少一个成功即可
+func (m *Membership) Join(ctx context.Context, seeds []string) error {
+	if len(seeds) == 0 {
+		return nil
+	}
+	var lastErr error
+	joined := false
+	for _, addr := range seeds {
+		if _, err := m.gossip(ctx, addr); err != nil {
+			lastErr = err
+			continue
+		}
+		joined = true
+	}
+	if !joined {
+		return lastErr
+	}
+	return nil
+}
+
+// Leave 把本节点标记为退出并通知在线成员，其他节点不必等待怀疑超时
+func (m *Membership) Leave(ctx context.Context) {
+	m.mu.Lock()
+	m.self.State = MemberLeft
+	m.self.Incarnation++
+	m.self.StateSince = time.Now()
+	var targets []string
+	for _, member := range m.members {
+		if member.Reachable() {
+			targets = append(targets, member.GRPCAddr)
+		}
+	}
+	m.mu.Unlock()
+
+	rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+	for _, addr := range targets[:min(len(targets), 3)] {
+		_, _ = m.gossip(ctx, addr)
+	}
+}
+
+// HandleGossip 处理其他节点的 Gossip 调用，返回包括本节点在内的成员列表
+func (m *Membership) HandleGossip(from Member, members []Member) []Member {
+	m.Merge(append(members, from))
+	return m.Members()
+}
+
+// gossip 向 addr 发送本节点的成员列表并合并返回的列表，同时作为一次直接探测
+func (m *Membership) gossip(ctx context.Context, addr string) (time.Duration, error) {
+	if addr == "" {
+		return 0, errors.New("节点地址未知")
+	}
+	conn, err := m.dial(addr)
+	if err != nil {
+		return 0, err
+	}
+	if conn.GetState() == connectivity.TransientFailure {
+		// 节点可能刚恢复，不等待重连退避
+		conn.ResetConnectBackoff()
+	}
+	m.mu.Lock()
+	self := memberToProto(m.self)
+	var members []*filesharev1.Member
+	for _, member := range m.members {
+		members = append(members, memberToProto(*member))
+	}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.ProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := filesharev1.NewClusterServiceClient(conn).Gossip(ctx, &filesharev1.GossipRequest{From: self, Members: members})
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	incoming := make([]Member, 0, len(resp.GetMembers()))
+	for _, member := range resp.GetMembers() {
+		incoming = append(incoming, memberFromProto(member))
+	}
+	m.Merge(incoming)
+	return rtt, nil
+}
+
+// Probe 直接探测 addr，供 ProbeNode 间接探测使用
+func (m *Membership) Probe(ctx context.Context, addr string, timeout time.Duration) bool {
+	if timeout > 0 && timeout < m.cfg.ProbeTimeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	_, err := m.gossip(ctx, addr)
+	return err == nil
+}
+
+// Run 按 ProbeInterval 探测成员，直到 ctx 结束
+func (m *Membership) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m.expireSuspects()
+		if target, ok := m.nextTarget(); ok {
+			m.probe(ctx, target)
+		}
+	}
+}
+
+// nextTarget 按随机顺序轮流选择探测目标，每轮重新打乱。
+// 下线的节点也会被探测，分区恢复后可以重新发现；已退出的节点不再探测
+func (m *Membership) nextTarget() (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for attempts := 0; attempts <= len(m.members); attempts++ {
+		if m.probeIndex >= len(m.probeOrder) {
+			m.probeOrder = m.probeOrder[:0]
+			for id, member := range m.members {
+				if member.State != MemberLeft {
+					m.probeOrder = append(m.probeOrder, id)
+				}
+			}
+			rand.Shuffle(len(m.probeOrder), func(i, j int) {
+				m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+			})
+			m.probeIndex = 0
+			if len(m.probeOrder) == 0 {
+				return Member{}, false
+			}
+		}
+		id := m.probeOrder[m.probeIndex]
+		m.probeIndex++
+		if member, ok := m.members[id]; ok && member.State != MemberLeft {
+			return *member, true
+		}
+	}
+	return Member{}, false
+}
+
+// probe 直接探测失败后请其他在线成员间接探测，都失败时标记为怀疑
+func (m *Membership) probe(ctx context.Context, target Member) {
+	rtt, err := m.gossip(ctx, target.GRPCAddr)
+	if err == nil {
+		m.mu.Lock()
+		if member, ok := m.members[target.ID]; ok {
+			member.LastAck = time.Now()
+			member.RTTMillis = rtt.Milliseconds()
+		}
+		m.mu.Unlock()
+		return
+	}
+	if ctx.Err() != nil || target.State == MemberDead {
+		return
+	}
+	if m.probeIndirect(ctx, target) || ctx.Err() != nil {
+		return
+	}
+	m.setState(target.ID, target.Incarnation, MemberSuspect)
+}
+
+// probeIndirect 随机选择 IndirectProbes 个在线成员代为探测，任何一个成功即可
+func (m *Membership) probeIndirect(ctx context.Context, target Member) bool {
+	m.mu.Lock()
+	var helpers []Member
+	for _, member := range m.members {
+		if member.ID != target.ID && member.Healthy() {
+			helpers = append(helpers, *member)
+		}
+	}
+	m.mu.Unlock()
+	rand.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+	helpers = helpers[:min(len(helpers), m.cfg.IndirectProbes)]
+	if len(helpers) == 0 {
+		return false
+	}
+
+	results := make(chan bool, len(helpers))
+	for _, helper := range helpers {
+		go func(helper Member) {
+			conn, err := m.dial(helper.GRPCAddr)
+			if err != nil {
+				results <- false
+				return
+			}
+			ctx, cancel := context.WithTimeout(ctx, 2*m.cfg.ProbeTimeout)
+			defer cancel()
+			resp, err := filesharev1.NewClusterServiceClient(conn).ProbeNode(ctx, &filesharev1.ProbeNodeRequest{
+				NodeId:    target.ID,
+				GrpcAddr:  target.GRPCAddr,
+				TimeoutMs: m.cfg.ProbeTimeout.Milliseconds(),
+			})
+			results <- err == nil && resp.GetReachable()
+		}(helper)
+	}
+	reachable := false
+	for range helpers {
+		if <-results {
+			reachable = true
+		}
+	}
+	return reachable
+}
+
+// expireSuspects 怀疑超过 SuspectTimeout 的成员判定为下线，下线或退出超过 memberRetention 的成员从成员表中移除
+func (m *Membership) expireSuspects() {
+	m.mu.Lock()
+	var expired []Member
+	for id, member := range m.members {
+		switch {
+		case member.State == MemberSuspect && time.Since(member.StateSince) > m.cfg.SuspectTimeout:
+			expired = append(expired, *member)
+		case !member.Reachable() && time.Since(member.StateSince) > memberRetention:
+			delete(m.members, id)
+		}
+	}
+	m.mu.Unlock()
+	for _, member := range expired {
+		m.setState(member.ID, member.Incarnation, MemberDead)
+	}
+}
+
+func memberToProto(member Member) *filesharev1.Member {
+	state := filesharev1.MemberState_MEMBER_STATE_ALIVE
+	switch member.State {
+	case MemberSuspect:
+		state = filesharev1.MemberState_MEMBER_STATE_SUSPECT
+	case MemberDead:
+		state = filesharev1.MemberState_MEMBER_STATE_DEAD
+	case MemberLeft:
+		state = filesharev1.MemberState_MEMBER_STATE_LEFT
+	}
+	return &filesharev1.Member{
+		NodeId:      member.ID,
+		GrpcAddr:    member.GRPCAddr,
+		HttpAddr:    member.HTTPAddr,
+		State:       state,
+		Incarnation: member.Incarnation,
+	}
+}
+
+func memberFromProto(member *filesharev1.Member) Member {
+	state := MemberAlive
+	switch member.GetState() {
+	case filesharev1.MemberState_MEMBER_STATE_SUSPECT:
+		state = MemberSuspect
+	case filesharev1.MemberState_MEMBER_STATE_DEAD:
+		state = MemberDead
+	case filesharev1.MemberState_MEMBER_STATE_LEFT:
+		state = MemberLeft
+	}
+	return Member{
+		ID:          member.GetNodeId(),
+		GRPCAddr:    member.GetGrpcAddr(),
+		HTTPAddr:    member.GetHttpAddr(),
+		State:       state,
+		Incarnation: member.GetIncarnation(),
+	}
+}
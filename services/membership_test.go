@@ -0,0 +1,213 @@
+package services
+
+import (
+	filesharev1 "GoFileShare/proto/fileshare/v1"
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memberTestServer 把 ClusterService 的 Gossip 和 ProbeNode 交给单个节点的成员表处理，
+// 与 ClusterServer 的区别只是不依赖全局的 Cluster，同一进程中可以运行多个节点
+type memberTestServer struct {
+	filesharev1.UnimplementedClusterServiceServer
+
+	members *Membership
+}
+
+func (s *memberTestServer) Gossip(ctx context.Context, req *filesharev1.GossipRequest) (*filesharev1.GossipResponse, error) {
+	incoming := make([]Member, 0, len(req.GetMembers()))
+	for _, member := range req.GetMembers() {
+		incoming = append(incoming, memberFromProto(member))
+	}
+	resp := &filesharev1.GossipResponse{}
+	for _, member := range s.members.HandleGossip(memberFromProto(req.GetFrom()), incoming) {
+		resp.Members = append(resp.Members, memberToProto(member))
+	}
+	return resp, nil
+}
+
+func (s *memberTestServer) ProbeNode(ctx context.Context, req *filesharev1.ProbeNodeRequest) (*filesharev1.ProbeNodeResponse, error) {
+	member, ok := s.members.Get(req.GetNodeId())
+	if !ok {
+		return &filesharev1.ProbeNodeResponse{}, nil
+	}
+	timeout := time.Duration(req.GetTimeoutMs()) * time.Millisecond
+	return &filesharev1.ProbeNodeResponse{Reachable: s.members.Probe(ctx, member.GRPCAddr, timeout)}, nil
+}
+
+// memberTestNode 在回环地址上运行的一个集群节点
+type memberTestNode struct {
+	members *Membership
+	server  *grpc.Server
+	cancel  context.CancelFunc
+
+	mu          sync.Mutex
+	conns       map[string]*grpc.ClientConn
+	transitions map[string][]MemberState
+}
+
+func startMemberTestNode(t *testing.T, id string) *memberTestNode {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	node := &memberTestNode{
+		conns:       make(map[string]*grpc.ClientConn),
+		transitions: make(map[string][]MemberState),
+	}
+	node.members = NewMembership(Member{ID: id, GRPCAddr: listener.Addr().String(), Incarnation: 1}, MembershipConfig{
+		ProbeInterval:  30 * time.Millisecond,
+		ProbeTimeout:   100 * time.Millisecond,
+		SuspectTimeout: 400 * time.Millisecond,
+		IndirectProbes: 2,
+	}, node.dial)
+	node.members.OnChange = func(member Member, previous MemberState) {
+		node.mu.Lock()
+		node.transitions[member.ID] = append(node.transitions[member.ID], member.State)
+		node.mu.Unlock()
+	}
+	node.server = grpc.NewServer()
+	filesharev1.RegisterClusterServiceServer(node.server, &memberTestServer{members: node.members})
+	go node.server.Serve(listener)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	node.cancel = cancel
+	go node.members.Run(ctx)
+	t.Cleanup(node.stop)
+	t.Cleanup(func() {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		for _, conn := range node.conns {
+			conn.Close()
+		}
+	})
+	return node
+}
+
+// dial 与 Cluster.dial 一样按地址复用连接
+func (n *memberTestNode) dial(addr string) (*grpc.ClientConn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if conn, ok := n.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	n.conns[addr] = conn
+	return conn, nil
+}
+
+// stop 模拟节点宕机：停止探测并关闭服务，不发送退出通知
+func (n *memberTestNode) stop() {
+	n.cancel()
+	n.server.Stop()
+}
+
+func (n *memberTestNode) seen(id string) []MemberState {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]MemberState(nil), n.transitions[id]...)
+}
+
+// waitMembers 等待 check 对所有节点都成立
+func waitMembers(t *testing.T, nodes []*memberTestNode, what string, check func(*Membership) error) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var err error
+		for _, node := range nodes {
+			if err = check(node.members); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: %v", what, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func memberState(id string, want MemberState) func(*Membership) error {
+	return func(m *Membership) error {
+		member, ok := m.Get(id)
+		if !ok {
+			return fmt.Errorf("%s 不知道节点 %s", m.Self().ID, id)
+		}
+		if member.State != want {
+			return fmt.Errorf("%s 看到节点 %s 为 %s，期望 %s", m.Self().ID, id, member.State, want)
+		}
+		return nil
+	}
+}
+
+func TestMembershipConvergenceFailureAndRefutation(t *testing.T) {
+	ids := []string{"node-a", "node-b", "node-c", "node-d"}
+	nodes := make([]*memberTestNode, len(ids))
+	for i, id := range ids {
+		nodes[i] = startMemberTestNode(t, id)
+	}
+	// 所有节点只知道第一个节点，其余成员通过 Gossip 传播
+	seed := nodes[0].members.Self().GRPCAddr
+	for _, node := range nodes[1:] {
+		if err := node.members.Join(context.Background(), []string{seed}); err != nil {
+			t.Fatalf("加入集群失败: %v", err)
+		}
+	}
+	waitMembers(t, nodes, "成员表没有收敛", func(m *Membership) error {
+		members := m.Members()
+		if len(members) != len(ids) {
+			return fmt.Errorf("%s 只看到 %d 个成员", m.Self().ID, len(members))
+		}
+		for _, member := range members {
+			if member.State != MemberAlive {
+				return fmt.Errorf("%s 看到 %s 为 %s", m.Self().ID, member.ID, member.State)
+			}
+		}
+		return nil
+	})
+
+	// 错误的怀疑传播到节点 c 后，c 增大 incarnation 反驳，所有节点重新看到 c 在线
+	victim := nodes[2].members.Self()
+	nodes[0].members.Merge([]Member{{ID: victim.ID, GRPCAddr: victim.GRPCAddr, State: MemberSuspect, Incarnation: victim.Incarnation}})
+	waitMembers(t, nodes, "怀疑没有被反驳", func(m *Membership) error {
+		member, _ := m.Get(victim.ID)
+		if member.Incarnation <= victim.Incarnation || member.State != MemberAlive {
+			return fmt.Errorf("%s 看到 %s 为 %s（incarnation %d）", m.Self().ID, victim.ID, member.State, member.Incarnation)
+		}
+		return nil
+	})
+
+	// 停止节点 d，其余节点先怀疑再判定为下线
+	stopped := nodes[3]
+	stopped.stop()
+	running := nodes[:3]
+	waitMembers(t, running, "停止的节点没有被判定为下线", memberState(stopped.members.Self().ID, MemberDead))
+	for _, node := range running {
+		transitions := node.seen(stopped.members.Self().ID)
+		suspected := false
+		for _, state := range transitions {
+			if state == MemberSuspect {
+				suspected = true
+			}
+			if state == MemberDead && !suspected {
+				t.Fatalf("%s 没有经过怀疑就判定下线: %v", node.members.Self().ID, transitions)
+			}
+		}
+	}
+	// 运行中的节点不受影响
+	for _, node := range running {
+		waitMembers(t, running, "运行中的节点状态错误", memberState(node.members.Self().ID, MemberAlive))
+	}
+}
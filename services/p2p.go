@@ -1,16 +1,38 @@
 package services
 
 import (
+	"GoFileShare/utils"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// P2P信令协议的任务类型，PacketData.Task
+const (
+	P2PTaskRegister    int8 = 1 // 注册，服务器记录来源地址作为外部地址
+	P2PTaskQuery       int8 = 2 // 查询目标客户端的地址
+	P2PTaskHolePunch   int8 = 3 // 请求服务器通知目标客户端打洞
+	P2PTaskPunchNotify int8 = 4 // 服务器发给目标客户端的打洞通知
+	P2PTaskHandshake   int8 = 5 // 客户端之间握手时携带的身份信息，不发给服务器
+	P2PTaskRelay       int8 = 6 // 请求服务器中继与目标客户端之间的报文
+)
+
+const (
+	p2pRequestTimeout = 5 * time.Second
+	p2pPunchTimeout   = 3 * time.Second
+	p2pPunchInterval  = 200 * time.Millisecond
+)
+
 // 扩展的数据包结构
 type PacketData struct {
 	Task      int8   `json:"task"`
@@ -21,6 +43,17 @@ type PacketData struct {
 	Timestamp int64  `json:"timestamp"`
 }
 
+// PunchNotification 服务器转发给目标客户端的打洞通知（任务4）
+type PunchNotification struct {
+	Task               int8   `json:"task"`
+	RequesterKey       string `json:"requester_key"`
+	RequesterIP        string `json:"requester_ip"`
+	RequesterPort      int    `json:"requester_port"`
+	RequesterLocalIP   string `json:"requester_local_ip"`
+	RequesterLocalPort int    `json:"requester_local_port"`
+	Timestamp          int64  `json:"timestamp"`
+}
+
 // 客户端信息响应
 type ClientInfoResponse struct {
 	Status       string `json:"status"`
@@ -39,7 +72,20 @@ type P2PConnection struct {
 	RemoteLocalIP      string
 	RemoteLocalPort    int
 	Conn               *net.UDPConn
-	IsConnected        bool
+	IsConnected        bool      // 处于 connected 或 idle 状态，可以发送数据
+	State              string    // punching、connected、idle、lost
+	RTTMs              float64   // 心跳测得的平滑往返时间（毫秒），收到第一个回复前为0
+	LastSeen           time.Time // 最后一次收到对方加密报文的时间
+	RemotePublicKey    string    // 握手时对方证明持有的身份公钥
+	Local              bool      // 对方地址在本机网卡的子网内，没有经过NAT
+	Mode               string    // direct 直接连接，relayed 经信令服务器中继
+
+	remoteAddr  *net.UDPAddr // 完成握手的地址，确认连通后只向这个地址发送
+	stateSince  time.Time
+	rtt         time.Duration
+	lastPing    time.Time
+	repunchedAt time.Time
+	repunching  bool
 }
 
 type EnhancedUdpClient struct {
@@ -48,15 +94,71 @@ type EnhancedUdpClient struct {
 	localIP        string
 	localPort      int
 	clientKey      string
+	identity       *P2PIdentity
+	trust          *P2PTrustStore
 	externalIP     string
 	externalPort   int
 	natType        string
+	natBehavior    *NATBehavior
+	stun           *STUNClient
+	streams        *streamMux
 	p2pConnections map[string]*P2PConnection
 	mutex          sync.RWMutex
-	isRunning      bool
+	running        atomic.Bool
+	keepalive      time.Duration // 心跳间隔，同时刷新NAT映射
+	peerTimeout    time.Duration // 这么久没有收到对方的报文时断开连接
+	registerEvery  time.Duration // 定期重新注册，刷新信令服务器记录的外部地址
+	registeredAt   time.Time
+	lan            *lanDiscovery // 未启用局域网发现时为 nil
+
+	// 握手和加密会话，按本端分配的会话编号索引
+	handshakes   map[uint32]*p2pPendingHandshake
+	sessions     map[uint32]*p2pSession
+	peerSessions map[string]*p2pSession // 向每个对方发送时使用的会话
+	seenInits    map[string]time.Time   // 已完成握手的发起方临时公钥，拒绝重放的第一条消息
+
+	// 服务器的回复由接收协程转交给等待中的请求，同一时间只有一个请求在等待
+	requestMu sync.Mutex
+	responses chan []byte
+	onMessage func(fromKey string, data []byte)
+
+	// 对方打开的流按第一个信封的频道分发
+	channels     map[string]P2PChannelHandler
+	dispatchOnce sync.Once
+	connectMu    sync.Mutex
 }
 
-func NewEnhancedUdpClient(serverAddr, clientKey string) (*EnhancedUdpClient, error) {
+// NewEnhancedUdpClient 创建客户端。identity 为 nil 时使用临时生成的身份，clientKey 为空时使用由身份公钥派生的Key；
+// trust 为 nil 时使用只保存在内存中的信任列表
+func NewEnhancedUdpClient(serverAddr, clientKey string, identity *P2PIdentity, trust *P2PTrustStore) (*EnhancedUdpClient, error) {
+	if identity == nil {
+		generated, err := GenerateP2PIdentity()
+		if err != nil {
+			return nil, fmt.Errorf("生成身份密钥失败: %w", err)
+		}
+		identity = generated
+	}
+	if clientKey == "" {
+		clientKey = identity.KeyName()
+	}
+	if trust == nil {
+		trust, _ = LoadP2PTrustStore("", false)
+	}
+	keepalive, err := clusterDuration("P2P_KEEPALIVE", "15s")
+	if err != nil {
+		return nil, err
+	}
+	peerTimeout, err := clusterDuration("P2P_PEER_TIMEOUT", "60s")
+	if err != nil {
+		return nil, err
+	}
+	if peerTimeout <= 2*keepalive {
+		return nil, fmt.Errorf("P2P_PEER_TIMEOUT（%s）必须大于两倍的 P2P_KEEPALIVE（%s）", peerTimeout, keepalive)
+	}
+	registerEvery, err := clusterDuration("P2P_REGISTER_INTERVAL", "1m")
+	if err != nil {
+		return nil, err
+	}
 	sAddr, err := net.ResolveUDPAddr("udp", serverAddr)
 	if err != nil {
 		return nil, fmt.Errorf("无法解析服务器地址: %w", err)
@@ -70,7 +172,10 @@ func NewEnhancedUdpClient(serverAddr, clientKey string) (*EnhancedUdpClient, err
 
 	// 获取本地监听信息
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	localIP := getLocalIP()
+	localIP := getLocalIP(sAddr)
+
+	// 超过系统上限时内核按上限设置，失败也不影响正确性
+	_ = conn.SetReadBuffer(streamSocketBuffer)
 
 	client := &EnhancedUdpClient{
 		conn:           conn,
@@ -78,20 +183,43 @@ func NewEnhancedUdpClient(serverAddr, clientKey string) (*EnhancedUdpClient, err
 		localIP:        localIP,
 		localPort:      localAddr.Port,
 		clientKey:      clientKey,
+		identity:       identity,
+		trust:          trust,
 		p2pConnections: make(map[string]*P2PConnection),
-		isRunning:      true,
-	}
+		handshakes:     make(map[uint32]*p2pPendingHandshake),
+		sessions:       make(map[uint32]*p2pSession),
+		peerSessions:   make(map[string]*p2pSession),
+		seenInits:      make(map[string]time.Time),
+		channels:       make(map[string]P2PChannelHandler),
+		responses:      make(chan []byte, 1),
+		stun:           newSTUNClient(conn),
+		keepalive:      keepalive,
+		peerTimeout:    peerTimeout,
+		registerEvery:  registerEvery,
+	}
+	mtu, _ := strconv.Atoi(os.Getenv("P2P_MTU"))
+	if mtu < streamMinMTU {
+		mtu = streamDefaultMTU
+	}
+	// 中继时每个报文多出中继报头，按直连和中继都不超过 P2P_MTU 计算可靠流的报文大小
+	client.streams = newStreamMux(client.sendStreamPacket, mtu-P2PTransportOverhead-P2PRelayOverhead, client.keyForAddr)
+	client.running.Store(true)
 
 	// 启动消息接收goroutine
 	go client.messageReceiver()
+	go client.maintainConnections()
+	if err := client.startLANDiscovery(); err != nil {
+		client.Close()
+		return nil, err
+	}
 
 	log.Printf("增强版客户端已启动: 本地地址=%s:%d, Key=%s", localIP, localAddr.Port, clientKey)
 	return client, nil
 }
 
-// 获取本地IP地址
-func getLocalIP() string {
-	conn, err := net.Dial("udp", os.Getenv("baidu.com"))
+// getLocalIP 返回访问信令服务器时使用的本地IP，不会真正发送数据
+func getLocalIP(serverAddr *net.UDPAddr) string {
+	conn, err := net.DialUDP("udp", nil, serverAddr)
 	if err != nil {
 		return "127.0.0.1"
 	}
@@ -99,83 +227,127 @@ func getLocalIP() string {
 	return conn.LocalAddr().(*net.UDPAddr).IP.String()
 }
 
-func detectNATType() (string, net.UDPAddr) {
-	localAddr, _ := net.ResolveUDPAddr("udp", "0.0.0.0:0")
-	remoteAddr, _ := net.ResolveUDPAddr("udp", "stun.l.google.com:19302")
-
-	conn, _ := net.DialUDP("udp", localAddr, remoteAddr)
-	defer conn.Close()
-
-	// 发送STUN请求
-	_, _ = conn.Write([]byte("STUN Request"))
-
-	// 设置超时
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-
-	buffer := make([]byte, 1024)
-	n, _, _ := conn.ReadFromUDP(buffer)
+// request 向信令服务器发送请求并等待回复
+func (c *EnhancedUdpClient) request(packet PacketData) ([]byte, error) {
+	c.requestMu.Lock()
+	defer c.requestMu.Unlock()
 
-	// 解析STUN响应
-	if n > 0 {
-		// 简化处理，实际需解析STUN响应格式
-		return "Full Cone NAT", *remoteAddr
+	// 丢弃上一个请求超时后才到达的回复
+	select {
+	case <-c.responses:
+	default:
 	}
-	return "Unknown NAT Type", *remoteAddr
-}
-
-// Register 注册到信令服务器并获取NAT信息
-func (c *EnhancedUdpClient) Register() error {
-	NATType, ADDR := detectNATType()
-	if NATType == "Full Cone NAT" {
-		c.natType = NATType
-	} else {
-		c.natType = "Restricted Cone NAT"
+	packet.Key = c.clientKey
+	packet.Timestamp = time.Now().Unix()
+	jsonData, err := json.Marshal(packet)
+	if err != nil {
+		return nil, fmt.Errorf("JSON编码失败: %w", err)
 	}
-	packet := PacketData{
-		Task:      1,
-		IP:        ADDR.IP.String(),
-		Port:      strconv.Itoa(ADDR.Port),
-		Key:       c.clientKey,
-		Timestamp: time.Now().Unix(),
+	if _, err := c.conn.WriteToUDP(jsonData, c.serverAddr); err != nil {
+		return nil, fmt.Errorf("发送请求失败: %w", err)
 	}
 
-	jsonData, err := json.Marshal(packet)
-	if err != nil {
-		return fmt.Errorf("JSON编码失败: %w", err)
+	timer := time.NewTimer(p2pRequestTimeout)
+	defer timer.Stop()
+	select {
+	case data := <-c.responses:
+		return data, nil
+	case <-timer.C:
+		return nil, errors.New("等待服务器响应超时")
 	}
+}
 
-	_, err = c.conn.WriteToUDP(jsonData, c.serverAddr)
+// Register 注册到信令服务器，服务器记录本客户端的外部地址和本地地址
+func (c *EnhancedUdpClient) Register() error {
+	response, err := c.register()
 	if err != nil {
-		return fmt.Errorf("发送注册请求失败: %w", err)
+		return err
 	}
+	log.Printf("注册成功: 外部地址=%s:%d, NAT类型=%s", response.ExternalIP, response.ExternalPort, response.NATType)
+	// NAT行为发现需要多次请求，不阻塞注册
+	if len(stunServers()) > 0 {
+		go func() {
+			if _, err := c.DiscoverNAT(); err != nil {
+				log.Printf("NAT类型检测失败: %v", err)
+			}
+		}()
+	}
+	return nil
+}
 
-	// 等待响应
-	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	buffer := make([]byte, 1024)
-	n, _, err := c.conn.ReadFromUDP(buffer)
+// register 发送注册请求并记录服务器看到的外部地址，STUN检测过的NAT类型不被覆盖
+func (c *EnhancedUdpClient) register() (*ClientInfoResponse, error) {
+	data, err := c.request(PacketData{
+		Task: P2PTaskRegister,
+		IP:   c.localIP,
+		Port: strconv.Itoa(c.localPort),
+	})
 	if err != nil {
-		return fmt.Errorf("等待注册响应失败: %w", err)
+		return nil, fmt.Errorf("等待注册响应失败: %w", err)
 	}
 
 	var response ClientInfoResponse
-	err = json.Unmarshal(buffer[:n], &response)
+	err = json.Unmarshal(data, &response)
 	if err != nil {
-		return fmt.Errorf("解析注册响应失败: %w", err)
+		return nil, fmt.Errorf("解析注册响应失败: %w", err)
+	}
+	if response.Status != "registered" {
+		return nil, fmt.Errorf("注册失败: %s", response.Status)
 	}
 
-	if response.Status == "registered" {
-		c.externalIP = response.ExternalIP
-		c.externalPort = response.ExternalPort
+	c.mutex.Lock()
+	c.externalIP = response.ExternalIP
+	c.externalPort = response.ExternalPort
+	if c.natBehavior == nil {
 		c.natType = response.NATType
-		log.Printf("注册成功: 外部地址=%s:%d, NAT类型=%s", c.externalIP, c.externalPort, c.natType)
+	}
+	c.registeredAt = time.Now()
+	c.mutex.Unlock()
+	return &response, nil
+}
+
+// stunServers 从 P2P_STUN_SERVERS 读取STUN服务器列表（逗号分隔），设置为 none 时不检测NAT类型
+func stunServers() []string {
+	value := utils.GetEnv("P2P_STUN_SERVERS", "stun.l.google.com:19302")
+	if value == "none" {
 		return nil
 	}
+	var servers []string
+	for _, server := range strings.Split(value, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
 
-	return fmt.Errorf("注册失败: %s", response.Status)
+// DiscoverNAT 通过STUN服务器检测本客户端套接字的NAT映射和过滤行为，结果覆盖信令服务器判断的NAT类型
+func (c *EnhancedUdpClient) DiscoverNAT() (*NATBehavior, error) {
+	behavior, err := c.stun.DiscoverNAT(stunServers())
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.natBehavior = behavior
+	c.natType = behavior.Type
+	c.mutex.Unlock()
+	log.Printf("NAT类型: %s（映射: %s，过滤: %s，外部地址: %s）", behavior.Type, behavior.Mapping, behavior.Filtering, behavior.Mapped)
+	return behavior, nil
 }
 
-// ConnectToPeer 连接到目标客户端
+// ConnectToPeer 连接到目标客户端，在局域网中发现的对方直接握手，失败时再通过信令服务器打洞
 func (c *EnhancedUdpClient) ConnectToPeer(targetKey string) error {
+	if addr := c.lanAddr(targetKey); addr != nil {
+		err := c.establishP2PConnection(targetKey, &ClientInfoResponse{
+			ExternalIP:   addr.IP.String(),
+			ExternalPort: addr.Port,
+		})
+		if err == nil {
+			return nil
+		}
+		log.Printf("局域网直连 %s 失败，改用信令服务器: %v", targetKey, err)
+	}
+
 	// 1. 查询目标客户端信息
 	targetInfo, err := c.queryPeerInfo(targetKey)
 	if err != nil {
@@ -188,8 +360,15 @@ func (c *EnhancedUdpClient) ConnectToPeer(targetKey string) error {
 		return fmt.Errorf("请求打洞失败: %w", err)
 	}
 
-	// 3. 尝试建立P2P连接
+	// 3. 尝试建立P2P连接，打洞超时（例如双方都是对称型NAT）时改用信令服务器中继
 	err = c.establishP2PConnection(targetKey, targetInfo)
+	if errors.Is(err, ErrP2PHandshakeTimeout) {
+		log.Printf("与 %s 打洞失败，尝试通过信令服务器中继: %v", targetKey, err)
+		if relayErr := c.establishRelayedConnection(targetKey); relayErr != nil {
+			return fmt.Errorf("建立P2P连接失败: %w；中继失败: %v", err, relayErr)
+		}
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("建立P2P连接失败: %w", err)
 	}
@@ -198,27 +377,18 @@ func (c *EnhancedUdpClient) ConnectToPeer(targetKey string) error {
 }
 
 func (c *EnhancedUdpClient) queryPeerInfo(targetKey string) (*ClientInfoResponse, error) {
-	packet := PacketData{
-		Task:      2,
+	data, err := c.request(PacketData{
+		Task:      P2PTaskQuery,
 		IP:        c.localIP,
 		Port:      strconv.Itoa(c.localPort),
-		Key:       c.clientKey,
 		TargetKey: targetKey,
-		Timestamp: time.Now().Unix(),
-	}
-
-	jsonData, _ := json.Marshal(packet)
-	c.conn.WriteToUDP(jsonData, c.serverAddr)
-
-	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	buffer := make([]byte, 1024)
-	n, _, err := c.conn.ReadFromUDP(buffer)
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	var response ClientInfoResponse
-	err = json.Unmarshal(buffer[:n], &response)
+	err = json.Unmarshal(data, &response)
 	if err != nil {
 		return nil, err
 	}
@@ -231,27 +401,18 @@ func (c *EnhancedUdpClient) queryPeerInfo(targetKey string) (*ClientInfoResponse
 }
 
 func (c *EnhancedUdpClient) requestHolePunch(targetKey string) error {
-	packet := PacketData{
-		Task:      3,
+	data, err := c.request(PacketData{
+		Task:      P2PTaskHolePunch,
 		IP:        c.localIP,
 		Port:      strconv.Itoa(c.localPort),
-		Key:       c.clientKey,
 		TargetKey: targetKey,
-		Timestamp: time.Now().Unix(),
-	}
-
-	jsonData, _ := json.Marshal(packet)
-	c.conn.WriteToUDP(jsonData, c.serverAddr)
-
-	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	buffer := make([]byte, 1024)
-	n, _, err := c.conn.ReadFromUDP(buffer)
+	})
 	if err != nil {
 		return err
 	}
 
 	var response map[string]interface{}
-	json.Unmarshal(buffer[:n], &response)
+	json.Unmarshal(data, &response)
 
 	if response["status"] == "hole_punch_initiated" {
 		log.Printf("打洞请求已发送给目标客户端: %s", targetKey)
@@ -261,83 +422,263 @@ func (c *EnhancedUdpClient) requestHolePunch(targetKey string) error {
 	return fmt.Errorf("打洞请求失败: %v", response["status"])
 }
 
+// establishP2PConnection 同时向目标的外部地址和本地地址发送打洞包，直到收到对方的打洞包或超时
 func (c *EnhancedUdpClient) establishP2PConnection(targetKey string, targetInfo *ClientInfoResponse) error {
-	// 实现UDP打洞逻辑
-	// 1. 同时向目标的外部地址和本地地址发送UDP包
-	// 2. 建立双向通信通道
-
-	externalAddr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", targetInfo.ExternalIP, targetInfo.ExternalPort))
-	localAddr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", targetInfo.LocalIP, targetInfo.LocalPort))
+	c.mutex.Lock()
+	p2pConn, exists := c.p2pConnections[targetKey]
+	if !exists {
+		p2pConn = &P2PConnection{RemoteKey: targetKey, Conn: c.conn}
+		c.p2pConnections[targetKey] = p2pConn
+	}
+	p2pConn.RemoteExternalIP = targetInfo.ExternalIP
+	p2pConn.RemoteExternalPort = targetInfo.ExternalPort
+	p2pConn.RemoteLocalIP = targetInfo.LocalIP
+	p2pConn.RemoteLocalPort = targetInfo.LocalPort
+	c.mutex.Unlock()
 
-	// 发送打洞包到外部地址
-	punchMsg := []byte(fmt.Sprintf("PUNCH_FROM_%s", c.clientKey))
-	c.conn.WriteToUDP(punchMsg, externalAddr)
+	// 握手的第一条消息同时作为打洞包
+	return c.handshakeWith(targetKey, func(init []byte) {
+		c.sendPunch(targetInfo.ExternalIP, targetInfo.ExternalPort, targetInfo.LocalIP, targetInfo.LocalPort, init)
+	})
+}
 
-	// 如果在同一内网，也尝试本地地址
-	if c.isInSameNetwork(targetInfo.LocalIP) {
-		c.conn.WriteToUDP(punchMsg, localAddr)
+// handshakeWith 通过 sendInit 重复发送握手的第一条消息，直到对方确认握手完成、被拒绝或超时
+func (c *EnhancedUdpClient) handshakeWith(targetKey string, sendInit func(init []byte)) error {
+	c.mutex.Lock()
+	p2pConn, exists := c.p2pConnections[targetKey]
+	if !exists {
+		p2pConn = &P2PConnection{RemoteKey: targetKey, Conn: c.conn}
+		c.p2pConnections[targetKey] = p2pConn
 	}
+	if !p2pConn.IsConnected {
+		p2pConn.setState(P2PStatePunching)
+	}
+	c.mutex.Unlock()
 
-	// 等待连接确认（简化实现）
-	time.Sleep(2 * time.Second)
+	pending, init, err := c.startHandshake(targetKey)
+	if err != nil {
+		return err
+	}
+	defer c.dropHandshake(pending.localIndex)
+	failed := func(err error) error {
+		c.mutex.Lock()
+		if p2pConn.State == P2PStatePunching {
+			p2pConn.setState(P2PStateLost)
+		}
+		c.mutex.Unlock()
+		return err
+	}
 
-	// 创建P2P连接对象
-	p2pConn := &P2PConnection{
-		RemoteKey:          targetKey,
-		RemoteExternalIP:   targetInfo.ExternalIP,
-		RemoteExternalPort: targetInfo.ExternalPort,
-		RemoteLocalIP:      targetInfo.LocalIP,
-		RemoteLocalPort:    targetInfo.LocalPort,
-		Conn:               c.conn,
-		IsConnected:        true,
+	deadline := time.Now().Add(p2pPunchTimeout)
+	for time.Now().Before(deadline) {
+		c.mutex.RLock()
+		session, final, failure := pending.session, pending.final, pending.err
+		established := session != nil && session.installed
+		c.mutex.RUnlock()
+		switch {
+		case failure != nil:
+			return failed(failure)
+		case established:
+			log.Printf("P2P连接建立成功: %s", targetKey)
+			return nil
+		case session == nil:
+			sendInit(init)
+		default:
+			// 第三条消息可能丢失，响应方收到后会回复加密的确认
+			c.writeTo(final, session.addr)
+		}
+		time.Sleep(p2pPunchInterval)
 	}
+	return failed(fmt.Errorf("%w: %s 内没有完成与 %s 的握手", ErrP2PHandshakeTimeout, p2pPunchTimeout, targetKey))
+}
 
-	c.mutex.Lock()
-	c.p2pConnections[targetKey] = p2pConn
-	c.mutex.Unlock()
+// sendPunch 向对方的外部地址发送打洞包，在同一内网时也发往本地地址
+func (c *EnhancedUdpClient) sendPunch(externalIP string, externalPort int, localIP string, localPort int, packet []byte) {
+	if externalAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(externalIP, strconv.Itoa(externalPort))); err == nil {
+		c.conn.WriteToUDP(packet, externalAddr)
+	}
+	if localIP != "" && c.isInSameNetwork(localIP) {
+		if localAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(localIP, strconv.Itoa(localPort))); err == nil {
+			c.conn.WriteToUDP(packet, localAddr)
+		}
+	}
+}
 
-	log.Printf("P2P连接建立成功: %s", targetKey)
-	return nil
+func (c *EnhancedUdpClient) isConnected(key string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	conn, ok := c.p2pConnections[key]
+	return ok && conn.IsConnected
 }
 
+// isInSameNetwork 根据本机网卡的子网掩码判断对方的本地地址是否可以直接到达
 func (c *EnhancedUdpClient) isInSameNetwork(remoteIP string) bool {
-	// 简化的同网络检测
-	if len(c.localIP) < 7 || len(remoteIP) < 7 {
-		return false
-	}
-	return c.localIP[:7] == remoteIP[:7] // 比较前三个网段
+	return inLocalSubnet(net.ParseIP(remoteIP))
 }
 
-// 消息接收器
+// messageReceiver 唯一读取 UDP 连接的协程：服务器的回复交给等待中的请求，打洞通知和握手在这里处理，
+// P2P数据只接受加密传输报文，未加密的数据一律丢弃
 func (c *EnhancedUdpClient) messageReceiver() {
-	buffer := make([]byte, 1024)
-	for c.isRunning {
-		c.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-		n, addr, err := c.conn.ReadFrom(buffer)
+	buffer := make([]byte, 64*1024)
+	for c.running.Load() {
+		n, addr, err := c.conn.ReadFromUDP(buffer)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			continue
 		}
+		if n == 0 {
+			continue
+		}
+		data := buffer[:n]
 
-		// 检查是否是打洞通知
-		var notification map[string]interface{}
-		if json.Unmarshal(buffer[:n], &notification) == nil {
-			if task, ok := notification["task"].(float64); ok && int(task) == 4 {
-				log.Printf("收到打洞通知，来自: %v", notification["requester_key"])
-				// 响应打洞请求
-				c.respondToHolePunch(addr, notification)
+		if isSTUNMessage(data) {
+			c.stun.handle(data, addr)
+			continue
+		}
+		switch data[0] {
+		case p2pHandshakeMagic:
+			c.handleHandshake(data, addr)
+			continue
+		case p2pTransportMagic:
+			c.handleTransport(data, addr)
+			continue
+		case p2pRelayMagic:
+			if sameUDPAddr(addr, c.serverAddr) {
+				c.handleRelay(data)
+			}
+			continue
+		}
+
+		if addr.IP.Equal(c.serverAddr.IP) && addr.Port == c.serverAddr.Port {
+			var notification PunchNotification
+			if json.Unmarshal(data, &notification) == nil && notification.Task == P2PTaskPunchNotify {
+				log.Printf("收到打洞通知，来自: %s", notification.RequesterKey)
+				c.respondToHolePunch(notification)
+				continue
+			}
+			select {
+			case c.responses <- append([]byte(nil), data...):
+			default:
+				// 没有等待中的请求
 			}
+			continue
+		}
+		// 其他来源的未加密数据无法确认发送者，直接丢弃
+	}
+}
+
+// respondToHolePunch 收到通知后向请求方打洞，请求方发起的握手完成后连接即建立
+func (c *EnhancedUdpClient) respondToHolePunch(notification PunchNotification) {
+	c.mutex.Lock()
+	conn, exists := c.p2pConnections[notification.RequesterKey]
+	if !exists {
+		conn = &P2PConnection{RemoteKey: notification.RequesterKey, Conn: c.conn}
+		c.p2pConnections[notification.RequesterKey] = conn
+	}
+	// 对方重新打洞时地址可能已经变化
+	conn.RemoteExternalIP = notification.RequesterIP
+	conn.RemoteExternalPort = notification.RequesterPort
+	conn.RemoteLocalIP = notification.RequesterLocalIP
+	conn.RemoteLocalPort = notification.RequesterLocalPort
+	if !conn.IsConnected {
+		conn.setState(P2PStatePunching)
+	}
+	c.mutex.Unlock()
+	probe := make([]byte, 10)
+	probe[0], probe[1] = p2pHandshakeMagic, p2pHandshakeProbe
+	rand.Read(probe[2:])
+	c.sendPunch(notification.RequesterIP, notification.RequesterPort, notification.RequesterLocalIP, notification.RequesterLocalPort, probe)
+}
+
+// markConnected 与对方完成握手，记录实际可达的地址，调用时持有 c.mutex
+func (c *EnhancedUdpClient) markConnected(key string, addr *net.UDPAddr, publicKey []byte) {
+	conn, exists := c.p2pConnections[key]
+	if !exists {
+		conn = &P2PConnection{
+			RemoteKey:          key,
+			RemoteExternalIP:   addr.IP.String(),
+			RemoteExternalPort: addr.Port,
+			Conn:               c.conn,
+		}
+		c.p2pConnections[key] = conn
+	}
+	mode := P2PModeDirect
+	if c.isRelayAddr(addr) {
+		mode = P2PModeRelayed
+	}
+	if !conn.IsConnected || !sameUDPAddr(conn.remoteAddr, addr) {
+		if mode == P2PModeRelayed {
+			log.Printf("与 %s 完成握手，P2P连接已建立: 经信令服务器中继", key)
 		} else {
-			// 处理P2P数据
-			log.Printf("收到P2P数据: %s", string(buffer[:n]))
+			log.Printf("与 %s 完成握手，P2P连接已建立: %s", key, addr)
+		}
+	}
+	conn.IsConnected = true
+	conn.Mode = mode
+	conn.Local = conn.Mode == P2PModeDirect && inLocalSubnet(addr.IP)
+	conn.setState(P2PStateConnected)
+	conn.LastSeen = time.Now()
+	conn.RemotePublicKey = base64.StdEncoding.EncodeToString(publicKey)
+	conn.remoteAddr = addr
+}
+
+// handleP2PData 处理已建立连接的对方发来的数据
+func (c *EnhancedUdpClient) handleP2PData(fromKey string, data []byte) {
+	c.mutex.RLock()
+	handler := c.onMessage
+	c.mutex.RUnlock()
+
+	if handler != nil {
+		handler(fromKey, data)
+		return
+	}
+	log.Printf("收到P2P数据: %s", string(data))
+}
+
+// keyForAddr 根据地址找到已建立连接的对方
+func (c *EnhancedUdpClient) keyForAddr(addr *net.UDPAddr) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for key, conn := range c.p2pConnections {
+		if sameUDPAddr(conn.remoteAddr, addr) {
+			return key
 		}
 	}
+	return ""
 }
 
-func (c *EnhancedUdpClient) respondToHolePunch(addr net.Addr, notification map[string]interface{}) {
-	// 向请求者发送响应
-	response := []byte(fmt.Sprintf("PUNCH_RESPONSE_FROM_%s", c.clientKey))
-	udpAddr, _ := net.ResolveUDPAddr("udp", addr.String())
-	c.conn.WriteToUDP(response, udpAddr)
+// OpenStream 在已建立的P2P连接上打开一个可靠流，用于传输文件等大量数据
+func (c *EnhancedUdpClient) OpenStream(targetKey string) (*P2PStream, error) {
+	c.mutex.RLock()
+	conn, exists := c.p2pConnections[targetKey]
+	var remoteAddr *net.UDPAddr
+	if exists && conn.IsConnected {
+		remoteAddr = conn.remoteAddr
+	}
+	c.mutex.RUnlock()
+	if remoteAddr == nil {
+		return nil, fmt.Errorf("与 %s 的P2P连接不存在", targetKey)
+	}
+	return c.streams.open(remoteAddr, targetKey)
+}
+
+// AcceptStream 等待对方打开的流，客户端关闭后返回 ErrStreamClosed。
+// 通过 HandleChannel 注册频道后流由客户端分发，不要再调用 AcceptStream
+func (c *EnhancedUdpClient) AcceptStream() (*P2PStream, error) {
+	stream, ok := <-c.streams.accept
+	if !ok {
+		return nil, ErrStreamClosed
+	}
+	return stream, nil
+}
+
+// SetMessageHandler 设置收到P2P数据时的回调，fromKey 为完成握手的对方
+func (c *EnhancedUdpClient) SetMessageHandler(handler func(fromKey string, data []byte)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onMessage = handler
 }
 
 // SendP2PMessage 发送P2P消息
@@ -349,18 +690,12 @@ func (c *EnhancedUdpClient) SendP2PMessage(targetKey, message string) error {
 	if !exists || !conn.IsConnected {
 		return fmt.Errorf("与 %s 的P2P连接不存在", targetKey)
 	}
+	return c.sendSecure(targetKey, p2pInnerData, []byte(message))
+}
 
-	// 尝试多个地址发送（提高成功率）
-	externalAddr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", conn.RemoteExternalIP, conn.RemoteExternalPort))
-	localAddr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", conn.RemoteLocalIP, conn.RemoteLocalPort))
-
-	msgData := []byte(message)
-	c.conn.WriteToUDP(msgData, externalAddr)
-	if c.isInSameNetwork(conn.RemoteLocalIP) {
-		c.conn.WriteToUDP(msgData, localAddr)
-	}
-
-	return nil
+// TrustStore 返回记录对方身份公钥的信任列表
+func (c *EnhancedUdpClient) TrustStore() *P2PTrustStore {
+	return c.trust
 }
 
 // GetClientInfo 获取客户端状态信息
@@ -368,19 +703,30 @@ func (c *EnhancedUdpClient) GetClientInfo() map[string]interface{} {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
-	return map[string]interface{}{
+	info := map[string]interface{}{
 		"client_key":    c.clientKey,
+		"public_key":    c.identity.PublicKey(),
+		"trust_mode":    map[bool]string{true: "strict", false: "tofu"}[c.trust.Strict()],
 		"local_ip":      c.localIP,
 		"local_port":    c.localPort,
 		"external_ip":   c.externalIP,
 		"external_port": c.externalPort,
 		"nat_type":      c.natType,
-		"is_running":    c.isRunning,
+		"is_running":    c.running.Load(),
 		"connections":   len(c.p2pConnections),
 	}
+	info["lan_discovery"] = c.lan != nil
+	if c.lan != nil {
+		info["lan_group"] = c.lan.group.String()
+	}
+	if c.natBehavior != nil {
+		info["nat_mapping"] = c.natBehavior.Mapping
+		info["nat_filtering"] = c.natBehavior.Filtering
+	}
+	return info
 }
 
-// GetP2PConnections 获取P2P连接列表
+// GetP2PConnections 获取P2P连接列表，包含每个连接的状态、往返时间和最后收到报文的时间
 func (c *EnhancedUdpClient) GetP2PConnections() map[string]*P2PConnection {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
@@ -388,13 +734,18 @@ func (c *EnhancedUdpClient) GetP2PConnections() map[string]*P2PConnection {
 	// 返回连接的副本以避免并发问题
 	connections := make(map[string]*P2PConnection)
 	for k, v := range c.p2pConnections {
-		connections[k] = v
+		snapshot := *v
+		connections[k] = &snapshot
 	}
 	return connections
 }
 
 func (c *EnhancedUdpClient) Close() {
-	c.isRunning = false
+	c.running.Store(false)
+	c.streams.close()
+	if c.lan != nil {
+		c.lan.listener.Close()
+	}
 	if c.conn != nil {
 		c.conn.Close()
 	}
@@ -403,9 +754,21 @@ func (c *EnhancedUdpClient) Close() {
 // GlobalEnhancedP2PClient 全局客户端实例
 var GlobalEnhancedP2PClient *EnhancedUdpClient
 
-// InitEnhancedP2PClient 初始化增强版P2P客户端
+// InitEnhancedP2PClient 初始化增强版P2P客户端，身份密钥和信任列表从 P2P_IDENTITY_FILE、P2P_KNOWN_PEERS 读取
 func InitEnhancedP2PClient(serverAddr, clientKey string) error {
-	client, err := NewEnhancedUdpClient(serverAddr, clientKey)
+	identity, err := LoadP2PIdentity(utils.GetEnv("P2P_IDENTITY_FILE", "p2p_identity.key"))
+	if err != nil {
+		return fmt.Errorf("读取身份密钥失败: %w", err)
+	}
+	trustMode := utils.GetEnv("P2P_TRUST", "tofu")
+	if trustMode != "tofu" && trustMode != "strict" {
+		return fmt.Errorf("P2P_TRUST 无效: %s", trustMode)
+	}
+	trust, err := LoadP2PTrustStore(utils.GetEnv("P2P_KNOWN_PEERS", "p2p_known_peers.json"), trustMode == "strict")
+	if err != nil {
+		return err
+	}
+	client, err := NewEnhancedUdpClient(serverAddr, clientKey, identity, trust)
 	if err != nil {
 		return err
 	}
@@ -418,10 +781,9 @@ func GetGlobalEnhancedP2PClient() *EnhancedUdpClient {
 	return GlobalEnhancedP2PClient
 }
 
-// InitP2PClient 初始化P2P客户端（向后兼容）
+// InitP2PClient 初始化P2P客户端，P2P_CLIENT_KEY 未设置时使用由身份公钥派生的Key
 func InitP2PClient(serverAddr string) error {
-	clientKey := fmt.Sprintf("fileserver-%d", time.Now().Unix())
-	return InitEnhancedP2PClient(serverAddr, clientKey)
+	return InitEnhancedP2PClient(serverAddr, utils.GetEnv("P2P_CLIENT_KEY", ""))
 }
 
 // GetGlobalP2PClient 获取全局P2P客户端（向后兼容）
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"sync"
+	"time"
+)
+
+// P2PMaxChatMessage 单条聊天消息的最大字节数
+const P2PMaxChatMessage = 64 << 10
+
+// inboxSubscriberBuffer 每个消息流连接缓存的消息数，消费过慢时断开
+const inboxSubscriberBuffer = 64
+
+// 每个对方节点每秒可以发来的消息数和突发消息数
+const (
+	inboxPeerRate  = 5
+	inboxPeerBurst = 20
+)
+
+// ErrInvalidP2PMessage 消息为空、过长或格式不正确
+var ErrInvalidP2PMessage = errors.New("无效的P2P消息")
+
+// ErrP2PMessageRateLimited 对方发送消息过于频繁
+var ErrP2PMessageRateLimited = errors.New("消息过于频繁，请稍后再试")
+
+// P2PMessageStore 收件箱的存储
+type P2PMessageStore interface {
+	Insert(msg *models.P2PMessage) (bool, error) // 同一对方的同一消息ID只保存一次，返回是否为新消息
+	List(users []string, unreadOnly bool, limit int64) ([]models.P2PMessage, error)
+	MarkRead(users []string, ids []primitive.ObjectID) (int64, error)
+	UserExists(name string) (bool, error) // 指定了接收用户的消息只保存给存在的用户
+}
+
+// MongoMessageStore 基于 models 的收件箱存储
+var MongoMessageStore P2PMessageStore = mongoMessageStore{}
+
+type mongoMessageStore struct{}
+
+func (mongoMessageStore) Insert(msg *models.P2PMessage) (bool, error) {
+	return models.InsertP2PMessage(msg)
+}
+
+func (mongoMessageStore) List(users []string, unreadOnly bool, limit int64) ([]models.P2PMessage, error) {
+	return models.ListP2PMessages(users, unreadOnly, limit)
+}
+
+func (mongoMessageStore) MarkRead(users []string, ids []primitive.ObjectID) (int64, error) {
+	return models.MarkP2PMessagesRead(users, ids)
+}
+
+func (mongoMessageStore) UserExists(name string) (bool, error) {
+	return models.UserExists(name)
+}
+
+// P2PInbox 收发聊天频道的消息，收到的消息按接收用户保存并推送给订阅者
+type P2PInbox struct {
+	client  *EnhancedUdpClient
+	store   P2PMessageStore
+	limiter *utils.KeyedLimiter // 按对方节点限制收到的消息数
+
+	mu          sync.Mutex
+	subscribers map[*inboxSubscriber]struct{}
+}
+
+type inboxSubscriber struct {
+	caller Caller
+	ch     chan models.P2PMessage
+	closed bool
+}
+
+// NewP2PInbox 创建收件箱，处理对方在聊天和控制频道上的流，以及携带信封的数据报
+func NewP2PInbox(client *EnhancedUdpClient, store P2PMessageStore) *P2PInbox {
+	m := &P2PInbox{
+		client:      client,
+		store:       store,
+		limiter:     utils.NewKeyedLimiter(inboxPeerRate, inboxPeerBurst),
+		subscribers: make(map[*inboxSubscriber]struct{}),
+	}
+	client.HandleChannel(P2PChannelChat, m.handleChat)
+	client.HandleChannel(P2PChannelControl, m.handleControl)
+	client.SetMessageHandler(m.handleDatagram)
+	return m
+}
+
+// Send 把文本消息可靠地发送给对方节点上的 toUser（为空时由管理员接收），对方保存后返回
+func (m *P2PInbox) Send(caller Caller, peerKey, toUser, text string) (*P2PEnvelope, error) {
+	if text == "" || len(text) > P2PMaxChatMessage {
+		return nil, ErrInvalidP2PMessage
+	}
+	env, err := newP2PEnvelope(P2PChannelChat, "text", text)
+	if err != nil {
+		return nil, err
+	}
+	env.From = caller.Name
+	env.To = toUser
+
+	stream, err := m.client.OpenChannel(peerKey, env)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	reply, err := readP2PEnvelope(stream)
+	if err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	if reply.Type != "ack" || reply.ID != env.ID {
+		return nil, fmt.Errorf("对方没有接收消息: %s", reply.Text())
+	}
+	return env, nil
+}
+
+// Inbox 列出当前用户收到的消息，管理员同时看到没有指定接收用户的消息
+func (m *P2PInbox) Inbox(caller Caller, unreadOnly bool, limit int64) ([]models.P2PMessage, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	return m.store.List(inboxUsers(caller), unreadOnly, limit)
+}
+
+// MarkRead 把消息标记为已读，ids 为空时标记全部
+func (m *P2PInbox) MarkRead(caller Caller, ids []string) (int64, error) {
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return 0, fmt.Errorf("%w: ID %s", ErrInvalidP2PMessage, id)
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+	return m.store.MarkRead(inboxUsers(caller), objectIDs)
+}
+
+// Subscribe 订阅当前用户之后收到的消息，返回的函数用于取消订阅
+func (m *P2PInbox) Subscribe(caller Caller) (<-chan models.P2PMessage, func()) {
+	sub := &inboxSubscriber{caller: caller, ch: make(chan models.P2PMessage, inboxSubscriberBuffer)}
+	m.mu.Lock()
+	m.subscribers[sub] = struct{}{}
+	m.mu.Unlock()
+	return sub.ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.removeLocked(sub)
+	}
+}
+
+func (m *P2PInbox) removeLocked(sub *inboxSubscriber) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	delete(m.subscribers, sub)
+}
+
+// publish 推送新消息，消费过慢的连接被断开，重连后可以从收件箱补齐
+func (m *P2PInbox) publish(msg models.P2PMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sub := range m.subscribers {
+		if !inboxVisibleTo(msg, sub.caller) {
+			continue
+		}
+		select {
+		case sub.ch <- msg:
+		default:
+			m.removeLocked(sub)
+		}
+	}
+}
+
+// receive 保存对方发来的聊天消息，重发的消息不会重复保存和推送。
+// 每个对方节点的消息数受限，指定的接收用户必须存在，没有指定时只有管理员能看到
+func (m *P2PInbox) receive(peerKey string, env *P2PEnvelope) error {
+	if !m.limiter.Allow(peerKey) {
+		return ErrP2PMessageRateLimited
+	}
+	if env.Channel != P2PChannelChat || env.ID == "" || len(env.Payload) == 0 || len(env.Payload) > P2PMaxChatMessage {
+		return ErrInvalidP2PMessage
+	}
+	if env.To != "" {
+		exists, err := m.store.UserExists(env.To)
+		if err != nil {
+			logger.Errorf("查询P2P消息的接收用户失败 %s: %v", env.To, err)
+			return errors.New("保存消息失败")
+		}
+		if !exists {
+			return fmt.Errorf("%w: 接收用户 %s 不存在", ErrInvalidP2PMessage, env.To)
+		}
+	}
+	msg := &models.P2PMessage{
+		MessageID:  env.ID,
+		User:       env.To,
+		PeerKey:    peerKey,
+		FromUser:   env.From,
+		Channel:    env.Channel,
+		Type:       env.Type,
+		Payload:    env.Text(),
+		ReceivedAt: time.Now(),
+	}
+	inserted, err := m.store.Insert(msg)
+	if err != nil {
+		logger.Errorf("保存P2P消息失败 %s/%s: %v", peerKey, env.ID, err)
+		return errors.New("保存消息失败")
+	}
+	if inserted {
+		m.publish(*msg)
+	}
+	return nil
+}
+
+// handleChat 保存消息后在控制频道上确认
+func (m *P2PInbox) handleChat(stream *P2PStream, env *P2PEnvelope) {
+	defer stream.Close()
+	if err := m.receive(stream.RemoteKey(), env); err != nil {
+		writeP2PEnvelope(stream, p2pControlReply("error", env.ID, err.Error()))
+		return
+	}
+	writeP2PEnvelope(stream, p2pControlReply("ack", env.ID, ""))
+}
+
+// handleControl 回复对方的心跳，其他控制消息只在回复中出现
+func (m *P2PInbox) handleControl(stream *P2PStream, env *P2PEnvelope) {
+	defer stream.Close()
+	if env.Type == "ping" {
+		writeP2PEnvelope(stream, p2pControlReply("pong", env.ID, ""))
+		return
+	}
+	writeP2PEnvelope(stream, p2pControlReply("error", env.ID, "不支持的控制消息: "+env.Type))
+}
+
+// handleDatagram 数据报不可靠，携带聊天信封时保存但不确认，其他数据只记录长度
+func (m *P2PInbox) handleDatagram(fromKey string, data []byte) {
+	env, err := decodeP2PDatagram(data)
+	if err != nil {
+		logger.Debugf("收到 %s 的P2P数据 %d 字节", fromKey, len(data))
+		return
+	}
+	if err := m.receive(fromKey, env); err != nil {
+		log.Printf("丢弃 %s 的P2P消息 %s: %v", fromKey, env.ID, err)
+	}
+}
+
+// inboxUsers 收件箱查询的接收用户，管理员包括没有指定用户的消息
+func inboxUsers(caller Caller) []string {
+	if caller.IsAdmin() {
+		return []string{caller.Name, ""}
+	}
+	return []string{caller.Name}
+}
+
+func inboxVisibleTo(msg models.P2PMessage, caller Caller) bool {
+	if msg.User != "" {
+		return msg.User == caller.Name
+	}
+	return caller.IsAdmin()
+}
+
+// GlobalP2PInbox 全局P2P收件箱
+var GlobalP2PInbox *P2PInbox
+
+// InitP2PInbox 在P2P客户端上启用消息收发
+func InitP2PInbox(client *EnhancedUdpClient) {
+	GlobalP2PInbox = NewP2PInbox(client, MongoMessageStore)
+}
+
+// GetP2PInbox 获取全局P2P收件箱，P2P客户端未初始化时为 nil
+func GetP2PInbox() *P2PInbox {
+	return GlobalP2PInbox
+}
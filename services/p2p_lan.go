@@ -0,0 +1,211 @@
+package services
+
+import (
+	"GoFileShare/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	p2pLANService        = "GoFileShare P2P 1"
+	p2pLANDefaultGroup   = "239.255.70.83:9877"
+	p2pLANAnnounceMinGap = time.Second // 发现新节点时立即公告的最小间隔
+)
+
+// LANPeer 通过局域网组播发现的节点，公告没有经过认证，只用来确定对方地址，身份仍由握手验证
+type LANPeer struct {
+	Key       string    `json:"key"`
+	PublicKey string    `json:"public_key"`
+	Addr      string    `json:"addr"`
+	LastSeen  time.Time `json:"last_seen"`
+	addr      *net.UDPAddr
+}
+
+// lanAnnouncement 组播公告，从P2P套接字发出，Port 为接收握手的端口
+type lanAnnouncement struct {
+	Service   string `json:"service"`
+	Key       string `json:"key"`
+	PublicKey string `json:"public_key"`
+	Port      int    `json:"port"`
+}
+
+// lanDiscovery 局域网发现的状态，peers 由 c.mutex 保护
+type lanDiscovery struct {
+	group        *net.UDPAddr
+	listener     *net.UDPConn
+	interval     time.Duration
+	peers        map[string]*LANPeer
+	lastAnnounce time.Time
+}
+
+// startLANDiscovery 按 P2P_LAN_DISCOVERY（组播地址，none 表示关闭）和 P2P_LAN_INTERVAL 启动局域网发现，
+// 加入组播组失败时只记录日志，客户端仍可通过信令服务器连接
+func (c *EnhancedUdpClient) startLANDiscovery() error {
+	groupAddr := utils.GetEnv("P2P_LAN_DISCOVERY", p2pLANDefaultGroup)
+	if groupAddr == "none" {
+		return nil
+	}
+	group, err := net.ResolveUDPAddr("udp4", groupAddr)
+	if err != nil || !group.IP.IsMulticast() {
+		return fmt.Errorf("无效的 P2P_LAN_DISCOVERY: %s", groupAddr)
+	}
+	interval, err := clusterDuration("P2P_LAN_INTERVAL", "10s")
+	if err != nil {
+		return err
+	}
+	listener, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		log.Printf("加入局域网组播组 %s 失败，不使用局域网发现: %v", group, err)
+		return nil
+	}
+	c.lan = &lanDiscovery{
+		group:    group,
+		listener: listener,
+		interval: interval,
+		peers:    make(map[string]*LANPeer),
+	}
+	go c.lanReceiver()
+	go c.lanAnnouncer()
+	return nil
+}
+
+// lanAnnouncer 定期公告本节点，过期的节点在这里清理
+func (c *EnhancedUdpClient) lanAnnouncer() {
+	ticker := time.NewTicker(c.lan.interval)
+	defer ticker.Stop()
+	c.announceLAN()
+	for range ticker.C {
+		if !c.running.Load() {
+			return
+		}
+		c.announceLAN()
+		c.mutex.Lock()
+		for key, peer := range c.lan.peers {
+			if time.Since(peer.LastSeen) > 3*c.lan.interval {
+				delete(c.lan.peers, key)
+			}
+		}
+		c.mutex.Unlock()
+	}
+}
+
+func (c *EnhancedUdpClient) announceLAN() {
+	data, err := json.Marshal(lanAnnouncement{
+		Service:   p2pLANService,
+		Key:       c.clientKey,
+		PublicKey: c.identity.PublicKey(),
+		Port:      c.localPort,
+	})
+	if err != nil {
+		return
+	}
+	c.mutex.Lock()
+	c.lan.lastAnnounce = time.Now()
+	c.mutex.Unlock()
+	c.conn.WriteToUDP(data, c.lan.group)
+}
+
+// lanReceiver 读取组播公告，本节点自己的公告和其他服务的数据被忽略
+func (c *EnhancedUdpClient) lanReceiver() {
+	buffer := make([]byte, 2048)
+	for c.running.Load() {
+		n, addr, err := c.lan.listener.ReadFromUDP(buffer)
+		if err != nil {
+			if !c.running.Load() {
+				return
+			}
+			continue
+		}
+		var announcement lanAnnouncement
+		if json.Unmarshal(buffer[:n], &announcement) != nil || announcement.Service != p2pLANService {
+			continue
+		}
+		if announcement.Key == "" || announcement.Key == c.clientKey || announcement.Port <= 0 || announcement.Port > 65535 {
+			continue
+		}
+		peerAddr := &net.UDPAddr{IP: addr.IP, Port: announcement.Port}
+
+		c.mutex.Lock()
+		peer, known := c.lan.peers[announcement.Key]
+		changed := !known || !sameUDPAddr(peer.addr, peerAddr)
+		if !known {
+			peer = &LANPeer{Key: announcement.Key}
+			c.lan.peers[announcement.Key] = peer
+		}
+		peer.PublicKey = announcement.PublicKey
+		peer.Addr = peerAddr.String()
+		peer.LastSeen = time.Now()
+		peer.addr = peerAddr
+		announceNow := !known && time.Since(c.lan.lastAnnounce) >= p2pLANAnnounceMinGap
+		c.mutex.Unlock()
+
+		if changed {
+			log.Printf("局域网发现节点 %s: %s", announcement.Key, peerAddr)
+		}
+		if announceNow {
+			// 让新加入的节点尽快发现本节点
+			c.announceLAN()
+		}
+	}
+}
+
+// lanPeerLocked 返回仍然有效的局域网节点，调用时持有 c.mutex
+func (c *EnhancedUdpClient) lanPeerLocked(key string) *LANPeer {
+	if c.lan == nil {
+		return nil
+	}
+	peer, exists := c.lan.peers[key]
+	if !exists || time.Since(peer.LastSeen) > 3*c.lan.interval {
+		return nil
+	}
+	return peer
+}
+
+// lanAddr 返回在局域网中发现的对方地址，不在同一网段或没有发现时返回 nil
+func (c *EnhancedUdpClient) lanAddr(key string) *net.UDPAddr {
+	c.mutex.RLock()
+	peer := c.lanPeerLocked(key)
+	c.mutex.RUnlock()
+	if peer == nil || !inLocalSubnet(peer.addr.IP) {
+		return nil
+	}
+	return peer.addr
+}
+
+// LANPeers 返回局域网中发现的节点，按Key排序
+func (c *EnhancedUdpClient) LANPeers() []LANPeer {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	peers := make([]LANPeer, 0)
+	if c.lan == nil {
+		return peers
+	}
+	for key := range c.lan.peers {
+		if peer := c.lanPeerLocked(key); peer != nil {
+			peers = append(peers, *peer)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Key < peers[j].Key })
+	return peers
+}
+
+// inLocalSubnet 判断地址是否属于本机某个网卡所在的子网
+func inLocalSubnet(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
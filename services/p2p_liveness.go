@@ -0,0 +1,191 @@
+package services
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"time"
+)
+
+// P2P连接的状态
+const (
+	P2PStatePunching  = "punching"  // 正在打洞和握手
+	P2PStateConnected = "connected" // 握手完成，最近收到过对方的报文
+	P2PStateIdle      = "idle"      // 超过两个心跳间隔没有收到对方的报文，正在重新打洞
+	P2PStateLost      = "lost"      // 超时没有收到对方的报文，会话已删除
+)
+
+// setState 切换连接状态，调用时持有 c.mutex
+func (conn *P2PConnection) setState(state string) {
+	if conn.State != state {
+		conn.State = state
+		conn.stateSince = time.Now()
+	}
+}
+
+// touchLocked 收到对方的加密报文，刷新最后活动时间，调用时持有 c.mutex
+func (c *EnhancedUdpClient) touchLocked(key string) {
+	conn, exists := c.p2pConnections[key]
+	if !exists || !conn.IsConnected {
+		return
+	}
+	conn.LastSeen = time.Now()
+	if conn.State == P2PStateIdle {
+		log.Printf("与 %s 的P2P连接已恢复", key)
+		conn.setState(P2PStateConnected)
+	}
+}
+
+// sendPong 原样带回心跳中的发送时间
+func (c *EnhancedUdpClient) sendPong(session *p2pSession, payload []byte) {
+	if len(payload) != 8 {
+		return
+	}
+	if packet, err := session.seal(p2pInnerPong, payload); err == nil {
+		c.writeTo(packet, session.addr)
+	}
+}
+
+// handlePong 根据心跳回复计算往返时间，按 7/8 旧值加 1/8 新样本平滑
+func (c *EnhancedUdpClient) handlePong(key string, payload []byte) {
+	if len(payload) != 8 {
+		return
+	}
+	sample := time.Since(time.Unix(0, int64(binary.BigEndian.Uint64(payload))))
+	if sample < 0 || sample > c.peerTimeout {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	conn, exists := c.p2pConnections[key]
+	if !exists {
+		return
+	}
+	if conn.rtt == 0 {
+		conn.rtt = sample
+	} else {
+		conn.rtt = (7*conn.rtt + sample) / 8
+	}
+	conn.RTTMs = float64(conn.rtt.Microseconds()) / 1000
+}
+
+// maintainConnections 定期发送心跳保持NAT映射，长时间没有回应的连接先重新打洞，超时后断开；
+// 同时定期重新注册，避免信令服务器因超时移除本客户端
+func (c *EnhancedUdpClient) maintainConnections() {
+	interval := c.keepalive / 4
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !c.running.Load() {
+			return
+		}
+		c.checkConnections(time.Now())
+	}
+}
+
+func (c *EnhancedUdpClient) checkConnections(now time.Time) {
+	var pings []*p2pSession
+	var repunch []string
+	var lost []*net.UDPAddr
+
+	c.mutex.Lock()
+	for key, conn := range c.p2pConnections {
+		if !conn.IsConnected {
+			// 被通知方等待的握手一直没有完成
+			if conn.State == P2PStatePunching && now.Sub(conn.stateSince) > 2*p2pPunchTimeout {
+				conn.setState(P2PStateLost)
+			}
+			continue
+		}
+		silence := now.Sub(conn.LastSeen)
+		switch {
+		case silence >= c.peerTimeout:
+			log.Printf("与 %s 的P2P连接超时（%s 没有收到报文），连接已断开", key, silence.Round(time.Second))
+			conn.IsConnected = false
+			conn.setState(P2PStateLost)
+			c.dropSessionsLocked(key)
+			lost = append(lost, conn.remoteAddr)
+			continue
+		case silence >= 2*c.keepalive:
+			if conn.State == P2PStateConnected {
+				log.Printf("与 %s 的P2P连接 %s 没有收到报文，重新打洞", key, silence.Round(time.Second))
+				conn.setState(P2PStateIdle)
+			}
+			if !conn.repunching && now.Sub(conn.repunchedAt) >= c.keepalive {
+				conn.repunching = true
+				conn.repunchedAt = now
+				repunch = append(repunch, key)
+			}
+		}
+		if now.Sub(conn.lastPing) >= c.keepalive {
+			if session := c.peerSessions[key]; session != nil {
+				conn.lastPing = now
+				pings = append(pings, session)
+			}
+		}
+	}
+	refresh := !c.registeredAt.IsZero() && now.Sub(c.registeredAt) >= c.registerEvery
+	if refresh {
+		// 刷新完成前不重复发起
+		c.registeredAt = now
+	}
+	c.mutex.Unlock()
+
+	for _, addr := range lost {
+		c.streams.abort(addr, ErrStreamTimeout)
+	}
+	var stamp [8]byte
+	binary.BigEndian.PutUint64(stamp[:], uint64(now.UnixNano()))
+	for _, session := range pings {
+		if packet, err := session.seal(p2pInnerPing, stamp[:]); err == nil {
+			c.writeTo(packet, session.addr)
+		}
+	}
+	for _, key := range repunch {
+		go c.repunch(key)
+	}
+	if refresh {
+		go c.refreshRegistration()
+	}
+}
+
+// dropSessionsLocked 删除与对方的所有会话，之后收到的旧会话报文直接丢弃，调用时持有 c.mutex
+func (c *EnhancedUdpClient) dropSessionsLocked(key string) {
+	delete(c.peerSessions, key)
+	for index, session := range c.sessions {
+		if session.peerKey == key {
+			delete(c.sessions, index)
+		}
+	}
+}
+
+// repunch 通过信令服务器重新打洞并握手，对方的NAT映射变化后连接可以恢复
+func (c *EnhancedUdpClient) repunch(key string) {
+	err := c.ConnectToPeer(key)
+	c.mutex.Lock()
+	if conn, exists := c.p2pConnections[key]; exists {
+		conn.repunching = false
+	}
+	c.mutex.Unlock()
+	if err != nil {
+		log.Printf("重新打洞 %s 失败: %v", key, err)
+	}
+}
+
+// refreshRegistration 重新注册到信令服务器，外部地址变化时记录日志
+func (c *EnhancedUdpClient) refreshRegistration() {
+	c.mutex.RLock()
+	oldIP, oldPort := c.externalIP, c.externalPort
+	c.mutex.RUnlock()
+	response, err := c.register()
+	if err != nil {
+		log.Printf("重新注册失败: %v", err)
+		return
+	}
+	if response.ExternalIP != oldIP || response.ExternalPort != oldPort {
+		log.Printf("外部地址已变化: %s:%d -> %s:%d", oldIP, oldPort, response.ExternalIP, response.ExternalPort)
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// P2P消息的频道，同一个连接上的流按频道分发
+const (
+	P2PChannelChat    = "chat"    // 用户之间的聊天消息，保存到接收用户的收件箱
+	P2PChannelControl = "control" // 确认、错误、心跳等控制消息
+	P2PChannelFile    = "file"    // 文件提议、传输控制和分块数据
+)
+
+// p2pMaxFrame 流上每个信封的最大长度
+const p2pMaxFrame = 4 << 20
+
+// P2PEnvelope P2P消息的信封。流上的每一帧都是一个信封，打开流时的第一个信封决定由哪个频道处理；
+// 通过 SendP2PMessage 发送的数据报也可以是一个信封
+type P2PEnvelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Channel string          `json:"channel"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	From    string          `json:"from,omitempty"` // 发送用户
+	To      string          `json:"to,omitempty"`   // 接收用户，为空时由管理员处理
+}
+
+// P2PChannelHandler 处理对方在某个频道上打开的流，env 为流上的第一个信封，处理完后负责关闭流
+type P2PChannelHandler func(stream *P2PStream, env *P2PEnvelope)
+
+// newP2PEnvelope 创建带新ID的信封，payload 按JSON编码
+func newP2PEnvelope(channel, msgType string, payload interface{}) (*P2PEnvelope, error) {
+	env := &P2PEnvelope{Type: msgType, ID: newP2PID(), Channel: channel}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		env.Payload = data
+	}
+	return env, nil
+}
+
+// p2pControlReply 控制频道上对 id 的回复
+func p2pControlReply(msgType, id, message string) *P2PEnvelope {
+	env := &P2PEnvelope{Type: msgType, ID: id, Channel: P2PChannelControl}
+	if message != "" {
+		env.Payload, _ = json.Marshal(message)
+	}
+	return env
+}
+
+// Text 把字符串负载解码为文本，负载不是JSON字符串时返回原始JSON
+func (e *P2PEnvelope) Text() string {
+	var text string
+	if json.Unmarshal(e.Payload, &text) == nil {
+		return text
+	}
+	return string(e.Payload)
+}
+
+// writeP2PEnvelope 写入4字节长度加JSON
+func writeP2PEnvelope(w io.Writer, env *P2PEnvelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err = w.Write(frame)
+	return err
+}
+
+func readP2PEnvelope(r io.Reader) (*P2PEnvelope, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > p2pMaxFrame {
+		return nil, fmt.Errorf("消息过长: %d", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return decodeP2PDatagram(data)
+}
+
+// decodeP2PDatagram 解析一个信封，流上的帧和数据报的内容相同
+func decodeP2PDatagram(data []byte) (*P2PEnvelope, error) {
+	var env P2PEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	if env.Channel == "" || env.Type == "" {
+		return nil, errors.New("消息缺少频道或类型")
+	}
+	return &env, nil
+}
+
+// SendEnvelope 把信封作为一个加密数据报发送，不保证送达，适合可以丢失的小消息
+func (c *EnhancedUdpClient) SendEnvelope(peerKey string, env *P2PEnvelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if !c.isConnected(peerKey) {
+		return fmt.Errorf("与 %s 的P2P连接不存在", peerKey)
+	}
+	return c.sendSecure(peerKey, p2pInnerData, data)
+}
+
+// HandleChannel 注册频道的处理函数，第一次注册时开始分发对方打开的流
+func (c *EnhancedUdpClient) HandleChannel(channel string, handler P2PChannelHandler) {
+	c.mutex.Lock()
+	c.channels[channel] = handler
+	c.mutex.Unlock()
+	c.dispatchOnce.Do(func() {
+		go c.dispatchStreams()
+	})
+}
+
+func (c *EnhancedUdpClient) dispatchStreams() {
+	for {
+		stream, err := c.AcceptStream()
+		if err != nil {
+			return
+		}
+		go c.dispatchStream(stream)
+	}
+}
+
+// dispatchStream 读取流上的第一个信封，交给对应频道处理
+func (c *EnhancedUdpClient) dispatchStream(stream *P2PStream) {
+	env, err := readP2PEnvelope(stream)
+	if err != nil {
+		stream.Reset()
+		return
+	}
+	c.mutex.RLock()
+	handler := c.channels[env.Channel]
+	c.mutex.RUnlock()
+	if handler == nil {
+		writeP2PEnvelope(stream, p2pControlReply("error", env.ID, "不支持的频道: "+env.Channel))
+		stream.Close()
+		return
+	}
+	handler(stream, env)
+}
+
+// ensureConnected 与对方还没有连接时先建立连接，同一时间只建立一个
+func (c *EnhancedUdpClient) ensureConnected(peerKey string) error {
+	if c.isConnected(peerKey) {
+		return nil
+	}
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	if c.isConnected(peerKey) {
+		return nil
+	}
+	return c.ConnectToPeer(peerKey)
+}
+
+// OpenChannel 打开到对方的流并发送第一个信封，必要时先建立P2P连接
+func (c *EnhancedUdpClient) OpenChannel(peerKey string, env *P2PEnvelope) (*P2PStream, error) {
+	if err := c.ensureConnected(peerKey); err != nil {
+		return nil, err
+	}
+	stream, err := c.OpenStream(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeP2PEnvelope(stream, env); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	return stream, nil
+}
@@ -0,0 +1,994 @@
+package services
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// P2P握手和加密传输报文，首字节与STUN（前两位为0）、可靠流（0xFF）和信令JSON都不冲突
+const (
+	p2pHandshakeMagic byte = 0xFE
+	p2pTransportMagic byte = 0xFD
+
+	p2pHandshakeProbe    byte = 0 // 被通知方发出的打洞包，只用于打开NAT映射
+	p2pHandshakeInit     byte = 1 // -> e
+	p2pHandshakeResponse byte = 2 // <- e, ee, s, es
+	p2pHandshakeFinal    byte = 3 // -> s, se
+
+	// 加密传输报文内的类型
+	p2pInnerKeepalive byte = 0 // 空报文，响应方用它确认握手完成
+	p2pInnerData      byte = 1 // SendP2PMessage 发送的消息
+	p2pInnerStream    byte = 2 // 可靠流报文
+	p2pInnerPing      byte = 3 // 心跳，携带发送时间
+	p2pInnerPong      byte = 4 // 心跳回复，原样带回发送时间
+
+	p2pTransportHeader = 1 + 4 + 8 // 类型、接收方会话编号、计数器
+	// P2PTransportOverhead 加密后每个数据报增加的字节数
+	P2PTransportOverhead = p2pTransportHeader + 1 + chacha20poly1305.Overhead
+
+	p2pHandshakeSkew       = 2 * time.Minute // 握手中的时间戳与本地时间允许的误差
+	p2pHandshakeLifetime   = 5 * time.Second // 未完成的握手保留时间
+	p2pMaxPendingHandshake = 256
+	p2pSessionGrace        = 2 * time.Minute // 被新会话替换后旧会话继续接收的时间
+	p2pMaxCounter          = 1 << 60
+	p2pReplayWindow        = 2048
+	p2pKeyNamePrefix       = "fileserver-"
+)
+
+// noiseProtocolName 恰好32字节，按 Noise 规范直接作为初始哈希
+const noiseProtocolName = "Noise_XX_25519_ChaChaPoly_SHA256"
+
+var noisePrologue = []byte("GoFileShare P2P 1")
+
+var (
+	ErrP2PUntrustedPeer    = errors.New("对方的身份公钥不受信任")
+	ErrP2PKeyMismatch      = errors.New("对方的身份公钥与记录的不一致")
+	ErrP2PHandshakeTimeout = errors.New("P2P握手超时")
+	errP2PHandshake        = errors.New("P2P握手报文无效")
+)
+
+// P2PIdentity 节点的长期身份密钥（X25519），用于握手时证明本节点的客户端Key
+type P2PIdentity struct {
+	private *ecdh.PrivateKey
+}
+
+// GenerateP2PIdentity 生成新的身份密钥
+func GenerateP2PIdentity() (*P2PIdentity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &P2PIdentity{private: key}, nil
+}
+
+// LoadP2PIdentity 从文件读取 base64 编码的私钥，文件不存在时生成并保存（权限 0600）
+func LoadP2PIdentity(path string) (*P2PIdentity, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		identity, err := GenerateP2PIdentity()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(identity.private.Bytes())
+		if err := os.WriteFile(path, []byte(encoded+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("保存身份密钥失败: %w", err)
+		}
+		return identity, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("身份密钥文件 %s 格式错误: %w", path, err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("身份密钥文件 %s 格式错误: %w", path, err)
+	}
+	return &P2PIdentity{private: key}, nil
+}
+
+// PublicKey base64 编码的公钥，对方在信任列表中记录的就是这个值
+func (id *P2PIdentity) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(id.private.PublicKey().Bytes())
+}
+
+// KeyName 由公钥派生的客户端Key，对方可以直接验证持有者，不需要事先交换公钥
+func (id *P2PIdentity) KeyName() string {
+	return P2PKeyName(id.private.PublicKey().Bytes())
+}
+
+// P2PKeyName 公钥 SHA-256 的前80位，加上 fileserver- 前缀
+func P2PKeyName(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	return p2pKeyNamePrefix + hex.EncodeToString(sum[:10])
+}
+
+// isDerivedKeyName 是否具有派生Key的格式，这种Key只能由对应的公钥使用
+func isDerivedKeyName(key string) bool {
+	suffix := strings.TrimPrefix(key, p2pKeyNamePrefix)
+	if suffix == key || len(suffix) != 20 {
+		return false
+	}
+	_, err := hex.DecodeString(suffix)
+	return err == nil
+}
+
+// P2PTrustStore 客户端Key与身份公钥的对应关系。记录过的Key必须使用相同的公钥；
+// 非严格模式下首次见到的Key会被记录（TOFU），派生格式的Key必须与公钥匹配；严格模式只接受记录过的Key
+type P2PTrustStore struct {
+	path   string
+	strict bool
+
+	mu    sync.Mutex
+	peers map[string]string
+}
+
+// LoadP2PTrustStore 读取信任列表，path 为空时只保存在内存中
+func LoadP2PTrustStore(path string, strict bool) (*P2PTrustStore, error) {
+	store := &P2PTrustStore{path: path, strict: strict, peers: make(map[string]string)}
+	if path == "" {
+		return store, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.peers); err != nil {
+		return nil, fmt.Errorf("信任列表 %s 格式错误: %w", path, err)
+	}
+	return store, nil
+}
+
+// Verify 检查对方声明的Key是否可以使用这个公钥
+func (t *P2PTrustStore) Verify(key string, publicKey []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(publicKey)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if pinned, ok := t.peers[key]; ok {
+		if pinned != encoded {
+			return ErrP2PKeyMismatch
+		}
+		return nil
+	}
+	if t.strict {
+		return ErrP2PUntrustedPeer
+	}
+	if isDerivedKeyName(key) && key != P2PKeyName(publicKey) {
+		return ErrP2PKeyMismatch
+	}
+	t.peers[key] = encoded
+	return t.saveLocked()
+}
+
+// Pinned 是否已记录该Key
+func (t *P2PTrustStore) Pinned(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.peers[key]
+	return ok
+}
+
+// Pin 记录或替换Key对应的公钥
+func (t *P2PTrustStore) Pin(key, publicKey string) error {
+	raw, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(raw) != 32 {
+		return fmt.Errorf("无效的公钥: %s", publicKey)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peers[key] = base64.StdEncoding.EncodeToString(raw)
+	return t.saveLocked()
+}
+
+// Unpin 删除记录，返回记录是否存在
+func (t *P2PTrustStore) Unpin(key string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.peers[key]; !ok {
+		return false, nil
+	}
+	delete(t.peers, key)
+	return true, t.saveLocked()
+}
+
+// List 按Key排序的记录
+func (t *P2PTrustStore) List() []map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := make([]string, 0, len(t.peers))
+	for key := range t.peers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]map[string]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, map[string]string{"key": key, "public_key": t.peers[key]})
+	}
+	return result
+}
+
+// Strict 是否只接受记录过的Key
+func (t *P2PTrustStore) Strict() bool {
+	return t.strict
+}
+
+func (t *P2PTrustStore) saveLocked() error {
+	if t.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(t.peers, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// noiseCipher Noise 规范中的 CipherState，握手阶段计数器从0递增
+type noiseCipher struct {
+	aead  cipher.AEAD
+	nonce uint64
+}
+
+func newNoiseCipher(key []byte) *noiseCipher {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		panic(err) // 密钥长度固定为32字节
+	}
+	return &noiseCipher{aead: aead}
+}
+
+func noiseNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// noiseHandshake XX 模式握手的 SymmetricState 和 HandshakeState
+type noiseHandshake struct {
+	initiator bool
+	static    *ecdh.PrivateKey
+	ephemeral *ecdh.PrivateKey
+	remoteE   *ecdh.PublicKey
+	remoteS   *ecdh.PublicKey
+
+	ck     [32]byte
+	h      [32]byte
+	cipher *noiseCipher
+}
+
+func newNoiseHandshake(identity *P2PIdentity, initiator bool) (*noiseHandshake, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hs := &noiseHandshake{initiator: initiator, static: identity.private, ephemeral: ephemeral}
+	copy(hs.h[:], noiseProtocolName)
+	hs.ck = hs.h
+	hs.mixHash(noisePrologue)
+	return hs, nil
+}
+
+// noiseHKDF 规范中的 HKDF，输出两个32字节的值
+func noiseHKDF(chainingKey, input []byte) ([32]byte, [32]byte) {
+	mac := hmac.New(sha256.New, chainingKey)
+	mac.Write(input)
+	temp := mac.Sum(nil)
+	var out1, out2 [32]byte
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{1})
+	copy(out1[:], mac.Sum(nil))
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1[:])
+	mac.Write([]byte{2})
+	copy(out2[:], mac.Sum(nil))
+	return out1, out2
+}
+
+func (hs *noiseHandshake) mixHash(data []byte) {
+	sum := sha256.New()
+	sum.Write(hs.h[:])
+	sum.Write(data)
+	copy(hs.h[:], sum.Sum(nil))
+}
+
+func (hs *noiseHandshake) mixKey(input []byte) {
+	var key [32]byte
+	hs.ck, key = noiseHKDF(hs.ck[:], input)
+	hs.cipher = newNoiseCipher(key[:])
+}
+
+func (hs *noiseHandshake) dh(private *ecdh.PrivateKey, public *ecdh.PublicKey) error {
+	shared, err := private.ECDH(public)
+	if err != nil {
+		return err
+	}
+	hs.mixKey(shared)
+	return nil
+}
+
+func (hs *noiseHandshake) encryptAndHash(plaintext []byte) []byte {
+	if hs.cipher == nil {
+		hs.mixHash(plaintext)
+		return append([]byte(nil), plaintext...)
+	}
+	ciphertext := hs.cipher.aead.Seal(nil, noiseNonce(hs.cipher.nonce), plaintext, hs.h[:])
+	hs.cipher.nonce++
+	hs.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (hs *noiseHandshake) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	if hs.cipher == nil {
+		hs.mixHash(ciphertext)
+		return append([]byte(nil), ciphertext...), nil
+	}
+	plaintext, err := hs.cipher.aead.Open(nil, noiseNonce(hs.cipher.nonce), ciphertext, hs.h[:])
+	if err != nil {
+		return nil, errP2PHandshake
+	}
+	hs.cipher.nonce++
+	hs.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// writeInit -> e
+func (hs *noiseHandshake) writeInit(payload []byte) []byte {
+	e := hs.ephemeral.PublicKey().Bytes()
+	hs.mixHash(e)
+	return append(append([]byte(nil), e...), hs.encryptAndHash(payload)...)
+}
+
+func (hs *noiseHandshake) readInit(message []byte) ([]byte, error) {
+	if len(message) < 32 {
+		return nil, errP2PHandshake
+	}
+	remoteE, err := ecdh.X25519().NewPublicKey(message[:32])
+	if err != nil {
+		return nil, errP2PHandshake
+	}
+	hs.remoteE = remoteE
+	hs.mixHash(message[:32])
+	return hs.decryptAndHash(message[32:])
+}
+
+// writeResponse <- e, ee, s, es
+func (hs *noiseHandshake) writeResponse(payload []byte) ([]byte, error) {
+	e := hs.ephemeral.PublicKey().Bytes()
+	hs.mixHash(e)
+	if err := hs.dh(hs.ephemeral, hs.remoteE); err != nil {
+		return nil, err
+	}
+	message := append([]byte(nil), e...)
+	message = append(message, hs.encryptAndHash(hs.static.PublicKey().Bytes())...)
+	if err := hs.dh(hs.static, hs.remoteE); err != nil {
+		return nil, err
+	}
+	return append(message, hs.encryptAndHash(payload)...), nil
+}
+
+func (hs *noiseHandshake) readResponse(message []byte) ([]byte, error) {
+	if len(message) < 32+32+chacha20poly1305.Overhead {
+		return nil, errP2PHandshake
+	}
+	remoteE, err := ecdh.X25519().NewPublicKey(message[:32])
+	if err != nil {
+		return nil, errP2PHandshake
+	}
+	hs.remoteE = remoteE
+	hs.mixHash(message[:32])
+	if err := hs.dh(hs.ephemeral, hs.remoteE); err != nil {
+		return nil, errP2PHandshake
+	}
+	static, err := hs.decryptAndHash(message[32 : 64+chacha20poly1305.Overhead])
+	if err != nil {
+		return nil, err
+	}
+	if hs.remoteS, err = ecdh.X25519().NewPublicKey(static); err != nil {
+		return nil, errP2PHandshake
+	}
+	if err := hs.dh(hs.ephemeral, hs.remoteS); err != nil {
+		return nil, errP2PHandshake
+	}
+	return hs.decryptAndHash(message[64+chacha20poly1305.Overhead:])
+}
+
+// writeFinal -> s, se
+func (hs *noiseHandshake) writeFinal(payload []byte) ([]byte, error) {
+	message := hs.encryptAndHash(hs.static.PublicKey().Bytes())
+	if err := hs.dh(hs.static, hs.remoteE); err != nil {
+		return nil, err
+	}
+	return append(message, hs.encryptAndHash(payload)...), nil
+}
+
+func (hs *noiseHandshake) readFinal(message []byte) ([]byte, error) {
+	if len(message) < 32+2*chacha20poly1305.Overhead {
+		return nil, errP2PHandshake
+	}
+	static, err := hs.decryptAndHash(message[:32+chacha20poly1305.Overhead])
+	if err != nil {
+		return nil, err
+	}
+	if hs.remoteS, err = ecdh.X25519().NewPublicKey(static); err != nil {
+		return nil, errP2PHandshake
+	}
+	if err := hs.dh(hs.ephemeral, hs.remoteS); err != nil {
+		return nil, errP2PHandshake
+	}
+	return hs.decryptAndHash(message[32+chacha20poly1305.Overhead:])
+}
+
+// split 握手完成后派生两个方向的传输密钥
+func (hs *noiseHandshake) split() (send, recv cipher.AEAD) {
+	k1, k2 := noiseHKDF(hs.ck[:], nil)
+	c1, c2 := newNoiseCipher(k1[:]), newNoiseCipher(k2[:])
+	if hs.initiator {
+		return c1.aead, c2.aead
+	}
+	return c2.aead, c1.aead
+}
+
+// replayWindow 记录最近收到的计数器，拒绝重复和过旧的报文
+type replayWindow struct {
+	highest uint64
+	bitmap  [p2pReplayWindow / 64]uint64
+}
+
+func (w *replayWindow) check(counter uint64) bool {
+	if counter == 0 {
+		return false
+	}
+	if counter > w.highest {
+		return true
+	}
+	if w.highest-counter >= p2pReplayWindow {
+		return false
+	}
+	bit := counter % p2pReplayWindow
+	return w.bitmap[bit/64]&(1<<(bit%64)) == 0
+}
+
+// mark 在报文通过认证后调用
+func (w *replayWindow) mark(counter uint64) {
+	if counter > w.highest {
+		if counter-w.highest >= p2pReplayWindow {
+			w.bitmap = [p2pReplayWindow / 64]uint64{}
+		} else {
+			for c := w.highest + 1; c <= counter; c++ {
+				bit := c % p2pReplayWindow
+				w.bitmap[bit/64] &^= 1 << (bit % 64)
+			}
+		}
+		w.highest = counter
+	}
+	bit := counter % p2pReplayWindow
+	w.bitmap[bit/64] |= 1 << (bit % 64)
+}
+
+// p2pSession 握手完成后的加密会话，报文头中的会话编号由接收方分配
+type p2pSession struct {
+	localIndex  uint32
+	remoteIndex uint32
+	peerKey     string
+	peerStatic  []byte
+	addr        *net.UDPAddr
+	createdAt   time.Time
+	installed   bool // 已成为向对方发送时使用的会话，受 EnhancedUdpClient.mutex 保护
+
+	send cipher.AEAD
+	recv cipher.AEAD
+
+	mu        sync.Mutex
+	counter   uint64
+	replay    replayWindow
+	confirmed bool // 收到过对方的加密报文，说明对方也完成了握手
+}
+
+// seal 加密一个报文，报文头作为附加数据
+func (s *p2pSession) seal(inner byte, payload []byte) ([]byte, error) {
+	s.mu.Lock()
+	s.counter++
+	counter := s.counter
+	s.mu.Unlock()
+	if counter >= p2pMaxCounter {
+		return nil, errors.New("P2P会话的计数器已用完，需要重新握手")
+	}
+	packet := make([]byte, p2pTransportHeader, P2PTransportOverhead+len(payload))
+	packet[0] = p2pTransportMagic
+	binary.BigEndian.PutUint32(packet[1:], s.remoteIndex)
+	binary.BigEndian.PutUint64(packet[5:], counter)
+	plaintext := make([]byte, 0, 1+len(payload))
+	plaintext = append(append(plaintext, inner), payload...)
+	return s.send.Seal(packet, noiseNonce(counter), plaintext, packet[:p2pTransportHeader]), nil
+}
+
+// open 解密并检查重放，返回内层类型和数据
+func (s *p2pSession) open(packet []byte) (byte, []byte, error) {
+	counter := binary.BigEndian.Uint64(packet[5:])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.replay.check(counter) {
+		return 0, nil, errors.New("重放或过旧的P2P报文")
+	}
+	plaintext, err := s.recv.Open(nil, noiseNonce(counter), packet[p2pTransportHeader:], packet[:p2pTransportHeader])
+	if err != nil || len(plaintext) == 0 {
+		return 0, nil, errors.New("P2P报文认证失败")
+	}
+	s.replay.mark(counter)
+	s.confirmed = true
+	return plaintext[0], plaintext[1:], nil
+}
+
+// p2pPendingHandshake 进行中的握手
+type p2pPendingHandshake struct {
+	hs         *noiseHandshake
+	localIndex uint32
+	expires    time.Time
+
+	// 发起方
+	targetKey string
+	session   *p2pSession // 收到第二条消息后建立，对方确认前不用于发送
+	final     []byte      // 第三条消息，对方确认前重复发送
+	err       error       // 对方身份验证失败等原因，握手超时后返回
+
+	// 响应方
+	addr        *net.UDPAddr
+	remoteIndex uint32
+	ephemeral   string // 发起方的临时公钥，用于识别重传
+	response    []byte // 已发送的第二条消息，收到重传的第一条消息时重发
+}
+
+// handshakePayload 握手中携带的身份信息，沿用信令协议的数据包结构和时间戳
+func handshakePayload(key, targetKey string) []byte {
+	data, _ := json.Marshal(PacketData{Task: P2PTaskHandshake, Key: key, TargetKey: targetKey, Timestamp: time.Now().Unix()})
+	return data
+}
+
+// parseHandshakePayload 解析并检查时间戳，拒绝过期的握手
+func parseHandshakePayload(data []byte) (*PacketData, error) {
+	var payload PacketData
+	if err := json.Unmarshal(data, &payload); err != nil || payload.Task != P2PTaskHandshake {
+		return nil, errP2PHandshake
+	}
+	skew := time.Since(time.Unix(payload.Timestamp, 0))
+	if skew > p2pHandshakeSkew || skew < -p2pHandshakeSkew {
+		return nil, fmt.Errorf("握手时间戳相差 %s，可能是重放", skew.Round(time.Second))
+	}
+	return &payload, nil
+}
+
+// clone 复制握手状态，报文验证失败时不影响原来的状态
+func (hs *noiseHandshake) clone() *noiseHandshake {
+	copied := *hs
+	if hs.cipher != nil {
+		cipherCopy := *hs.cipher
+		copied.cipher = &cipherCopy
+	}
+	return &copied
+}
+
+// newIndexLocked 分配未使用的会话编号，调用时持有 c.mutex
+func (c *EnhancedUdpClient) newIndexLocked() uint32 {
+	for {
+		var buf [4]byte
+		rand.Read(buf[:])
+		index := binary.BigEndian.Uint32(buf[:])
+		_, pending := c.handshakes[index]
+		_, active := c.sessions[index]
+		if index != 0 && !pending && !active {
+			return index
+		}
+	}
+}
+
+// purgeHandshakesLocked 删除过期的握手和重放记录，调用时持有 c.mutex
+func (c *EnhancedUdpClient) purgeHandshakesLocked(now time.Time) {
+	for index, pending := range c.handshakes {
+		if !pending.hs.initiator && now.After(pending.expires) {
+			delete(c.handshakes, index)
+		}
+	}
+	for ephemeral, seen := range c.seenInits {
+		if now.Sub(seen) > p2pHandshakeSkew {
+			delete(c.seenInits, ephemeral)
+		}
+	}
+}
+
+// startHandshake 以发起方开始握手，返回第一条消息
+func (c *EnhancedUdpClient) startHandshake(targetKey string) (*p2pPendingHandshake, []byte, error) {
+	hs, err := newNoiseHandshake(c.identity, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	pending := &p2pPendingHandshake{hs: hs, localIndex: c.newIndexLocked(), targetKey: targetKey}
+	c.handshakes[pending.localIndex] = pending
+
+	packet := []byte{p2pHandshakeMagic, p2pHandshakeInit, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(packet[2:], pending.localIndex)
+	return pending, append(packet, hs.writeInit(handshakePayload("", ""))...), nil
+}
+
+// dropHandshake 发起方结束等待，已建立的会话保留
+func (c *EnhancedUdpClient) dropHandshake(index uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.handshakes, index)
+}
+
+// handleHandshake 处理握手报文
+func (c *EnhancedUdpClient) handleHandshake(data []byte, addr *net.UDPAddr) {
+	if len(data) < 2 {
+		return
+	}
+	body := data[2:]
+	switch data[1] {
+	case p2pHandshakeInit:
+		c.handleInit(body, addr)
+	case p2pHandshakeResponse:
+		c.handleResponse(body, addr)
+	case p2pHandshakeFinal:
+		c.handleFinal(body, addr)
+	}
+	// 打洞包只用于打开对方的NAT映射，不需要处理
+}
+
+// handleInit 响应方收到第一条消息，回复自己的临时公钥和加密的身份
+func (c *EnhancedUdpClient) handleInit(body []byte, addr *net.UDPAddr) {
+	if len(body) < 4+32 {
+		return
+	}
+	remoteIndex := binary.BigEndian.Uint32(body)
+	ephemeral := string(body[4:36])
+
+	c.mutex.Lock()
+	c.purgeHandshakesLocked(time.Now())
+	for _, pending := range c.handshakes {
+		if pending.ephemeral == ephemeral {
+			// 发起方重传了第一条消息
+			response := pending.response
+			same := sameUDPAddr(pending.addr, addr)
+			c.mutex.Unlock()
+			if same {
+				c.writeTo(response, addr)
+			}
+			return
+		}
+	}
+	_, replayed := c.seenInits[ephemeral]
+	full := len(c.handshakes) >= p2pMaxPendingHandshake
+	c.mutex.Unlock()
+	if replayed || full {
+		return
+	}
+
+	hs, err := newNoiseHandshake(c.identity, false)
+	if err != nil {
+		return
+	}
+	payload, err := hs.readInit(body[4:])
+	if err != nil {
+		return
+	}
+	if _, err := parseHandshakePayload(payload); err != nil {
+		log.Printf("丢弃来自 %s 的握手: %v", addr, err)
+		return
+	}
+	message, err := hs.writeResponse(handshakePayload(c.clientKey, ""))
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	pending := &p2pPendingHandshake{
+		hs:          hs,
+		localIndex:  c.newIndexLocked(),
+		expires:     time.Now().Add(p2pHandshakeLifetime),
+		addr:        addr,
+		remoteIndex: remoteIndex,
+		ephemeral:   ephemeral,
+	}
+	packet := []byte{p2pHandshakeMagic, p2pHandshakeResponse, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(packet[2:], pending.localIndex)
+	binary.BigEndian.PutUint32(packet[6:], remoteIndex)
+	pending.response = append(packet, message...)
+	c.handshakes[pending.localIndex] = pending
+	c.mutex.Unlock()
+	c.writeTo(pending.response, addr)
+}
+
+// handleResponse 发起方收到第二条消息，验证对方身份后发送自己的身份，建立会话
+func (c *EnhancedUdpClient) handleResponse(body []byte, addr *net.UDPAddr) {
+	if len(body) < 8 {
+		return
+	}
+	remoteIndex := binary.BigEndian.Uint32(body)
+	localIndex := binary.BigEndian.Uint32(body[4:])
+
+	c.mutex.Lock()
+	pending, ok := c.handshakes[localIndex]
+	if !ok || !pending.hs.initiator {
+		c.mutex.Unlock()
+		return
+	}
+	if pending.session != nil {
+		// 对方重发了第二条消息，说明第三条消息丢失
+		final, sessionAddr := pending.final, pending.session.addr
+		c.mutex.Unlock()
+		if sameUDPAddr(sessionAddr, addr) {
+			c.writeTo(final, addr)
+		}
+		return
+	}
+	if pending.err != nil {
+		c.mutex.Unlock()
+		return
+	}
+	hs := pending.hs.clone()
+	targetKey := pending.targetKey
+	c.mutex.Unlock()
+
+	data, err := hs.readResponse(body[8:])
+	if err != nil {
+		return
+	}
+	fail := func(err error) {
+		log.Printf("与 %s 握手失败: %v", targetKey, err)
+		c.mutex.Lock()
+		pending.err = err
+		c.mutex.Unlock()
+	}
+	payload, err := parseHandshakePayload(data)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if payload.Key != targetKey {
+		fail(fmt.Errorf("对方的Key为 %s，不是要连接的 %s", payload.Key, targetKey))
+		return
+	}
+	static := hs.remoteS.Bytes()
+	if err := c.trust.Verify(targetKey, static); err != nil {
+		fail(err)
+		return
+	}
+	message, err := hs.writeFinal(handshakePayload(c.clientKey, targetKey))
+	if err != nil {
+		return
+	}
+	packet := []byte{p2pHandshakeMagic, p2pHandshakeFinal, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(packet[2:], remoteIndex)
+	packet = append(packet, message...)
+
+	send, recv := hs.split()
+	session := &p2pSession{
+		localIndex:  localIndex,
+		remoteIndex: remoteIndex,
+		peerKey:     targetKey,
+		peerStatic:  static,
+		addr:        addr,
+		createdAt:   time.Now(),
+		send:        send,
+		recv:        recv,
+	}
+	c.mutex.Lock()
+	pending.hs = hs
+	pending.session = session
+	pending.final = packet
+	c.sessions[localIndex] = session
+	c.mutex.Unlock()
+	c.writeTo(packet, addr)
+}
+
+// handleFinal 响应方收到第三条消息，验证发起方身份后建立会话并回复加密的确认
+func (c *EnhancedUdpClient) handleFinal(body []byte, addr *net.UDPAddr) {
+	if len(body) < 4 {
+		return
+	}
+	localIndex := binary.BigEndian.Uint32(body)
+
+	c.mutex.Lock()
+	pending, ok := c.handshakes[localIndex]
+	if !ok {
+		session := c.sessions[localIndex]
+		c.mutex.Unlock()
+		if session != nil && sameUDPAddr(session.addr, addr) {
+			// 确认丢失，发起方重发了第三条消息
+			c.sendKeepalive(session)
+		}
+		return
+	}
+	if pending.hs.initiator || !sameUDPAddr(pending.addr, addr) {
+		c.mutex.Unlock()
+		return
+	}
+	hs := pending.hs.clone()
+	c.mutex.Unlock()
+
+	data, err := hs.readFinal(body[4:])
+	if err != nil {
+		return
+	}
+	// 第三条消息通过认证后，无论是否接受都不再处理这次握手的重传
+	reject := func(reason string) {
+		log.Printf("拒绝来自 %s 的握手: %s", addr, reason)
+		c.mutex.Lock()
+		delete(c.handshakes, localIndex)
+		c.seenInits[pending.ephemeral] = time.Now()
+		c.mutex.Unlock()
+	}
+	payload, err := parseHandshakePayload(data)
+	if err != nil {
+		reject(err.Error())
+		return
+	}
+	if payload.Key == "" || payload.TargetKey != c.clientKey {
+		reject("身份信息无效")
+		return
+	}
+	key := payload.Key
+	static := hs.remoteS.Bytes()
+
+	// 只接受通过信令服务器请求打洞的对方、在局域网中从这个地址公告过的对方，或者已经记录过身份的对方
+	c.mutex.RLock()
+	_, expected := c.p2pConnections[key]
+	if peer := c.lanPeerLocked(key); peer != nil && sameUDPAddr(peer.addr, addr) {
+		expected = true
+	}
+	c.mutex.RUnlock()
+	if !expected && !c.trust.Pinned(key) {
+		reject(key + " 没有对应的打洞请求")
+		return
+	}
+	if err := c.trust.Verify(key, static); err != nil {
+		reject(key + " " + err.Error())
+		return
+	}
+
+	send, recv := hs.split()
+	session := &p2pSession{
+		localIndex:  localIndex,
+		remoteIndex: pending.remoteIndex,
+		peerKey:     key,
+		peerStatic:  static,
+		addr:        addr,
+		createdAt:   time.Now(),
+		send:        send,
+		recv:        recv,
+	}
+	c.mutex.Lock()
+	delete(c.handshakes, localIndex)
+	c.seenInits[pending.ephemeral] = time.Now()
+	c.installSessionLocked(session)
+	c.mutex.Unlock()
+	c.sendKeepalive(session)
+}
+
+// installSessionLocked 把会话作为向对方发送时使用的会话，被替换的旧会话继续接收一段时间，调用时持有 c.mutex
+func (c *EnhancedUdpClient) installSessionLocked(session *p2pSession) {
+	session.installed = true
+	c.sessions[session.localIndex] = session
+	old := c.peerSessions[session.peerKey]
+	c.peerSessions[session.peerKey] = session
+	c.markConnected(session.peerKey, session.addr, session.peerStatic)
+	if old != nil && old != session {
+		time.AfterFunc(p2pSessionGrace, func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			if c.sessions[old.localIndex] == old {
+				delete(c.sessions, old.localIndex)
+			}
+		})
+	}
+}
+
+// handleTransport 解密对方发来的报文，按内层类型分发
+func (c *EnhancedUdpClient) handleTransport(data []byte, addr *net.UDPAddr) {
+	if len(data) < P2PTransportOverhead {
+		return
+	}
+	c.mutex.RLock()
+	session := c.sessions[binary.BigEndian.Uint32(data[1:])]
+	c.mutex.RUnlock()
+	if session == nil || !sameUDPAddr(session.addr, addr) {
+		return
+	}
+	inner, payload, err := session.open(data)
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	if !session.installed {
+		// 发起方收到第一个加密报文，对方已完成握手
+		c.installSessionLocked(session)
+	}
+	c.touchLocked(session.peerKey)
+	c.mutex.Unlock()
+
+	switch inner {
+	case p2pInnerPing:
+		c.sendPong(session, payload)
+	case p2pInnerPong:
+		c.handlePong(session.peerKey, payload)
+	case p2pInnerData:
+		c.handleP2PData(session.peerKey, payload)
+	case p2pInnerStream:
+		if isStreamPacket(payload) {
+			c.streams.handle(payload, addr)
+		}
+	}
+}
+
+// sendSecure 使用与对方的当前会话加密发送
+func (c *EnhancedUdpClient) sendSecure(targetKey string, inner byte, payload []byte) error {
+	c.mutex.RLock()
+	session := c.peerSessions[targetKey]
+	c.mutex.RUnlock()
+	if session == nil {
+		return fmt.Errorf("与 %s 没有加密会话", targetKey)
+	}
+	packet, err := session.seal(inner, payload)
+	if err != nil {
+		return err
+	}
+	err = c.writeTo(packet, session.addr)
+	return err
+}
+
+// sendStreamPacket 可靠流的发送函数，按地址找到会话后加密发送
+func (c *EnhancedUdpClient) sendStreamPacket(data []byte, to *net.UDPAddr) {
+	var session *p2pSession
+	c.mutex.RLock()
+	for _, candidate := range c.peerSessions {
+		if sameUDPAddr(candidate.addr, to) {
+			session = candidate
+			break
+		}
+	}
+	c.mutex.RUnlock()
+	if session == nil {
+		return
+	}
+	if packet, err := session.seal(p2pInnerStream, data); err == nil {
+		c.writeTo(packet, to)
+	}
+}
+
+func (c *EnhancedUdpClient) sendKeepalive(session *p2pSession) {
+	if packet, err := session.seal(p2pInnerKeepalive, nil); err == nil {
+		c.writeTo(packet, session.addr)
+	}
+}
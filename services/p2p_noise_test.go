@@ -0,0 +1,170 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+)
+
+// noiseTestHandshake 在内存中完成一次 XX 握手，返回双方建立的会话
+func noiseTestHandshake(t *testing.T) (*p2pSession, *p2pSession) {
+	t.Helper()
+	alice, err := GenerateP2PIdentity()
+	if err != nil {
+		t.Fatalf("生成身份密钥失败: %v", err)
+	}
+	bob, err := GenerateP2PIdentity()
+	if err != nil {
+		t.Fatalf("生成身份密钥失败: %v", err)
+	}
+	initiator, err := newNoiseHandshake(alice, true)
+	if err != nil {
+		t.Fatalf("创建握手失败: %v", err)
+	}
+	responder, err := newNoiseHandshake(bob, false)
+	if err != nil {
+		t.Fatalf("创建握手失败: %v", err)
+	}
+
+	first := initiator.writeInit([]byte("init"))
+	if payload, err := responder.readInit(first); err != nil || string(payload) != "init" {
+		t.Fatalf("读取第一条消息失败: %q %v", payload, err)
+	}
+	response, err := responder.writeResponse([]byte("response"))
+	if err != nil {
+		t.Fatalf("生成第二条消息失败: %v", err)
+	}
+
+	// 篡改过的第二条消息不能通过认证，也不影响原来的握手状态
+	tampered := append([]byte(nil), response...)
+	tampered[len(tampered)-1] ^= 0x01
+	if _, err := initiator.clone().readResponse(tampered); err == nil {
+		t.Fatal("篡改过的握手消息应被拒绝")
+	}
+	if payload, err := initiator.readResponse(response); err != nil || string(payload) != "response" {
+		t.Fatalf("读取第二条消息失败: %q %v", payload, err)
+	}
+	if !bytes.Equal(initiator.remoteS.Bytes(), bob.private.PublicKey().Bytes()) {
+		t.Fatal("发起方得到的对方公钥不一致")
+	}
+
+	final, err := initiator.writeFinal([]byte("final"))
+	if err != nil {
+		t.Fatalf("生成第三条消息失败: %v", err)
+	}
+	if payload, err := responder.readFinal(final); err != nil || string(payload) != "final" {
+		t.Fatalf("读取第三条消息失败: %q %v", payload, err)
+	}
+	if !bytes.Equal(responder.remoteS.Bytes(), alice.private.PublicKey().Bytes()) {
+		t.Fatal("响应方得到的对方公钥不一致")
+	}
+	if initiator.h != responder.h {
+		t.Fatal("握手结束后双方的哈希不一致")
+	}
+
+	// 会话编号由接收方分配，报文头中写的是对方的编号
+	initSend, initRecv := initiator.split()
+	respSend, respRecv := responder.split()
+	return &p2pSession{localIndex: 1, remoteIndex: 2, send: initSend, recv: initRecv},
+		&p2pSession{localIndex: 2, remoteIndex: 1, send: respSend, recv: respRecv}
+}
+
+func TestNoiseHandshakeRoundTrip(t *testing.T) {
+	alice, bob := noiseTestHandshake(t)
+	for _, tc := range []struct {
+		from, to *p2pSession
+		payload  string
+	}{
+		{alice, bob, "hello bob"},
+		{bob, alice, "hello alice"},
+		{alice, bob, ""},
+	} {
+		packet, err := tc.from.seal(p2pInnerData, []byte(tc.payload))
+		if err != nil {
+			t.Fatalf("加密失败: %v", err)
+		}
+		inner, payload, err := tc.to.open(packet)
+		if err != nil || inner != p2pInnerData || string(payload) != tc.payload {
+			t.Fatalf("解密结果为 %d %q %v，应为 %q", inner, payload, err, tc.payload)
+		}
+	}
+
+	// 同一方向的密钥不能解密自己发出的报文
+	packet, _ := alice.seal(p2pInnerData, []byte("loop"))
+	if _, _, err := alice.open(packet); err == nil {
+		t.Fatal("发送方不应能解密自己的报文")
+	}
+}
+
+func TestP2PSessionRejectsTamperedAndReplayed(t *testing.T) {
+	alice, bob := noiseTestHandshake(t)
+	packet, err := alice.seal(p2pInnerData, []byte("transfer"))
+	if err != nil {
+		t.Fatalf("加密失败: %v", err)
+	}
+
+	// 篡改密文或报文头（作为附加数据参与认证）都会被拒绝
+	for _, offset := range []int{len(packet) - 1, p2pTransportHeader, 1} {
+		tampered := append([]byte(nil), packet...)
+		tampered[offset] ^= 0x01
+		if _, _, err := bob.open(tampered); err == nil {
+			t.Fatalf("修改第 %d 字节后的报文应被拒绝", offset)
+		}
+	}
+
+	// 被拒绝的报文不占用计数器，原报文仍然可以接收一次
+	if _, payload, err := bob.open(packet); err != nil || string(payload) != "transfer" {
+		t.Fatalf("原报文应能解密: %q %v", payload, err)
+	}
+	if _, _, err := bob.open(packet); err == nil {
+		t.Fatal("重放的报文应被拒绝")
+	}
+}
+
+func TestReplayWindowEdges(t *testing.T) {
+	var w replayWindow
+	if w.check(0) {
+		t.Fatal("计数器从1开始，0 应被拒绝")
+	}
+	w.mark(1)
+	if w.check(1) || !w.check(2) {
+		t.Fatal("已收到的计数器应被拒绝，新的应被接受")
+	}
+
+	// 乱序到达：窗口内未收到的计数器仍可接受
+	w.mark(10)
+	for _, counter := range []uint64{2, 9} {
+		if !w.check(counter) {
+			t.Fatalf("窗口内未收到的 %d 应被接受", counter)
+		}
+	}
+	w.mark(5)
+	if w.check(5) {
+		t.Fatal("乱序收到的 5 重放时应被拒绝")
+	}
+
+	// 窗口滑动到 10+p2pReplayWindow 后，距最高值恰好一个窗口的 10 过旧，11 仍在窗口内；
+	// 与已收到的 5 同位的 5+p2pReplayWindow 不能被旧记录误判为重放
+	highest := uint64(10 + p2pReplayWindow)
+	w.mark(highest)
+	if w.check(10) {
+		t.Fatal("距最高值一个窗口的计数器应被拒绝")
+	}
+	if !w.check(11) {
+		t.Fatal("窗口最旧一端未收到的计数器应被接受")
+	}
+	if !w.check(5 + p2pReplayWindow) {
+		t.Fatal("窗口滑动后同位的旧记录应被清除")
+	}
+	if w.check(highest) {
+		t.Fatal("最高的计数器重放时应被拒绝")
+	}
+
+	// 一次跳过整个窗口后清空记录
+	w.mark(highest + 1 + p2pReplayWindow*3)
+	if !w.check(highest + 2 + p2pReplayWindow*2) {
+		t.Fatal("跳过整个窗口后，窗口内未收到的计数器应被接受")
+	}
+	if w.check(highest) {
+		t.Fatal("跳过整个窗口后，旧的计数器应被拒绝")
+	}
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+)
+
+// 中继报文：p2pRelayMagic | Key长度(1) | Key | 握手或加密传输报文。
+// 客户端发给服务器时 Key 是目标，服务器转发给目标时 Key 是发送方；中继的内容仍是端到端加密的
+const (
+	p2pRelayMagic  byte = 0xFC
+	p2pRelayMaxKey      = 64
+	// P2PRelayOverhead 中继时每个数据报最多增加的字节数
+	P2PRelayOverhead = 2 + p2pRelayMaxKey
+)
+
+// 连接方式
+const (
+	P2PModeDirect  = "direct"
+	P2PModeRelayed = "relayed"
+)
+
+// RelayAllocation 信令服务器为一对客户端分配的中继
+type RelayAllocation struct {
+	Status    string `json:"status"`
+	TargetKey string `json:"target_key,omitempty"`
+	Quota     int64  `json:"quota"` // 剩余可以中继的字节数
+	Rate      int64  `json:"rate"`  // 每秒允许中继的字节数，0 表示不限速
+}
+
+// encodeRelayFrame 组装中继报文，Key 超过长度上限时返回 nil
+func encodeRelayFrame(key string, payload []byte) []byte {
+	if key == "" || len(key) > p2pRelayMaxKey {
+		return nil
+	}
+	frame := make([]byte, 0, 2+len(key)+len(payload))
+	frame = append(frame, p2pRelayMagic, byte(len(key)))
+	frame = append(frame, key...)
+	return append(frame, payload...)
+}
+
+// decodeRelayFrame 拆分中继报文，payload 引用 data 的内存
+func decodeRelayFrame(data []byte) (string, []byte, bool) {
+	if len(data) < 2 || data[0] != p2pRelayMagic {
+		return "", nil, false
+	}
+	size := int(data[1])
+	if size == 0 || size > p2pRelayMaxKey || len(data) < 2+size+1 {
+		return "", nil, false
+	}
+	return string(data[2 : 2+size]), data[2+size:], true
+}
+
+// relayAddr 经信令服务器中继到对方时使用的地址：服务器地址加上对方Key作为Zone，
+// 会话、可靠流等按地址索引的状态因此不需要区分直连和中继
+func (c *EnhancedUdpClient) relayAddr(key string) *net.UDPAddr {
+	return &net.UDPAddr{IP: c.serverAddr.IP, Port: c.serverAddr.Port, Zone: key}
+}
+
+func (c *EnhancedUdpClient) isRelayAddr(addr *net.UDPAddr) bool {
+	return addr.Zone != "" && addr.IP.Equal(c.serverAddr.IP) && addr.Port == c.serverAddr.Port
+}
+
+// writeTo 向对方发送握手或加密传输报文，中继地址的报文封装后发给信令服务器
+func (c *EnhancedUdpClient) writeTo(packet []byte, addr *net.UDPAddr) error {
+	if c.isRelayAddr(addr) {
+		frame := encodeRelayFrame(addr.Zone, packet)
+		if frame == nil {
+			return fmt.Errorf("客户端Key超过 %d 字节，无法中继", p2pRelayMaxKey)
+		}
+		_, err := c.conn.WriteToUDP(frame, c.serverAddr)
+		return err
+	}
+	_, err := c.conn.WriteToUDP(packet, addr)
+	return err
+}
+
+// handleRelay 处理信令服务器转发的报文，只接受握手和加密传输报文
+func (c *EnhancedUdpClient) handleRelay(data []byte) {
+	fromKey, payload, ok := decodeRelayFrame(data)
+	if !ok {
+		return
+	}
+	switch payload[0] {
+	case p2pHandshakeMagic:
+		c.handleHandshake(payload, c.relayAddr(fromKey))
+	case p2pTransportMagic:
+		c.handleTransport(payload, c.relayAddr(fromKey))
+	}
+}
+
+// requestRelay 请求信令服务器为本客户端和目标分配中继
+func (c *EnhancedUdpClient) requestRelay(targetKey string) (*RelayAllocation, error) {
+	if len(c.clientKey) > p2pRelayMaxKey || len(targetKey) > p2pRelayMaxKey {
+		return nil, fmt.Errorf("客户端Key超过 %d 字节，无法中继", p2pRelayMaxKey)
+	}
+	data, err := c.request(PacketData{
+		Task:      P2PTaskRelay,
+		IP:        c.localIP,
+		Port:      strconv.Itoa(c.localPort),
+		TargetKey: targetKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var allocation RelayAllocation
+	if err := json.Unmarshal(data, &allocation); err != nil {
+		return nil, err
+	}
+	if allocation.Status != "relay_allocated" {
+		return nil, fmt.Errorf("信令服务器拒绝中继: %s", allocation.Status)
+	}
+	return &allocation, nil
+}
+
+// establishRelayedConnection 通过信令服务器中继与对方握手，用于打洞失败的情况
+func (c *EnhancedUdpClient) establishRelayedConnection(targetKey string) error {
+	allocation, err := c.requestRelay(targetKey)
+	if err != nil {
+		return err
+	}
+	log.Printf("信令服务器已分配到 %s 的中继，剩余配额 %d 字节", targetKey, allocation.Quota)
+	addr := c.relayAddr(targetKey)
+	return c.handshakeWith(targetKey, func(init []byte) {
+		c.writeTo(init, addr)
+	})
+}
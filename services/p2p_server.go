@@ -0,0 +1,753 @@
+package services
+
+import (
+	"GoFileShare/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// PeerRecord 信令服务器登记的一个客户端
+type PeerRecord struct {
+	Key          string    `json:"key"`
+	ExternalIP   string    `json:"external_ip"`
+	ExternalPort int       `json:"external_port"`
+	LocalIP      string    `json:"local_ip"`
+	LocalPort    int       `json:"local_port"`
+	NATType      string    `json:"nat_type"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// SignalingOptions 信令服务器配置
+type SignalingOptions struct {
+	PeerTTL   time.Duration     // 超过该时间没有请求的客户端被移除
+	RateLimit float64           // 每个来源IP每秒允许的请求数，<=0 不限流
+	RateBurst int               // 每个来源IP允许的突发请求数
+	Store     *utils.RESPClient // 可选，Redis 兼容的持久化存储，服务器重启后仍能查询到客户端
+	KeyPrefix string            // 存储中的键前缀
+
+	RelayQuota int64 // 每对客户端的中继在空闲过期前最多转发的字节数，<=0 不提供中继
+	RelayRate  int64 // 每对客户端每秒最多中继的字节数，<=0 不限速
+
+	// 同一来源可以登记任意多个Key组成新的配对，所以还按来源IP和整个服务器限制
+	RelayIPQuota        int64 // 每个来源IP在空闲过期前最多发送的中继字节数（所有配对合计），<=0 不限制
+	RelayIPAllocations  int   // 每个来源IP最多申请的中继配对数，<=0 不限制
+	RelayMaxAllocations int   // 服务器上的中继配对总数，<=0 不限制
+	RelayTotalRate      int64 // 服务器每秒最多中继的字节数，<=0 不限速
+}
+
+// RelayRecord 一对客户端之间的中继使用情况
+type RelayRecord struct {
+	Peers      [2]string `json:"peers"`
+	Used       int64     `json:"used"`
+	Quota      int64     `json:"quota"`
+	LastActive time.Time `json:"last_active"`
+}
+
+type relayAllocation struct {
+	peers      [2]string
+	owner      string // 申请中继的来源IP
+	used       int64
+	lastActive time.Time
+	exhausted  bool
+}
+
+// relayUsage 一个来源IP的中继使用情况
+type relayUsage struct {
+	used        int64
+	allocations int
+	lastActive  time.Time
+	exhausted   bool
+}
+
+// SignalingServer P2P 信令服务器，实现 EnhancedUdpClient 使用的 UDP 协议（任务1-4、6），
+// 打洞失败的客户端可以通过它中继报文
+type SignalingServer struct {
+	conn         *net.UDPConn
+	opts         SignalingOptions
+	limiter      *utils.KeyedLimiter
+	relayLimiter *utils.KeyedLimiter
+	relayTotal   *utils.KeyedLimiter
+
+	mu       sync.RWMutex
+	peers    map[string]*PeerRecord
+	byAddr   map[string]string // 外部地址到客户端Key，用于确认中继报文的发送方
+	relays   map[string]*relayAllocation
+	relayIPs map[string]*relayUsage
+
+	// 写入存储在后台进行，同一客户端未写入的记录只保留最新的一条；
+	// 内存中没有的客户端到存储中查找时同样不占用读循环
+	persistMu   sync.Mutex
+	pending     map[string]PeerRecord
+	persistWake chan struct{}
+	lookups     chan struct{}
+	storeDown   atomic.Int64 // 存储出错后暂停访问到该时间（UnixNano）
+
+	done      chan struct{}
+	closeOnce sync.Once
+	persisted chan struct{} // 后台写入结束
+}
+
+// 存储出错后暂停访问的时间、同时进行的查找数，以及回复查询前最多等待存储的时间
+const (
+	signalingStoreBackoff = 5 * time.Second
+	signalingStoreLookups = 16
+	signalingLookupWait   = time.Second
+)
+
+// NewSignalingServer 在 addr 上监听 UDP
+func NewSignalingServer(addr string, opts SignalingOptions) (*SignalingServer, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("解析监听地址失败: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("监听UDP失败: %w", err)
+	}
+	if opts.PeerTTL <= 0 {
+		opts.PeerTTL = 5 * time.Minute
+	}
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = "gfs:p2p:peer:"
+	}
+	// 与带宽限速一致，桶容量为一秒的流量，至少 64KB
+	relayBurst := opts.RelayRate
+	if relayBurst < 64*1024 {
+		relayBurst = 64 * 1024
+	}
+	totalBurst := opts.RelayTotalRate
+	if totalBurst < 64*1024 {
+		totalBurst = 64 * 1024
+	}
+	server := &SignalingServer{
+		conn:         conn,
+		opts:         opts,
+		limiter:      utils.NewKeyedLimiter(opts.RateLimit, opts.RateBurst),
+		relayLimiter: utils.NewKeyedLimiter(float64(opts.RelayRate), int(relayBurst)),
+		relayTotal:   utils.NewKeyedLimiter(float64(opts.RelayTotalRate), int(totalBurst)),
+		peers:        make(map[string]*PeerRecord),
+		byAddr:       make(map[string]string),
+		relays:       make(map[string]*relayAllocation),
+		relayIPs:     make(map[string]*relayUsage),
+		pending:      make(map[string]PeerRecord),
+		persistWake:  make(chan struct{}, 1),
+		lookups:      make(chan struct{}, signalingStoreLookups),
+		done:         make(chan struct{}),
+		persisted:    make(chan struct{}),
+	}
+	go server.persistLoop()
+	return server, nil
+}
+
+// Addr 实际监听的地址
+func (s *SignalingServer) Addr() *net.UDPAddr {
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Peers 当前登记的客户端
+func (s *SignalingServer) Peers() []PeerRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	peers := make([]PeerRecord, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, *peer)
+	}
+	return peers
+}
+
+// Serve 处理请求直到 Close
+func (s *SignalingServer) Serve() error {
+	go s.expireLoop()
+	buffer := make([]byte, 64*1024)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			logger.Errorf("信令服务器读取失败: %v", err)
+			continue
+		}
+		// 中继报文按字节限速，不计入请求限流
+		if n > 0 && buffer[0] == p2pRelayMagic {
+			s.forwardRelay(addr, buffer[:n])
+			continue
+		}
+		if !s.limiter.Allow(addr.IP.String()) {
+			s.reply(addr, map[string]interface{}{"status": "rate_limited"})
+			continue
+		}
+		var packet PacketData
+		if err := json.Unmarshal(buffer[:n], &packet); err != nil || packet.Key == "" {
+			s.reply(addr, map[string]interface{}{"status": "invalid_request"})
+			continue
+		}
+		s.handle(addr, packet)
+	}
+}
+
+// Close 停止服务
+func (s *SignalingServer) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.conn.Close()
+		if s.opts.Store != nil {
+			// 等待后台写入退出，最多等一次存储超时
+			select {
+			case <-s.persisted:
+			case <-time.After(signalingStoreBackoff):
+			}
+			s.opts.Store.Close()
+		}
+	})
+	return err
+}
+
+func (s *SignalingServer) handle(addr *net.UDPAddr, packet PacketData) {
+	switch packet.Task {
+	case P2PTaskRegister:
+		s.register(addr, packet)
+	case P2PTaskQuery:
+		s.touch(packet.Key, addr)
+		s.query(addr, packet.TargetKey)
+	case P2PTaskHolePunch:
+		s.touch(packet.Key, addr)
+		s.holePunch(addr, packet)
+	case P2PTaskRelay:
+		s.touch(packet.Key, addr)
+		s.allocateRelay(addr, packet)
+	default:
+		s.reply(addr, map[string]interface{}{"status": "unknown_task"})
+	}
+}
+
+// register 来源地址就是客户端在NAT外的地址，包中的 IP/Port 是客户端自己看到的本地地址
+func (s *SignalingServer) register(addr *net.UDPAddr, packet PacketData) {
+	localPort, _ := strconv.Atoi(packet.Port)
+	natType := "NAT"
+	if addr.IP.String() == packet.IP && addr.Port == localPort {
+		natType = "Open Internet"
+	}
+	peer := &PeerRecord{
+		Key:          packet.Key,
+		ExternalIP:   addr.IP.String(),
+		ExternalPort: addr.Port,
+		LocalIP:      packet.IP,
+		LocalPort:    localPort,
+		NATType:      natType,
+		LastSeen:     time.Now(),
+	}
+	s.mu.Lock()
+	if old, ok := s.peers[peer.Key]; ok {
+		oldAddr := net.JoinHostPort(old.ExternalIP, strconv.Itoa(old.ExternalPort))
+		if s.byAddr[oldAddr] == peer.Key {
+			delete(s.byAddr, oldAddr)
+		}
+	}
+	s.peers[peer.Key] = peer
+	s.byAddr[addr.String()] = peer.Key
+	s.mu.Unlock()
+	s.persist(*peer)
+
+	s.reply(addr, peerResponse("registered", *peer))
+}
+
+// touch 已登记的客户端从同一地址发来请求时刷新过期时间
+func (s *SignalingServer) touch(key string, addr *net.UDPAddr) {
+	s.mu.Lock()
+	peer, ok := s.peers[key]
+	if ok && peer.ExternalIP == addr.IP.String() && peer.ExternalPort == addr.Port {
+		peer.LastSeen = time.Now()
+	} else {
+		ok = false
+	}
+	var record PeerRecord
+	if ok {
+		record = *peer
+	}
+	s.mu.Unlock()
+	if ok {
+		s.persist(record)
+	}
+}
+
+func (s *SignalingServer) query(addr *net.UDPAddr, targetKey string) {
+	s.mu.RLock()
+	peer, ok := s.peers[targetKey]
+	var record PeerRecord
+	if ok {
+		record = *peer
+	}
+	s.mu.RUnlock()
+	if ok {
+		s.reply(addr, peerResponse("found", record))
+		return
+	}
+	s.lookup(targetKey, func(record PeerRecord, ok bool) {
+		if ok {
+			s.reply(addr, peerResponse("found_in_redis", record))
+			return
+		}
+		s.reply(addr, map[string]interface{}{"status": "not_found"})
+	})
+}
+
+// holePunch 通知目标客户端向请求方打洞，请求方收到回复后同时向目标打洞
+func (s *SignalingServer) holePunch(addr *net.UDPAddr, packet PacketData) {
+	s.mu.RLock()
+	target, ok := s.peers[packet.TargetKey]
+	var record PeerRecord
+	if ok {
+		record = *target
+	}
+	s.mu.RUnlock()
+	if ok {
+		s.notifyPunch(addr, packet, record)
+		return
+	}
+	// 目标的地址只在存储中时仍然可以尝试通知
+	s.lookup(packet.TargetKey, func(record PeerRecord, ok bool) {
+		if !ok {
+			s.reply(addr, map[string]interface{}{"status": "target_not_found"})
+			return
+		}
+		s.notifyPunch(addr, packet, record)
+	})
+}
+
+// notifyPunch 把请求方的地址发给目标，并回复请求方
+func (s *SignalingServer) notifyPunch(addr *net.UDPAddr, packet PacketData, record PeerRecord) {
+	localPort, _ := strconv.Atoi(packet.Port)
+	targetAddr := &net.UDPAddr{IP: net.ParseIP(record.ExternalIP), Port: record.ExternalPort}
+	s.reply(targetAddr, PunchNotification{
+		Task:               P2PTaskPunchNotify,
+		RequesterKey:       packet.Key,
+		RequesterIP:        addr.IP.String(),
+		RequesterPort:      addr.Port,
+		RequesterLocalIP:   packet.IP,
+		RequesterLocalPort: localPort,
+		Timestamp:          time.Now().Unix(),
+	})
+	s.reply(addr, map[string]interface{}{"status": "hole_punch_initiated", "target_key": packet.TargetKey})
+}
+
+// allocateRelay 为请求方和目标分配中继，双方都必须已在本服务器登记；配额按这对客户端计算，
+// 空闲超过 PeerTTL 后释放。新的配对计入请求方IP的配对数和服务器的配对总数
+func (s *SignalingServer) allocateRelay(addr *net.UDPAddr, packet PacketData) {
+	if s.opts.RelayQuota <= 0 {
+		s.reply(addr, RelayAllocation{Status: "relay_disabled"})
+		return
+	}
+	if len(packet.Key) > p2pRelayMaxKey || len(packet.TargetKey) > p2pRelayMaxKey {
+		s.reply(addr, RelayAllocation{Status: "key_too_long"})
+		return
+	}
+	s.mu.Lock()
+	if s.byAddr[addr.String()] != packet.Key {
+		s.mu.Unlock()
+		s.reply(addr, RelayAllocation{Status: "not_registered"})
+		return
+	}
+	if _, ok := s.peers[packet.TargetKey]; !ok || packet.TargetKey == packet.Key {
+		s.mu.Unlock()
+		s.reply(addr, RelayAllocation{Status: "target_not_found"})
+		return
+	}
+	pair, peers := relayPair(packet.Key, packet.TargetKey)
+	relay, ok := s.relays[pair]
+	if !ok {
+		if s.opts.RelayMaxAllocations > 0 && len(s.relays) >= s.opts.RelayMaxAllocations {
+			s.mu.Unlock()
+			s.reply(addr, RelayAllocation{Status: "relay_unavailable", TargetKey: packet.TargetKey})
+			return
+		}
+		owner := s.relayUsageLocked(addr.IP.String())
+		if s.opts.RelayIPAllocations > 0 && owner.allocations >= s.opts.RelayIPAllocations {
+			s.mu.Unlock()
+			s.reply(addr, RelayAllocation{Status: "relay_limit_exceeded", TargetKey: packet.TargetKey})
+			return
+		}
+		owner.allocations++
+		relay = &relayAllocation{peers: peers, owner: addr.IP.String()}
+		s.relays[pair] = relay
+	}
+	relay.lastActive = time.Now()
+	remaining := s.opts.RelayQuota - relay.used
+	if usage := s.relayUsageLocked(addr.IP.String()); s.opts.RelayIPQuota > 0 && s.opts.RelayIPQuota-usage.used < remaining {
+		remaining = s.opts.RelayIPQuota - usage.used
+	}
+	s.mu.Unlock()
+
+	if remaining <= 0 {
+		s.reply(addr, RelayAllocation{Status: "relay_quota_exceeded", TargetKey: packet.TargetKey})
+		return
+	}
+	rate := s.opts.RelayRate
+	if rate < 0 {
+		rate = 0
+	}
+	s.reply(addr, RelayAllocation{Status: "relay_allocated", TargetKey: packet.TargetKey, Quota: remaining, Rate: rate})
+}
+
+// relayUsageLocked 返回来源IP的使用记录，不存在时创建，调用方持有 s.mu
+func (s *SignalingServer) relayUsageLocked(ip string) *relayUsage {
+	usage, ok := s.relayIPs[ip]
+	if !ok {
+		usage = &relayUsage{}
+		s.relayIPs[ip] = usage
+	}
+	usage.lastActive = time.Now()
+	return usage
+}
+
+// forwardRelay 把中继报文转发给目标，超过这对客户端或来源IP的配额、或者超过速率的报文直接丢弃
+func (s *SignalingServer) forwardRelay(addr *net.UDPAddr, data []byte) {
+	targetKey, payload, ok := decodeRelayFrame(data)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	fromKey := s.byAddr[addr.String()]
+	target, registered := s.peers[targetKey]
+	pair, _ := relayPair(fromKey, targetKey)
+	relay := s.relays[pair]
+	if fromKey == "" || !registered || relay == nil {
+		s.mu.Unlock()
+		return
+	}
+	if relay.used+int64(len(payload)) > s.opts.RelayQuota {
+		exhausted := relay.exhausted
+		relay.exhausted = true
+		s.mu.Unlock()
+		if !exhausted {
+			color.Yellow("P2P中继配额已用完: %s <-> %s", relay.peers[0], relay.peers[1])
+		}
+		return
+	}
+	usage := s.relayUsageLocked(addr.IP.String())
+	if s.opts.RelayIPQuota > 0 && usage.used+int64(len(payload)) > s.opts.RelayIPQuota {
+		exhausted := usage.exhausted
+		usage.exhausted = true
+		s.mu.Unlock()
+		if !exhausted {
+			color.Yellow("P2P中继来源IP配额已用完: %s", addr.IP)
+		}
+		return
+	}
+	if !s.relayLimiter.AllowN(pair, len(payload)) || !s.relayTotal.AllowN("", len(payload)) {
+		s.mu.Unlock()
+		return
+	}
+	relay.used += int64(len(payload))
+	relay.lastActive = time.Now()
+	usage.used += int64(len(payload))
+	targetAddr := &net.UDPAddr{IP: net.ParseIP(target.ExternalIP), Port: target.ExternalPort}
+	s.mu.Unlock()
+
+	if _, err := s.conn.WriteToUDP(encodeRelayFrame(fromKey, payload), targetAddr); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Errorf("信令服务器中继失败 %s: %v", targetAddr, err)
+	}
+}
+
+// Relays 当前的中继分配
+func (s *SignalingServer) Relays() []RelayRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	relays := make([]RelayRecord, 0, len(s.relays))
+	for _, relay := range s.relays {
+		relays = append(relays, RelayRecord{Peers: relay.peers, Used: relay.used, Quota: s.opts.RelayQuota, LastActive: relay.lastActive})
+	}
+	return relays
+}
+
+// relayPair 一对客户端的中继标识，与方向无关
+func relayPair(a, b string) (string, [2]string) {
+	if b < a {
+		a, b = b, a
+	}
+	return a + "\x00" + b, [2]string{a, b}
+}
+
+func peerResponse(status string, peer PeerRecord) ClientInfoResponse {
+	return ClientInfoResponse{
+		Status:       status,
+		ExternalIP:   peer.ExternalIP,
+		ExternalPort: peer.ExternalPort,
+		LocalIP:      peer.LocalIP,
+		LocalPort:    peer.LocalPort,
+		NATType:      peer.NATType,
+	}
+}
+
+func (s *SignalingServer) reply(addr *net.UDPAddr, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if _, err := s.conn.WriteToUDP(data, addr); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Errorf("信令服务器发送失败 %s: %v", addr, err)
+	}
+}
+
+// persist 把记录交给后台写入，不等待存储
+func (s *SignalingServer) persist(peer PeerRecord) {
+	if s.opts.Store == nil {
+		return
+	}
+	s.persistMu.Lock()
+	s.pending[peer.Key] = peer
+	s.persistMu.Unlock()
+	select {
+	case s.persistWake <- struct{}{}:
+	default:
+	}
+}
+
+// persistLoop 写入等待中的记录，过期时间与内存中的一致；存储出错时保留未写入的记录，暂停一段时间后重试
+func (s *SignalingServer) persistLoop() {
+	defer close(s.persisted)
+	if s.opts.Store == nil {
+		return
+	}
+	ttl := strconv.FormatInt(s.opts.PeerTTL.Milliseconds(), 10)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.persistWake:
+		}
+		s.persistMu.Lock()
+		batch := s.pending
+		s.pending = make(map[string]PeerRecord)
+		s.persistMu.Unlock()
+
+		var failed error
+		for key, peer := range batch {
+			if failed == nil {
+				if failed = s.writePeer(peer, ttl); failed == nil {
+					continue
+				}
+			}
+			// 放回没有写入的记录，期间更新过的以新的为准
+			s.persistMu.Lock()
+			if _, newer := s.pending[key]; !newer {
+				s.pending[key] = peer
+			}
+			s.persistMu.Unlock()
+		}
+		if failed == nil {
+			continue
+		}
+		logger.Errorf("保存客户端信息失败，%s 后重试: %v", signalingStoreBackoff, failed)
+		s.storeDown.Store(time.Now().Add(signalingStoreBackoff).UnixNano())
+		select {
+		case <-s.done:
+			return
+		case <-time.After(signalingStoreBackoff):
+		}
+		select {
+		case s.persistWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *SignalingServer) writePeer(peer PeerRecord, ttl string) error {
+	data, err := json.Marshal(peer)
+	if err != nil {
+		return err
+	}
+	_, err = s.opts.Store.Do("SET", s.opts.KeyPrefix+peer.Key, string(data), "PX", ttl)
+	return err
+}
+
+// lookup 在后台到存储中查找内存里没有的客户端，再调用 done；没有存储、存储暂停访问、查找过多
+// 或存储在 signalingLookupWait 内没有回复时直接视为不存在，保证客户端在请求超时前得到回复
+func (s *SignalingServer) lookup(key string, done func(PeerRecord, bool)) {
+	if s.opts.Store == nil || key == "" || time.Now().UnixNano() < s.storeDown.Load() {
+		done(PeerRecord{}, false)
+		return
+	}
+	select {
+	case s.lookups <- struct{}{}:
+	default:
+		done(PeerRecord{}, false)
+		return
+	}
+	var once sync.Once
+	timer := time.AfterFunc(signalingLookupWait, func() {
+		once.Do(func() { done(PeerRecord{}, false) })
+	})
+	go func() {
+		defer func() { <-s.lookups }()
+		peer, found := s.load(key)
+		timer.Stop()
+		once.Do(func() { done(peer, found) })
+	}()
+}
+
+// load 从存储中读取内存里没有的客户端（例如服务器重启前登记的），读到后放回内存
+func (s *SignalingServer) load(key string) (PeerRecord, bool) {
+	reply, err := s.opts.Store.Do("GET", s.opts.KeyPrefix+key)
+	if err != nil {
+		logger.Errorf("读取客户端信息失败 %s: %v", key, err)
+		var respErr utils.RESPError
+		if !errors.As(err, &respErr) {
+			s.storeDown.Store(time.Now().Add(signalingStoreBackoff).UnixNano())
+		}
+		return PeerRecord{}, false
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return PeerRecord{}, false
+	}
+	var peer PeerRecord
+	if err := json.Unmarshal([]byte(data), &peer); err != nil || peer.Key != key {
+		return PeerRecord{}, false
+	}
+	s.mu.Lock()
+	if _, exists := s.peers[key]; !exists {
+		s.peers[key] = &peer
+	}
+	s.mu.Unlock()
+	return peer, true
+}
+
+func (s *SignalingServer) expireLoop() {
+	interval := s.opts.PeerTTL / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, peer := range s.peers {
+				if now.Sub(peer.LastSeen) > s.opts.PeerTTL {
+					delete(s.peers, key)
+				}
+			}
+			for addr, key := range s.byAddr {
+				if _, ok := s.peers[key]; !ok {
+					delete(s.byAddr, addr)
+				}
+			}
+			for pair, relay := range s.relays {
+				if now.Sub(relay.lastActive) > s.opts.PeerTTL {
+					delete(s.relays, pair)
+					if owner, ok := s.relayIPs[relay.owner]; ok {
+						owner.allocations--
+					}
+				}
+			}
+			// 来源IP的用量在它的配对都释放、并且空闲超过 PeerTTL 后清零
+			for ip, usage := range s.relayIPs {
+				if usage.allocations <= 0 && now.Sub(usage.lastActive) > s.opts.PeerTTL {
+					delete(s.relayIPs, ip)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// RunP2PServer 以 p2p-server 模式运行，只启动信令服务器，不连接数据库。
+// 配置：P2P_LISTEN_ADDR（默认 :P2P_SERVER_PORT 或 :8888）、P2P_PEER_TTL（默认5m）、
+// P2P_RATE_LIMIT / P2P_RATE_BURST（默认每个IP每秒20次、突发40次）、
+// P2P_RELAY_QUOTA / P2P_RELAY_RATE（每对客户端的中继配额和每秒字节数，默认100MB、256KB/s，配额为0时不提供中继）、
+// P2P_RELAY_IP_QUOTA / P2P_RELAY_IP_ALLOCATIONS（每个来源IP的中继字节数和配对数，默认200MB、8个）、
+// P2P_RELAY_MAX_ALLOCATIONS / P2P_RELAY_TOTAL_RATE（服务器的配对总数和每秒中继字节数，默认1024个、16MB/s）、
+// P2P_REDIS_ADDR / P2P_REDIS_PASSWORD / P2P_REDIS_DB（可选持久化）
+func RunP2PServer() error {
+	listenAddr := utils.GetEnv("P2P_LISTEN_ADDR", ":"+utils.GetEnv("P2P_SERVER_PORT", "8888"))
+	ttl, err := clusterDuration("P2P_PEER_TTL", "5m")
+	if err != nil {
+		return err
+	}
+	opts := SignalingOptions{
+		PeerTTL:             ttl,
+		RateLimit:           20,
+		RateBurst:           40,
+		RelayQuota:          100 << 20,
+		RelayRate:           256 << 10,
+		RelayIPQuota:        200 << 20,
+		RelayIPAllocations:  8,
+		RelayMaxAllocations: 1024,
+		RelayTotalRate:      16 << 20,
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("P2P_RATE_LIMIT"), 64); err == nil {
+		opts.RateLimit = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("P2P_RATE_BURST")); err == nil {
+		opts.RateBurst = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("P2P_RELAY_QUOTA"), 10, 64); err == nil {
+		opts.RelayQuota = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("P2P_RELAY_RATE"), 10, 64); err == nil {
+		opts.RelayRate = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("P2P_RELAY_IP_QUOTA"), 10, 64); err == nil {
+		opts.RelayIPQuota = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("P2P_RELAY_IP_ALLOCATIONS")); err == nil {
+		opts.RelayIPAllocations = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("P2P_RELAY_MAX_ALLOCATIONS")); err == nil {
+		opts.RelayMaxAllocations = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("P2P_RELAY_TOTAL_RATE"), 10, 64); err == nil {
+		opts.RelayTotalRate = v
+	}
+	if redisAddr := os.Getenv("P2P_REDIS_ADDR"); redisAddr != "" {
+		db, _ := strconv.Atoi(os.Getenv("P2P_REDIS_DB"))
+		store := utils.NewRESPClient(redisAddr, os.Getenv("P2P_REDIS_PASSWORD"), db)
+		if err := store.Ping(); err != nil {
+			// 存储暂时不可用时照常提供服务，读写都在后台进行，恢复后重新连接
+			color.Yellow("P2P信令服务器: 无法连接存储 %s: %v", redisAddr, err)
+		}
+		opts.Store = store
+	}
+
+	server, err := NewSignalingServer(listenAddr, opts)
+	if err != nil {
+		return err
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		server.Close()
+	}()
+
+	// 可选的STUN响应器，配置备用IP时支持完整的NAT行为发现
+	if stunAddr := os.Getenv("P2P_STUN_ADDR"); stunAddr != "" {
+		stunServer, err := NewSTUNServer(stunAddr, os.Getenv("P2P_STUN_ALT_IP"))
+		if err != nil {
+			server.Close()
+			return fmt.Errorf("启动STUN服务失败: %w", err)
+		}
+		defer stunServer.Close()
+		go stunServer.Serve()
+		color.Green("STUN服务启动在 udp://%s", stunServer.Addr())
+	}
+
+	color.Green("P2P信令服务器启动在 udp://%s", server.Addr())
+	return server.Serve()
+}
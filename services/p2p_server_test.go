@@ -0,0 +1,312 @@
+package services
+
+import (
+	"GoFileShare/utils"
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startSignalingServer 在本机随机端口上运行信令服务器
+func startSignalingServer(t *testing.T, opts SignalingOptions) *SignalingServer {
+	t.Helper()
+	server, err := NewSignalingServer("127.0.0.1:0", opts)
+	if err != nil {
+		t.Fatalf("启动信令服务器失败: %v", err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// newTestP2PClient 连接信令服务器并注册，不做局域网发现和STUN检测
+func newTestP2PClient(t *testing.T, server *SignalingServer, key string) *EnhancedUdpClient {
+	t.Helper()
+	t.Setenv("P2P_LAN_DISCOVERY", "none")
+	t.Setenv("P2P_STUN_SERVERS", "none")
+	client, err := NewEnhancedUdpClient(server.Addr().String(), key, nil, nil)
+	if err != nil {
+		t.Fatalf("创建客户端 %s 失败: %v", key, err)
+	}
+	t.Cleanup(client.Close)
+	if err := client.Register(); err != nil {
+		t.Fatalf("客户端 %s 注册失败: %v", key, err)
+	}
+	return client
+}
+
+func TestSignalingTwoClients(t *testing.T) {
+	server := startSignalingServer(t, SignalingOptions{PeerTTL: time.Minute})
+	alice := newTestP2PClient(t, server, "alice")
+	bob := newTestP2PClient(t, server, "bob")
+	if peers := server.Peers(); len(peers) != 2 {
+		t.Fatalf("服务器应登记 2 个客户端，实际为 %v", peers)
+	}
+
+	received := make(chan string, 2)
+	alice.SetMessageHandler(func(fromKey string, data []byte) { received <- fromKey + ":" + string(data) })
+	bob.SetMessageHandler(func(fromKey string, data []byte) { received <- fromKey + ":" + string(data) })
+
+	if err := alice.ConnectToPeer("bob"); err != nil {
+		t.Fatalf("alice 连接 bob 失败: %v", err)
+	}
+	if err := alice.SendP2PMessage("bob", "hello bob"); err != nil {
+		t.Fatalf("发送消息失败: %v", err)
+	}
+	expectMessage(t, received, "alice:hello bob")
+
+	// 被通知打洞的一方也建立了连接，可以直接回复
+	deadline := time.Now().Add(3 * time.Second)
+	for !bob.isConnected("alice") {
+		if time.Now().After(deadline) {
+			t.Fatal("bob 没有建立到 alice 的连接")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := bob.SendP2PMessage("alice", "hello alice"); err != nil {
+		t.Fatalf("回复消息失败: %v", err)
+	}
+	expectMessage(t, received, "bob:hello alice")
+
+	// 可靠流传输的数据完整送达
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 16<<10)
+	accepted := make(chan []byte, 1)
+	go func() {
+		stream, err := bob.AcceptStream()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		data, _ := io.ReadAll(stream)
+		accepted <- data
+	}()
+	stream, err := alice.OpenStream("bob")
+	if err != nil {
+		t.Fatalf("打开流失败: %v", err)
+	}
+	if _, err := stream.Write(payload); err != nil {
+		t.Fatalf("写入流失败: %v", err)
+	}
+	stream.Close()
+	select {
+	case data := <-accepted:
+		if !bytes.Equal(data, payload) {
+			t.Fatalf("流上收到 %d/%d 字节", len(data), len(payload))
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("流传输超时")
+	}
+}
+
+func expectMessage(t *testing.T, received <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-received:
+		if got != want {
+			t.Fatalf("收到 %q，期望 %q", got, want)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("没有收到消息 %q", want)
+	}
+}
+
+// fakeRESP 只支持 PING、SET、GET 的 Redis 兼容服务，stall 为 true 时接受连接但不回复
+type fakeRESP struct {
+	listener net.Listener
+	mu       sync.Mutex
+	data     map[string]string
+	stall    bool
+}
+
+func startFakeRESP(t *testing.T, stall bool) *fakeRESP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	f := &fakeRESP{listener: listener, data: make(map[string]string), stall: stall}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRESP) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(rd)
+		if err != nil {
+			return
+		}
+		if f.stall {
+			continue
+		}
+		var reply string
+		f.mu.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			reply = "+PONG\r\n"
+		case "SET":
+			f.data[args[1]] = args[2]
+			reply = "+OK\r\n"
+		case "GET":
+			if value, ok := f.data[args[1]]; ok {
+				reply = "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+			} else {
+				reply = "$-1\r\n"
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		f.mu.Unlock()
+		conn.Write([]byte(reply))
+	}
+}
+
+func (f *fakeRESP) keys() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.data)
+}
+
+func readRESPCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, count)
+	for i := range args {
+		if _, err := rd.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		value, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(value, "\r\n")
+	}
+	return args, nil
+}
+
+func TestSignalingPersistsToStore(t *testing.T) {
+	store := startFakeRESP(t, false)
+	opts := SignalingOptions{PeerTTL: time.Minute, Store: utils.NewRESPClient(store.listener.Addr().String(), "", 0)}
+	first := startSignalingServer(t, opts)
+	newTestP2PClient(t, first, "alice")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for store.keys() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("客户端信息没有写入存储")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 重启后的服务器从存储中找到之前登记的客户端
+	opts.Store = utils.NewRESPClient(store.listener.Addr().String(), "", 0)
+	second := startSignalingServer(t, opts)
+	bob := newTestP2PClient(t, second, "bob")
+	info, err := bob.queryPeerInfo("alice")
+	if err != nil {
+		t.Fatalf("查询失败: %v", err)
+	}
+	if info.Status != "found_in_redis" {
+		t.Fatalf("应从存储中找到 alice，实际状态为 %s", info.Status)
+	}
+}
+
+func TestSignalingStalledStoreDoesNotBlock(t *testing.T) {
+	store := startFakeRESP(t, true)
+	server := startSignalingServer(t, SignalingOptions{
+		PeerTTL: time.Minute,
+		Store:   utils.NewRESPClient(store.listener.Addr().String(), "", 0),
+	})
+
+	// 存储不回复时注册、查询和打洞仍然立即得到回复
+	start := time.Now()
+	alice := newTestP2PClient(t, server, "alice")
+	newTestP2PClient(t, server, "bob")
+	if info, err := alice.queryPeerInfo("bob"); err != nil || info.Status != "found" {
+		t.Fatalf("查询 bob 失败: %v %v", info, err)
+	}
+	if _, err := alice.queryPeerInfo("nobody"); err == nil || !strings.Contains(err.Error(), "not_found") {
+		t.Fatalf("查询不存在的客户端应返回 not_found，实际为 %v", err)
+	}
+	if err := alice.ConnectToPeer("bob"); err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("存储无响应时请求耗时 %s", elapsed)
+	}
+}
+
+func TestSignalingRelayLimits(t *testing.T) {
+	// 同一来源IP登记多个Key时，配对数受来源IP的上限约束
+	server := startSignalingServer(t, SignalingOptions{PeerTTL: time.Minute, RelayQuota: 1 << 20, RelayIPAllocations: 1})
+	alice := newTestP2PClient(t, server, "alice")
+	bob := newTestP2PClient(t, server, "bob")
+	newTestP2PClient(t, server, "carol")
+	if _, err := alice.requestRelay("bob"); err != nil {
+		t.Fatalf("第一个中继应分配成功: %v", err)
+	}
+	if _, err := bob.requestRelay("alice"); err != nil {
+		t.Fatalf("已有的配对应可以重复申请: %v", err)
+	}
+	if _, err := alice.requestRelay("carol"); err == nil || !strings.Contains(err.Error(), "relay_limit_exceeded") {
+		t.Fatalf("超过来源IP的配对数应被拒绝，实际为 %v", err)
+	}
+
+	// 服务器的配对总数
+	server = startSignalingServer(t, SignalingOptions{PeerTTL: time.Minute, RelayQuota: 1 << 20, RelayMaxAllocations: 1})
+	alice = newTestP2PClient(t, server, "alice")
+	newTestP2PClient(t, server, "bob")
+	carol := newTestP2PClient(t, server, "carol")
+	if _, err := alice.requestRelay("bob"); err != nil {
+		t.Fatalf("第一个中继应分配成功: %v", err)
+	}
+	if _, err := carol.requestRelay("alice"); err == nil || !strings.Contains(err.Error(), "relay_unavailable") {
+		t.Fatalf("超过配对总数应被拒绝，实际为 %v", err)
+	}
+
+	// 来源IP的字节配额在所有配对之间共享，超出的报文被丢弃
+	const ipQuota = 16 << 10
+	server = startSignalingServer(t, SignalingOptions{PeerTTL: time.Minute, RelayQuota: 1 << 20, RelayIPQuota: ipQuota})
+	alice = newTestP2PClient(t, server, "alice")
+	bob = newTestP2PClient(t, server, "bob")
+	allocation, err := alice.requestRelay("bob")
+	if err != nil {
+		t.Fatalf("申请中继失败: %v", err)
+	}
+	if allocation.Quota != ipQuota {
+		t.Fatalf("剩余配额应受来源IP配额限制: %d", allocation.Quota)
+	}
+	// 双方各发送 32KB，服务器只按字节数计量，接收方丢弃无法识别的报文
+	packet := bytes.Repeat([]byte{0}, 1024)
+	for i := 0; i < 32; i++ {
+		alice.writeTo(packet, alice.relayAddr("bob"))
+		bob.writeTo(packet, bob.relayAddr("alice"))
+	}
+	time.Sleep(200 * time.Millisecond)
+	var used int64
+	for _, relay := range server.Relays() {
+		used += relay.Used
+	}
+	if used == 0 || used > ipQuota {
+		t.Fatalf("来源IP的中继字节数为 %d，应在 (0, %d] 之间", used, ipQuota)
+	}
+}
@@ -0,0 +1,896 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 可靠流的报文格式（作为内层类型 p2pInnerStream 的内容在加密传输报文中发送）：
+//
+//	0xFF | 类型 | 标志 | SACK块数 | 流ID(4) | 序号(4) | 累计确认(4) | 接收窗口(4) | SACK块(8*n) 或 数据
+//
+// 报文按内层类型交给多路复用器，首字节 0xFF 只用于解密后再检查一次格式。
+// 序号以数据段为单位，从0开始递增；累计确认是期望收到的下一个序号，SACK块为已收到的乱序区间 [起, 止)
+const (
+	streamMagic      byte = 0xFF
+	streamHeaderSize      = 20
+
+	streamPacketData  byte = 1
+	streamPacketAck   byte = 2
+	streamPacketReset byte = 3
+
+	streamFlagOpener byte = 0x01 // 发送方是打开流的一方
+	streamFlagFin    byte = 0x02 // 该数据段之后没有数据
+
+	streamMaxSACK       = 8
+	streamInitialWindow = 4   // 初始拥塞窗口，单位为数据段
+	streamMaxWindow     = 256 // 接收窗口上限，单位为数据段
+	streamSendBuffer    = 1 << 20
+	streamDefaultMTU    = 1200 // 不分片即可穿过绝大多数链路（包括 IPv6 最小 MTU 1280 减去报头）
+	streamMinMTU        = 576
+	streamInitialRTO    = time.Second
+	streamMinRTO        = 200 * time.Millisecond
+	streamMaxRTO        = 5 * time.Second
+	streamIdleTimeout   = 15 * time.Second // 有未确认的数据且这么久没有收到任何确认时认为对方已断开
+	streamAcceptIdle    = 2 * time.Minute  // 对方打开的流这么久没有任何收发时重置，避免对方打开后不再发送而一直占用
+	streamTick          = 10 * time.Millisecond
+	streamTimeWait      = 30 * time.Second
+	streamAcceptBacklog = 16
+	streamSocketBuffer  = 4 << 20 // 套接字接收缓冲区，默认值容纳不下一个满窗口的突发
+)
+
+var (
+	ErrStreamClosed  = errors.New("P2P流已关闭")
+	ErrStreamReset   = errors.New("P2P流被对方重置")
+	ErrStreamTimeout = errors.New("P2P流超时，对方没有响应")
+)
+
+type streamPacket struct {
+	Type     byte
+	Flags    byte
+	StreamID uint32
+	Seq      uint32
+	Ack      uint32
+	Window   uint32
+	SACK     [][2]uint32
+	Payload  []byte
+}
+
+func isStreamPacket(data []byte) bool {
+	return len(data) >= streamHeaderSize && data[0] == streamMagic
+}
+
+func (p *streamPacket) encode() []byte {
+	buf := make([]byte, streamHeaderSize+8*len(p.SACK)+len(p.Payload))
+	buf[0] = streamMagic
+	buf[1] = p.Type
+	buf[2] = p.Flags
+	buf[3] = byte(len(p.SACK))
+	binary.BigEndian.PutUint32(buf[4:], p.StreamID)
+	binary.BigEndian.PutUint32(buf[8:], p.Seq)
+	binary.BigEndian.PutUint32(buf[12:], p.Ack)
+	binary.BigEndian.PutUint32(buf[16:], p.Window)
+	offset := streamHeaderSize
+	for _, block := range p.SACK {
+		binary.BigEndian.PutUint32(buf[offset:], block[0])
+		binary.BigEndian.PutUint32(buf[offset+4:], block[1])
+		offset += 8
+	}
+	copy(buf[offset:], p.Payload)
+	return buf
+}
+
+func decodeStreamPacket(data []byte) (*streamPacket, error) {
+	if !isStreamPacket(data) {
+		return nil, errors.New("不是P2P流报文")
+	}
+	p := &streamPacket{
+		Type:     data[1],
+		Flags:    data[2],
+		StreamID: binary.BigEndian.Uint32(data[4:]),
+		Seq:      binary.BigEndian.Uint32(data[8:]),
+		Ack:      binary.BigEndian.Uint32(data[12:]),
+		Window:   binary.BigEndian.Uint32(data[16:]),
+	}
+	offset := streamHeaderSize
+	count := int(data[3])
+	if count > streamMaxSACK || len(data) < offset+8*count {
+		return nil, errors.New("P2P流报文长度错误")
+	}
+	for i := 0; i < count; i++ {
+		p.SACK = append(p.SACK, [2]uint32{binary.BigEndian.Uint32(data[offset:]), binary.BigEndian.Uint32(data[offset+4:])})
+		offset += 8
+	}
+	p.Payload = append([]byte(nil), data[offset:]...)
+	return p, nil
+}
+
+type streamSegment struct {
+	seq           uint32
+	data          []byte
+	fin           bool
+	sentAt        time.Time
+	transmissions int
+	sacked        bool
+	lost          bool // 超时后认为已丢失，等待按拥塞窗口重传
+}
+
+// P2PStream 建立在P2P连接上的可靠有序字节流，实现 io.ReadWriteCloser。
+// 超时重传的时间按 RFC 6298 根据往返时间计算，收到3个重复确认时快速重传；
+// 拥塞控制为 NewReno（慢启动、拥塞避免、快速恢复），发送量同时受对方接收窗口限制
+type P2PStream struct {
+	mux       *streamMux
+	id        uint32
+	opener    bool
+	remote    *net.UDPAddr
+	remoteKey string
+	mss       int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// 发送
+	sendBuf    []byte
+	nextSeq    uint32
+	sndUna     uint32
+	unacked    []*streamSegment
+	cwnd       float64
+	ssthresh   float64
+	peerWindow uint32
+	srtt       time.Duration
+	rttvar     time.Duration
+	rto        time.Duration
+	dupAcks    int
+	inRecovery bool
+	recoverSeq uint32
+	finQueued  bool
+	finAcked   bool
+	lastAckAt  time.Time
+
+	// 接收
+	rcvNext     uint32
+	outOfOrder  map[uint32]*streamSegment
+	readBuf     []byte
+	finReceived bool
+	zeroWindow  bool
+
+	err  error
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newP2PStream(mux *streamMux, id uint32, opener bool, remote *net.UDPAddr, remoteKey string) *P2PStream {
+	s := &P2PStream{
+		mux:        mux,
+		id:         id,
+		opener:     opener,
+		remote:     remote,
+		remoteKey:  remoteKey,
+		mss:        mux.mtu - streamHeaderSize,
+		cwnd:       streamInitialWindow,
+		ssthresh:   streamMaxWindow,
+		peerWindow: streamMaxWindow,
+		rto:        streamInitialRTO,
+		lastAckAt:  time.Now(),
+		outOfOrder: make(map[uint32]*streamSegment),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.run()
+	return s
+}
+
+// ID 流ID
+func (s *P2PStream) ID() uint32 {
+	return s.id
+}
+
+// RemoteKey 对方客户端的Key，来自未建立P2P连接的地址时为空
+func (s *P2PStream) RemoteKey() string {
+	return s.remoteKey
+}
+
+// RemoteAddr 对方地址
+func (s *P2PStream) RemoteAddr() *net.UDPAddr {
+	return s.remote
+}
+
+// Read 读取按序到达的数据，对方关闭写入后返回 io.EOF
+func (s *P2PStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	for len(s.readBuf) == 0 && !s.finReceived && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.readBuf) == 0 {
+		err := s.err
+		s.mu.Unlock()
+		if err == nil || errors.Is(err, ErrStreamClosed) {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	n := copy(p, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	var ack []byte
+	if s.zeroWindow && s.receiveWindow() > 0 {
+		// 通知对方窗口重新打开
+		s.zeroWindow = false
+		ack = s.ackPacket().encode()
+	}
+	s.mu.Unlock()
+	if ack != nil {
+		s.mux.send(ack, s.remote)
+	}
+	return n, nil
+}
+
+// Write 写入发送缓冲区，缓冲区满时阻塞
+func (s *P2PStream) Write(p []byte) (int, error) {
+	written := 0
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for written < len(p) {
+		for len(s.sendBuf) >= streamSendBuffer && s.err == nil && !s.finQueued {
+			s.cond.Wait()
+		}
+		if s.err != nil {
+			return written, s.err
+		}
+		if s.finQueued {
+			return written, ErrStreamClosed
+		}
+		n := len(p) - written
+		if free := streamSendBuffer - len(s.sendBuf); n > free {
+			n = free
+		}
+		s.sendBuf = append(s.sendBuf, p[written:written+n]...)
+		written += n
+		s.notify()
+	}
+	return written, nil
+}
+
+// Close 关闭写入，等待已写入的数据全部被对方确认；对方仍可以继续发送，直到它也关闭
+func (s *P2PStream) Close() error {
+	s.mu.Lock()
+	if !s.finQueued {
+		s.finQueued = true
+		s.notify()
+	}
+	for !s.finAcked && s.err == nil {
+		s.cond.Wait()
+	}
+	err := s.err
+	s.mu.Unlock()
+	if errors.Is(err, ErrStreamClosed) {
+		return nil
+	}
+	return err
+}
+
+// Reset 立即终止流，未发送和未确认的数据被丢弃
+func (s *P2PStream) Reset() {
+	s.mux.send((&streamPacket{Type: streamPacketReset, Flags: s.flags(), StreamID: s.id}).encode(), s.remote)
+	s.fail(ErrStreamClosed)
+}
+
+func (s *P2PStream) flags() byte {
+	if s.opener {
+		return streamFlagOpener
+	}
+	return 0
+}
+
+func (s *P2PStream) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// fail 结束流，唤醒所有等待中的读写
+func (s *P2PStream) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+		close(s.done)
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.mux.remove(s)
+}
+
+func (s *P2PStream) run() {
+	ticker := time.NewTicker(streamTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+		s.pump(time.Now())
+	}
+}
+
+// pump 处理超时重传并在窗口允许时发送新数据
+func (s *P2PStream) pump(now time.Time) {
+	var packets [][]byte
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.unacked) > 0 && now.Sub(s.lastAckAt) > streamIdleTimeout {
+		s.mu.Unlock()
+		s.fail(ErrStreamTimeout)
+		return
+	}
+	if !s.opener && now.Sub(s.lastAckAt) > streamAcceptIdle {
+		s.mu.Unlock()
+		s.mux.send((&streamPacket{Type: streamPacketReset, Flags: s.flags(), StreamID: s.id}).encode(), s.remote)
+		s.fail(ErrStreamTimeout)
+		return
+	}
+
+	// 超时重传最早的未确认数据段，拥塞窗口回到1；其余在途数据段视为丢失，随窗口增长依次重传
+	if seg := s.firstInFlight(); seg != nil && now.Sub(seg.sentAt) > s.rto {
+		if s.peerWindow > 0 {
+			s.ssthresh = maxFloat(float64(s.flight())/2, 2)
+			s.cwnd = 1
+		}
+		s.inRecovery = false
+		s.dupAcks = 0
+		s.rto = minDuration(s.rto*2, streamMaxRTO)
+		for _, other := range s.unacked {
+			if !other.sacked && other != seg {
+				other.lost = true
+			}
+		}
+		packets = append(packets, s.transmit(seg, now))
+	}
+
+	// 对方窗口为0时仍允许1个数据段在途，作为窗口探测
+	window := int(s.cwnd)
+	if peer := int(s.peerWindow); peer < window {
+		window = peer
+	}
+	if window < 1 {
+		window = 1
+	}
+	for s.flight() < window {
+		if seg := s.firstLost(); seg != nil {
+			packets = append(packets, s.transmit(seg, now))
+			continue
+		}
+		seg := s.nextSegment()
+		if seg == nil {
+			break
+		}
+		if len(s.unacked) == 0 {
+			// 空闲之后重新开始计算无响应时间
+			s.lastAckAt = now
+		}
+		s.unacked = append(s.unacked, seg)
+		packets = append(packets, s.transmit(seg, now))
+	}
+	s.mu.Unlock()
+
+	for _, packet := range packets {
+		s.mux.send(packet, s.remote)
+	}
+}
+
+// nextSegment 从发送缓冲区取出下一个数据段，调用 Close 后缓冲区发完时生成结束段
+func (s *P2PStream) nextSegment() *streamSegment {
+	if len(s.sendBuf) == 0 && (!s.finQueued || s.finSent()) {
+		return nil
+	}
+	n := len(s.sendBuf)
+	if n > s.mss {
+		n = s.mss
+	}
+	seg := &streamSegment{seq: s.nextSeq, data: append([]byte(nil), s.sendBuf[:n]...)}
+	s.sendBuf = s.sendBuf[n:]
+	seg.fin = len(s.sendBuf) == 0 && s.finQueued
+	s.nextSeq++
+	s.cond.Broadcast()
+	return seg
+}
+
+func (s *P2PStream) finSent() bool {
+	if s.finAcked {
+		return true
+	}
+	for _, seg := range s.unacked {
+		if seg.fin {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *P2PStream) transmit(seg *streamSegment, now time.Time) []byte {
+	seg.sentAt = now
+	seg.lost = false
+	seg.transmissions++
+	packet := s.ackPacket()
+	packet.Type = streamPacketData
+	packet.Seq = seg.seq
+	packet.Payload = seg.data
+	if seg.fin {
+		packet.Flags |= streamFlagFin
+	}
+	return packet.encode()
+}
+
+func (s *P2PStream) firstUnsacked() *streamSegment {
+	for _, seg := range s.unacked {
+		if !seg.sacked {
+			return seg
+		}
+	}
+	return nil
+}
+
+func (s *P2PStream) firstInFlight() *streamSegment {
+	for _, seg := range s.unacked {
+		if !seg.sacked && !seg.lost {
+			return seg
+		}
+	}
+	return nil
+}
+
+func (s *P2PStream) firstLost() *streamSegment {
+	for _, seg := range s.unacked {
+		if seg.lost && !seg.sacked {
+			return seg
+		}
+	}
+	return nil
+}
+
+// flight 已发送、未确认、没有被 SACK 也没有判定为丢失的数据段数
+func (s *P2PStream) flight() int {
+	n := 0
+	for _, seg := range s.unacked {
+		if !seg.sacked && !seg.lost {
+			n++
+		}
+	}
+	return n
+}
+
+// receiveWindow 还能接收的数据段数
+func (s *P2PStream) receiveWindow() uint32 {
+	used := len(s.outOfOrder) + (len(s.readBuf)+s.mss-1)/s.mss
+	if used >= streamMaxWindow {
+		return 0
+	}
+	return uint32(streamMaxWindow - used)
+}
+
+// ackPacket 当前的确认信息，数据报文也携带确认
+func (s *P2PStream) ackPacket() *streamPacket {
+	packet := &streamPacket{
+		Type:     streamPacketAck,
+		Flags:    s.flags(),
+		StreamID: s.id,
+		Ack:      s.rcvNext,
+		Window:   s.receiveWindow(),
+	}
+	if packet.Window == 0 {
+		s.zeroWindow = true
+	}
+	if len(s.outOfOrder) > 0 {
+		seqs := make([]uint32, 0, len(s.outOfOrder))
+		for seq := range s.outOfOrder {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		for _, seq := range seqs {
+			last := len(packet.SACK) - 1
+			if last >= 0 && packet.SACK[last][1] == seq {
+				packet.SACK[last][1] = seq + 1
+				continue
+			}
+			if len(packet.SACK) == streamMaxSACK {
+				break
+			}
+			packet.SACK = append(packet.SACK, [2]uint32{seq, seq + 1})
+		}
+	}
+	return packet
+}
+
+// handle 处理对方发来的报文
+func (s *P2PStream) handle(packet *streamPacket, now time.Time) {
+	if packet.Type == streamPacketReset {
+		s.fail(ErrStreamReset)
+		return
+	}
+	var reply [][]byte
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	reply = append(reply, s.handleAck(packet, now)...)
+	if packet.Type == streamPacketData {
+		s.handleData(packet)
+		reply = append(reply, s.ackPacket().encode())
+	}
+	s.mu.Unlock()
+	for _, data := range reply {
+		s.mux.send(data, s.remote)
+	}
+	s.notify()
+	s.maybeFinish()
+}
+
+func (s *P2PStream) handleAck(packet *streamPacket, now time.Time) [][]byte {
+	s.lastAckAt = now
+	s.peerWindow = packet.Window
+	var retransmit [][]byte
+
+	acked := 0
+	remaining := s.unacked[:0]
+	for _, seg := range s.unacked {
+		if seg.seq < packet.Ack {
+			if seg.transmissions == 1 && !seg.sacked {
+				s.sampleRTT(now.Sub(seg.sentAt))
+			}
+			if seg.fin {
+				s.finAcked = true
+			}
+			acked++
+			continue
+		}
+		for _, block := range packet.SACK {
+			if seg.seq >= block[0] && seg.seq < block[1] && !seg.sacked {
+				if seg.transmissions == 1 {
+					s.sampleRTT(now.Sub(seg.sentAt))
+				}
+				seg.sacked = true
+			}
+		}
+		remaining = append(remaining, seg)
+	}
+	s.unacked = remaining
+
+	if packet.Ack > s.sndUna {
+		s.sndUna = packet.Ack
+		s.dupAcks = 0
+		if s.inRecovery {
+			if packet.Ack >= s.recoverSeq {
+				s.inRecovery = false
+				s.cwnd = s.ssthresh
+			} else if seg := s.firstUnsacked(); seg != nil {
+				// NewReno 部分确认：下一个缺口也丢了
+				retransmit = append(retransmit, s.transmit(seg, now))
+			}
+		} else {
+			for i := 0; i < acked; i++ {
+				if s.cwnd < s.ssthresh {
+					s.cwnd++
+				} else {
+					s.cwnd += 1 / s.cwnd
+				}
+			}
+			if s.cwnd > streamMaxWindow {
+				s.cwnd = streamMaxWindow
+			}
+		}
+		s.cond.Broadcast()
+	} else if packet.Type == streamPacketAck && packet.Ack == s.sndUna && len(s.unacked) > 0 {
+		s.dupAcks++
+		// 在途数据段少于4个时收不到3个重复确认，按 RFC 5827 降低门限
+		threshold := 3
+		if n := len(s.unacked); n < 4 {
+			threshold = n - 1
+		}
+		if threshold < 1 {
+			threshold = 1
+		}
+		if s.dupAcks == threshold && !s.inRecovery {
+			if seg := s.firstUnsacked(); seg != nil {
+				s.ssthresh = maxFloat(float64(s.flight())/2, 2)
+				s.cwnd = s.ssthresh
+				s.inRecovery = true
+				s.recoverSeq = s.nextSeq
+				retransmit = append(retransmit, s.transmit(seg, now))
+			}
+		}
+	}
+	if s.inRecovery {
+		// 对方 SACK 了更后面的数据段时，前面还没有重传过的缺口也已丢失，一并重传
+		var highest uint32
+		for _, seg := range s.unacked {
+			if seg.sacked && seg.seq > highest {
+				highest = seg.seq
+			}
+		}
+		for _, seg := range s.unacked {
+			if !seg.sacked && seg.seq < highest && seg.transmissions == 1 {
+				retransmit = append(retransmit, s.transmit(seg, now))
+			}
+		}
+	}
+	if s.finAcked {
+		s.cond.Broadcast()
+	}
+	return retransmit
+}
+
+func (s *P2PStream) handleData(packet *streamPacket) {
+	if packet.Seq < s.rcvNext || packet.Seq >= s.rcvNext+streamMaxWindow {
+		return
+	}
+	if _, exists := s.outOfOrder[packet.Seq]; exists {
+		return
+	}
+	if s.receiveWindow() == 0 && packet.Seq != s.rcvNext {
+		return
+	}
+	s.outOfOrder[packet.Seq] = &streamSegment{seq: packet.Seq, data: packet.Payload, fin: packet.Flags&streamFlagFin != 0}
+	for {
+		seg, ok := s.outOfOrder[s.rcvNext]
+		if !ok {
+			break
+		}
+		delete(s.outOfOrder, s.rcvNext)
+		s.readBuf = append(s.readBuf, seg.data...)
+		s.rcvNext++
+		if seg.fin {
+			s.finReceived = true
+		}
+	}
+	s.cond.Broadcast()
+}
+
+// sampleRTT 按 RFC 6298 更新平滑往返时间和重传超时，重传过的数据段不取样（Karn 算法）
+func (s *P2PStream) sampleRTT(rtt time.Duration) {
+	if s.srtt == 0 {
+		s.srtt = rtt
+		s.rttvar = rtt / 2
+	} else {
+		delta := s.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		s.rttvar = (3*s.rttvar + delta) / 4
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
+	s.rto = s.srtt + 4*s.rttvar
+	if s.rto < streamMinRTO {
+		s.rto = streamMinRTO
+	}
+	if s.rto > streamMaxRTO {
+		s.rto = streamMaxRTO
+	}
+}
+
+// maybeFinish 双方都已关闭写入且数据都已确认时移除流
+func (s *P2PStream) maybeFinish() {
+	s.mu.Lock()
+	finished := s.finAcked && s.finReceived && s.err == nil
+	s.mu.Unlock()
+	if finished {
+		s.fail(ErrStreamClosed)
+	}
+}
+
+// Stats 发送状态，用于调试和显示
+func (s *P2PStream) Stats() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{
+		"id":          s.id,
+		"remote_key":  s.remoteKey,
+		"cwnd":        s.cwnd,
+		"ssthresh":    s.ssthresh,
+		"srtt_ms":     s.srtt.Milliseconds(),
+		"rto_ms":      s.rto.Milliseconds(),
+		"in_flight":   s.flight(),
+		"send_buffer": len(s.sendBuf),
+		"read_buffer": len(s.readBuf),
+	}
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type streamKey struct {
+	addr   string
+	id     uint32
+	opener bool // 本端打开的流
+}
+
+// streamMux 在一个 UDP 套接字上按（对方地址、流ID、打开方）区分多个流
+type streamMux struct {
+	write   func(data []byte, to *net.UDPAddr)
+	mtu     int
+	lookup  func(addr *net.UDPAddr) string
+	mu      sync.Mutex
+	streams map[streamKey]*P2PStream
+	// 已结束的流保留一段时间，继续确认对方重传的数据段
+	timeWait map[streamKey]*P2PStream
+	accept   chan *P2PStream
+	closed   bool
+}
+
+// newStreamMux 创建多路复用器，write 负责把流报文发给对方（加密后发送），mtu 为每个流报文的最大字节数
+func newStreamMux(write func(data []byte, to *net.UDPAddr), mtu int, lookup func(addr *net.UDPAddr) string) *streamMux {
+	return &streamMux{
+		write:    write,
+		mtu:      mtu,
+		lookup:   lookup,
+		streams:  make(map[streamKey]*P2PStream),
+		timeWait: make(map[streamKey]*P2PStream),
+		accept:   make(chan *P2PStream, streamAcceptBacklog),
+	}
+}
+
+func (m *streamMux) send(data []byte, to *net.UDPAddr) {
+	m.write(data, to)
+}
+
+// open 向 remote 打开新的流
+func (m *streamMux) open(remote *net.UDPAddr, remoteKey string) (*P2PStream, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrStreamClosed
+	}
+	for {
+		var buf [4]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			return nil, err
+		}
+		key := streamKey{addr: remote.String(), id: binary.BigEndian.Uint32(buf[:]), opener: true}
+		if _, exists := m.streams[key]; exists {
+			continue
+		}
+		if _, exists := m.timeWait[key]; exists {
+			continue
+		}
+		stream := newP2PStream(m, key.id, true, remote, remoteKey)
+		m.streams[key] = stream
+		return stream, nil
+	}
+}
+
+func (m *streamMux) handle(data []byte, from *net.UDPAddr) {
+	packet, err := decodeStreamPacket(data)
+	if err != nil {
+		return
+	}
+	// 对方是打开方时这是本端接受的流
+	key := streamKey{addr: from.String(), id: packet.StreamID, opener: packet.Flags&streamFlagOpener == 0}
+
+	m.mu.Lock()
+	stream, exists := m.streams[key]
+	if !exists {
+		if old, ok := m.timeWait[key]; ok {
+			m.mu.Unlock()
+			if packet.Type == streamPacketData {
+				old.mu.Lock()
+				ack := old.ackPacket().encode()
+				old.mu.Unlock()
+				m.send(ack, from)
+			}
+			return
+		}
+		if key.opener || packet.Type != streamPacketData || packet.Seq >= streamMaxWindow || m.closed {
+			m.mu.Unlock()
+			if packet.Type != streamPacketReset {
+				m.reset(packet.StreamID, !key.opener, from)
+			}
+			return
+		}
+		stream = newP2PStream(m, packet.StreamID, false, from, m.lookup(from))
+		select {
+		case m.accept <- stream:
+			m.streams[key] = stream
+		default:
+			// 没有人接受新的流
+			m.mu.Unlock()
+			stream.fail(ErrStreamClosed)
+			m.reset(packet.StreamID, false, from)
+			return
+		}
+	}
+	m.mu.Unlock()
+	stream.handle(packet, time.Now())
+}
+
+func (m *streamMux) reset(id uint32, opener bool, to *net.UDPAddr) {
+	packet := &streamPacket{Type: streamPacketReset, StreamID: id}
+	if opener {
+		packet.Flags = streamFlagOpener
+	}
+	m.send(packet.encode(), to)
+}
+
+func (m *streamMux) remove(stream *P2PStream) {
+	key := streamKey{addr: stream.remote.String(), id: stream.id, opener: stream.opener}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.streams[key] != stream {
+		return
+	}
+	delete(m.streams, key)
+	m.timeWait[key] = stream
+	time.AfterFunc(streamTimeWait, func() {
+		m.mu.Lock()
+		if m.timeWait[key] == stream {
+			delete(m.timeWait, key)
+		}
+		m.mu.Unlock()
+	})
+}
+
+// close 终止所有流
+func (m *streamMux) close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	streams := make([]*P2PStream, 0, len(m.streams))
+	for _, stream := range m.streams {
+		streams = append(streams, stream)
+	}
+	close(m.accept)
+	m.mu.Unlock()
+	for _, stream := range streams {
+		stream.fail(ErrStreamClosed)
+	}
+}
+
+// abort 终止与 remote 之间的所有流，连接断开时调用
+func (m *streamMux) abort(remote *net.UDPAddr, err error) {
+	m.mu.Lock()
+	var streams []*P2PStream
+	for key, stream := range m.streams {
+		if key.addr == remote.String() {
+			streams = append(streams, stream)
+		}
+	}
+	m.mu.Unlock()
+	for _, stream := range streams {
+		stream.fail(err)
+	}
+}
+
+// list 当前的流
+func (m *streamMux) list() []*P2PStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	streams := make([]*P2PStream, 0, len(m.streams))
+	for _, stream := range m.streams {
+		streams = append(streams, stream)
+	}
+	return streams
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyLink 在内存中连接两个多路复用器，按比例丢弃报文并随机延迟，延迟不同的报文会乱序到达
+type lossyLink struct {
+	mu       sync.Mutex
+	rng      *rand.Rand
+	loss     float64
+	maxDelay time.Duration
+	sent     int
+	dropped  int
+}
+
+// deliver 返回作为 streamMux.write 使用的函数，报文交给 to 处理时来源地址为 from
+func (l *lossyLink) deliver(to **streamMux, from *net.UDPAddr) func(data []byte, _ *net.UDPAddr) {
+	return func(data []byte, _ *net.UDPAddr) {
+		l.mu.Lock()
+		l.sent++
+		drop := l.rng.Float64() < l.loss
+		delay := time.Duration(l.rng.Int63n(int64(l.maxDelay) + 1))
+		if drop {
+			l.dropped++
+		}
+		l.mu.Unlock()
+		if drop {
+			return
+		}
+		packet := append([]byte(nil), data...)
+		time.AfterFunc(delay, func() { (*to).handle(packet, from) })
+	}
+}
+
+// newLossyStreamPair 创建通过 lossyLink 相连的两个多路复用器
+func newLossyStreamPair(t *testing.T, loss float64, maxDelay time.Duration) (a, b *streamMux, addrA, addrB *net.UDPAddr, link *lossyLink) {
+	link = &lossyLink{rng: rand.New(rand.NewSource(1)), loss: loss, maxDelay: maxDelay}
+	addrA = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}
+	addrB = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4000}
+	a = newStreamMux(link.deliver(&b, addrA), streamDefaultMTU, func(*net.UDPAddr) string { return "peer-b" })
+	b = newStreamMux(link.deliver(&a, addrB), streamDefaultMTU, func(*net.UDPAddr) string { return "peer-a" })
+	t.Cleanup(func() {
+		a.close()
+		b.close()
+	})
+	return a, b, addrA, addrB, link
+}
+
+// acceptStream 等待对方打开的流
+func acceptStream(t *testing.T, mux *streamMux) *P2PStream {
+	t.Helper()
+	select {
+	case stream := <-mux.accept:
+		return stream
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有收到对方打开的流")
+		return nil
+	}
+}
+
+func TestP2PStreamLossyReorderingLink(t *testing.T) {
+	a, b, _, addrB, link := newLossyStreamPair(t, 0.1, 20*time.Millisecond)
+	request := testContent(200 << 10)
+	reply := testContent(30 << 10)
+
+	stream, err := a.open(addrB, "peer-b")
+	if err != nil {
+		t.Fatalf("打开流失败: %v", err)
+	}
+	go func() {
+		stream.Write(request)
+		stream.Close()
+	}()
+
+	// 对方读完请求后回复，两个方向同时经过有丢包和乱序的链路
+	accepted := acceptStream(t, b)
+	served := make(chan error, 1)
+	go func() {
+		got, err := io.ReadAll(accepted)
+		if err == nil && !bytes.Equal(got, request) {
+			err = errors.New("收到的请求内容不一致")
+		}
+		if err == nil {
+			_, err = accepted.Write(reply)
+		}
+		if err == nil {
+			err = accepted.Close()
+		}
+		served <- err
+	}()
+
+	result := make(chan []byte, 1)
+	go func() {
+		got, _ := io.ReadAll(stream)
+		result <- got
+	}()
+	select {
+	case got := <-result:
+		if !bytes.Equal(got, reply) {
+			t.Fatalf("收到的回复长度为 %d，内容与发送的 %d 字节不一致", len(got), len(reply))
+		}
+	case <-time.After(60 * time.Second):
+		t.Fatalf("传输没有完成: %v", stream.Stats())
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("对方处理失败: %v", err)
+	}
+
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	if link.dropped == 0 {
+		t.Fatalf("链路应丢弃部分报文，共发送 %d 个", link.sent)
+	}
+}
+
+func TestP2PStreamAcceptIdleReset(t *testing.T) {
+	a, b, _, addrB, _ := newLossyStreamPair(t, 0, 0)
+	stream, err := a.open(addrB, "peer-b")
+	if err != nil {
+		t.Fatalf("打开流失败: %v", err)
+	}
+	if _, err := stream.Write([]byte("hello")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	accepted := acceptStream(t, b)
+	buf := make([]byte, 16)
+	if n, err := accepted.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("读取失败: %q %v", buf[:n], err)
+	}
+
+	// 打开方收到确认后不再发送；打开方自己的流空闲时不会被重置
+	deadline := time.Now().Add(5 * time.Second)
+	for stream.Stats()["in_flight"] != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("数据没有被确认: %v", stream.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stream.pump(time.Now().Add(streamAcceptIdle + time.Minute))
+	select {
+	case <-stream.done:
+		t.Fatal("打开方的空闲流不应被重置")
+	default:
+	}
+	accepted.pump(time.Now().Add(streamAcceptIdle - time.Second))
+	select {
+	case <-accepted.done:
+		t.Fatal("未到空闲时间的流不应被重置")
+	default:
+	}
+
+	// 接受的流空闲超过 streamAcceptIdle 后重置，并通知打开方
+	accepted.pump(time.Now().Add(streamAcceptIdle + time.Second))
+	if _, err := accepted.Read(buf); !errors.Is(err, ErrStreamTimeout) {
+		t.Fatalf("空闲的流应超时，实际为 %v", err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := stream.Read(buf)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrStreamReset) {
+			t.Fatalf("打开方应收到重置，实际为 %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("打开方没有收到重置")
+	}
+	if len(b.list()) != 0 {
+		t.Fatal("重置后的流应从多路复用器中移除")
+	}
+}
@@ -0,0 +1,891 @@
+package services
+
+import (
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrP2POfferNotFound 文件提议不存在或当前用户无权查看
+var ErrP2POfferNotFound = errors.New("文件提议不存在")
+
+// p2pMaxPendingOffers 每个对端最多同时等待处理的文件提议数
+const p2pMaxPendingOffers = 32
+
+// 文件提议的方向和状态，运行中和结束后的状态与传输任务相同
+const (
+	P2POfferOutgoing = "outgoing" // 本节点发给对端
+	P2POfferIncoming = "incoming" // 对端发给本节点
+	P2POfferPending  = "pending"  // 等待接收方接受或拒绝
+	P2POfferRejected = "rejected"
+)
+
+// P2POffer 通过P2P连接发送的文件提议。接收方接受后以 p2p://<发送方Key>/<提议ID> 为数据源创建下载任务，
+// 按发送方的校验清单逐块拉取和校验；发送方对应一个上传任务。下载失败后再次接受会从已完成的块继续
+type P2POffer struct {
+	ID        string    `json:"id"`
+	Direction string    `json:"direction"`
+	PeerKey   string    `json:"peer_key"`
+	NodeID    string    `json:"node_id"` // 发送方：被发送的文件节点；接收方：保存后新建的文件节点
+	FileName  string    `json:"file_name"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	From      string    `json:"from"`              // 发起发送的用户
+	ToUser    string    `json:"to_user,omitempty"` // 接收方的用户，为空时由管理员处理
+	Owner     string    `json:"owner"`             // 发送方为发起用户，接收方为接受的用户
+	ParentID  string    `json:"parent_id,omitempty"`
+	State     string    `json:"state"`
+	TaskID    string    `json:"task_id,omitempty"` // 对应的传输任务，与事件流中的 task_id 一致
+	Progress  float64   `json:"progress"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	filePath  string
+	authLevel int
+	chunkSize int64
+	manifest  *models.FileManifest
+	upload    *FileTask
+}
+
+func (o *P2POffer) finished() bool {
+	return o.State == TaskStateCompleted || o.State == TaskStateCancelled || o.State == P2POfferRejected
+}
+
+// p2pTransferMessage 文件频道上信封的负载，每个请求打开一个新的流
+type p2pTransferMessage struct {
+	Type      string               `json:"type"`
+	OfferID   string               `json:"offer_id,omitempty"`
+	FileName  string               `json:"file_name,omitempty"`
+	Size      int64                `json:"size,omitempty"`
+	SHA256    string               `json:"sha256,omitempty"`
+	ChunkSize int64                `json:"chunk_size,omitempty"`
+	From      string               `json:"from,omitempty"`
+	ToUser    string               `json:"to_user,omitempty"`
+	Start     int64                `json:"start,omitempty"`
+	End       int64                `json:"end,omitempty"`
+	Manifest  *models.FileManifest `json:"manifest,omitempty"`
+	Message   string               `json:"message,omitempty"`
+}
+
+// writeP2PFrame 把消息放进文件频道的信封写入流
+func writeP2PFrame(w io.Writer, msg *p2pTransferMessage) error {
+	env, err := newP2PEnvelope(P2PChannelFile, msg.Type, msg)
+	if err != nil {
+		return err
+	}
+	return writeP2PEnvelope(w, env)
+}
+
+func readP2PFrame(r io.Reader) (*p2pTransferMessage, error) {
+	env, err := readP2PEnvelope(r)
+	if err != nil {
+		return nil, err
+	}
+	return p2pTransferPayload(env)
+}
+
+// p2pTransferPayload 取出文件频道信封中的消息，控制频道的错误转换为 error 类型的消息
+func p2pTransferPayload(env *P2PEnvelope) (*p2pTransferMessage, error) {
+	switch env.Channel {
+	case P2PChannelFile:
+		var msg p2pTransferMessage
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	case P2PChannelControl:
+		if env.Type == "error" {
+			return &p2pTransferMessage{Type: "error", Message: env.Text()}, nil
+		}
+	}
+	return nil, fmt.Errorf("意外的消息: %s/%s", env.Channel, env.Type)
+}
+
+// P2PTransferManager 管理本节点发出和收到的文件提议，并处理对端的数据请求
+type P2PTransferManager struct {
+	client *EnhancedUdpClient
+	nodes  FileNodeStore
+
+	mu     sync.Mutex
+	offers map[string]*P2POffer
+}
+
+var (
+	p2pManagersMu sync.RWMutex
+	p2pManagers   = make(map[string]*P2PTransferManager)
+)
+
+func init() {
+	RegisterSourceScheme("p2p", newP2PSource)
+}
+
+// NewP2PTransferManager 创建传输管理器，处理对端在文件频道上的请求
+func NewP2PTransferManager(client *EnhancedUdpClient, nodes FileNodeStore) *P2PTransferManager {
+	m := &P2PTransferManager{
+		client: client,
+		nodes:  nodes,
+		offers: make(map[string]*P2POffer),
+	}
+	p2pManagersMu.Lock()
+	p2pManagers[client.clientKey] = m
+	p2pManagersMu.Unlock()
+	client.HandleChannel(P2PChannelFile, m.handleStream)
+	return m
+}
+
+// OfferFile 把文件节点提议给已知Key的对端，toUser 为对端节点上的接收用户
+func (m *P2PTransferManager) OfferFile(caller Caller, peerKey, nodeID, toUser string) (*P2POffer, error) {
+	objID, err := primitive.ObjectIDFromHex(nodeID)
+	if err != nil {
+		return nil, ErrInvalidNodeID
+	}
+	node, err := m.nodes.Get(objID)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, ErrNodeNotFound
+	}
+	if node.EffectiveAuthLevel > caller.AuthLevel {
+		return nil, ErrPermissionDenied
+	}
+	if node.Type {
+		return nil, errors.New("只能发送文件，不能发送文件夹")
+	}
+	filePath := LocalFilePath(*node)
+	if filePath == "" {
+		return nil, errors.New("本节点没有该文件的副本")
+	}
+	manifest, err := BuildFileManifest(filePath, p2pChunkSize())
+	if err != nil {
+		return nil, fmt.Errorf("生成校验清单失败: %w", err)
+	}
+
+	offer := &P2POffer{
+		ID:        newP2PID(),
+		Direction: P2POfferOutgoing,
+		PeerKey:   peerKey,
+		NodeID:    nodeID,
+		FileName:  node.Name,
+		Size:      manifest.FileSize,
+		SHA256:    manifest.FileHash,
+		From:      caller.Name,
+		ToUser:    toUser,
+		Owner:     caller.Name,
+		State:     P2POfferPending,
+		CreatedAt: time.Now(),
+		filePath:  filePath,
+		chunkSize: manifest.ChunkSize,
+		manifest:  manifest,
+	}
+	m.mu.Lock()
+	m.offers[offer.ID] = offer
+	m.mu.Unlock()
+
+	_, err = m.call(peerKey, &p2pTransferMessage{
+		Type:      "offer",
+		OfferID:   offer.ID,
+		FileName:  offer.FileName,
+		Size:      offer.Size,
+		SHA256:    offer.SHA256,
+		ChunkSize: offer.chunkSize,
+		From:      caller.Name,
+		ToUser:    toUser,
+	})
+	if err != nil {
+		m.mu.Lock()
+		delete(m.offers, offer.ID)
+		m.mu.Unlock()
+		return nil, fmt.Errorf("发送文件提议失败: %w", err)
+	}
+	return offer.snapshot(&m.mu), nil
+}
+
+// Accept 接受对端的文件提议，文件保存到 parentID 文件夹
+func (m *P2PTransferManager) Accept(caller Caller, offerID, parentID string) (*P2POffer, error) {
+	service := GetTransferService()
+	if service == nil {
+		return nil, errors.New("传输服务未初始化")
+	}
+	if parentID == "" || parentID == "undefined" || parentID == "null" {
+		parentID = "root"
+	}
+	if err := m.checkParent(parentID, caller.AuthLevel); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	offer, ok := m.offers[offerID]
+	if !ok || offer.Direction != P2POfferIncoming || !offer.visibleTo(caller) {
+		m.mu.Unlock()
+		return nil, ErrP2POfferNotFound
+	}
+	if offer.State != P2POfferPending && offer.State != TaskStateFailed {
+		state := offer.State
+		m.mu.Unlock()
+		return nil, fmt.Errorf("文件提议状态为 %s，不能接受", state)
+	}
+	if offer.filePath == "" {
+		// 失败后重新接受时沿用之前的路径，从已完成的块继续
+		offer.FileName, offer.filePath = m.reserveOfferPath(offer.FileName)
+	}
+	offer.ParentID = parentID
+	offer.Owner = caller.Name
+	offer.authLevel = caller.AuthLevel
+	offer.State = TaskStateQueued
+	offer.Error = ""
+	peerKey, filePath, expectedHash := offer.PeerKey, offer.filePath, offer.SHA256
+	m.mu.Unlock()
+
+	if _, err := m.call(peerKey, &p2pTransferMessage{Type: "accept", OfferID: offerID}); err != nil {
+		m.setFailed(offer, err)
+		return nil, fmt.Errorf("通知发送方失败: %w", err)
+	}
+
+	source := p2pSourceURL(peerKey, offerID, m.client.clientKey)
+	var startErr error
+	taskID := service.AddDownloadTaskWithOptions(source, filePath, DownloadOptions{
+		ManifestURL:  source,
+		ExpectedHash: expectedHash,
+		Owner:        caller.Name,
+		Priority:     utils.PriorityNormal,
+		TaskID:       "p2p_dl_" + offerID,
+	},
+		func(progress float64) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if !offer.finished() {
+				offer.State = TaskStateRunning
+				offer.Progress = progress
+			}
+		},
+		func(task *FileTask) {
+			m.completeIncoming(offer, task)
+		},
+		func(task *FileTask, err error) {
+			if task == nil {
+				startErr = err
+				return
+			}
+			m.failIncoming(offer, task, err)
+		})
+	if taskID == "" {
+		if startErr == nil {
+			startErr = errors.New("创建下载任务失败")
+		}
+		m.setFailed(offer, startErr)
+		m.notify(peerKey, &p2pTransferMessage{Type: "failed", OfferID: offerID, Message: startErr.Error()})
+		return nil, startErr
+	}
+	m.mu.Lock()
+	offer.TaskID = taskID
+	m.mu.Unlock()
+	return offer.snapshot(&m.mu), nil
+}
+
+// Reject 拒绝对端的文件提议
+func (m *P2PTransferManager) Reject(caller Caller, offerID string) error {
+	m.mu.Lock()
+	offer, ok := m.offers[offerID]
+	if !ok || offer.Direction != P2POfferIncoming || !offer.visibleTo(caller) {
+		m.mu.Unlock()
+		return ErrP2POfferNotFound
+	}
+	if offer.State != P2POfferPending && offer.State != TaskStateFailed {
+		state := offer.State
+		m.mu.Unlock()
+		return fmt.Errorf("文件提议状态为 %s，不能拒绝", state)
+	}
+	offer.State = P2POfferRejected
+	m.releasePathLocked(offer)
+	peerKey := offer.PeerKey
+	m.mu.Unlock()
+	m.notify(peerKey, &p2pTransferMessage{Type: "reject", OfferID: offerID})
+	return nil
+}
+
+// Cancel 取消发出或收到的文件提议，进行中的传输随之停止
+func (m *P2PTransferManager) Cancel(caller Caller, offerID string) error {
+	m.mu.Lock()
+	offer, ok := m.offers[offerID]
+	if !ok || !offer.visibleTo(caller) {
+		m.mu.Unlock()
+		return ErrP2POfferNotFound
+	}
+	if offer.finished() {
+		state := offer.State
+		m.mu.Unlock()
+		return fmt.Errorf("文件提议已结束: %s", state)
+	}
+	peerKey, taskID, upload, direction := offer.PeerKey, offer.TaskID, offer.upload, offer.Direction
+	running := direction == P2POfferIncoming && (offer.State == TaskStateQueued || offer.State == TaskStateRunning)
+	if !running {
+		offer.State = TaskStateCancelled
+		m.releasePathLocked(offer)
+	}
+	m.mu.Unlock()
+
+	if service := GetTransferService(); service != nil {
+		if running {
+			// 下载任务的错误回调会清理临时文件并更新状态
+			service.CancelTask(taskID)
+		} else if upload != nil {
+			service.CancelTask(upload.ID)
+			service.FinishUploadTask(upload, errTaskCancelled)
+		}
+	}
+	m.notify(peerKey, &p2pTransferMessage{Type: "cancel", OfferID: offerID})
+	return nil
+}
+
+// List 返回用户发出的和可以处理的文件提议，新的在前
+func (m *P2PTransferManager) List(caller Caller) []P2POffer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]P2POffer, 0)
+	for _, offer := range m.offers {
+		if offer.visibleTo(caller) {
+			result = append(result, *offer)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// visibleTo 发出的提议只有发起用户可见；收到的提议指定了用户时只有该用户可见，否则由管理员处理
+func (o *P2POffer) visibleTo(caller Caller) bool {
+	if o.Direction == P2POfferOutgoing {
+		return o.Owner == caller.Name
+	}
+	if o.ToUser != "" {
+		return o.ToUser == caller.Name
+	}
+	return caller.IsAdmin()
+}
+
+func (o *P2POffer) snapshot(mu *sync.Mutex) *P2POffer {
+	mu.Lock()
+	defer mu.Unlock()
+	copied := *o
+	return &copied
+}
+
+// completeIncoming 下载并校验完成后创建文件节点并通知发送方
+func (m *P2PTransferManager) completeIncoming(offer *P2POffer, task *FileTask) {
+	m.mu.Lock()
+	parentID, fileName, authLevel, peerKey := offer.ParentID, offer.FileName, offer.authLevel, offer.PeerKey
+	m.mu.Unlock()
+
+	node, err := m.nodes.Create(task.FilePath, fileName, parentID, authLevel)
+
+	m.mu.Lock()
+	m.releasePathLocked(offer)
+	if err != nil {
+		logger.Errorf("Error adding file node for P2P offer %s: %v", offer.ID, err)
+		color.Red("Error adding file node for P2P offer %s: %v", offer.ID, err)
+		if removeErr := os.Remove(task.FilePath); removeErr != nil {
+			color.Red("Error removing received file %s: %v", task.FilePath, removeErr)
+		}
+		offer.State = TaskStateFailed
+		offer.Error = "添加文件节点失败: " + err.Error()
+		m.mu.Unlock()
+		m.notify(peerKey, &p2pTransferMessage{Type: "failed", OfferID: offer.ID, Message: offer.Error})
+		return
+	}
+	offer.State = TaskStateCompleted
+	offer.Progress = 100
+	offer.NodeID = node.ID.Hex()
+	m.mu.Unlock()
+	color.Green("P2P接收完成: %s 来自 %s", fileName, peerKey)
+	m.notify(peerKey, &p2pTransferMessage{Type: "done", OfferID: offer.ID})
+}
+
+// failIncoming 取消时清理临时文件；其他失败保留已下载的块，再次接受时继续
+func (m *P2PTransferManager) failIncoming(offer *P2POffer, task *FileTask, err error) {
+	cancelled := errors.Is(err, errTaskCancelled)
+	if cancelled {
+		if service := GetTransferService(); service != nil {
+			service.DiscardTaskFiles(task)
+		}
+	}
+	m.mu.Lock()
+	peerKey := offer.PeerKey
+	if cancelled {
+		offer.State = TaskStateCancelled
+		m.releasePathLocked(offer)
+	} else {
+		offer.State = TaskStateFailed
+		offer.Error = err.Error()
+	}
+	m.mu.Unlock()
+	if !cancelled {
+		m.notify(peerKey, &p2pTransferMessage{Type: "failed", OfferID: offer.ID, Message: err.Error()})
+	}
+}
+
+func (m *P2PTransferManager) setFailed(offer *P2POffer, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	offer.State = TaskStateFailed
+	offer.Error = err.Error()
+}
+
+// checkParent 校验目标节点是用户有权限访问的文件夹
+func (m *P2PTransferManager) checkParent(parentID string, authLevel int) error {
+	if parentID == "root" {
+		return nil
+	}
+	objID, err := primitive.ObjectIDFromHex(parentID)
+	if err != nil {
+		return fmt.Errorf("无效的父节点ID: %s", parentID)
+	}
+	node, err := m.nodes.Get(objID)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return fmt.Errorf("目标文件夹不存在")
+	}
+	if !node.Type {
+		return fmt.Errorf("目标节点不是文件夹")
+	}
+	if node.EffectiveAuthLevel > authLevel {
+		return fmt.Errorf("没有目标文件夹的权限")
+	}
+	return nil
+}
+
+// reserveOfferPath 在存储目录中为接收的文件保留未被占用的路径
+func (m *P2PTransferManager) reserveOfferPath(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		name = "p2p-download"
+	}
+	return reserveStorePath(name)
+}
+
+func (m *P2PTransferManager) releasePathLocked(offer *P2POffer) {
+	if offer.Direction == P2POfferIncoming && offer.filePath != "" {
+		utils.GlobalPathReserver.Release(offer.filePath)
+	}
+}
+
+// handleStream 处理对端的一个请求
+func (m *P2PTransferManager) handleStream(stream *P2PStream, env *P2PEnvelope) {
+	defer stream.Close()
+	req, err := p2pTransferPayload(env)
+	if err != nil {
+		stream.Reset()
+		return
+	}
+	peerKey := stream.RemoteKey()
+	if peerKey == "" {
+		writeP2PFrame(stream, &p2pTransferMessage{Type: "error", Message: "未建立P2P连接"})
+		return
+	}
+
+	var resp *p2pTransferMessage
+	switch req.Type {
+	case "offer":
+		resp = m.handleOffer(peerKey, req)
+	case "chunk":
+		m.serveChunk(stream, peerKey, req)
+		return
+	default:
+		resp = m.handleControl(peerKey, req)
+	}
+	writeP2PFrame(stream, resp)
+}
+
+// handleOffer 记录对端发来的文件提议，等待用户处理
+func (m *P2PTransferManager) handleOffer(peerKey string, req *p2pTransferMessage) *p2pTransferMessage {
+	// 提议ID会用作任务ID和元数据文件名，只接受 newP2PID 生成的格式
+	if !isP2PID(req.OfferID) || req.Size < 0 || req.FileName == "" {
+		return &p2pTransferMessage{Type: "error", Message: "无效的文件提议"}
+	}
+	offer := &P2POffer{
+		ID:        req.OfferID,
+		Direction: P2POfferIncoming,
+		PeerKey:   peerKey,
+		FileName:  req.FileName,
+		Size:      req.Size,
+		SHA256:    req.SHA256,
+		From:      req.From,
+		ToUser:    req.ToUser,
+		State:     P2POfferPending,
+		CreatedAt: time.Now(),
+		chunkSize: req.ChunkSize,
+	}
+	m.mu.Lock()
+	if _, exists := m.offers[offer.ID]; exists {
+		m.mu.Unlock()
+		return &p2pTransferMessage{Type: "error", Message: "重复的文件提议"}
+	}
+	if m.pendingFromLocked(peerKey) >= p2pMaxPendingOffers {
+		m.mu.Unlock()
+		return &p2pTransferMessage{Type: "error", Message: "待处理的文件提议过多"}
+	}
+	m.offers[offer.ID] = offer
+	m.mu.Unlock()
+
+	color.Yellow("收到 %s 的文件提议: %s (%d 字节)", peerKey, offer.FileName, offer.Size)
+	GetEventHub().Publish(TransferEvent{
+		Type:    EventState,
+		Kind:    "p2p-offer",
+		TaskID:  offer.ID,
+		User:    offer.ToUser,
+		Name:    offer.FileName,
+		State:   P2POfferPending,
+		Message: fmt.Sprintf("%s 通过 %s 发送", offer.From, peerKey),
+	})
+	return &p2pTransferMessage{Type: "ok"}
+}
+
+// pendingFromLocked 对端发来、还没有处理的提议数，调用时持有 m.mu
+func (m *P2PTransferManager) pendingFromLocked(peerKey string) int {
+	count := 0
+	for _, offer := range m.offers {
+		if offer.Direction == P2POfferIncoming && offer.PeerKey == peerKey && offer.State == P2POfferPending {
+			count++
+		}
+	}
+	return count
+}
+
+// handleControl 处理接受、拒绝、取消、完成等消息以及元数据请求
+func (m *P2PTransferManager) handleControl(peerKey string, req *p2pTransferMessage) *p2pTransferMessage {
+	m.mu.Lock()
+	offer, ok := m.offers[req.OfferID]
+	if !ok || offer.PeerKey != peerKey {
+		m.mu.Unlock()
+		return &p2pTransferMessage{Type: "error", Message: ErrP2POfferNotFound.Error()}
+	}
+
+	switch req.Type {
+	case "cancel":
+		if offer.finished() {
+			break
+		}
+		if offer.Direction == P2POfferIncoming {
+			running := offer.State == TaskStateQueued || offer.State == TaskStateRunning
+			taskID := offer.TaskID
+			if !running {
+				offer.State = TaskStateCancelled
+				m.releasePathLocked(offer)
+			}
+			m.mu.Unlock()
+			if service := GetTransferService(); running && service != nil {
+				service.CancelTask(taskID)
+			}
+			return &p2pTransferMessage{Type: "ok"}
+		}
+		offer.State = TaskStateCancelled
+		upload := offer.upload
+		m.mu.Unlock()
+		if service := GetTransferService(); upload != nil && service != nil {
+			service.CancelTask(upload.ID)
+			service.FinishUploadTask(upload, errTaskCancelled)
+		}
+		return &p2pTransferMessage{Type: "ok"}
+	}
+
+	// 其余消息只针对本节点发出的提议
+	if offer.Direction != P2POfferOutgoing {
+		m.mu.Unlock()
+		return &p2pTransferMessage{Type: "error", Message: ErrP2POfferNotFound.Error()}
+	}
+	switch req.Type {
+	case "accept":
+		if offer.State != P2POfferPending && offer.State != TaskStateFailed && offer.State != TaskStateRunning {
+			state := offer.State
+			m.mu.Unlock()
+			return &p2pTransferMessage{Type: "error", Message: "文件提议状态为 " + state}
+		}
+		if offer.upload == nil {
+			service := GetTransferService()
+			if service == nil {
+				m.mu.Unlock()
+				return &p2pTransferMessage{Type: "error", Message: "传输服务未初始化"}
+			}
+			task, err := service.AddUploadTask("p2p_up_"+offer.ID, offer.filePath, offer.FileName, offer.Owner, offer.Size, offer.chunkSize)
+			if err != nil {
+				m.mu.Unlock()
+				return &p2pTransferMessage{Type: "error", Message: err.Error()}
+			}
+			task.OnProgress = func(progress float64) {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				offer.Progress = progress
+			}
+			offer.upload = task
+			offer.TaskID = task.ID
+		}
+		offer.State = TaskStateRunning
+		offer.Error = ""
+	case "reject":
+		offer.State = P2POfferRejected
+	case "done", "failed":
+		upload := offer.upload
+		offer.upload = nil
+		var finishErr error
+		if req.Type == "done" {
+			offer.State = TaskStateCompleted
+			offer.Progress = 100
+		} else {
+			offer.State = TaskStateFailed
+			offer.Error = req.Message
+			finishErr = errors.New("接收方: " + req.Message)
+		}
+		m.mu.Unlock()
+		if service := GetTransferService(); upload != nil && service != nil {
+			service.FinishUploadTask(upload, finishErr)
+		}
+		return &p2pTransferMessage{Type: "ok"}
+	case "stat", "manifest":
+		if offer.upload == nil {
+			m.mu.Unlock()
+			return &p2pTransferMessage{Type: "error", Message: "文件提议尚未被接受"}
+		}
+		resp := &p2pTransferMessage{Type: "ok", Size: offer.Size, SHA256: offer.SHA256, ChunkSize: offer.chunkSize}
+		if req.Type == "manifest" {
+			resp.Manifest = offer.manifest
+		}
+		m.mu.Unlock()
+		return resp
+	default:
+		m.mu.Unlock()
+		return &p2pTransferMessage{Type: "error", Message: "未知的请求: " + req.Type}
+	}
+	m.mu.Unlock()
+	return &p2pTransferMessage{Type: "ok"}
+}
+
+// serveChunk 发送 [Start, End] 字节区间，受上传用户和任务的限速约束
+func (m *P2PTransferManager) serveChunk(stream *P2PStream, peerKey string, req *p2pTransferMessage) {
+	m.mu.Lock()
+	offer, ok := m.offers[req.OfferID]
+	var upload *FileTask
+	var filePath, owner string
+	var size int64
+	if ok && offer.PeerKey == peerKey && offer.Direction == P2POfferOutgoing {
+		upload, filePath, owner, size = offer.upload, offer.filePath, offer.Owner, offer.Size
+	}
+	m.mu.Unlock()
+
+	fail := func(message string) {
+		writeP2PFrame(stream, &p2pTransferMessage{Type: "error", Message: message})
+	}
+	if upload == nil {
+		fail("文件提议未被接受或已结束")
+		return
+	}
+	if req.Start < 0 || req.End < req.Start || req.End >= size {
+		fail(fmt.Sprintf("无效的区间 %d-%d", req.Start, req.End))
+		return
+	}
+	select {
+	case <-upload.cancel:
+		fail(errTaskCancelled.Error())
+		return
+	default:
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		fail("打开文件失败")
+		return
+	}
+	defer file.Close()
+
+	length := req.End - req.Start + 1
+	if err := writeP2PFrame(stream, &p2pTransferMessage{Type: "data", Start: req.Start, End: req.End, Size: length}); err != nil {
+		return
+	}
+	body := GetBandwidthManager().Reader(context.Background(), io.NewSectionReader(file, req.Start, length), owner, upload.ID)
+	if _, err := io.CopyN(stream, body, length); err != nil {
+		stream.Reset()
+		return
+	}
+	if upload.ChunkSize > 0 && req.Start%upload.ChunkSize == 0 {
+		if service := GetTransferService(); service != nil {
+			service.MarkUploadChunk(upload, int(req.Start/upload.ChunkSize))
+		}
+	}
+}
+
+// open 打开到对端的流并发送请求，必要时先建立P2P连接
+func (m *P2PTransferManager) open(peerKey string, req *p2pTransferMessage) (*P2PStream, *p2pTransferMessage, error) {
+	env, err := newP2PEnvelope(P2PChannelFile, req.Type, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	stream, err := m.client.OpenChannel(peerKey, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := readP2PFrame(stream)
+	if err != nil {
+		stream.Reset()
+		return nil, nil, err
+	}
+	if resp.Type == "error" {
+		stream.Close()
+		return nil, nil, errors.New(resp.Message)
+	}
+	return stream, resp, nil
+}
+
+// call 发送一个请求并等待回复
+func (m *P2PTransferManager) call(peerKey string, req *p2pTransferMessage) (*p2pTransferMessage, error) {
+	stream, resp, err := m.open(peerKey, req)
+	if err != nil {
+		return nil, err
+	}
+	stream.Close()
+	return resp, nil
+}
+
+// notify 发送通知，失败时只记录日志
+func (m *P2PTransferManager) notify(peerKey string, req *p2pTransferMessage) {
+	go func() {
+		if _, err := m.call(peerKey, req); err != nil {
+			logger.Errorf("通知 %s 失败 (%s %s): %v", peerKey, req.Type, req.OfferID, err)
+		}
+	}()
+}
+
+func newP2PID() string {
+	var buf [12]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return fmt.Sprintf("%024x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// isP2PID 是否为 newP2PID 生成的24位十六进制ID
+func isP2PID(id string) bool {
+	if len(id) != 24 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// p2pChunkSize P2P传输的分块大小，与传输服务一致
+func p2pChunkSize() int64 {
+	if service := GetTransferService(); service != nil && service.config.ChunkSize > 0 {
+		return service.config.ChunkSize
+	}
+	return 1 << 20
+}
+
+// p2pSourceURL 数据源地址 p2p://<对端Key>/<提议ID>?via=<本端Key>
+func p2pSourceURL(peerKey, offerID, localKey string) string {
+	u := url.URL{Scheme: "p2p", Host: peerKey, Path: "/" + offerID, RawQuery: url.Values{"via": {localKey}}.Encode()}
+	return u.String()
+}
+
+// p2pSource 从P2P对端按块拉取文件
+type p2pSource struct {
+	raw     string
+	manager *P2PTransferManager
+	peerKey string
+	offerID string
+}
+
+func newP2PSource(u *url.URL) (ChunkSource, error) {
+	p2pManagersMu.RLock()
+	manager := p2pManagers[u.Query().Get("via")]
+	if manager == nil && GlobalP2PTransferManager != nil {
+		manager = GlobalP2PTransferManager
+	}
+	p2pManagersMu.RUnlock()
+	if manager == nil {
+		return nil, errors.New("P2P传输未启用")
+	}
+	offerID := strings.Trim(u.Path, "/")
+	if u.Host == "" || offerID == "" {
+		return nil, fmt.Errorf("无效的P2P数据源: %s", u.String())
+	}
+	return &p2pSource{raw: u.String(), manager: manager, peerKey: u.Host, offerID: offerID}, nil
+}
+
+func (p *p2pSource) Name() string {
+	return p.raw
+}
+
+// Probe 向发送方查询文件大小，文件哈希作为 ETag，发送方文件变化时之前下载的块作废
+func (p *p2pSource) Probe(task *FileTask) (*probeResult, error) {
+	resp, err := p.manager.call(p.peerKey, &p2pTransferMessage{Type: "stat", OfferID: p.offerID})
+	if err != nil {
+		return nil, err
+	}
+	return &probeResult{size: resp.Size, rangeSupported: true, etag: resp.SHA256}, nil
+}
+
+// Manifest 获取发送方生成的校验清单
+func (p *p2pSource) Manifest() (*models.FileManifest, error) {
+	resp, err := p.manager.call(p.peerKey, &p2pTransferMessage{Type: "manifest", OfferID: p.offerID})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Manifest == nil {
+		return nil, errors.New("发送方没有返回校验清单")
+	}
+	return resp.Manifest, nil
+}
+
+// FetchChunk 请求一个字节区间并写入文件，ctx 结束时重置流
+func (p *p2pSource) FetchChunk(ctx context.Context, task *FileTask, w io.WriterAt, start, end int64) error {
+	stream, resp, err := p.manager.open(p.peerKey, &p2pTransferMessage{Type: "chunk", OfferID: p.offerID, Start: start, End: end})
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	stop := context.AfterFunc(ctx, stream.Reset)
+	defer stop()
+	expected := end - start + 1
+	if resp.Type != "data" || resp.Start != start || resp.Size != expected {
+		stream.Reset()
+		return fmt.Errorf("对端返回的区间不匹配: 请求 %d-%d", start, end)
+	}
+	body := GetBandwidthManager().Reader(ctx, stream, task.Owner, task.ID)
+	written, err := io.CopyN(io.NewOffsetWriter(w, start), body, expected)
+	if err != nil {
+		stream.Reset()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("分块被截断: 预期 %d 字节，实际 %d 字节", expected, written)
+		}
+		return err
+	}
+	return nil
+}
+
+// GlobalP2PTransferManager 全局P2P文件传输管理器
+var GlobalP2PTransferManager *P2PTransferManager
+
+// InitP2PTransfer 在P2P客户端上启用文件传输
+func InitP2PTransfer(client *EnhancedUdpClient) {
+	GlobalP2PTransferManager = NewP2PTransferManager(client, MongoNodeStore)
+}
+
+// GetP2PTransferManager 获取全局P2P文件传输管理器，P2P客户端未初始化时为 nil
+func GetP2PTransferManager() *P2PTransferManager {
+	return GlobalP2PTransferManager
+}
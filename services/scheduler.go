@@ -0,0 +1,486 @@
+package services
+
+import (
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrScheduleNotFound 定时任务不存在或不属于当前用户
+var ErrScheduleNotFound = errors.New("定时任务不存在")
+
+const (
+	scheduleRunHistory = 100             // 每个定时任务保留的触发记录数
+	scheduleCatchUpMax = 24              // all 策略最多补跑的次数，更早的按跳过处理
+	scheduleGrace      = 2 * time.Minute // 晚于计划时间不超过该值的触发视为准时
+)
+
+// schedulableKinds 允许定时执行的后台任务类型，参数均为 ImportJobParams
+var schedulableKinds = map[string]bool{
+	JobKindImport: true,
+	JobKindSync:   true,
+}
+
+// ScheduleSpec 创建或修改定时任务的参数
+type ScheduleSpec struct {
+	Name     string          `json:"name"`
+	Cron     string          `json:"cron"`
+	Timezone string          `json:"timezone"`
+	Kind     string          `json:"kind"`     // import：每次下载一份新文件；sync：有变化时替换同名文件
+	CatchUp  string          `json:"catch_up"` // skip、once 或 all，默认 once
+	Enabled  *bool           `json:"enabled"`  // 创建时默认启用，修改时不传则保持不变
+	Params   ImportJobParams `json:"params"`
+}
+
+// Scheduler 按 cron 表达式向后台任务管理器提交任务，计划保存在 MongoDB 中，重启后继续执行
+type Scheduler struct {
+	jobs *JobManager
+	mu   sync.Mutex // 串行化触发和修改，避免同一计划被重复提交
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewScheduler 创建调度器
+func NewScheduler(jobs *JobManager) *Scheduler {
+	return &Scheduler{
+		jobs: jobs,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Start 启动调度循环，启动时立即处理停机期间错过的计划
+func (s *Scheduler) Start() {
+	go s.loop()
+}
+
+// Stop 停止调度循环
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// loop 每分钟开始时检查一次到期的计划
+func (s *Scheduler) loop() {
+	defer close(s.done)
+	for {
+		s.tick(time.Now())
+
+		now := time.Now()
+		wait := now.Truncate(time.Minute).Add(time.Minute + time.Second).Sub(now)
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// tick 触发所有到期的计划
+func (s *Scheduler) tick(now time.Time) {
+	due, err := models.ListDueSchedules(now)
+	if err != nil {
+		logger.Errorf("Error listing due schedules: %v", err)
+		color.Red("Error listing due schedules: %v", err)
+		return
+	}
+	for i := range due {
+		s.fire(due[i].ID, now)
+	}
+}
+
+// fire 按补跑策略处理一个到期的计划，并计算下一次执行时间
+func (s *Scheduler) fire(id primitive.ObjectID, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 重新读取，计划可能在查询之后被修改或删除
+	schedule, err := models.GetSchedule(id)
+	if err != nil || schedule == nil || !schedule.Enabled || schedule.NextRun.After(now) {
+		return
+	}
+
+	cron, err := parseScheduleCron(schedule.Cron, schedule.Timezone)
+	if err != nil {
+		s.record(schedule, &models.ScheduleRun{ScheduledAt: schedule.NextRun, Result: models.ScheduleRunError, Message: err.Error()})
+		s.save(schedule, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	// 收集 next_run 到现在之间的所有计划时间，只保留最近的 scheduleCatchUpMax 个
+	var dueTimes []time.Time
+	total := 0
+	for t := schedule.NextRun; !t.IsZero() && !t.After(now); t = cron.Next(t) {
+		total++
+		dueTimes = append(dueTimes, t)
+		if len(dueTimes) > scheduleCatchUpMax {
+			dueTimes = dueTimes[1:]
+		}
+	}
+	if len(dueTimes) == 0 {
+		// 没有有效的计划时间（例如手动修改过数据库），从现在重新计算
+		s.save(schedule, map[string]interface{}{"next_run": cron.Next(now)})
+		return
+	}
+	latest := dueTimes[len(dueTimes)-1]
+	onTime := now.Sub(latest) <= scheduleGrace
+
+	fields := map[string]interface{}{"last_run": now}
+	next := cron.Next(now)
+	fields["next_run"] = next
+	if next.IsZero() {
+		fields["enabled"] = false
+	}
+
+	if running := s.previousRunning(schedule); running != "" {
+		s.record(schedule, &models.ScheduleRun{
+			ScheduledAt: latest,
+			Result:      models.ScheduleRunSkipped,
+			Missed:      total,
+			Message:     "上一次执行的任务 " + running + " 尚未结束",
+		})
+		s.save(schedule, fields)
+		return
+	}
+
+	switch {
+	case onTime && total == 1:
+		s.trigger(schedule, &models.ScheduleRun{ScheduledAt: latest})
+	case schedule.CatchUp == models.CatchUpSkip:
+		missed := total
+		if onTime {
+			missed--
+		}
+		s.record(schedule, &models.ScheduleRun{
+			ScheduledAt: latest,
+			Result:      models.ScheduleRunSkipped,
+			Missed:      missed,
+			Message:     fmt.Sprintf("跳过 %d 次错过的执行", missed),
+		})
+		if onTime {
+			s.trigger(schedule, &models.ScheduleRun{ScheduledAt: latest})
+		}
+	case schedule.CatchUp == models.CatchUpAll:
+		if skipped := total - len(dueTimes); skipped > 0 {
+			s.record(schedule, &models.ScheduleRun{
+				ScheduledAt: dueTimes[0],
+				Result:      models.ScheduleRunSkipped,
+				Missed:      skipped,
+				Message:     fmt.Sprintf("超过补跑上限 %d 次，跳过更早的 %d 次执行", scheduleCatchUpMax, skipped),
+			})
+		}
+		for i, t := range dueTimes {
+			s.trigger(schedule, &models.ScheduleRun{ScheduledAt: t, CatchUp: !onTime || i < len(dueTimes)-1})
+		}
+	default:
+		s.trigger(schedule, &models.ScheduleRun{ScheduledAt: latest, CatchUp: !onTime, Missed: total - 1})
+	}
+	if schedule.LastJobID != "" {
+		fields["last_job_id"] = schedule.LastJobID
+	}
+	s.save(schedule, fields)
+}
+
+// previousRunning 返回上一次提交且尚未结束的任务ID，同一计划不并发执行
+func (s *Scheduler) previousRunning(schedule *models.Schedule) string {
+	if schedule.LastJobID == "" {
+		return ""
+	}
+	job, err := s.jobs.Get(schedule.Owner, schedule.LastJobID)
+	if err != nil || job.Finished() {
+		return ""
+	}
+	return schedule.LastJobID
+}
+
+// trigger 提交一次后台任务并记录结果
+func (s *Scheduler) trigger(schedule *models.Schedule, run *models.ScheduleRun) {
+	job, err := s.jobs.Submit(schedule.Kind, schedule.Owner, "定时 "+schedule.Name, schedule.Params)
+	if err != nil {
+		run.Result = models.ScheduleRunError
+		run.Message = err.Error()
+		logger.Errorf("Error submitting job for schedule %s: %v", schedule.ID.Hex(), err)
+		color.Red("Error submitting job for schedule %s: %v", schedule.ID.Hex(), err)
+	} else {
+		run.Result = models.ScheduleRunSubmitted
+		run.JobID = job.ID.Hex()
+		schedule.LastJobID = run.JobID
+	}
+	s.record(schedule, run)
+}
+
+// record 保存触发记录
+func (s *Scheduler) record(schedule *models.Schedule, run *models.ScheduleRun) {
+	run.ScheduleID = schedule.ID
+	run.TriggeredAt = time.Now()
+	if err := models.InsertScheduleRun(run, scheduleRunHistory); err != nil {
+		logger.Errorf("Error saving run for schedule %s: %v", schedule.ID.Hex(), err)
+		color.Red("Error saving run for schedule %s: %v", schedule.ID.Hex(), err)
+	}
+}
+
+func (s *Scheduler) save(schedule *models.Schedule, fields map[string]interface{}) {
+	if err := models.UpdateSchedule(schedule.ID, fields); err != nil {
+		logger.Errorf("Error updating schedule %s: %v", schedule.ID.Hex(), err)
+		color.Red("Error updating schedule %s: %v", schedule.ID.Hex(), err)
+	}
+}
+
+// Create 校验并保存新的定时任务
+func (s *Scheduler) Create(owner string, authLevel int, spec ScheduleSpec) (*models.Schedule, error) {
+	schedule := &models.Schedule{Owner: owner}
+	if err := applyScheduleSpec(schedule, authLevel, spec); err != nil {
+		return nil, err
+	}
+	if err := models.InsertSchedule(schedule); err != nil {
+		return nil, err
+	}
+	return redactSchedule(schedule), nil
+}
+
+// Update 替换定时任务的配置，下一次执行时间从现在重新计算
+func (s *Scheduler) Update(owner string, authLevel int, id string, spec ScheduleSpec) (*models.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, err := s.get(owner, id)
+	if err != nil {
+		return nil, err
+	}
+	// 查询接口返回的请求头取值是隐藏的，原样提交时保留已保存的值
+	if saved, ok := schedule.Params["headers"].(map[string]interface{}); ok {
+		for key, value := range spec.Params.Headers {
+			if previous, ok := saved[key].(string); ok && value == "***" {
+				spec.Params.Headers[key] = previous
+			}
+		}
+	}
+	if err := applyScheduleSpec(schedule, authLevel, spec); err != nil {
+		return nil, err
+	}
+	if err := models.UpdateSchedule(schedule.ID, map[string]interface{}{
+		"name":       schedule.Name,
+		"cron":       schedule.Cron,
+		"timezone":   schedule.Timezone,
+		"kind":       schedule.Kind,
+		"params":     schedule.Params,
+		"auth_level": schedule.AuthLevel,
+		"catch_up":   schedule.CatchUp,
+		"enabled":    schedule.Enabled,
+		"next_run":   schedule.NextRun,
+	}); err != nil {
+		return nil, err
+	}
+	return redactSchedule(schedule), nil
+}
+
+// Delete 删除定时任务和执行历史，已提交的后台任务不受影响
+func (s *Scheduler) Delete(owner, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, err := s.get(owner, id)
+	if err != nil {
+		return err
+	}
+	return models.DeleteSchedule(schedule.ID)
+}
+
+// Get 获取用户的定时任务
+func (s *Scheduler) Get(owner, id string) (*models.Schedule, error) {
+	schedule, err := s.get(owner, id)
+	if err != nil {
+		return nil, err
+	}
+	return redactSchedule(schedule), nil
+}
+
+// List 列出用户的定时任务
+func (s *Scheduler) List(owner string) ([]*models.Schedule, error) {
+	schedules, err := models.ListSchedulesByOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*models.Schedule, 0, len(schedules))
+	for i := range schedules {
+		result = append(result, redactSchedule(&schedules[i]))
+	}
+	return result, nil
+}
+
+// RunNow 立即执行一次，不影响下一次计划时间
+func (s *Scheduler) RunNow(owner, id string) (*models.ScheduleRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, err := s.get(owner, id)
+	if err != nil {
+		return nil, err
+	}
+	if running := s.previousRunning(schedule); running != "" {
+		return nil, fmt.Errorf("上一次执行的任务 %s 尚未结束", running)
+	}
+	run := &models.ScheduleRun{ScheduledAt: time.Now(), Manual: true}
+	s.trigger(schedule, run)
+	if run.Result == models.ScheduleRunError {
+		return nil, errors.New(run.Message)
+	}
+	s.save(schedule, map[string]interface{}{"last_run": run.TriggeredAt, "last_job_id": schedule.LastJobID})
+	return run, nil
+}
+
+// History 返回最近的触发记录，并附上对应后台任务的当前状态
+func (s *Scheduler) History(owner, id string) ([]models.ScheduleRun, error) {
+	schedule, err := s.get(owner, id)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := models.ListScheduleRuns(schedule.ID, scheduleRunHistory)
+	if err != nil {
+		return nil, err
+	}
+	for i := range runs {
+		if runs[i].JobID == "" {
+			continue
+		}
+		if job, err := s.jobs.Get(owner, runs[i].JobID); err == nil {
+			runs[i].JobState = job.State
+		}
+	}
+	return runs, nil
+}
+
+func (s *Scheduler) get(owner, id string) (*models.Schedule, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrScheduleNotFound
+	}
+	schedule, err := models.GetSchedule(objID)
+	if err != nil {
+		return nil, err
+	}
+	if schedule == nil || schedule.Owner != owner {
+		return nil, ErrScheduleNotFound
+	}
+	return schedule, nil
+}
+
+// applyScheduleSpec 校验参数并写入计划，任务参数中的权限等级以创建者的会话为准
+func applyScheduleSpec(schedule *models.Schedule, authLevel int, spec ScheduleSpec) error {
+	spec.Name = strings.TrimSpace(spec.Name)
+	if spec.Name == "" {
+		return errors.New("缺少定时任务名称")
+	}
+	if !schedulableKinds[spec.Kind] {
+		return fmt.Errorf("不支持定时执行的任务类型: %s", spec.Kind)
+	}
+	switch spec.CatchUp {
+	case "":
+		spec.CatchUp = models.CatchUpOnce
+	case models.CatchUpSkip, models.CatchUpOnce, models.CatchUpAll:
+	default:
+		return fmt.Errorf("无效的补跑策略: %s", spec.CatchUp)
+	}
+	cron, err := parseScheduleCron(spec.Cron, spec.Timezone)
+	if err != nil {
+		return err
+	}
+	next := cron.Next(time.Now())
+	if next.IsZero() {
+		return fmt.Errorf("cron 表达式不会触发: %s", spec.Cron)
+	}
+
+	u, err := url.Parse(spec.Params.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("无效的下载地址: %s", spec.Params.URL)
+	}
+	if spec.Params.ParentID == "" || spec.Params.ParentID == "undefined" || spec.Params.ParentID == "null" {
+		spec.Params.ParentID = "root"
+	}
+	if err := checkImportParent(spec.Params.ParentID, authLevel); err != nil {
+		return err
+	}
+	spec.Params.AuthLevel = authLevel
+
+	var params map[string]interface{}
+	data, err := json.Marshal(spec.Params)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return err
+	}
+
+	schedule.Name = spec.Name
+	schedule.Cron = strings.TrimSpace(spec.Cron)
+	schedule.Timezone = spec.Timezone
+	schedule.Kind = spec.Kind
+	schedule.Params = params
+	schedule.AuthLevel = authLevel
+	schedule.CatchUp = spec.CatchUp
+	if spec.Enabled != nil {
+		schedule.Enabled = *spec.Enabled
+	} else if schedule.ID.IsZero() {
+		schedule.Enabled = true
+	}
+	schedule.NextRun = next
+	return nil
+}
+
+// parseScheduleCron 按计划的时区解析 cron 表达式
+func parseScheduleCron(expr, timezone string) (*utils.CronSchedule, error) {
+	var loc *time.Location
+	if timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("无效的时区: %s", timezone)
+		}
+	}
+	return utils.ParseCron(expr, loc)
+}
+
+// redactSchedule 返回隐藏了请求头取值的副本，请求头可能包含下载地址的认证信息
+func redactSchedule(schedule *models.Schedule) *models.Schedule {
+	copied := *schedule
+	copied.Params = make(map[string]interface{}, len(schedule.Params))
+	for key, value := range schedule.Params {
+		copied.Params[key] = value
+	}
+	if headers, ok := copied.Params["headers"].(map[string]interface{}); ok {
+		redacted := make(map[string]interface{}, len(headers))
+		for key := range headers {
+			redacted[key] = "***"
+		}
+		copied.Params["headers"] = redacted
+	}
+	return &copied
+}
+
+// GlobalScheduler 全局定时任务调度器
+var GlobalScheduler *Scheduler
+
+// InitScheduler 初始化并启动全局调度器，需要在后台任务管理器之后调用
+func InitScheduler() error {
+	jobs := GetJobManager()
+	if jobs == nil {
+		return errors.New("后台任务管理器未初始化")
+	}
+	GlobalScheduler = NewScheduler(jobs)
+	GlobalScheduler.Start()
+	color.Green("定时任务调度器已启动")
+	return nil
+}
+
+// GetScheduler 获取全局定时任务调度器，未初始化时返回 nil
+func GetScheduler() *Scheduler {
+	return GlobalScheduler
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ChunkSource 下载任务的一个数据来源（HTTP镜像、其他GoFileShare节点或P2P对端）
+type ChunkSource interface {
+	// Name 数据源的可读名称，用于日志和状态展示
+	Name() string
+	// FetchChunk 将 [start, end] 字节区间写入 w 的相同偏移处，ctx 结束时中止请求
+	FetchChunk(ctx context.Context, task *FileTask, w io.WriterAt, start, end int64) error
+}
+
+// SourceFactory 根据数据源地址创建 ChunkSource
+type SourceFactory func(u *url.URL) (ChunkSource, error)
+
+var (
+	sourceFactoriesMu sync.RWMutex
+	sourceFactories   = map[string]SourceFactory{
+		"http":  newHTTPSource,
+		"https": newHTTPSource,
+	}
+)
+
+// RegisterSourceScheme 注册新的数据源协议，例如 P2P 对端
+func RegisterSourceScheme(scheme string, factory SourceFactory) {
+	sourceFactoriesMu.Lock()
+	defer sourceFactoriesMu.Unlock()
+	sourceFactories[scheme] = factory
+}
+
+// newChunkSource 按地址协议创建数据源
+func newChunkSource(raw string) (ChunkSource, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("无效的数据源地址 %s: %w", raw, err)
+	}
+	sourceFactoriesMu.RLock()
+	factory, ok := sourceFactories[u.Scheme]
+	sourceFactoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("不支持的数据源协议: %s", u.Scheme)
+	}
+	return factory(u)
+}
+
+// httpSource 通过 HTTP Range 请求获取分块
+type httpSource struct {
+	url string
+}
+
+func newHTTPSource(u *url.URL) (ChunkSource, error) {
+	return &httpSource{url: u.String()}, nil
+}
+
+func (h *httpSource) Name() string {
+	return h.url
+}
+
+// FetchChunk 下载单个块，写入前校验状态码、Content-Range和长度
+func (h *httpSource) FetchChunk(ctx context.Context, task *FileTask, file io.WriterAt, start, end int64) error {
+	req, err := task.newRequest(ctx, http.MethodGet, h.url)
+	if err != nil {
+		return err
+	}
+
+	// 添加Range头以请求特定的字节范围，文件在服务器上变化时 If-Range 使服务器返回整个文件，下面会拒绝
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if validator := task.ifRangeValidator(); validator != "" && h.url == task.streamURL {
+		req.Header.Set("If-Range", validator)
+	}
+
+	// 发送请求
+	resp, err := task.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			logger.Errorf("Error closing response body: %v", err)
+			color.Red("Error closing response body: %v", err)
+		}
+	}(resp.Body)
+
+	expected := end - start + 1
+
+	// 验证响应状态
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if cr := resp.Header.Get("Content-Range"); cr != "" {
+			var gotStart, gotEnd int64
+			if _, err := fmt.Sscanf(cr, "bytes %d-%d/", &gotStart, &gotEnd); err != nil || gotStart != start || gotEnd != end {
+				return fmt.Errorf("Content-Range 不匹配: 请求 %d-%d，返回 %s", start, end, cr)
+			}
+		}
+	case http.StatusOK:
+		// 服务器忽略了Range，只有当该块恰好是整个文件时才可用
+		if start != 0 || resp.ContentLength != expected {
+			return fmt.Errorf("服务器忽略了Range请求 (bytes=%d-%d)", start, end)
+		}
+	default:
+		return fmt.Errorf("意外的状态码: %d", resp.StatusCode)
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength != expected {
+		return fmt.Errorf("分块长度不匹配: 预期 %d，响应声明 %d", expected, resp.ContentLength)
+	}
+
+	// 各 worker 共享同一个文件句柄，使用 WriteAt 避免 Seek 竞争
+	body := GetBandwidthManager().Reader(ctx, resp.Body, task.Owner, task.ID)
+	written, err := io.CopyN(io.NewOffsetWriter(file, start), body, expected)
+	if err != nil {
+		logger.Errorf("Error writing chunk to file: %v", err)
+		color.Red("Error writing chunk to file: %v", err)
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("分块被截断: 预期 %d 字节，实际 %d 字节", expected, written)
+		}
+		return err
+	}
+	return nil
+}
+
+// maxSourceFailures 数据源连续失败多少次后被剔除
+const maxSourceFailures = 3
+
+// SourceStatus 数据源的运行状态
+type SourceStatus struct {
+	Name     string  `json:"name"`
+	Speed    float64 `json:"speed"` // 平滑后的速度，字节/秒
+	Bytes    int64   `json:"bytes"`
+	Chunks   int     `json:"chunks"`
+	Failures int     `json:"failures"` // 连续失败次数
+	Dropped  bool    `json:"dropped"`
+	LastErr  string  `json:"last_error,omitempty"`
+}
+
+// sourceState 单个数据源在任务中的统计
+type sourceState struct {
+	source ChunkSource
+	status SourceStatus
+}
+
+// sourceSet 任务的全部数据源，记录速度并剔除持续失败的来源
+type sourceSet struct {
+	mu      sync.Mutex
+	sources []*sourceState
+}
+
+func newSourceSet(sources []ChunkSource) *sourceSet {
+	set := &sourceSet{}
+	for _, src := range sources {
+		set.sources = append(set.sources, &sourceState{source: src, status: SourceStatus{Name: src.Name()}})
+	}
+	return set
+}
+
+// recordSuccess 记录一次成功的分块下载并更新速度
+func (set *sourceSet) recordSuccess(state *sourceState, size int64, elapsed time.Duration) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	speed := float64(size) / elapsed.Seconds()
+	if state.status.Speed == 0 {
+		state.status.Speed = speed
+	} else {
+		state.status.Speed = 0.7*state.status.Speed + 0.3*speed
+	}
+	state.status.Bytes += size
+	state.status.Chunks++
+	state.status.Failures = 0
+	state.status.LastErr = ""
+}
+
+// recordFailure 记录一次失败，连续失败过多时剔除该数据源，返回是否被剔除
+func (set *sourceSet) recordFailure(state *sourceState, err error) bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	state.status.Failures++
+	state.status.LastErr = err.Error()
+	if state.status.Failures >= maxSourceFailures && !state.status.Dropped {
+		state.status.Dropped = true
+		color.Yellow("数据源 %s 连续失败 %d 次，已剔除: %v", state.status.Name, state.status.Failures, err)
+	}
+	return state.status.Dropped
+}
+
+// alive 返回未被剔除的数据源数量
+func (set *sourceSet) alive() int {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	count := 0
+	for _, state := range set.sources {
+		if !state.status.Dropped {
+			count++
+		}
+	}
+	return count
+}
+
+// isSlow 判断数据源是否明显慢于最快的来源，用于在任务尾部让出分块
+func (set *sourceSet) isSlow(state *sourceState) bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	var best float64
+	for _, other := range set.sources {
+		if !other.status.Dropped && other.status.Speed > best {
+			best = other.status.Speed
+		}
+	}
+	return state.status.Speed > 0 && state.status.Speed*4 < best
+}
+
+// bySpeed 按速度从快到慢返回可用的数据源
+func (set *sourceSet) bySpeed() []*sourceState {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	var result []*sourceState
+	for _, state := range set.sources {
+		if !state.status.Dropped {
+			result = append(result, state)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].status.Speed > result[j].status.Speed
+	})
+	return result
+}
+
+// snapshot 返回所有数据源状态的副本
+func (set *sourceSet) snapshot() []SourceStatus {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	result := make([]SourceStatus, 0, len(set.sources))
+	for _, state := range set.sources {
+		result = append(result, state.status)
+	}
+	return result
+}
+
+// chunkScheduler 所有数据源共享的分块队列
+// 速度快的来源完成得早、取走得多，分块因此按实测速度分配
+type chunkScheduler struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	pending   []int
+	remaining int
+	attempts  map[int]int
+	err       error
+}
+
+func newChunkScheduler(pending []int) *chunkScheduler {
+	sched := &chunkScheduler{
+		pending:   pending,
+		remaining: len(pending),
+		attempts:  make(map[int]int),
+	}
+	sched.cond = sync.NewCond(&sched.mu)
+	return sched
+}
+
+// next 取出下一个待下载的块，全部完成或出错时返回 false
+func (sched *chunkScheduler) next() (int, bool) {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	for len(sched.pending) == 0 && sched.remaining > 0 && sched.err == nil {
+		sched.cond.Wait()
+	}
+	if sched.err != nil || len(sched.pending) == 0 {
+		return 0, false
+	}
+	index := sched.pending[0]
+	sched.pending = sched.pending[1:]
+	return index, true
+}
+
+// queued 返回队列中尚未被取走的块数
+func (sched *chunkScheduler) queued() int {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	return len(sched.pending)
+}
+
+// done 标记一个块完成
+func (sched *chunkScheduler) done() {
+	sched.mu.Lock()
+	sched.remaining--
+	sched.mu.Unlock()
+	sched.cond.Broadcast()
+}
+
+// retry 将失败的块放回队列，超过重试上限时返回 false
+func (sched *chunkScheduler) retry(index, limit int) bool {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	sched.attempts[index]++
+	if sched.attempts[index] > limit {
+		return false
+	}
+	sched.pending = append(sched.pending, index)
+	sched.cond.Broadcast()
+	return true
+}
+
+// giveBack 将未尝试的块放回队首，不计入重试次数
+func (sched *chunkScheduler) giveBack(index int) {
+	sched.mu.Lock()
+	sched.pending = append([]int{index}, sched.pending...)
+	sched.mu.Unlock()
+	sched.cond.Broadcast()
+}
+
+// fail 终止调度，唤醒所有等待者
+func (sched *chunkScheduler) fail(err error) {
+	sched.mu.Lock()
+	if sched.err == nil {
+		sched.err = err
+	}
+	sched.mu.Unlock()
+	sched.cond.Broadcast()
+}
+
+// result 返回调度结束时的错误
+func (sched *chunkScheduler) result() error {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	return sched.err
+}
+
+// dropped 判断数据源是否已被剔除
+func (set *sourceSet) dropped(state *sourceState) bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return state.status.Dropped
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"GoFileShare/models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPSourceFetchChunkCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 只发送响应头和一部分数据，然后停住
+		w.Header().Set("Content-Range", "bytes 0-1023/1024")
+		w.Header().Set("Content-Length", "1024")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(make([]byte, 16))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "chunk"))
+	if err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	defer file.Close()
+	source := &httpSource{url: server.URL}
+	task := &FileTask{ID: "cancel-test", cancel: make(chan struct{})}
+	ctx, cancel := task.withCancel(context.Background())
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() { result <- source.FetchChunk(ctx, task, file, 0, 1023) }()
+	time.Sleep(100 * time.Millisecond)
+	// 取消任务后进行中的请求应立即结束
+	close(task.cancel)
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("取消后应返回 context.Canceled，实际为 %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("取消任务没有中止进行中的分块请求")
+	}
+}
+
+// memorySource 测试用的数据源，corrupt 时返回错误的数据，fail 时直接返回错误
+type memorySource struct {
+	name    string
+	content []byte
+	corrupt bool
+	fail    bool
+	delay   time.Duration
+}
+
+func (m *memorySource) Name() string {
+	return m.name
+}
+
+func (m *memorySource) FetchChunk(ctx context.Context, task *FileTask, w io.WriterAt, start, end int64) error {
+	time.Sleep(m.delay)
+	if m.fail {
+		return errors.New("连接被拒绝")
+	}
+	data := append([]byte(nil), m.content[start:end+1]...)
+	if m.corrupt {
+		data[0] ^= 0xff
+	}
+	_, err := w.WriteAt(data, start)
+	return err
+}
+
+// runSourceWorkers 按 processDownload 的方式为每个数据源启动 worker，返回调度结果
+func runSourceWorkers(t *testing.T, content []byte, chunkSize int64, sources ...ChunkSource) (*FileTask, *os.File, error) {
+	manifest := &models.FileManifest{FileSize: int64(len(content)), ChunkSize: chunkSize, Algorithm: ManifestAlgorithm}
+	var pending []int
+	for offset := int64(0); offset < int64(len(content)); offset += chunkSize {
+		end := offset + chunkSize
+		if end > int64(len(content)) {
+			end = int64(len(content))
+		}
+		sum := sha256.Sum256(content[offset:end])
+		manifest.ChunkHashes = append(manifest.ChunkHashes, hex.EncodeToString(sum[:]))
+		pending = append(pending, len(pending))
+	}
+	task := &FileTask{
+		ID:        "sources-test",
+		FileSize:  int64(len(content)),
+		ChunkSize: chunkSize,
+		manifest:  manifest,
+		chunkDone: make([]bool, len(pending)),
+		sources:   newSourceSet(sources),
+		cancel:    make(chan struct{}),
+	}
+	file, err := os.Create(filepath.Join(t.TempDir(), "data.bin"))
+	if err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	s := &TransferService{config: models.TransferConfig{WorkerCount: len(sources), ChunkRetries: 1}}
+	sched := newChunkScheduler(pending)
+	var wg sync.WaitGroup
+	for _, state := range task.sources.sources {
+		wg.Add(1)
+		go func(state *sourceState) {
+			defer wg.Done()
+			s.sourceWorker(context.Background(), task, file, state, sched, len(sources))
+		}(state)
+	}
+	wg.Wait()
+	return task, file, sched.result()
+}
+
+func TestSourceWorkerReassignsChunksFromBadSource(t *testing.T) {
+	content := testContent(16 << 10)
+	good := &memorySource{name: "good", content: content, delay: 10 * time.Millisecond}
+	bad := &memorySource{name: "bad", content: content, corrupt: true}
+	task, file, err := runSourceWorkers(t, content, 1<<10, good, bad)
+	if err != nil {
+		t.Fatalf("一个数据源返回错误数据时下载应由另一个完成，实际为 %v", err)
+	}
+	if completed := len(task.completedChunks()); completed != len(task.chunkDone) {
+		t.Fatalf("应完成 %d 块，实际为 %d 块", len(task.chunkDone), completed)
+	}
+	got := make([]byte, len(content))
+	if _, err := file.ReadAt(got, 0); err != nil {
+		t.Fatalf("读取文件失败: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("下载的文件内容不一致")
+	}
+
+	// 错误数据源连续校验失败后被剔除，它领取的块全部由正常数据源重新下载
+	status := task.sources.snapshot()
+	if !status[1].Dropped || status[1].Failures != maxSourceFailures || status[1].Chunks != 0 {
+		t.Fatalf("错误数据源应在 %d 次失败后被剔除，实际为 %+v", maxSourceFailures, status[1])
+	}
+	if !strings.Contains(status[1].LastErr, ErrChunkCorrupted.Error()) {
+		t.Fatalf("错误数据源的失败原因应为校验失败，实际为 %q", status[1].LastErr)
+	}
+	if status[0].Dropped || status[0].Chunks != len(task.chunkDone) {
+		t.Fatalf("正常数据源应下载全部 %d 块，实际为 %+v", len(task.chunkDone), status[0])
+	}
+}
+
+func TestSourceWorkerFailsWhenAllSourcesDropped(t *testing.T) {
+	content := testContent(16 << 10)
+	task, _, err := runSourceWorkers(t, content, 1<<10,
+		&memorySource{name: "a", content: content, fail: true},
+		&memorySource{name: "b", content: content, fail: true})
+	if err == nil {
+		t.Fatal("所有数据源都失败时下载应报错")
+	}
+	if completed := len(task.completedChunks()); completed != 0 {
+		t.Fatalf("不应有完成的块，实际为 %d 块", completed)
+	}
+}
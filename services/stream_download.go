@@ -0,0 +1,547 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultMaxRedirects 未指定时最多跟随的重定向次数
+const defaultMaxRedirects = 10
+
+// probeTimeout 探测文件大小和Range支持的超时时间
+const probeTimeout = 30 * time.Second
+
+// newTaskHTTPClient 为任务创建独立的 HTTP 客户端，Cookie 和重定向策略互不影响
+func newTaskHTTPClient(rawURL string, opts DownloadOptions) (*http.Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Cookies) > 0 {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		jar.SetCookies(u, opts.Cookies)
+	}
+
+	limit := opts.MaxRedirects
+	if limit == 0 {
+		limit = defaultMaxRedirects
+	}
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if limit < 0 {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= limit {
+				return fmt.Errorf("重定向次数超过 %d", limit)
+			}
+			if opts.PublicOnly {
+				if err := checkPublicURL(req.Context(), req.URL); err != nil {
+					return err
+				}
+			}
+			// 跨域时标准库会去掉 Authorization 和 Cookie，其余自定义请求头沿用
+			return nil
+		},
+	}
+	if opts.PublicOnly {
+		// 连接时再检查实际的IP，域名在检查之后重新解析到内网地址（DNS rebinding）也会被拒绝；
+		// 不使用代理，否则连接的是代理而不是目标地址
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: publicOnlyControl}
+		transport.DialContext = dialer.DialContext
+		client.Transport = transport
+	}
+	return client, nil
+}
+
+// ErrNonPublicAddress 下载地址指向本机、内网或链路本地地址
+var ErrNonPublicAddress = errors.New("不允许下载内网或本机地址")
+
+// isPublicIP 排除回环、私有、链路本地、组播和未指定地址
+func isPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 0 {
+		return false
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// checkPublicURL 解析地址中的主机名，任何一个结果不是公网地址时拒绝
+func checkPublicURL(ctx context.Context, u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("不支持的协议: %s", u.Scheme)
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("%w: %s", ErrNonPublicAddress, host)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return fmt.Errorf("%w: %s (%s)", ErrNonPublicAddress, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// checkPublicURLs 检查下载地址、清单地址和数据源中的 HTTP 地址，空地址和其他协议的数据源跳过
+func checkPublicURLs(ctx context.Context, rawURLs []string) error {
+	for _, raw := range rawURLs {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("无效的地址 %s: %w", raw, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			continue
+		}
+		if err := checkPublicURL(ctx, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publicOnlyControl 建立连接前检查已解析的IP
+func publicOnlyControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrNonPublicAddress, host)
+	}
+	return nil
+}
+
+// httpClient 返回任务的 HTTP 客户端
+func (t *FileTask) httpClient() *http.Client {
+	if t.client != nil {
+		return t.client
+	}
+	return http.DefaultClient
+}
+
+// newRequest 创建带有任务自定义请求头的请求
+func (t *FileTask) newRequest(ctx context.Context, method, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range t.Headers {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	return req, nil
+}
+
+// ifRangeValidator 返回 If-Range 使用的校验值，弱 ETag 不能用于 If-Range
+func (t *FileTask) ifRangeValidator() string {
+	if t.ETag != "" && !strings.HasPrefix(t.ETag, "W/") {
+		return t.ETag
+	}
+	return t.LastModified
+}
+
+// streamMode 判断任务是否需要单流下载：服务器不支持Range或文件大小未知
+func (t *FileTask) streamMode() bool {
+	return !t.RangeSupported || t.FileSize < 0
+}
+
+// markBytesDone 单流下载时按已写入字节更新进度，大小未知时进度保持为 0
+func (t *FileTask) markBytesDone(written int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.FileSize <= 0 {
+		return
+	}
+	progress := float64(written) / float64(t.FileSize) * 100
+	// 每增加 1% 才推送一次，避免小块写入产生大量事件
+	if progress-t.Progress < 1 && progress < 100 {
+		return
+	}
+	t.Progress = progress
+	if t.dispatcher != nil {
+		t.dispatcher.post(progress)
+	}
+}
+
+// probeResult 数据源探测结果
+type probeResult struct {
+	url            string
+	size           int64 // -1 表示未知
+	rangeSupported bool
+	etag           string
+	lastModified   string
+}
+
+// probingSource 能够自行提供文件大小和校验值的非 HTTP 数据源
+type probingSource interface {
+	Probe(task *FileTask) (*probeResult, error)
+}
+
+// probeSources 依次探测各 HTTP 数据源，返回第一个可用的结果
+func probeSources(task *FileTask, sources []string) (*probeResult, error) {
+	var lastErr error = errors.New("没有可用于获取文件大小的HTTP数据源")
+	for _, source := range sources {
+		if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+			// 其他协议的数据源可以自己提供文件大小，例如P2P对端
+			if chunkSource, err := newChunkSource(source); err == nil {
+				if prober, ok := chunkSource.(probingSource); ok {
+					result, err := prober.Probe(task)
+					if err != nil {
+						lastErr = err
+						continue
+					}
+					result.url = source
+					return result, nil
+				}
+			}
+			continue
+		}
+		result, err := probeSource(task, source)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return result, nil
+	}
+	return nil, lastErr
+}
+
+// probeSource 通过 HEAD 和一次 bytes=0-0 的测试请求确认文件大小和Range支持
+// 部分服务器不支持 HEAD 或对 HEAD 返回错误（例如只为 GET 签名的地址），因此 HEAD 失败不算探测失败
+func probeSource(task *FileTask, source string) (*probeResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	result := &probeResult{url: source, size: -1}
+	acceptRanges := ""
+	if req, err := task.newRequest(ctx, http.MethodHead, source); err == nil {
+		if resp, err := task.httpClient().Do(req); err == nil {
+			closeBody(resp, source)
+			if resp.StatusCode == http.StatusOK {
+				result.size = resp.ContentLength
+				result.etag = resp.Header.Get("ETag")
+				result.lastModified = resp.Header.Get("Last-Modified")
+				acceptRanges = strings.ToLower(resp.Header.Get("Accept-Ranges"))
+			}
+		}
+	}
+	if acceptRanges == "none" && result.size >= 0 {
+		// 服务器明确表示不支持Range，无需测试
+		return result, nil
+	}
+
+	req, err := task.newRequest(ctx, http.MethodGet, source)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := task.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// 服务器忽略Range时会返回整个文件，这里只读响应头，直接关闭
+	closeBody(resp, source)
+
+	if result.etag == "" {
+		result.etag = resp.Header.Get("ETag")
+	}
+	if result.lastModified == "" {
+		result.lastModified = resp.Header.Get("Last-Modified")
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, end, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != 0 || end != 0 {
+			// Content-Range 不可信，按不支持Range处理
+			return result, nil
+		}
+		result.rangeSupported = true
+		if total >= 0 {
+			result.size = total
+		}
+	case http.StatusOK:
+		if result.size < 0 {
+			result.size = resp.ContentLength
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 空文件无法满足 bytes=0-0
+		if _, _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && total == 0 {
+			result.size = 0
+		}
+	default:
+		return nil, fmt.Errorf("GET %s 返回状态码 %d", source, resp.StatusCode)
+	}
+	return result, nil
+}
+
+// parseContentRange 解析 "bytes start-end/total" 或 "bytes */total"，total 为 * 时返回 -1
+func parseContentRange(value string) (int64, int64, int64, bool) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "bytes ") {
+		return 0, 0, 0, false
+	}
+	rangePart, totalPart, found := strings.Cut(strings.TrimPrefix(value, "bytes "), "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+	total := int64(-1)
+	if totalPart != "*" {
+		n, err := strconv.ParseInt(totalPart, 10, 64)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		total = n
+	}
+	if rangePart == "*" {
+		return -1, -1, total, true
+	}
+	startPart, endPart, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	start, err1 := strconv.ParseInt(startPart, 10, 64)
+	end, err2 := strconv.ParseInt(endPart, 10, 64)
+	if err1 != nil || err2 != nil || start > end {
+		return 0, 0, 0, false
+	}
+	return start, end, total, true
+}
+
+// closeBody 关闭响应体并记录错误
+func closeBody(resp *http.Response, source string) {
+	if err := resp.Body.Close(); err != nil {
+		logger.Errorf("Error closing response body for %s: %v", source, err)
+		color.Red("Error closing response body for %s: %v", source, err)
+	}
+}
+
+// processStreamDownload 单流下载，用于不支持Range或大小未知的服务器
+// 临时文件已有内容且服务器支持Range时，带 If-Range 从断点继续；文件在服务器上变化时服务器返回完整内容，从头下载
+func (s *TransferService) processStreamDownload(ctx context.Context, task *FileTask) error {
+	tempFile := task.FilePath + ".download"
+	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			logger.Errorf("Error closing file %s: %v", tempFile, err)
+			color.Red("Error closing file %s: %v", tempFile, err)
+		}
+	}(file)
+
+	ctx, cancel := task.withCancel(ctx)
+	defer cancel()
+
+	dispatcher := newProgressDispatcher(task)
+	task.mu.Lock()
+	task.dispatcher = dispatcher
+	task.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		err = s.streamOnce(ctx, task, file)
+		if err == nil || ctx.Err() != nil || attempt >= s.chunkRetries() {
+			break
+		}
+		color.Yellow("Task %s: stream attempt %d failed, resuming: %v", task.ID, attempt+1, err)
+		select {
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		case <-ctx.Done():
+		}
+	}
+
+	task.mu.Lock()
+	task.dispatcher = nil
+	task.mu.Unlock()
+	dispatcher.close()
+
+	select {
+	case <-task.cancel:
+		err = errTaskCancelled
+	default:
+	}
+	if err != nil {
+		if saveErr := s.saveTaskStatus(task); saveErr != nil {
+			logger.Errorf("Error saving task status after error: %v", saveErr)
+			color.Red("Error saving task status after error: %v", saveErr)
+		}
+		return err
+	}
+
+	if err := verifyStreamedFile(task, file); err != nil {
+		// 无法定位损坏的位置，丢弃临时文件让下次整体重新下载
+		if truncErr := file.Truncate(0); truncErr != nil {
+			color.Red("Error truncating %s: %v", tempFile, truncErr)
+		}
+		return err
+	}
+
+	if err := os.Rename(tempFile, task.FilePath); err != nil {
+		return err
+	}
+	task.mu.Lock()
+	task.Completed = true
+	task.Progress = 100
+	task.mu.Unlock()
+	if task.OnProgress != nil {
+		task.OnProgress(100)
+	}
+	metaFile := filepath.Join(s.config.MetaDir, task.ID+".json")
+	if err := os.Remove(metaFile); err != nil && !os.IsNotExist(err) {
+		logger.Errorf("Error removing metadata file %s: %v", metaFile, err)
+		color.Red("Error removing metadata file %s: %v", metaFile, err)
+		return err
+	}
+	return nil
+}
+
+// streamOnce 发起一次GET请求并把响应写入临时文件，能续传时从文件末尾继续
+func (s *TransferService) streamOnce(ctx context.Context, task *FileTask, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+	validator := task.ifRangeValidator()
+	if offset > 0 && (!task.RangeSupported || validator == "") {
+		// 无法确认服务器上的文件没有变化，不能续传
+		offset = 0
+	}
+	if task.FileSize >= 0 && offset == task.FileSize && offset > 0 {
+		return nil
+	}
+
+	req, err := task.newRequest(ctx, http.MethodGet, task.streamURL)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+	resp, err := task.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp, task.streamURL)
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, _, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if offset == 0 || !ok || start != offset {
+			return fmt.Errorf("Content-Range 不匹配: 请求从 %d 开始，返回 %s", offset, resp.Header.Get("Content-Range"))
+		}
+		if task.FileSize < 0 && total >= 0 {
+			task.mu.Lock()
+			task.FileSize = total
+			task.mu.Unlock()
+		}
+	case http.StatusOK:
+		if offset > 0 {
+			color.Yellow("Task %s: 服务器返回完整内容（文件已变化或忽略了Range），从头下载", task.ID)
+		}
+		offset = 0
+		task.mu.Lock()
+		if resp.ContentLength >= 0 {
+			task.FileSize = resp.ContentLength
+		}
+		task.Progress = 0
+		task.mu.Unlock()
+	default:
+		if offset > 0 {
+			// 断点无效，清空临时文件，下一次重试从头开始
+			if err := file.Truncate(0); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("意外的状态码: %d", resp.StatusCode)
+	}
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+
+	// 记录服务器返回的最新校验值，供后续续传使用
+	task.mu.Lock()
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		task.ETag = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		task.LastModified = lastModified
+	}
+	task.mu.Unlock()
+
+	body := GetBandwidthManager().Reader(ctx, resp.Body, task.Owner, task.ID)
+	writer := &streamWriter{task: task, file: file, offset: offset}
+	if _, err := io.Copy(writer, body); err != nil {
+		return err
+	}
+	if task.FileSize >= 0 && writer.offset != task.FileSize {
+		return fmt.Errorf("下载不完整: 预期 %d 字节，实际 %d 字节", task.FileSize, writer.offset)
+	}
+	if task.FileSize < 0 {
+		// 服务器始终没有给出长度，以读到 EOF 为准
+		task.mu.Lock()
+		task.FileSize = writer.offset
+		task.mu.Unlock()
+	}
+	return nil
+}
+
+// streamWriter 顺序写入临时文件并更新进度
+type streamWriter struct {
+	task   *FileTask
+	file   *os.File
+	offset int64
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	w.task.markBytesDone(w.offset)
+	return n, err
+}
+
+// verifyStreamedFile 单流下载完成后按清单逐块和整体校验
+func verifyStreamedFile(task *FileTask, file *os.File) error {
+	if task.manifest != nil && len(task.manifest.ChunkHashes) > 0 {
+		if task.manifest.FileSize != task.FileSize {
+			return fmt.Errorf("文件大小 %d 与校验清单中的 %d 不一致", task.FileSize, task.manifest.FileSize)
+		}
+		for index := 0; index < task.chunkCount(); index++ {
+			if err := verifyChunk(task, file, index); err != nil {
+				return err
+			}
+		}
+	}
+	return verifyFile(task, file)
+}
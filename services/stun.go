@@ -0,0 +1,640 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// STUN 报文（RFC 5389）和 NAT 行为发现（RFC 5780）用到的常量
+const (
+	stunHeaderSize  = 20
+	stunMagicCookie = 0x2112A442
+
+	stunBindingRequest  uint16 = 0x0001
+	stunBindingSuccess  uint16 = 0x0101
+	stunBindingError    uint16 = 0x0111
+	stunAttrMapped      uint16 = 0x0001
+	stunAttrChangeReq   uint16 = 0x0003
+	stunAttrChanged     uint16 = 0x0005 // RFC 3489 的 CHANGED-ADDRESS，旧服务器用它代替 OTHER-ADDRESS
+	stunAttrErrorCode   uint16 = 0x0009
+	stunAttrXorMapped   uint16 = 0x0020
+	stunAttrSoftware    uint16 = 0x8022
+	stunAttrRespOrigin  uint16 = 0x802B
+	stunAttrOtherAddr   uint16 = 0x802C
+	stunChangeIPFlag    uint32 = 0x04
+	stunChangePortFlag  uint32 = 0x02
+	stunChangeNone             = 0
+	stunChangePort             = stunChangePortFlag
+	stunChangeIPAndPort        = stunChangeIPFlag | stunChangePortFlag
+	stunSoftware               = "GoFileShare"
+	stunDefaultRTO             = 300 * time.Millisecond
+	stunDefaultAttempts        = 4
+)
+
+// NAT 映射和过滤行为（RFC 4787）
+const (
+	NATBehaviorUnknown              = "unknown"
+	NATBehaviorEndpointIndependent  = "endpoint-independent"
+	NATBehaviorAddressDependent     = "address-dependent"
+	NATBehaviorAddressPortDependent = "address-and-port-dependent"
+	NATTypeOpenInternet             = "Open Internet"
+	NATTypeSymmetricFirewall        = "Symmetric UDP Firewall"
+	NATTypeFullCone                 = "Full Cone NAT"
+	NATTypeRestrictedCone           = "Restricted Cone NAT"
+	NATTypePortRestrictedCone       = "Port Restricted Cone NAT"
+	NATTypeSymmetric                = "Symmetric NAT"
+	NATTypeUnknown                  = "Unknown NAT"
+)
+
+// ErrSTUNTimeout 重传次数用完仍没有收到响应
+var ErrSTUNTimeout = errors.New("STUN请求超时")
+
+type stunMessage struct {
+	Type  uint16
+	TxID  [12]byte
+	Attrs []stunAttr
+}
+
+type stunAttr struct {
+	Type  uint16
+	Value []byte
+}
+
+func (m *stunMessage) add(attrType uint16, value []byte) {
+	m.Attrs = append(m.Attrs, stunAttr{Type: attrType, Value: value})
+}
+
+func (m *stunMessage) get(attrType uint16) ([]byte, bool) {
+	for _, attr := range m.Attrs {
+		if attr.Type == attrType {
+			return attr.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (m *stunMessage) encode() []byte {
+	size := 0
+	for _, attr := range m.Attrs {
+		size += 4 + (len(attr.Value)+3)&^3
+	}
+	buf := make([]byte, stunHeaderSize+size)
+	binary.BigEndian.PutUint16(buf[0:], m.Type)
+	binary.BigEndian.PutUint16(buf[2:], uint16(size))
+	binary.BigEndian.PutUint32(buf[4:], stunMagicCookie)
+	copy(buf[8:20], m.TxID[:])
+	offset := stunHeaderSize
+	for _, attr := range m.Attrs {
+		binary.BigEndian.PutUint16(buf[offset:], attr.Type)
+		binary.BigEndian.PutUint16(buf[offset+2:], uint16(len(attr.Value)))
+		copy(buf[offset+4:], attr.Value)
+		offset += 4 + (len(attr.Value)+3)&^3
+	}
+	return buf
+}
+
+// isSTUNMessage 前两位为0且带有 magic cookie，与P2P的JSON和文本消息不会混淆
+func isSTUNMessage(data []byte) bool {
+	return len(data) >= stunHeaderSize && data[0]&0xC0 == 0 &&
+		binary.BigEndian.Uint32(data[4:]) == stunMagicCookie &&
+		int(binary.BigEndian.Uint16(data[2:]))+stunHeaderSize == len(data)
+}
+
+func decodeSTUNMessage(data []byte) (*stunMessage, error) {
+	if !isSTUNMessage(data) {
+		return nil, errors.New("不是STUN报文")
+	}
+	msg := &stunMessage{Type: binary.BigEndian.Uint16(data[0:])}
+	copy(msg.TxID[:], data[8:20])
+	for offset := stunHeaderSize; offset < len(data); {
+		if offset+4 > len(data) {
+			return nil, errors.New("STUN属性不完整")
+		}
+		attrType := binary.BigEndian.Uint16(data[offset:])
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if offset+4+length > len(data) {
+			return nil, errors.New("STUN属性长度错误")
+		}
+		msg.add(attrType, append([]byte(nil), data[offset+4:offset+4+length]...))
+		offset += 4 + (length+3)&^3
+	}
+	return msg, nil
+}
+
+// encodeSTUNAddress 编码 MAPPED-ADDRESS 格式的地址，xor 为 true 时按 XOR-MAPPED-ADDRESS 处理
+func encodeSTUNAddress(addr *net.UDPAddr, txID [12]byte, xor bool) []byte {
+	ip := addr.IP.To4()
+	family := byte(0x01)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 0x02
+	}
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	port := uint16(addr.Port)
+	copy(value[4:], ip)
+	if xor {
+		port ^= stunMagicCookie >> 16
+		xorSTUNAddress(value[4:], txID)
+	}
+	binary.BigEndian.PutUint16(value[2:], port)
+	return value
+}
+
+func decodeSTUNAddress(value []byte, txID [12]byte, xor bool) (*net.UDPAddr, error) {
+	if len(value) < 8 {
+		return nil, errors.New("STUN地址长度错误")
+	}
+	var ip []byte
+	switch value[1] {
+	case 0x01:
+		ip = append([]byte(nil), value[4:8]...)
+	case 0x02:
+		if len(value) < 20 {
+			return nil, errors.New("STUN地址长度错误")
+		}
+		ip = append([]byte(nil), value[4:20]...)
+	default:
+		return nil, fmt.Errorf("未知的地址族: %d", value[1])
+	}
+	port := binary.BigEndian.Uint16(value[2:])
+	if xor {
+		port ^= stunMagicCookie >> 16
+		xorSTUNAddress(ip, txID)
+	}
+	return &net.UDPAddr{IP: net.IP(ip), Port: int(port)}, nil
+}
+
+// xorSTUNAddress IPv4 与 magic cookie 异或，IPv6 与 magic cookie 加事务ID异或
+func xorSTUNAddress(ip []byte, txID [12]byte) {
+	var key [16]byte
+	binary.BigEndian.PutUint32(key[:], stunMagicCookie)
+	copy(key[4:], txID[:])
+	for i := range ip {
+		ip[i] ^= key[i]
+	}
+}
+
+// STUNBinding 一次绑定请求的结果
+type STUNBinding struct {
+	Mapped *net.UDPAddr // 服务器看到的本端地址，即NAT映射后的地址
+	Origin *net.UDPAddr // 响应的来源地址
+	Other  *net.UDPAddr // 服务器的备用地址（IP和端口都不同），服务器不支持 RFC 5780 时为 nil
+}
+
+// STUNClient STUN 绑定客户端，按事务ID匹配响应，超时按 RFC 5389 的方式加倍间隔重传
+type STUNClient struct {
+	RTO      time.Duration // 第一次重传前的等待时间，之后每次加倍
+	Attempts int           // 最多发送的次数
+
+	conn    *net.UDPConn
+	owned   bool
+	mu      sync.Mutex
+	pending map[[12]byte]chan *stunMessage
+}
+
+// ListenSTUN 在 localAddr 上打开单独的 UDP 套接字并读取响应
+func ListenSTUN(localAddr string) (*STUNClient, error) {
+	addr, err := net.ResolveUDPAddr("udp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	client := newSTUNClient(conn)
+	client.owned = true
+	go client.readLoop()
+	return client, nil
+}
+
+// newSTUNClient 共用已有的套接字，读取该套接字的协程需要把 STUN 报文交给 handle
+func newSTUNClient(conn *net.UDPConn) *STUNClient {
+	return &STUNClient{
+		RTO:      stunDefaultRTO,
+		Attempts: stunDefaultAttempts,
+		conn:     conn,
+		pending:  make(map[[12]byte]chan *stunMessage),
+	}
+}
+
+// LocalAddr 发送请求使用的本地地址
+func (c *STUNClient) LocalAddr() *net.UDPAddr {
+	return c.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Close 关闭 ListenSTUN 打开的套接字，共用的套接字由所有者关闭
+func (c *STUNClient) Close() error {
+	if !c.owned {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+func (c *STUNClient) readLoop() {
+	buffer := make([]byte, 1500)
+	for {
+		n, addr, err := c.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		c.handle(buffer[:n], addr)
+	}
+}
+
+// handle 处理收到的 STUN 报文，属于等待中的事务时返回 true
+func (c *STUNClient) handle(data []byte, from *net.UDPAddr) bool {
+	msg, err := decodeSTUNMessage(data)
+	if err != nil || (msg.Type != stunBindingSuccess && msg.Type != stunBindingError) {
+		return false
+	}
+	if _, ok := msg.get(stunAttrRespOrigin); !ok {
+		msg.add(stunAttrRespOrigin, encodeSTUNAddress(from, msg.TxID, false))
+	}
+	c.mu.Lock()
+	ch, ok := c.pending[msg.TxID]
+	delete(c.pending, msg.TxID)
+	c.mu.Unlock()
+	if ok {
+		ch <- msg
+	}
+	return ok
+}
+
+// Binding 向 server 发送绑定请求，change 为 CHANGE-REQUEST 标志（RFC 5780 要求服务器从其他IP或端口回复）
+func (c *STUNClient) Binding(server *net.UDPAddr, change uint32) (*STUNBinding, error) {
+	req := &stunMessage{Type: stunBindingRequest}
+	if _, err := rand.Read(req.TxID[:]); err != nil {
+		return nil, err
+	}
+	req.add(stunAttrSoftware, []byte(stunSoftware))
+	if change != stunChangeNone {
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint32(value, change)
+		req.add(stunAttrChangeReq, value)
+	}
+	data := req.encode()
+
+	ch := make(chan *stunMessage, 1)
+	c.mu.Lock()
+	c.pending[req.TxID] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, req.TxID)
+		c.mu.Unlock()
+	}()
+
+	rto := c.RTO
+	if rto <= 0 {
+		rto = stunDefaultRTO
+	}
+	attempts := c.Attempts
+	if attempts <= 0 {
+		attempts = stunDefaultAttempts
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for i := 0; i < attempts; i++ {
+		if _, err := c.conn.WriteToUDP(data, server); err != nil {
+			return nil, fmt.Errorf("发送STUN请求失败: %w", err)
+		}
+		timer.Reset(rto)
+		select {
+		case resp := <-ch:
+			return parseSTUNBinding(resp)
+		case <-timer.C:
+			rto *= 2
+		}
+	}
+	return nil, ErrSTUNTimeout
+}
+
+func parseSTUNBinding(resp *stunMessage) (*STUNBinding, error) {
+	if resp.Type == stunBindingError {
+		if value, ok := resp.get(stunAttrErrorCode); ok && len(value) >= 4 {
+			return nil, fmt.Errorf("STUN错误 %d: %s", int(value[2]&0x07)*100+int(value[3]), value[4:])
+		}
+		return nil, errors.New("STUN错误响应")
+	}
+	result := &STUNBinding{}
+	var err error
+	if value, ok := resp.get(stunAttrXorMapped); ok {
+		result.Mapped, err = decodeSTUNAddress(value, resp.TxID, true)
+	} else if value, ok := resp.get(stunAttrMapped); ok {
+		result.Mapped, err = decodeSTUNAddress(value, resp.TxID, false)
+	} else {
+		err = errors.New("响应中没有映射地址")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := resp.get(stunAttrRespOrigin); ok {
+		result.Origin, _ = decodeSTUNAddress(value, resp.TxID, false)
+	}
+	if value, ok := resp.get(stunAttrOtherAddr); ok {
+		result.Other, _ = decodeSTUNAddress(value, resp.TxID, false)
+	} else if value, ok := resp.get(stunAttrChanged); ok {
+		result.Other, _ = decodeSTUNAddress(value, resp.TxID, false)
+	}
+	return result, nil
+}
+
+// NATBehavior NAT 行为发现的结果
+type NATBehavior struct {
+	Type      string       `json:"type"`      // RFC 3489 风格的类型名称
+	Mapping   string       `json:"mapping"`   // 映射行为
+	Filtering string       `json:"filtering"` // 过滤行为
+	Mapped    *net.UDPAddr `json:"mapped"`    // 映射后的外部地址
+	Server    string       `json:"server"`    // 使用的STUN服务器
+}
+
+// DiscoverNAT 按 RFC 5780 依次测试映射和过滤行为。
+// 第一个服务器不支持 OTHER-ADDRESS 时，用第二个服务器返回的映射地址判断映射行为，过滤行为记为 unknown
+func (c *STUNClient) DiscoverNAT(servers []string) (*NATBehavior, error) {
+	var primary *net.UDPAddr
+	var first *STUNBinding
+	var lastErr error
+	var resolved []*net.UDPAddr
+	for _, server := range servers {
+		addr, err := net.ResolveUDPAddr("udp", server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resolved = append(resolved, addr)
+		if first != nil {
+			continue
+		}
+		binding, err := c.Binding(addr, stunChangeNone)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		primary, first = addr, binding
+	}
+	if first == nil {
+		if lastErr == nil {
+			lastErr = errors.New("没有配置STUN服务器")
+		}
+		return nil, lastErr
+	}
+
+	result := &NATBehavior{
+		Type:      NATTypeUnknown,
+		Mapping:   NATBehaviorUnknown,
+		Filtering: NATBehaviorUnknown,
+		Mapped:    first.Mapped,
+		Server:    primary.String(),
+	}
+	noNAT := c.isLocalAddr(first.Mapped)
+	if noNAT {
+		result.Mapping = NATBehaviorEndpointIndependent
+	}
+
+	if first.Other != nil {
+		if !noNAT {
+			result.Mapping = c.testMapping(primary, first)
+		}
+		result.Filtering = c.testFiltering(primary)
+	} else if !noNAT {
+		// 没有备用地址时比较两个不同服务器看到的映射地址
+		for _, addr := range resolved {
+			if addr.String() == primary.String() {
+				continue
+			}
+			if binding, err := c.Binding(addr, stunChangeNone); err == nil {
+				if sameUDPAddr(binding.Mapped, first.Mapped) {
+					result.Mapping = NATBehaviorEndpointIndependent
+				} else {
+					result.Mapping = NATBehaviorAddressPortDependent
+				}
+				break
+			}
+		}
+	}
+	result.Type = classifyNAT(noNAT, result.Mapping, result.Filtering)
+	return result, nil
+}
+
+// testMapping 分别向备用IP的主端口和备用IP的备用端口发送请求，比较映射地址
+func (c *STUNClient) testMapping(primary *net.UDPAddr, first *STUNBinding) string {
+	second, err := c.Binding(&net.UDPAddr{IP: first.Other.IP, Port: primary.Port}, stunChangeNone)
+	if err != nil {
+		return NATBehaviorUnknown
+	}
+	if sameUDPAddr(second.Mapped, first.Mapped) {
+		return NATBehaviorEndpointIndependent
+	}
+	third, err := c.Binding(first.Other, stunChangeNone)
+	if err != nil {
+		return NATBehaviorUnknown
+	}
+	if sameUDPAddr(third.Mapped, second.Mapped) {
+		return NATBehaviorAddressDependent
+	}
+	return NATBehaviorAddressPortDependent
+}
+
+// testFiltering 请求服务器从其他IP和端口回复，收不到时再请求只换端口回复
+func (c *STUNClient) testFiltering(primary *net.UDPAddr) string {
+	if _, err := c.Binding(primary, stunChangeIPAndPort); err == nil {
+		return NATBehaviorEndpointIndependent
+	} else if !errors.Is(err, ErrSTUNTimeout) {
+		return NATBehaviorUnknown
+	}
+	if _, err := c.Binding(primary, stunChangePort); err == nil {
+		return NATBehaviorAddressDependent
+	} else if !errors.Is(err, ErrSTUNTimeout) {
+		return NATBehaviorUnknown
+	}
+	return NATBehaviorAddressPortDependent
+}
+
+// isLocalAddr 映射地址就是本机地址时没有经过NAT
+func (c *STUNClient) isLocalAddr(mapped *net.UDPAddr) bool {
+	if mapped == nil || mapped.Port != c.LocalAddr().Port {
+		return false
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(mapped.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func classifyNAT(noNAT bool, mapping, filtering string) string {
+	if noNAT {
+		if filtering == NATBehaviorEndpointIndependent {
+			return NATTypeOpenInternet
+		}
+		if filtering == NATBehaviorUnknown {
+			return NATTypeUnknown
+		}
+		return NATTypeSymmetricFirewall
+	}
+	switch mapping {
+	case NATBehaviorAddressDependent, NATBehaviorAddressPortDependent:
+		return NATTypeSymmetric
+	case NATBehaviorEndpointIndependent:
+		switch filtering {
+		case NATBehaviorEndpointIndependent:
+			return NATTypeFullCone
+		case NATBehaviorAddressDependent:
+			return NATTypeRestrictedCone
+		case NATBehaviorAddressPortDependent:
+			return NATTypePortRestrictedCone
+		}
+	}
+	return NATTypeUnknown
+}
+
+// sameUDPAddr 比较IP、端口和Zone，中继地址用Zone区分不同的对方
+func sameUDPAddr(a, b *net.UDPAddr) bool {
+	return a != nil && b != nil && a.IP.Equal(b.IP) && a.Port == b.Port && a.Zone == b.Zone
+}
+
+// STUNServer 本地 STUN 响应器。配置备用IP时在 主/备用IP × 主/备用端口 四个套接字上监听，
+// 支持 CHANGE-REQUEST 和 OTHER-ADDRESS，可用于完整的 NAT 行为发现
+type STUNServer struct {
+	// sockets[ip][port]，0 为主地址，1 为备用地址
+	sockets [2][2]*net.UDPConn
+	wg      sync.WaitGroup
+}
+
+// NewSTUNServer 监听 primary（如 127.0.0.1:3478），alternateIP 为空时只提供基本的绑定服务
+func NewSTUNServer(primary, alternateIP string) (*STUNServer, error) {
+	addr, err := net.ResolveUDPAddr("udp", primary)
+	if err != nil {
+		return nil, err
+	}
+	s := &STUNServer{}
+	s.sockets[0][0], err = net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if alternateIP == "" {
+		return s, nil
+	}
+	altIP := net.ParseIP(alternateIP)
+	if altIP == nil || altIP.Equal(addr.IP) {
+		s.Close()
+		return nil, fmt.Errorf("无效的备用IP: %s", alternateIP)
+	}
+	primaryPort := s.sockets[0][0].LocalAddr().(*net.UDPAddr).Port
+	primaryIP := s.sockets[0][0].LocalAddr().(*net.UDPAddr).IP
+	if s.sockets[1][0], err = net.ListenUDP("udp", &net.UDPAddr{IP: altIP, Port: primaryPort}); err != nil {
+		s.Close()
+		return nil, err
+	}
+	// 备用端口由系统分配后在备用IP上绑定同一端口，端口被占用时重试
+	for i := 0; i < 10; i++ {
+		s.sockets[0][1], err = net.ListenUDP("udp", &net.UDPAddr{IP: primaryIP})
+		if err != nil {
+			break
+		}
+		altPort := s.sockets[0][1].LocalAddr().(*net.UDPAddr).Port
+		if s.sockets[1][1], err = net.ListenUDP("udp", &net.UDPAddr{IP: altIP, Port: altPort}); err == nil {
+			return s, nil
+		}
+		s.sockets[0][1].Close()
+		s.sockets[0][1] = nil
+	}
+	s.Close()
+	return nil, fmt.Errorf("无法分配备用端口: %v", err)
+}
+
+// Addr 主地址
+func (s *STUNServer) Addr() *net.UDPAddr {
+	return s.sockets[0][0].LocalAddr().(*net.UDPAddr)
+}
+
+// Serve 在所有套接字上处理请求，直到 Close
+func (s *STUNServer) Serve() {
+	for ip := range s.sockets {
+		for port, conn := range s.sockets[ip] {
+			if conn == nil {
+				continue
+			}
+			s.wg.Add(1)
+			go func(ip, port int, conn *net.UDPConn) {
+				defer s.wg.Done()
+				s.serveSocket(ip, port, conn)
+			}(ip, port, conn)
+		}
+	}
+	s.wg.Wait()
+}
+
+// Close 关闭所有套接字
+func (s *STUNServer) Close() error {
+	for ip := range s.sockets {
+		for _, conn := range s.sockets[ip] {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+	return nil
+}
+
+func (s *STUNServer) serveSocket(ip, port int, conn *net.UDPConn) {
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		req, err := decodeSTUNMessage(buffer[:n])
+		if err != nil || req.Type != stunBindingRequest {
+			continue
+		}
+		var change uint32
+		if value, ok := req.get(stunAttrChangeReq); ok && len(value) == 4 {
+			change = binary.BigEndian.Uint32(value)
+		}
+		replyIP, replyPort := ip, port
+		if change&stunChangeIPFlag != 0 {
+			replyIP = 1 - ip
+		}
+		if change&stunChangePortFlag != 0 {
+			replyPort = 1 - port
+		}
+		reply := s.sockets[replyIP][replyPort]
+
+		resp := &stunMessage{Type: stunBindingSuccess, TxID: req.TxID}
+		if reply == nil {
+			// 没有备用地址时无法满足 CHANGE-REQUEST
+			resp.Type = stunBindingError
+			resp.add(stunAttrErrorCode, append([]byte{0, 0, 4, 20}, "Unknown Attribute"...))
+			reply = conn
+		} else {
+			resp.add(stunAttrXorMapped, encodeSTUNAddress(from, req.TxID, true))
+			resp.add(stunAttrMapped, encodeSTUNAddress(from, req.TxID, false))
+			resp.add(stunAttrRespOrigin, encodeSTUNAddress(reply.LocalAddr().(*net.UDPAddr), req.TxID, false))
+			if other := s.sockets[1-ip][1-port]; other != nil {
+				resp.add(stunAttrOtherAddr, encodeSTUNAddress(other.LocalAddr().(*net.UDPAddr), req.TxID, false))
+			}
+		}
+		resp.add(stunAttrSoftware, []byte(stunSoftware))
+		reply.WriteToUDP(resp.encode(), from)
+	}
+}
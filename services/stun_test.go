@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// RFC 5769 2.2 和 2.3 中的 XOR-MAPPED-ADDRESS 测试向量
+func TestSTUNXorMappedAddressVectors(t *testing.T) {
+	cases := []struct {
+		name  string
+		txID  string
+		value string
+		addr  string
+	}{
+		{"IPv4", "b7e7a701bc34d686fa87dfae", "0001a147e112a643", "192.0.2.1:32853"},
+		{"IPv6", "b7e7a701bc34d686fa87dfae", "0002a1470113a9faa5d3f179bc25f4b5bed2b9d9", "[2001:db8:1234:5678:11:2233:4455:6677]:32853"},
+	}
+	for _, tc := range cases {
+		var txID [12]byte
+		raw, _ := hex.DecodeString(tc.txID)
+		copy(txID[:], raw)
+		value, _ := hex.DecodeString(tc.value)
+		want, _ := net.ResolveUDPAddr("udp", tc.addr)
+
+		got, err := decodeSTUNAddress(value, txID, true)
+		if err != nil {
+			t.Fatalf("%s: 解码失败: %v", tc.name, err)
+		}
+		if !sameUDPAddr(got, want) {
+			t.Fatalf("%s: 解码得到 %s，期望 %s", tc.name, got, want)
+		}
+		if encoded := encodeSTUNAddress(want, txID, true); !bytes.Equal(encoded, value) {
+			t.Fatalf("%s: 编码得到 %x，期望 %x", tc.name, encoded, value)
+		}
+	}
+}
+
+// newTestSTUNServer 在 127.0.0.1 上监听，备用IP使用 127.0.0.2
+func newTestSTUNServer(t *testing.T, alternateIP string) *STUNServer {
+	t.Helper()
+	server, err := NewSTUNServer("127.0.0.1:0", alternateIP)
+	if err != nil {
+		t.Fatalf("启动STUN服务器失败: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func newTestSTUNClient(t *testing.T) *STUNClient {
+	t.Helper()
+	client, err := ListenSTUN("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("创建STUN客户端失败: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.RTO = 20 * time.Millisecond
+	client.Attempts = 3
+	return client
+}
+
+func TestSTUNServerBinding(t *testing.T) {
+	server := newTestSTUNServer(t, "127.0.0.2")
+	go server.Serve()
+	client := newTestSTUNClient(t)
+
+	binding, err := client.Binding(server.Addr(), stunChangeNone)
+	if err != nil {
+		t.Fatalf("绑定请求失败: %v", err)
+	}
+	if !sameUDPAddr(binding.Mapped, client.LocalAddr()) {
+		t.Fatalf("映射地址为 %s，期望 %s", binding.Mapped, client.LocalAddr())
+	}
+	if !sameUDPAddr(binding.Origin, server.Addr()) {
+		t.Fatalf("响应来源为 %s，期望 %s", binding.Origin, server.Addr())
+	}
+	if binding.Other == nil || !binding.Other.IP.Equal(net.ParseIP("127.0.0.2")) || binding.Other.Port == server.Addr().Port {
+		t.Fatalf("备用地址应为 127.0.0.2 上的另一个端口，实际为 %v", binding.Other)
+	}
+
+	// CHANGE-REQUEST 要求从备用IP和端口回复
+	changed, err := client.Binding(server.Addr(), stunChangeIPAndPort)
+	if err != nil {
+		t.Fatalf("CHANGE-REQUEST 失败: %v", err)
+	}
+	if !sameUDPAddr(changed.Origin, binding.Other) {
+		t.Fatalf("响应应来自 %s，实际为 %s", binding.Other, changed.Origin)
+	}
+
+	// 本机没有NAT，回环地址上也不过滤
+	behavior, err := client.DiscoverNAT([]string{server.Addr().String()})
+	if err != nil {
+		t.Fatalf("NAT检测失败: %v", err)
+	}
+	if behavior.Type != NATTypeOpenInternet || behavior.Mapping != NATBehaviorEndpointIndependent || behavior.Filtering != NATBehaviorEndpointIndependent {
+		t.Fatalf("检测结果为 %+v，期望 %s", behavior, NATTypeOpenInternet)
+	}
+}
+
+func TestSTUNServerWithoutAlternate(t *testing.T) {
+	server := newTestSTUNServer(t, "")
+	go server.Serve()
+	client := newTestSTUNClient(t)
+
+	binding, err := client.Binding(server.Addr(), stunChangeNone)
+	if err != nil {
+		t.Fatalf("绑定请求失败: %v", err)
+	}
+	if binding.Other != nil {
+		t.Fatalf("没有备用IP时不应返回备用地址: %s", binding.Other)
+	}
+	if _, err := client.Binding(server.Addr(), stunChangePort); err == nil || errors.Is(err, ErrSTUNTimeout) {
+		t.Fatalf("无法满足的 CHANGE-REQUEST 应返回错误响应，实际为 %v", err)
+	}
+	behavior, err := client.DiscoverNAT([]string{server.Addr().String()})
+	if err != nil {
+		t.Fatalf("NAT检测失败: %v", err)
+	}
+	if behavior.Filtering != NATBehaviorUnknown || behavior.Type != NATTypeUnknown {
+		t.Fatalf("没有备用地址时过滤行为应为 unknown: %+v", behavior)
+	}
+}
+
+// natPolicy 模拟客户端前面的NAT：mapping 决定外部端口随目的地址如何变化，filtering 决定哪些回复被丢弃
+type natPolicy struct {
+	mapping   string
+	filtering string
+}
+
+// serveBehindNAT 在 STUNServer 分配好的四个套接字上回复，映射地址和丢弃的回复按 policy 模拟NAT
+func serveBehindNAT(server *STUNServer, ip, port int, policy natPolicy) {
+	conn := server.sockets[ip][port]
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		req, err := decodeSTUNMessage(buffer[:n])
+		if err != nil || req.Type != stunBindingRequest {
+			continue
+		}
+		var change uint32
+		if value, ok := req.get(stunAttrChangeReq); ok && len(value) == 4 {
+			change = binary.BigEndian.Uint32(value)
+		}
+		changeIP, changePort := change&stunChangeIPFlag != 0, change&stunChangePortFlag != 0
+		switch policy.filtering {
+		case NATBehaviorAddressDependent:
+			if changeIP {
+				continue
+			}
+		case NATBehaviorAddressPortDependent:
+			if changeIP || changePort {
+				continue
+			}
+		}
+		replyIP, replyPort := ip, port
+		if changeIP {
+			replyIP = 1 - ip
+		}
+		if changePort {
+			replyPort = 1 - port
+		}
+
+		external := &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}
+		switch policy.mapping {
+		case NATBehaviorAddressDependent:
+			external.Port += ip
+		case NATBehaviorAddressPortDependent:
+			external.Port += ip*2 + port
+		}
+		reply := server.sockets[replyIP][replyPort]
+		resp := &stunMessage{Type: stunBindingSuccess, TxID: req.TxID}
+		resp.add(stunAttrXorMapped, encodeSTUNAddress(external, req.TxID, true))
+		resp.add(stunAttrRespOrigin, encodeSTUNAddress(reply.LocalAddr().(*net.UDPAddr), req.TxID, false))
+		resp.add(stunAttrOtherAddr, encodeSTUNAddress(server.sockets[1-ip][1-port].LocalAddr().(*net.UDPAddr), req.TxID, false))
+		reply.WriteToUDP(resp.encode(), from)
+	}
+}
+
+func TestSTUNNATClassification(t *testing.T) {
+	cases := []struct {
+		policy natPolicy
+		want   string
+	}{
+		{natPolicy{NATBehaviorEndpointIndependent, NATBehaviorEndpointIndependent}, NATTypeFullCone},
+		{natPolicy{NATBehaviorEndpointIndependent, NATBehaviorAddressDependent}, NATTypeRestrictedCone},
+		{natPolicy{NATBehaviorEndpointIndependent, NATBehaviorAddressPortDependent}, NATTypePortRestrictedCone},
+		{natPolicy{NATBehaviorAddressDependent, NATBehaviorAddressPortDependent}, NATTypeSymmetric},
+		{natPolicy{NATBehaviorAddressPortDependent, NATBehaviorAddressPortDependent}, NATTypeSymmetric},
+	}
+	for _, tc := range cases {
+		server := newTestSTUNServer(t, "127.0.0.2")
+		for ip := range server.sockets {
+			for port := range server.sockets[ip] {
+				go serveBehindNAT(server, ip, port, tc.policy)
+			}
+		}
+		client := newTestSTUNClient(t)
+
+		behavior, err := client.DiscoverNAT([]string{server.Addr().String()})
+		if err != nil {
+			t.Fatalf("%+v: NAT检测失败: %v", tc.policy, err)
+		}
+		if behavior.Mapping != tc.policy.mapping || behavior.Filtering != tc.policy.filtering || behavior.Type != tc.want {
+			t.Fatalf("%+v: 检测结果为 %+v，期望 %s", tc.policy, behavior, tc.want)
+		}
+		if behavior.Mapped.String() != "203.0.113.7:40000" {
+			t.Fatalf("%+v: 映射地址为 %s", tc.policy, behavior.Mapped)
+		}
+	}
+}
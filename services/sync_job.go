@@ -0,0 +1,227 @@
+package services
+
+import (
+	"GoFileShare/config"
+	"GoFileShare/models"
+	"GoFileShare/utils"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// JobKindSync 同步远程文件：远程文件有变化时才下载，并原地替换文件树中的同名文件
+const JobKindSync = "sync"
+
+func init() {
+	RegisterJobKind(JobKindSync, 3, utils.PriorityLow, runSyncJob)
+}
+
+// SyncJobParams 同步任务的参数，与离线下载相同
+type SyncJobParams = ImportJobParams
+
+// runSyncJob 比较远程文件的大小和 Last-Modified，有变化时下载到临时文件再替换
+func runSyncJob(jc *JobContext) (map[string]interface{}, error) {
+	var params SyncJobParams
+	if err := jc.Bind(&params); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(params.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("无效的下载地址: %s", params.URL)
+	}
+	if err := checkPublicURL(jc.Context(), u); err != nil {
+		return nil, err
+	}
+	parentID := params.ParentID
+	if parentID == "" || parentID == "undefined" || parentID == "null" {
+		parentID = "root"
+	}
+	if err := checkImportParent(parentID, params.AuthLevel); err != nil {
+		return nil, err
+	}
+	name := params.Name
+	if name == "" {
+		name = path.Base(u.Path)
+	}
+	name = filepath.Base(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." || name == "/" {
+		name = "download"
+	}
+
+	headers := make(http.Header)
+	for key, value := range params.Headers {
+		headers.Set(key, value)
+	}
+	opts := DownloadOptions{Owner: jc.Owner(), Headers: headers, Priority: utils.PriorityLow, PublicOnly: true}
+
+	jc.Progress(0, "正在检查远程文件")
+	client, err := newTaskHTTPClient(params.URL, opts)
+	if err != nil {
+		return nil, err
+	}
+	probe, err := probeSources(&FileTask{URL: params.URL, Headers: headers, client: client}, []string{params.URL})
+	if err != nil {
+		return nil, err
+	}
+	remoteModified, _ := http.ParseTime(probe.lastModified)
+
+	existing, err := findChildFile(parentID, name)
+	if err != nil {
+		return nil, err
+	}
+	target := ""
+	if existing != nil {
+		target = nodeFilePath(*existing)
+		if target == "" && config.NodeID == "" {
+			target = existing.Path
+		}
+		if target != "" && syncUnchanged(target, probe.size, remoteModified) {
+			return map[string]interface{}{"file_name": name, "changed": false}, nil
+		}
+	}
+
+	tmpPath := filepath.Join(config.RootPath, "FileStore", fmt.Sprintf(".sync_%s_%s", jc.ID(), name))
+	err = downloadToFile(jc.Context(), params.URL, tmpPath, opts, func(progress float64) {
+		jc.Progress(progress*0.99, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !remoteModified.IsZero() {
+		// 下次同步时用本地修改时间和远程 Last-Modified 比较
+		if err := os.Chtimes(tmpPath, time.Now(), remoteModified); err != nil {
+			return nil, err
+		}
+	}
+
+	if existing != nil {
+		if target == "" {
+			// 集群模式下本节点没有这个文件的副本
+			_, target = reserveStorePath(name)
+			defer utils.GlobalPathReserver.Release(target)
+		}
+		if err := os.Rename(tmpPath, target); err != nil {
+			_ = os.Remove(tmpPath)
+			return nil, fmt.Errorf("替换文件失败: %w", err)
+		}
+		if cluster := GetCluster(); cluster != nil {
+			// 其他节点上的副本已经过期，重新复制
+			replica := config.Replica{NodeID: cluster.NodeID(), Path: target}
+			if err := models.ResetFileReplicas(existing.ID, replica); err != nil {
+				return nil, fmt.Errorf("更新副本记录失败: %w", err)
+			}
+			stale := *existing
+			stale.Replicas = nil
+			for _, old := range existing.Replicas {
+				if old.NodeID != replica.NodeID {
+					stale.Replicas = append(stale.Replicas, old)
+				}
+			}
+			cluster.DropReplicas([]config.FileNode{stale})
+			existing.Replicas = []config.Replica{replica}
+			cluster.Announce(*existing)
+		}
+		return map[string]interface{}{"file_name": name, "changed": true, "node_id": existing.ID.Hex()}, nil
+	}
+
+	_, storePath := reserveStorePath(name)
+	defer utils.GlobalPathReserver.Release(storePath)
+	if err := os.Rename(tmpPath, storePath); err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, fmt.Errorf("保存文件失败: %w", err)
+	}
+	if err := models.AddFileNode(storePath, name, false, parentID, params.AuthLevel); err != nil {
+		_ = os.Remove(storePath)
+		return nil, fmt.Errorf("添加文件节点失败: %w", err)
+	}
+	return map[string]interface{}{"file_name": name, "changed": true, "created": true}, nil
+}
+
+// syncUnchanged 远程大小和修改时间都与本地文件一致时认为没有变化，服务器不提供 Last-Modified 时总是重新下载
+func syncUnchanged(localPath string, remoteSize int64, remoteModified time.Time) bool {
+	info, err := os.Stat(localPath)
+	if err != nil || remoteModified.IsZero() || remoteSize < 0 {
+		return false
+	}
+	return info.Size() == remoteSize && info.ModTime().Equal(remoteModified)
+}
+
+// findChildFile 在文件夹中查找同名文件，不存在时返回 nil
+func findChildFile(parentID, name string) (*config.FileNode, error) {
+	parentObjID := primitive.NilObjectID
+	if parentID != "root" {
+		var err error
+		if parentObjID, err = primitive.ObjectIDFromHex(parentID); err != nil {
+			return nil, fmt.Errorf("无效的父节点ID: %s", parentID)
+		}
+	}
+	children, err := models.SearchFileNodeByParentID(parentObjID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range children {
+		if !children[i].Type && children[i].Name == name {
+			return &children[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// downloadToFile 通过传输服务下载文件并等待结束，ctx 结束时取消下载，失败时清理临时文件
+func downloadToFile(ctx context.Context, rawURL, filePath string, opts DownloadOptions, onProgress func(float64)) error {
+	service := GetTransferService()
+	if service == nil {
+		return errors.New("传输服务未初始化")
+	}
+
+	done := make(chan error, 1)
+	var startErr error
+	taskID := service.AddDownloadTaskWithOptions(rawURL, filePath, opts, onProgress,
+		func(task *FileTask) {
+			done <- nil
+		},
+		func(task *FileTask, err error) {
+			if task == nil {
+				startErr = err
+				return
+			}
+			service.DiscardTaskFiles(task)
+			done <- err
+		})
+	if taskID == "" {
+		select {
+		case err := <-done:
+			return err
+		default:
+		}
+		if startErr == nil {
+			startErr = errors.New("创建下载任务失败")
+		}
+		return startErr
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	service.CancelTask(taskID)
+	select {
+	case err := <-done:
+		if err == nil {
+			// 取消前刚好下载完成
+			_ = os.Remove(filePath)
+		}
+	case <-time.After(30 * time.Second):
+		// 传输服务已停止，排队中的任务不会再回调
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"GoFileShare/utils"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runPoolOrder 在单 worker 的协程池中先占住 worker，按 enqueue 提交任务后放行，返回任务的执行顺序
+func runPoolOrder(t *testing.T, pool *utils.WorkerPool, enqueue func(add func(name string, prio utils.Priority))) []string {
+	pool.Start()
+	t.Cleanup(pool.Stop)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	pool.Enqueue(utils.TaskOptions{Priority: utils.PriorityHigh}, func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	var mu sync.Mutex
+	var order []string
+	var handles []*utils.TaskHandle
+	enqueue(func(name string, prio utils.Priority) {
+		handles = append(handles, pool.Enqueue(utils.TaskOptions{Priority: prio}, func(ctx context.Context) (interface{}, error) {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil, nil
+		}))
+	})
+	close(release)
+	for _, h := range handles {
+		if _, err := h.Wait(context.Background()); err != nil {
+			t.Fatalf("任务执行失败: %v", err)
+		}
+	}
+	return order
+}
+
+func TestWorkerPoolPriority(t *testing.T) {
+	order := runPoolOrder(t, utils.NewWorkerPool(1), func(add func(string, utils.Priority)) {
+		add("low", utils.PriorityLow)
+		add("normal", utils.PriorityNormal)
+		add("high", utils.PriorityHigh)
+	})
+	if len(order) != 3 || order[0] != "high" || order[1] != "normal" || order[2] != "low" {
+		t.Fatalf("刚入队的任务应按优先级执行，实际顺序为 %v", order)
+	}
+}
+
+func TestWorkerPoolPriorityAging(t *testing.T) {
+	const aging = 20 * time.Millisecond
+	pool := utils.NewWorkerPool(1)
+	pool.Aging = aging
+	order := runPoolOrder(t, pool, func(add func(string, utils.Priority)) {
+		add("low", utils.PriorityLow)
+		// 排队超过三个 aging 周期后，低优先级任务按高于 high 的级别参与调度
+		time.Sleep(4 * aging)
+		add("high-1", utils.PriorityHigh)
+		add("high-2", utils.PriorityHigh)
+	})
+	if len(order) != 3 || order[0] != "low" {
+		t.Fatalf("等待已久的低优先级任务应先执行，实际顺序为 %v", order)
+	}
+
+	// 关闭 aging 后严格按优先级调度
+	pool = utils.NewWorkerPool(1)
+	pool.Aging = 0
+	order = runPoolOrder(t, pool, func(add func(string, utils.Priority)) {
+		add("low", utils.PriorityLow)
+		time.Sleep(4 * aging)
+		add("high", utils.PriorityHigh)
+	})
+	if len(order) != 2 || order[0] != "high" {
+		t.Fatalf("关闭 aging 后应先执行高优先级任务，实际顺序为 %v", order)
+	}
+}
+
+func TestWorkerPoolWorkerCountShim(t *testing.T) {
+	pool := utils.NewWorkerPool(2)
+	pool.Start()
+	defer pool.Stop()
+	if pool.WorkerCount != 2 || pool.Size() != 2 {
+		t.Fatalf("创建时 WorkerCount=%d Size=%d，应都为 2", pool.WorkerCount, pool.Size())
+	}
+
+	// WorkerCount 只记录创建时的数量，当前数量通过 Size 获取
+	pool.Resize(4)
+	if pool.WorkerCount != 2 || pool.Size() != 4 {
+		t.Fatalf("扩容后 WorkerCount=%d Size=%d，应为 2 和 4", pool.WorkerCount, pool.Size())
+	}
+	if workers := pool.Metrics().Workers; workers != 4 {
+		t.Fatalf("扩容后应有 4 个 worker，实际为 %d", workers)
+	}
+	pool.Resize(0)
+	if pool.WorkerCount != 2 || pool.Size() != 1 {
+		t.Fatalf("缩容后 WorkerCount=%d Size=%d，应为 2 和 1", pool.WorkerCount, pool.Size())
+	}
+
+	// 非法的初始数量按 1 处理
+	if pool := utils.NewWorkerPool(0); pool.WorkerCount != 1 || pool.Size() != 1 {
+		t.Fatalf("数量为 0 时 WorkerCount=%d Size=%d，应都为 1", pool.WorkerCount, pool.Size())
+	}
+}
@@ -2,60 +2,532 @@ package utils
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/donnie4w/go-logger/logger"
+	"github.com/fatih/color"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
-// WorkerPool 表示一个简单的协程池，用于并发执行任务
+// Priority 任务优先级，优先调度高优先级任务；排队时间每超过 WorkerPool.Aging，任务按高一级参与调度，低优先级任务不会一直等待
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	priorityLevels
+)
+
+// priorityAging WorkerPool.Aging 的默认值
+const priorityAging = 30 * time.Second
+
+// 任务状态
+const (
+	TaskPending   = "pending"
+	TaskRunning   = "running"
+	TaskDone      = "done"
+	TaskFailed    = "failed"
+	TaskCancelled = "cancelled"
+)
+
+// ErrTaskCancelled 任务在执行前被取消或协程池已停止
+var ErrTaskCancelled = errors.New("任务已取消")
+
+// TaskOptions 提交任务的参数
+type TaskOptions struct {
+	Priority Priority
+	Owner    string // 任务所属用户，同一优先级内按用户轮转调度；为空时归入共享队列
+}
+
+// TaskHandle 已提交任务的句柄，用于查询状态、等待结果或取消
+type TaskHandle struct {
+	mu       sync.Mutex
+	fn       func(ctx context.Context) (interface{}, error)
+	owner    string
+	priority Priority
+	status   string
+	result   interface{}
+	err      error
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	enqueued time.Time
+	started  time.Time
+	finished time.Time
+}
+
+// Status 返回任务当前状态
+func (h *TaskHandle) Status() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+// Done 返回任务结束时关闭的通道
+func (h *TaskHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait 等待任务结束并返回结果，ctx 结束时提前返回
+func (h *TaskHandle) Wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-h.done:
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.result, h.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Cancel 取消任务：排队中的任务不再执行，执行中的任务其 ctx 被取消
+// 返回 true 表示任务在开始执行前被取消
+func (h *TaskHandle) Cancel() bool {
+	h.cancel()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.status != TaskPending {
+		return false
+	}
+	h.finishLocked(TaskCancelled, nil, ErrTaskCancelled)
+	return true
+}
+
+// finish 记录结果，只有第一次调用生效
+func (h *TaskHandle) finish(status string, result interface{}, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.status != TaskPending && h.status != TaskRunning {
+		return
+	}
+	h.finishLocked(status, result, err)
+}
+
+func (h *TaskHandle) finishLocked(status string, result interface{}, err error) {
+	h.status = status
+	h.result = result
+	h.err = err
+	h.finished = time.Now()
+	close(h.done)
+}
+
+// start 将排队中的任务标记为执行中，已取消时返回 false
+func (h *TaskHandle) start() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.status != TaskPending {
+		return false
+	}
+	h.status = TaskRunning
+	h.started = time.Now()
+	return true
+}
+
+// fairQueue 同一优先级的队列，每个用户一个子队列，出队时在用户之间轮转
+type fairQueue struct {
+	queues map[string][]*TaskHandle
+	order  []string // 有排队任务的用户，按轮转顺序排列
+	next   int
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{queues: make(map[string][]*TaskHandle)}
+}
+
+func (q *fairQueue) push(h *TaskHandle) {
+	if len(q.queues[h.owner]) == 0 {
+		q.order = append(q.order, h.owner)
+	}
+	q.queues[h.owner] = append(q.queues[h.owner], h)
+}
+
+// oldest 返回各用户队首任务中最早的入队时间，队列为空时返回 false
+func (q *fairQueue) oldest() (time.Time, bool) {
+	var oldest time.Time
+	for _, owner := range q.order {
+		if enqueued := q.queues[owner][0].enqueued; oldest.IsZero() || enqueued.Before(oldest) {
+			oldest = enqueued
+		}
+	}
+	return oldest, len(q.order) > 0
+}
+
+// pop 取出下一个用户的队首任务
+func (q *fairQueue) pop() *TaskHandle {
+	if len(q.order) == 0 {
+		return nil
+	}
+	if q.next >= len(q.order) {
+		q.next = 0
+	}
+	owner := q.order[q.next]
+	queue := q.queues[owner]
+	h := queue[0]
+	queue[0] = nil
+	queue = queue[1:]
+	if len(queue) == 0 {
+		delete(q.queues, owner)
+		q.order = append(q.order[:q.next], q.order[q.next+1:]...)
+	} else {
+		q.queues[owner] = queue
+		q.next++
+	}
+	return h
+}
+
+// PoolMetrics 协程池运行指标
+type PoolMetrics struct {
+	Workers      int            `json:"workers"`
+	Busy         int            `json:"busy"`
+	Queued       int            `json:"queued"`
+	QueuedByPrio map[string]int `json:"queued_by_priority"`
+	QueuedByUser map[string]int `json:"queued_by_user"`
+	Submitted    uint64         `json:"submitted"`
+	Completed    uint64         `json:"completed"`
+	Failed       uint64         `json:"failed"`
+	Panics       uint64         `json:"panics"`
+	Cancelled    uint64         `json:"cancelled"`
+	AvgWait      time.Duration  `json:"avg_wait_ns"` // 平均排队时间
+	MaxWait      time.Duration  `json:"max_wait_ns"`
+	AvgRun       time.Duration  `json:"avg_run_ns"` // 平均执行时间
+}
+
+// WorkerPool 协程池，支持优先级、按用户公平调度、panic 恢复和运行时调整大小
+// 队列不设上限，Submit 和 Enqueue 不会阻塞
 type WorkerPool struct {
+	// WorkerCount 创建时的 worker 数量，不随 Resize 变化
+	//
+	// Deprecated: 使用 Size 获取当前的 worker 数量，使用 Resize 调整
 	WorkerCount int
-	taskQueue   chan func()
-	ctx         context.Context
-	cancel      context.CancelFunc
-	wg          sync.WaitGroup
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queues  [priorityLevels]*fairQueue
+	target  int // 期望的 worker 数量
+	workers int // 当前存活的 worker 数量
+	busy    int
+	started bool
+	stopped bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	// OnPanic 任务 panic 时调用，为空时写日志
+	OnPanic func(recovered interface{}, stack []byte)
+
+	// Aging 任务排队每满这么长时间，调度时的优先级提高一级，为 0 时不提升；需在 Start 之前设置
+	Aging time.Duration
+
+	submitted uint64
+	completed uint64
+	failed    uint64
+	panics    uint64
+	cancelled uint64
+	totalWait time.Duration
+	maxWait   time.Duration
+	totalRun  time.Duration
+	ran       uint64
 }
 
 // NewWorkerPool 创建一个新的 WorkerPool 实例
 func NewWorkerPool(workerCount int) *WorkerPool {
+	if workerCount < 1 {
+		workerCount = 1
+	}
 	ctx, cancel := context.WithCancel(context.Background())
-	return &WorkerPool{
+	wp := &WorkerPool{
 		WorkerCount: workerCount,
-		taskQueue:   make(chan func(), 100),
+		target:      workerCount,
+		Aging:       priorityAging,
 		ctx:         ctx,
 		cancel:      cancel,
 	}
+	wp.cond = sync.NewCond(&wp.mu)
+	for i := range wp.queues {
+		wp.queues[i] = newFairQueue()
+	}
+	return wp
 }
 
 // Start 启动所有 worker 协程，开始处理任务
 func (wp *WorkerPool) Start() {
-	for i := 0; i < wp.WorkerCount; i++ {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	if wp.started || wp.stopped {
+		return
+	}
+	wp.started = true
+	wp.spawnLocked()
+}
+
+// spawnLocked 补足 worker 数量到 target
+func (wp *WorkerPool) spawnLocked() {
+	for wp.workers < wp.target {
+		wp.workers++
 		wp.wg.Add(1)
 		go wp.worker()
 	}
 }
 
+// Resize 运行时调整 worker 数量，多余的 worker 在完成当前任务后退出
+func (wp *WorkerPool) Resize(workerCount int) {
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.target = workerCount
+	if wp.started && !wp.stopped {
+		wp.spawnLocked()
+	}
+	wp.cond.Broadcast()
+}
+
+// Size 返回期望的 worker 数量
+func (wp *WorkerPool) Size() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return wp.target
+}
+
 // worker 是实际执行任务的协程函数
 func (wp *WorkerPool) worker() {
 	defer wp.wg.Done()
 	for {
-		select {
-		case task := <-wp.taskQueue:
-			task()
-		case <-wp.ctx.Done():
+		h := wp.next()
+		if h == nil {
 			return
 		}
+		wp.run(h)
+	}
+}
+
+// next 取出下一个可执行的任务，worker 需要退出时返回 nil
+func (wp *WorkerPool) next() *TaskHandle {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	for {
+		if wp.stopped || wp.workers > wp.target {
+			wp.workers--
+			return nil
+		}
+		queue := wp.pickLocked(time.Now())
+		if queue == nil {
+			wp.cond.Wait()
+			continue
+		}
+		h := queue.pop()
+		if !h.start() {
+			// 排队期间已被取消
+			wp.cancelled++
+			continue
+		}
+		wait := h.started.Sub(h.enqueued)
+		wp.totalWait += wait
+		if wait > wp.maxWait {
+			wp.maxWait = wait
+		}
+		wp.busy++
+		return h
+	}
+}
+
+// pickLocked 选择下一个出队的优先级队列：按队首任务的排队时间提升优先级后取最高的一个，
+// 相同时取原优先级更高的队列；所有队列为空时返回 nil
+func (wp *WorkerPool) pickLocked(now time.Time) *fairQueue {
+	var picked *fairQueue
+	best := Priority(-1)
+	for prio := priorityLevels - 1; prio >= 0; prio-- {
+		oldest, ok := wp.queues[prio].oldest()
+		if !ok {
+			continue
+		}
+		effective := prio
+		if wp.Aging > 0 {
+			effective += Priority(now.Sub(oldest) / wp.Aging)
+		}
+		if effective > best {
+			picked, best = wp.queues[prio], effective
+		}
+	}
+	return picked
+}
+
+// run 执行任务并恢复 panic
+func (wp *WorkerPool) run(h *TaskHandle) {
+	began := time.Now()
+	var result interface{}
+	var err error
+	panicked := false
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicked = true
+				err = fmt.Errorf("任务 panic: %v", r)
+				wp.reportPanic(r, debug.Stack())
+			}
+		}()
+		result, err = h.fn(h.ctx)
+	}()
+
+	status := TaskDone
+	if err != nil {
+		status = TaskFailed
+		if errors.Is(err, context.Canceled) && h.ctx.Err() != nil {
+			status = TaskCancelled
+		}
+	}
+	h.finish(status, result, err)
+	h.cancel()
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.busy--
+	wp.ran++
+	wp.totalRun += time.Since(began)
+	switch {
+	case panicked:
+		wp.panics++
+		wp.failed++
+	case status == TaskCancelled:
+		wp.cancelled++
+	case status == TaskFailed:
+		wp.failed++
+	default:
+		wp.completed++
 	}
 }
 
-// Submit 向任务队列提交一个任务
+func (wp *WorkerPool) reportPanic(recovered interface{}, stack []byte) {
+	if wp.OnPanic != nil {
+		wp.OnPanic(recovered, stack)
+		return
+	}
+	logger.Errorf("worker pool task panic: %v\n%s", recovered, stack)
+	color.Red("worker pool task panic: %v", recovered)
+}
+
+// Enqueue 提交任务并返回句柄，fn 的 ctx 在任务取消或协程池停止时结束
+func (wp *WorkerPool) Enqueue(opts TaskOptions, fn func(ctx context.Context) (interface{}, error)) *TaskHandle {
+	if opts.Priority < PriorityLow {
+		opts.Priority = PriorityLow
+	}
+	if opts.Priority >= priorityLevels {
+		opts.Priority = priorityLevels - 1
+	}
+	ctx, cancel := context.WithCancel(wp.ctx)
+	h := &TaskHandle{
+		fn:       fn,
+		owner:    opts.Owner,
+		priority: opts.Priority,
+		status:   TaskPending,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		enqueued: time.Now(),
+	}
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	if wp.stopped {
+		h.finish(TaskCancelled, nil, ErrTaskCancelled)
+		cancel()
+		return h
+	}
+	wp.submitted++
+	wp.queues[opts.Priority].push(h)
+	wp.cond.Signal()
+	return h
+}
+
+// Submit 以普通优先级提交一个没有返回值的任务，不会阻塞
 func (wp *WorkerPool) Submit(task func()) {
-	select {
-	case wp.taskQueue <- task:
-	case <-wp.ctx.Done():
+	wp.Enqueue(TaskOptions{Priority: PriorityNormal}, func(ctx context.Context) (interface{}, error) {
+		task()
+		return nil, nil
+	})
+}
+
+// Metrics 返回当前的队列深度、吞吐和延迟统计
+func (wp *WorkerPool) Metrics() PoolMetrics {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	metrics := PoolMetrics{
+		Workers:      wp.workers,
+		Busy:         wp.busy,
+		QueuedByPrio: make(map[string]int),
+		QueuedByUser: make(map[string]int),
+		Submitted:    wp.submitted,
+		Completed:    wp.completed,
+		Failed:       wp.failed,
+		Panics:       wp.panics,
+		Cancelled:    wp.cancelled,
+		MaxWait:      wp.maxWait,
 	}
+	for prio, queue := range wp.queues {
+		for owner, handles := range queue.queues {
+			for _, h := range handles {
+				if h.Status() != TaskPending {
+					continue
+				}
+				metrics.Queued++
+				metrics.QueuedByPrio[Priority(prio).String()]++
+				metrics.QueuedByUser[owner]++
+			}
+		}
+	}
+	if started := wp.ran + uint64(wp.busy); started > 0 {
+		metrics.AvgWait = wp.totalWait / time.Duration(started)
+	}
+	if wp.ran > 0 {
+		metrics.AvgRun = wp.totalRun / time.Duration(wp.ran)
+	}
+	return metrics
+}
+
+// String 返回优先级名称
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
 }
 
-// Stop 停止所有 worker，并等待其退出
+// Stop 停止所有 worker，并等待其退出；尚未执行的任务被取消
 func (wp *WorkerPool) Stop() {
+	wp.mu.Lock()
+	if wp.stopped {
+		wp.mu.Unlock()
+		return
+	}
+	wp.stopped = true
+	var pending []*TaskHandle
+	for i, queue := range wp.queues {
+		for _, handles := range queue.queues {
+			pending = append(pending, handles...)
+		}
+		wp.queues[i] = newFairQueue()
+	}
 	wp.cancel()
+	wp.cond.Broadcast()
+	wp.mu.Unlock()
+
+	for _, h := range pending {
+		if h.Status() == TaskPending {
+			wp.mu.Lock()
+			wp.cancelled++
+			wp.mu.Unlock()
+		}
+		h.Cancel()
+	}
 	wp.wg.Wait()
 }
@@ -2,13 +2,16 @@ package utils
 
 import (
 	"archive/zip"
+	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"github.com/donnie4w/go-logger/logger"
 	"github.com/fatih/color"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 type FileIOTask struct {
@@ -104,6 +107,40 @@ func MD5Check(fileName string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
+// SHA256Check 计算整个文件的SHA-256
+func SHA256Check(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			logger.Errorf("Error closing file %s: %v", fileName, err)
+			color.Red("Error closing file %s: %v", fileName, err)
+		}
+	}(file)
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// SHA256Range 计算 r 中 [offset, offset+size) 区间的SHA-256
+func SHA256Range(r io.ReaderAt, offset, size int64) (string, error) {
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, io.NewSectionReader(r, offset, size))
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", io.ErrUnexpectedEOF
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
 func createZipFile(zipPath string) (*zip.Writer, *os.File, error) {
 	zipFile, err := os.Create(zipPath)
 	if err != nil {
@@ -173,6 +210,79 @@ func compressFolder(sourcePath, zipPath string) error {
 	return err
 }
 
+// ZipEntry 要写入压缩包的文件，Name 为压缩包内的相对路径
+type ZipEntry struct {
+	Name string
+	Path string
+}
+
+// ZipFiles 将一组文件写入新的zip文件，每写完一个文件调用 onProgress，ctx 结束时中止并删除未完成的压缩包
+func ZipFiles(ctx context.Context, zipPath string, entries []ZipEntry, onProgress func(done, total int)) (err error) {
+	zipWriter, zipFile, err := createZipFile(zipPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := zipWriter.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if closeErr := zipFile.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			if removeErr := os.Remove(zipPath); removeErr != nil {
+				color.Red("Error removing incomplete zip file %s: %v", zipPath, removeErr)
+			}
+		}
+	}()
+
+	for i, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := addFileToZip(ctx, zipWriter, entry); err != nil {
+			return err
+		}
+		if onProgress != nil {
+			onProgress(i+1, len(entries))
+		}
+	}
+	return nil
+}
+
+func addFileToZip(ctx context.Context, zipWriter *zip.Writer, entry ZipEntry) error {
+	file, err := os.Open(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			logger.Errorf("Error closing file %s: %v", entry.Path, err)
+			color.Red("Error closing file %s: %v", entry.Path, err)
+		}
+	}(file)
+	writer, err := zipWriter.Create(filepath.ToSlash(entry.Name))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, &contextReader{ctx: ctx, r: file})
+	return err
+}
+
+// contextReader 在 ctx 结束后停止读取，使大文件的压缩可以被取消
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
 func UnzipTask(zipPath, destPath string) error {
 	zipReader, err := zip.OpenReader(zipPath)
 	if err != nil {
@@ -267,3 +377,12 @@ func GetEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// AdminAuthLevel 管理员的最低权限等级，由 ADMIN_AUTH_LEVEL 配置（默认100）
+func AdminAuthLevel() int {
+	level, err := strconv.Atoi(GetEnv("ADMIN_AUTH_LEVEL", "100"))
+	if err != nil {
+		return 100
+	}
+	return level
+}
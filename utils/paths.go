@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// PathReserver 为写入同一目录的各组件分配文件名。选中的路径在 Release 之前不会再分配给其他调用方，
+// 文件还没有写到最终路径时，也不会有两个组件选中同一个文件名而在重命名时互相覆盖
+type PathReserver struct {
+	mu       sync.Mutex
+	reserved map[string]bool
+}
+
+// NewPathReserver 创建路径分配器
+func NewPathReserver() *PathReserver {
+	return &PathReserver{reserved: make(map[string]bool)}
+}
+
+// GlobalPathReserver 进程内共用的路径分配器，所有写入存储目录的组件都通过它选择文件名
+var GlobalPathReserver = NewPathReserver()
+
+// Reserve 在 dir 中选择一个既不存在也未被保留的文件名，重名时追加序号，返回文件名和完整路径。
+// 文件写入最终路径或放弃写入后调用 Release
+func (r *PathReserver) Reserve(dir, name string) (string, string) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 1; ; i++ {
+		filePath := filepath.Join(dir, candidate)
+		key := reserveKey(filePath)
+		if !r.reserved[key] {
+			if _, err := os.Lstat(filePath); os.IsNotExist(err) {
+				r.reserved[key] = true
+				return candidate, filePath
+			}
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// Release 释放 Reserve 返回的路径
+func (r *PathReserver) Release(filePath string) {
+	if filePath == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.reserved, reserveKey(filePath))
+}
+
+// reserveKey 同一文件的相对路径和绝对路径使用同一个键
+func reserveKey(filePath string) string {
+	if abs, err := filepath.Abs(filePath); err == nil {
+		return abs
+	}
+	return filepath.Clean(filePath)
+}
@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶限速器，令牌单位为字节
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒产生的令牌数，<=0 表示不限速
+	burst  float64 // 桶容量
+	tokens float64
+	last   time.Time
+	used   time.Time // 最近一次读取或分配给读取器的时间
+}
+
+// NewTokenBucket 创建令牌桶，rate 为每秒字节数，<=0 表示不限速
+func NewTokenBucket(rate int64) *TokenBucket {
+	b := &TokenBucket{last: time.Now(), used: time.Now()}
+	b.SetRate(rate)
+	b.tokens = b.burst
+	return b
+}
+
+// SetRate 运行时调整速率
+func (b *TokenBucket) SetRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	b.rate = float64(rate)
+	// 桶容量为一秒的流量，至少留出一个读缓冲区的大小
+	b.burst = b.rate
+	if b.burst < 32*1024 {
+		b.burst = 32 * 1024
+	}
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Rate 返回当前速率，0 表示不限速
+func (b *TokenBucket) Rate() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	return int64(b.rate)
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// Touch 记录桶正在使用，避免被当作空闲的桶清理
+func (b *TokenBucket) Touch() {
+	b.mu.Lock()
+	b.used = time.Now()
+	b.mu.Unlock()
+}
+
+// LastUsed 最近一次读取或 Touch 的时间
+func (b *TokenBucket) LastUsed() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// WaitN 消耗 n 个令牌，令牌不足时阻塞直到补足或 ctx 结束
+func (b *TokenBucket) WaitN(ctx context.Context, n int) error {
+	b.mu.Lock()
+	b.used = time.Now()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	b.refill(now)
+	// 先预支令牌，允许单次请求超过桶容量，欠下的部分通过等待偿还
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RateLimitedReader 按一组令牌桶限制读取速度
+type RateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*TokenBucket
+}
+
+// NewRateLimitedReader 创建限速读取器，nil 桶会被忽略
+func NewRateLimitedReader(ctx context.Context, r io.Reader, buckets ...*TokenBucket) *RateLimitedReader {
+	var active []*TokenBucket
+	for _, b := range buckets {
+		if b != nil {
+			active = append(active, b)
+		}
+	}
+	return &RateLimitedReader{ctx: ctx, r: r, buckets: active}
+}
+
+func (l *RateLimitedReader) Read(p []byte) (int, error) {
+	// 控制单次读取大小，使限速更平滑
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		for _, b := range l.buckets {
+			if waitErr := b.WaitN(l.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
+
+// RateLimitedReadSeeker 限速且可Seek的读取器，用于 http.ServeContent
+type RateLimitedReadSeeker struct {
+	*RateLimitedReader
+	s io.Seeker
+}
+
+// NewRateLimitedReadSeeker 创建限速的 ReadSeeker
+func NewRateLimitedReadSeeker(ctx context.Context, rs io.ReadSeeker, buckets ...*TokenBucket) *RateLimitedReadSeeker {
+	return &RateLimitedReadSeeker{
+		RateLimitedReader: NewRateLimitedReader(ctx, rs, buckets...),
+		s:                 rs,
+	}
+}
+
+func (l *RateLimitedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return l.s.Seek(offset, whence)
+}
+
+// KeyedLimiter 按键（例如来源IP）限制请求频率，每个键一个令牌桶，单位为次
+type KeyedLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // 每秒补充的次数
+	burst   float64
+	buckets map[string]*keyedBucket
+	sweep   time.Time
+}
+
+type keyedBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewKeyedLimiter 创建按键限流器，rate 为每秒允许的请求数，burst 为允许的突发请求数；rate<=0 表示不限流
+func NewKeyedLimiter(rate float64, burst int) *KeyedLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &KeyedLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*keyedBucket), sweep: time.Now()}
+}
+
+// Allow 消耗 key 的一个令牌，令牌不足时返回 false，不阻塞
+func (l *KeyedLimiter) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN 消耗 key 的 n 个令牌，令牌不足时返回 false 且不消耗，不阻塞
+func (l *KeyedLimiter) AllowN(key string, n int) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.evictIdle(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &keyedBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// evictIdle 每分钟清理一次已经补满的桶，避免大量来源地址占用内存
+func (l *KeyedLimiter) evictIdle(now time.Time) {
+	if now.Sub(l.sweep) < time.Minute {
+		return
+	}
+	l.sweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RESPError 服务器返回的错误回复，例如 "ERR unknown command"
+type RESPError string
+
+func (e RESPError) Error() string {
+	return string(e)
+}
+
+// RESPClient Redis 序列化协议（RESP2）的最小客户端，可以连接 Redis、Valkey、KeyDB 等兼容服务。
+// 单连接串行执行命令，网络错误时断开，下一次调用重新连接
+type RESPClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// NewRESPClient 创建客户端，连接在第一次调用时建立；password 为空时不认证，db 为 0 时不切换数据库
+func NewRESPClient(addr, password string, db int) *RESPClient {
+	return &RESPClient{addr: addr, password: password, db: db, timeout: 3 * time.Second}
+}
+
+// Do 执行一条命令，返回 string、int64、[]interface{} 或 nil（键不存在）；服务器错误以 RESPError 返回
+func (c *RESPClient) Do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(args)
+	var respErr RESPError
+	if err != nil && !errors.As(err, &respErr) {
+		c.closeLocked()
+	}
+	return reply, err
+}
+
+// Ping 检查服务是否可用
+func (c *RESPClient) Ping() error {
+	_, err := c.Do("PING")
+	return err
+}
+
+// Close 关闭连接
+func (c *RESPClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeLocked()
+}
+
+func (c *RESPClient) closeLocked() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	c.rd = nil
+	return err
+}
+
+func (c *RESPClient) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.rd = bufio.NewReader(conn)
+	if c.password != "" {
+		if _, err := c.roundTrip([]string{"AUTH", c.password}); err != nil {
+			c.closeLocked()
+			return fmt.Errorf("认证失败: %w", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			c.closeLocked()
+			return fmt.Errorf("切换数据库失败: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *RESPClient) roundTrip(args []string) (interface{}, error) {
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readRESP(c.rd)
+}
+
+// readRESP 读取一个回复
+func readRESP(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("无效的RESP回复: %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, RESPError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("无效的RESP长度: %q", body)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("无效的RESP长度: %q", body)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := readRESP(rd)
+			var respErr RESPError
+			if err != nil && !errors.As(err, &respErr) {
+				return nil, err
+			}
+			if err != nil {
+				item = respErr
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("未知的RESP类型: %q", kind)
+}